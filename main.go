@@ -2,22 +2,32 @@ package main
 
 import (
 	"fmt"
+	"flag"
 	"bufio"
+	"io"
 	"io/ioutil"
 	"log"
 	"time"
 	"regexp"
 	"runtime"
 	"math/rand"
+	"magpie/checker"
+	"magpie/dap"
 	"magpie/eval"
 	"magpie/lexer"
+	"magpie/lsp"
 	"magpie/parser"
 	"magpie/message"
+	"magpie/module"
 	"magpie/repl"
+	"magpie/testrunner"
+	"magpie/vm"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-func runProgram(debug bool, filename string) {
+func runProgram(debug bool, useVM bool, checkTypes bool, filename string) {
 	wd, err := os.Getwd()
 	if err != nil {
 		fmt.Println(err.Error())
@@ -40,31 +50,45 @@ func runProgram(debug bool, filename string) {
 		}
 		os.Exit(1)
 	}
-	scope := eval.NewScope(nil, os.Stdout)
+	interp := eval.NewInterpreter(os.Stdout)
+	interp.CheckTypes = checkTypes
+	scope := interp.Scope()
 	RegisterGoGlobals()
 
 	if debug {
-		eval.REPLColor = true
-		eval.Dbg = eval.NewDebugger()
-		eval.Dbg.SetFunctions(p.Functions)
-		eval.Dbg.ShowBanner()
+		interp.REPLColor = true
+		interp.Dbg = eval.NewDebugger()
+		interp.Dbg.SetFunctions(p.Functions)
+		interp.Dbg.ShowBanner()
 
 		dbgInfosArr := parser.SplitSlice(parser.DebugInfos) //[][]ast.Node
-		eval.Dbg.SetDbgInfos(dbgInfosArr)
+		interp.Dbg.SetDbgInfos(dbgInfosArr)
 		// for idx, dbgInfos := range dbgInfosArr {
 		// 	for _, dbgInfo := range dbgInfos {
 		// 		fmt.Printf("idx:%d, Line:<%d-%d>, node.Type=%T, node=<%s>\n", idx, dbgInfo.Pos().Line, dbgInfo.End().Line, dbgInfo, dbgInfo.String())
 		// 	}
 		// }
 
-		eval.MsgHandler = message.NewMessageHandler()
-		eval.MsgHandler.AddListener(eval.Dbg)
+		interp.MsgHandler = message.NewMessageHandler()
+		interp.MsgHandler.AddListener(interp.Dbg)
 
 	}
 
-	result := eval.Eval(program, scope)
+	var result eval.Object
+	if useVM {
+		result = vm.New().Run(program, scope)
+	} else {
+		result = eval.Eval(program, scope)
+	}
 	if result.Type() == eval.ERROR_OBJ {
 		fmt.Println(result.Inspect())
+		if err := result.(*eval.Error); len(err.StackTrace) > 1 {
+			fmt.Print("Stack trace:\n" + err.StackTraceString())
+		}
+	} else {
+		//the services started by the program keep running until they
+		//are stopped, or until Ctrl+C
+		eval.WaitServers()
 	}
 
 //	e := eval.Eval(program, scope)
@@ -73,6 +97,199 @@ func runProgram(debug bool, filename string) {
 //	}
 }
 
+// magpie --dap
+// The program to debug is sent by the client in the 'launch' request.
+func runDebugAdapter() {
+	s := dap.NewServer(os.Stdin, os.Stdout)
+
+	//stdout is used by the protocol, what the program writes to it
+	//directly(not with 'print') is sent to the client as output events.
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "magpie --dap:", err)
+		os.Exit(1)
+	}
+	os.Stdout = w
+	go io.Copy(s.Output("stdout"), r)
+
+	RegisterGoGlobals()
+	if err := s.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "magpie --dap:", err)
+		os.Exit(1)
+	}
+}
+
+// magpie mod init [module-path]
+// magpie mod tidy
+// magpie mod vendor
+func runModCommand(args []string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	switch {
+	case args[0] == "init" && len(args) <= 2:
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+		}
+		err = module.Init(wd, path, os.Stdout)
+	case args[0] == "tidy" && len(args) == 1:
+		err = module.Tidy(wd, os.Stdout)
+	case args[0] == "vendor" && len(args) == 1:
+		err = module.Vendor(wd, os.Stdout)
+	default:
+		fmt.Println("Usage: magpie mod init [module-path]\n       magpie mod tidy\n       magpie mod vendor")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println("magpie mod:", err)
+		os.Exit(1)
+	}
+}
+
+// magpie test [-v] [-run regexp] [-junit file] [dir]
+func runTestCommand(args []string) {
+	flags := flag.NewFlagSet("magpie test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "print the result and the output of every test")
+	run := flags.String("run", "", "run only the tests whose names match the regular expression")
+	junit := flags.String("junit", "", "write the results to the file as JUnit XML")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: magpie test [-v] [-run regexp] [-junit file] [dir]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	dir := "."
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(1)
+	} else if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	var opts testrunner.Options
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Println("magpie test: invalid -run:", err)
+			os.Exit(1)
+		}
+		opts.Run = re
+	}
+
+	RegisterGoGlobals()
+	report, err := testrunner.Run(dir, opts)
+	if err != nil {
+		fmt.Println("magpie test:", err)
+		os.Exit(1)
+	}
+
+	for _, r := range report.Results {
+		if r.Status == testrunner.Passed && !*verbose {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = "(load)"
+		}
+		fmt.Printf("--- %s: %s %s (%.2fs)\n", r.Status, r.File, name, r.Duration.Seconds())
+		if r.Message != "" {
+			fmt.Println(indent(r.Message))
+		}
+		if r.Output != "" && (*verbose || r.Status == testrunner.Failed) {
+			fmt.Println(indent(strings.TrimRight(r.Output, "\n")))
+		}
+	}
+
+	status := "ok"
+	if report.Failed() {
+		status = "FAIL"
+	}
+	fmt.Printf("%s\t%d passed, %d failed, %d skipped (%.2fs)\n", status, report.Count(testrunner.Passed),
+		report.Count(testrunner.Failed), report.Count(testrunner.Skipped), report.Duration.Seconds())
+
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err == nil {
+			err = report.WriteJUnit(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Println("magpie test:", err)
+			os.Exit(1)
+		}
+	}
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+// magpie check [file.mp|dir ...]
+// The '.mp' files of a directory are checked recursively.
+func runCheckCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			fmt.Println("magpie check:", err)
+			os.Exit(1)
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != arg && (info.Name() == "vendor" || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".mp") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Println("magpie check:", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+		diags, err := checker.CheckFile(file)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		for _, d := range diags {
+			fmt.Println(d)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func indent(s string) string {
+	return "    " + strings.Replace(s, "\n", "\n    ", -1)
+}
+
 // Register go package methods/types
 // Note here, we use 'gfmt', 'glog', 'gos' 'gtime', because in magpie
 // we already have built in module 'fmt', 'log' 'os', 'time'.
@@ -217,23 +434,62 @@ func RegisterGoGlobals() {
 	})
 }
 
+// parseDefines removes the leading '-D NAME[=value]' options from the
+// arguments, and defines their macros in every file.
+func parseDefines(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-D") {
+		def := strings.TrimPrefix(args[0], "-D")
+		args = args[1:]
+		if def == "" { //'-D NAME'
+			if len(args) == 0 {
+				fmt.Println("magpie: -D requires a macro name")
+				os.Exit(1)
+			}
+			def, args = args[0], args[1:]
+		}
+
+		if err := parser.AddDefine(def); err != nil {
+			fmt.Println("magpie:", err)
+			os.Exit(1)
+		}
+	}
+	return args
+}
+
 func main() {
-	args := os.Args[1:]
+	args := parseDefines(os.Args[1:])
 	//We must reset `os.Args`, or the `flag` module will not functioning correctly
-	os.Args = os.Args[1:]
+	os.Args = args
 	if len(args) == 0 {
 		fmt.Println("Magpie programming language REPL\n")
 		repl.Start(os.Stdout, true)
+	} else if args[0] == "mod" { // module management
+		runModCommand(args[1:])
+	} else if args[0] == "test" { // run the tests of the *_test.mp files
+		runTestCommand(args[1:])
+	} else if args[0] == "check" { // type check the scripts without running them
+		runCheckCommand(args[1:])
+	} else if args[0] == "lsp" { // language server over stdin/stdout
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "magpie lsp:", err)
+			os.Exit(1)
+		}
+	} else if args[0] == "--dap" { // debug adapter over stdin/stdout
+		runDebugAdapter()
 	} else {
 		if len(args) == 2 {
 			if args[0] == "-d" || args[0] == "--debug" { // debug
-				runProgram(true, args[1])
+				runProgram(true, false, false, args[1])
+			} else if args[0] == "--vm" { // run with the bytecode vm
+				runProgram(false, true, false, args[1])
+			} else if args[0] == "--check-types" { // check the type annotations at runtime
+				runProgram(false, false, true, args[1])
 			} else {
-				fmt.Println("Usage: magpie -d file.mp")
+				fmt.Println("Usage: magpie [-D NAME[=value] ...] [-d|--debug|--vm|--check-types] file.mp\n       magpie check [file.mp|dir ...]\n       magpie mod init|tidy|vendor\n       magpie test [-v] [-run regexp] [-junit file] [dir]\n       magpie lsp\n       magpie --dap")
 				os.Exit(1)
 			}
 		} else {
-			runProgram(false, args[0])
+			runProgram(false, false, false, args[0])
 		}
 
 	}
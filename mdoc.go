@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"magpie/ast"
 	"magpie/docs"
 	"magpie/lexer"
 	"magpie/parser"
@@ -65,6 +66,11 @@ func genDoc(filename string, cfg doc.Config) {
 		os.Exit(1)
 	}
 
+	if cfg.OpenAPI == 1 {
+		genOpenAPI(filename, program, cfg)
+		return
+	}
+
 	//generate markdown docs
 	file := doc.New(filename, program)
 	md := doc.MdDocGen(file)
@@ -106,6 +112,42 @@ func genDoc(filename string, cfg doc.Config) {
 	}
 }
 
+//generate the OpenAPI document of the file's services, e.g. 'api.mp' -> 'api.openapi.json'
+func genOpenAPI(filename string, program *ast.Program, cfg doc.Config) {
+	hasService := false
+	for _, statement := range program.Statements {
+		if _, ok := statement.(*ast.ServiceStatement); ok {
+			hasService = true
+		}
+	}
+	if !hasService {
+		fmt.Printf("No service found in '%s'\n", filename)
+		return
+	}
+
+	var contents []byte
+	var err error
+	api := doc.NewOpenAPI(filename, program)
+	ext := ".openapi.json"
+	if cfg.OpenAPIYAML == 1 {
+		contents, err = api.YAML()
+		ext = ".openapi.yaml"
+	} else {
+		contents, err = api.JSON()
+	}
+	if err != nil {
+		fmt.Printf("Error generating OpenAPI document of '%s', reason:%v\n", filename, err)
+		os.Exit(1)
+	}
+
+	apiFile := strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+	err = ioutil.WriteFile(apiFile, append(contents, '\n'), 0644)
+	if err != nil {
+		fmt.Printf("Error creating '%s' file, reason:%v\n", apiFile, err)
+		os.Exit(1)
+	}
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [magpie file]\n", os.Args[0])
@@ -126,6 +168,12 @@ func main() {
 	var cssFile string
 	flag.StringVar(&cssFile, "cssfile", "", "Css file to use for generating html file.")
 
+	var openAPIFlag bool
+	flag.BoolVar(&openAPIFlag, "openapi", false, "Generate the OpenAPI 3 document of the services instead.")
+
+	var yamlFlag bool
+	flag.BoolVar(&yamlFlag, "yaml", false, "Generate the OpenAPI document in YAML format(with -openapi).")
+
 	//parse the command line options
 	flag.Parse()
 
@@ -164,6 +212,13 @@ func main() {
 	if showSrcFlag {
 		doc.Cfg.ShowSrcComment = 1
 	}
+
+	if openAPIFlag {
+		doc.Cfg.OpenAPI = 1
+		if yamlFlag {
+			doc.Cfg.OpenAPIYAML = 1
+		}
+	}
 	switch mode := fi.Mode(); {
 	case mode.IsDir():
 		genDocs(path, doc.Cfg, true)
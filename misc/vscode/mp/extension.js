@@ -0,0 +1,16 @@
+const vscode = require('vscode');
+
+// The debug adapter is the magpie executable itself: 'magpie --dap'.
+function activate(context) {
+    context.subscriptions.push(vscode.debug.registerDebugAdapterDescriptorFactory('magpie', {
+        createDebugAdapterDescriptor() {
+            const executable = vscode.workspace.getConfiguration('magpie').get('executable') || 'magpie';
+            return new vscode.DebugAdapterExecutable(executable, ['--dap']);
+        }
+    }));
+}
+
+function deactivate() {
+}
+
+module.exports = { activate, deactivate };
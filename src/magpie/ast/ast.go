@@ -3,6 +3,9 @@ package ast
 import (
 	"bytes"
 	"magpie/token"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -412,6 +415,91 @@ func (ifex *IfMacroStatement) String() string {
 	return out.String()
 }
 
+///////////////////////////////////////////////////////////
+//                    MACRO STATEMENT                    //
+///////////////////////////////////////////////////////////
+//macro unless(cond, body) { quote(if !(unquote(cond)) { unquote(body) }) }
+type MacroStatement struct {
+	Token      token.Token
+	Name       *Identifier
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (m *MacroStatement) Pos() token.Position {
+	return m.Token.Pos
+}
+
+func (m *MacroStatement) End() token.Position {
+	return m.Body.End()
+}
+
+func (m *MacroStatement) statementNode()       {}
+func (m *MacroStatement) TokenLiteral() string { return m.Token.Literal }
+
+func (m *MacroStatement) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro ")
+	out.WriteString(m.Name.String())
+	out.WriteString("(" + strings.Join(params, ", ") + ") { ")
+	out.WriteString(m.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+//quote(expression)
+//quote { block-statements }
+type QuoteExpression struct {
+	Token token.Token
+	Node  Node //an Expression or a *BlockStatement
+}
+
+func (q *QuoteExpression) Pos() token.Position {
+	return q.Token.Pos
+}
+
+func (q *QuoteExpression) End() token.Position {
+	return q.Node.End()
+}
+
+func (q *QuoteExpression) expressionNode()      {}
+func (q *QuoteExpression) TokenLiteral() string { return q.Token.Literal }
+
+func (q *QuoteExpression) String() string {
+	if _, ok := q.Node.(*BlockStatement); ok {
+		return "quote { " + q.Node.String() + " }"
+	}
+	return "quote(" + q.Node.String() + ")"
+}
+
+//unquote(expression), only in a quote
+type UnquoteExpression struct {
+	Token      token.Token
+	Expression Expression
+}
+
+func (u *UnquoteExpression) Pos() token.Position {
+	return u.Token.Pos
+}
+
+func (u *UnquoteExpression) End() token.Position {
+	return u.Expression.End()
+}
+
+func (u *UnquoteExpression) expressionNode()      {}
+func (u *UnquoteExpression) TokenLiteral() string { return u.Token.Literal }
+
+func (u *UnquoteExpression) String() string {
+	return "unquote(" + u.Expression.String() + ")"
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Conditions  []*IfConditionExpr //if or elif part
@@ -817,6 +905,13 @@ type FunctionLiteral struct {
 
 	//If the function is async or not
 	Async bool
+
+	//If the function body contains 'yield', calling it returns a generator
+	Generator bool
+
+	//Type annotations: parameter name -> type, and the return type(nil if none)
+	ParamTypes map[string]*TypeAnnotation
+	ReturnType *TypeAnnotation
 }
 
 func (fl *FunctionLiteral) Pos() token.Position {
@@ -857,18 +952,34 @@ func (fl *FunctionLiteral) String() string {
 			param = "..." + param
 		}
 
-		params = append(params, p.String())
+		params = append(params, fl.annotated(p))
 
 	}
 	out.WriteString(" (")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")" + fl.returnType() + " ")
 	out.WriteString("{ ")
 	out.WriteString(fl.Body.String())
 	out.WriteString(" }")
 	return out.String()
 }
 
+//the parameter with its type annotation, e.g. 'a: int'
+func (fl *FunctionLiteral) annotated(p Expression) string {
+	if t, ok := fl.ParamTypes[p.String()]; ok {
+		return p.String() + ": " + t.String()
+	}
+	return p.String()
+}
+
+//the return type annotation, e.g. ': int', or "" if none
+func (fl *FunctionLiteral) returnType() string {
+	if fl.ReturnType == nil {
+		return ""
+	}
+	return ": " + fl.ReturnType.String()
+}
+
 ///////////////////////////////////////////////////////////
 //                  FUNCTION STATEMENT                   //
 ///////////////////////////////////////////////////////////
@@ -924,12 +1035,12 @@ func (f *FunctionStatement) String() string {
 			param = "..." + param
 		}
 
-		params = append(params, p.String())
+		params = append(params, f.FunctionLiteral.annotated(p))
 
 	}
 	out.WriteString(" (")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")" + f.FunctionLiteral.returnType() + " ")
 	out.WriteString("{ ")
 	out.WriteString(f.FunctionLiteral.Body.String())
 	out.WriteString(" }")
@@ -952,16 +1063,46 @@ func (f *FunctionStatement) Docs() string {
 			param = "..." + param
 		}
 
-		params = append(params, p.String())
+		params = append(params, f.FunctionLiteral.annotated(p))
 
 	}
 	out.WriteString(" (")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")" + f.FunctionLiteral.returnType() + " ")
 
 	return out.String()
 }
 
+///////////////////////////////////////////////////////////
+//                    TYPE ANNOTATION                    //
+///////////////////////////////////////////////////////////
+//the type annotation of a parameter, a return value, a let or a property,
+//e.g. ': int' or ': int | nil'. The types are the names of the type patterns
+//of 'case'(int, float, string, ...), or class names.
+type TypeAnnotation struct {
+	Token token.Token //the ':'
+	Types []*Identifier
+}
+
+func (ta *TypeAnnotation) Pos() token.Position {
+	return ta.Types[0].Pos()
+}
+
+func (ta *TypeAnnotation) End() token.Position {
+	return ta.Types[len(ta.Types)-1].End()
+}
+
+func (ta *TypeAnnotation) expressionNode()      {}
+func (ta *TypeAnnotation) TokenLiteral() string { return ta.Token.Literal }
+
+func (ta *TypeAnnotation) String() string {
+	types := []string{}
+	for _, t := range ta.Types {
+		types = append(types, t.String())
+	}
+	return strings.Join(types, " | ")
+}
+
 ///////////////////////////////////////////////////////////
 //                      STRING LITERAL                   //
 ///////////////////////////////////////////////////////////
@@ -1012,8 +1153,7 @@ func (is *InterpolatedString) String() string       { return is.Token.Literal }
 type TryStmt struct {
 	Token   token.Token
 	Try     *BlockStatement
-	Var     string
-	Catch   *BlockStatement
+	Catches []*CatchClause
 	Finally *BlockStatement
 }
 
@@ -1026,7 +1166,10 @@ func (t *TryStmt) End() token.Position {
 		return t.Finally.End()
 	}
 
-	return t.Catch.End()
+	if len(t.Catches) > 0 {
+		return t.Catches[len(t.Catches)-1].End()
+	}
+	return t.Try.End()
 }
 
 func (t *TryStmt) statementNode()       {}
@@ -1039,14 +1182,8 @@ func (t *TryStmt) String() string {
 	out.WriteString(t.Try.String())
 	out.WriteString(" }")
 
-	if t.Catch != nil {
-		if len(t.Var) > 0 {
-			out.WriteString(" catch " + t.Var + " { ")
-		} else {
-			out.WriteString(" catch { ")
-		}
-		out.WriteString(t.Catch.String())
-		out.WriteString(" }")
+	for _, c := range t.Catches {
+		out.WriteString(" " + c.String())
 	}
 
 	if t.Finally != nil {
@@ -1058,6 +1195,46 @@ func (t *TryStmt) String() string {
 	return out.String()
 }
 
+//CatchClause is one 'catch' of the try statement:
+//  catch { }, catch e { }, catch (e) { }, catch (e: IOError | KeyError) { }
+//A clause without types catches everything.
+type CatchClause struct {
+	Token token.Token //the 'catch' token
+	Var   string
+	Types []*Identifier
+	Block *BlockStatement
+}
+
+func (c *CatchClause) Pos() token.Position {
+	return c.Token.Pos
+}
+
+func (c *CatchClause) End() token.Position {
+	return c.Block.End()
+}
+
+func (c *CatchClause) TokenLiteral() string { return c.Token.Literal }
+
+func (c *CatchClause) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("catch ")
+	if len(c.Types) > 0 {
+		types := []string{}
+		for _, t := range c.Types {
+			types = append(types, t.String())
+		}
+		out.WriteString("(" + c.Var + ": " + strings.Join(types, " | ") + ") ")
+	} else if len(c.Var) > 0 {
+		out.WriteString(c.Var + " ")
+	}
+	out.WriteString("{ ")
+	out.WriteString(c.Block.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
 //throw <expression>
 type ThrowStmt struct {
 	Token token.Token
@@ -1248,6 +1425,7 @@ type LetStatement struct {
 	Token  token.Token
 	Names  []*Identifier
 	Values []Expression
+	Types  []*TypeAnnotation //the type annotations of the names, nil for a name without one
 
 	StaticFlag    bool
 	ModifierLevel ModifierLevel //used in 'class'
@@ -1307,8 +1485,12 @@ func (ls *LetStatement) String() string {
 	}
 
 	names := []string{}
-	for _, name := range ls.Names {
-		names = append(names, name.String())
+	for i, name := range ls.Names {
+		if i < len(ls.Types) && ls.Types[i] != nil {
+			names = append(names, name.String()+": "+ls.Types[i].String())
+		} else {
+			names = append(names, name.String())
+		}
 	}
 	out.WriteString(strings.Join(names, ", "))
 
@@ -1417,7 +1599,9 @@ func (cs *ConstStatement) Docs() string {
 ///////////////////////////////////////////////////////////
 type ImportStatement struct {
 	Token      token.Token
-	ImportPath string
+	ImportPath string //the name the module is bound to: the last element of 'Path', or the 'as' name
+	Path       string //import path, e.g. 'acme/util/strings'
+	File       string //the resolved '.mp' file or package directory
 	Program    *Program
 	Functions  map[string]*FunctionLiteral //for debugger usage
 }
@@ -1438,8 +1622,16 @@ func (is *ImportStatement) String() string {
 
 	out.WriteString(is.TokenLiteral())
 	out.WriteString(" ")
-	out.WriteString(is.ImportPath)
+	if is.Path == "" || is.Path == is.ImportPath {
+		out.WriteString(is.ImportPath)
+		return out.String()
+	}
 
+	out.WriteString(strconv.Quote(is.Path))
+	if path.Base(is.Path) != is.ImportPath {
+		out.WriteString(" as ")
+		out.WriteString(is.ImportPath)
+	}
 	return out.String()
 }
 
@@ -1751,7 +1943,6 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
-	Awaited   bool // if it is an awaited call
 }
 
 func (ce *CallExpression) Pos() token.Position {
@@ -1862,7 +2053,8 @@ func (c *CaseExpr) String() string {
 
 type CaseMatchExpr struct {
 	Token token.Token
-	Expr  Expression
+	Expr  Expression //a value or a pattern
+	Guard Expression //the 'if' condition of the arm, could be nil
 	Block *BlockStatement
 }
 
@@ -1881,6 +2073,10 @@ func (cm *CaseMatchExpr) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(cm.Expr.String())
+	if cm.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(cm.Guard.String())
+	}
 	out.WriteString(" { ")
 	out.WriteString(cm.Block.String())
 	out.WriteString(" }")
@@ -1915,6 +2111,181 @@ func (ce *CaseElseExpr) String() string {
 	return out.String()
 }
 
+///////////////////////////////////////////////////////////
+//                     CASE PATTERNS                     //
+///////////////////////////////////////////////////////////
+// The patterns of the 'case' arms. An arm which isn't a pattern is a value,
+// which is compared with the 'case' expression.
+
+// tokenEnd returns the position after the token.
+func tokenEnd(t token.Token) token.Position {
+	ret := t.Pos
+	ret.Col += utf8.RuneCountInString(t.Literal)
+	return ret
+}
+
+// _
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Pos }
+func (wp *WildcardPattern) End() token.Position  { return tokenEnd(wp.Token) }
+func (wp *WildcardPattern) expressionNode()      {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// a name in a structural pattern, it's bound to the matched value
+type BindPattern struct {
+	Token token.Token
+	Name  *Identifier
+}
+
+func (bp *BindPattern) Pos() token.Position  { return bp.Token.Pos }
+func (bp *BindPattern) End() token.Position  { return bp.Name.End() }
+func (bp *BindPattern) expressionNode()      {}
+func (bp *BindPattern) TokenLiteral() string { return bp.Token.Literal }
+func (bp *BindPattern) String() string       { return bp.Name.String() }
+
+// name: type, e.g. 'x: int', 'p: Point' or '_: string'
+type TypePattern struct {
+	Token    token.Token
+	Name     *Identifier //nil for '_'
+	TypeName *Identifier
+}
+
+func (tp *TypePattern) Pos() token.Position  { return tp.Token.Pos }
+func (tp *TypePattern) End() token.Position  { return tp.TypeName.End() }
+func (tp *TypePattern) expressionNode()      {}
+func (tp *TypePattern) TokenLiteral() string { return tp.Token.Literal }
+
+func (tp *TypePattern) String() string {
+	name := "_"
+	if tp.Name != nil {
+		name = tp.Name.String()
+	}
+	return name + ": " + tp.TypeName.String()
+}
+
+// [first, *rest] or (a, b, *_)
+type SequencePattern struct {
+	Token    token.Token
+	IsTuple  bool
+	Elements []Expression
+	Rest     int         //the index of the '*rest' element, -1 if none
+	RestName *Identifier //nil for '*_'
+	EndToken token.Token
+}
+
+func (sp *SequencePattern) Pos() token.Position  { return sp.Token.Pos }
+func (sp *SequencePattern) End() token.Position  { return tokenEnd(sp.EndToken) }
+func (sp *SequencePattern) expressionNode()      {}
+func (sp *SequencePattern) TokenLiteral() string { return sp.Token.Literal }
+
+func (sp *SequencePattern) String() string {
+	elems := []string{}
+	for i, e := range sp.Elements {
+		if i == sp.Rest {
+			rest := "_"
+			if sp.RestName != nil {
+				rest = sp.RestName.String()
+			}
+			elems = append(elems, "*"+rest)
+		}
+		elems = append(elems, e.String())
+	}
+	if sp.Rest == len(sp.Elements) {
+		rest := "_"
+		if sp.RestName != nil {
+			rest = sp.RestName.String()
+		}
+		elems = append(elems, "*"+rest)
+	}
+	if sp.IsTuple {
+		if len(elems) == 1 {
+			return "(" + elems[0] + ",)"
+		}
+		return "(" + strings.Join(elems, ", ") + ")"
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// {Name: n, "age": a, Calories}, matches the hashes, structs and instances
+// which have the keys
+type HashPattern struct {
+	Token    token.Token
+	Keys     []Expression //the names are *StringLiteral
+	Values   []Expression
+	EndToken token.Token
+}
+
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position  { return tokenEnd(hp.EndToken) }
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for i, k := range hp.Keys {
+		pairs = append(pairs, k.String()+": "+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Point(x, y), matches the instances of the class, the arguments are
+// matched with the members of the class in their declaring order
+type ClassPattern struct {
+	Token    token.Token
+	Class    *Identifier
+	Args     []Expression
+	EndToken token.Token
+}
+
+func (cp *ClassPattern) Pos() token.Position  { return cp.Token.Pos }
+func (cp *ClassPattern) End() token.Position  { return tokenEnd(cp.EndToken) }
+func (cp *ClassPattern) expressionNode()      {}
+func (cp *ClassPattern) TokenLiteral() string { return cp.Token.Literal }
+
+func (cp *ClassPattern) String() string {
+	args := []string{}
+	for _, a := range cp.Args {
+		args = append(args, a.String())
+	}
+	return cp.Class.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// a | b
+type AltPattern struct {
+	Token        token.Token
+	Alternatives []Expression
+}
+
+func (ap *AltPattern) Pos() token.Position  { return ap.Alternatives[0].Pos() }
+func (ap *AltPattern) End() token.Position  { return ap.Alternatives[len(ap.Alternatives)-1].End() }
+func (ap *AltPattern) expressionNode()      {}
+func (ap *AltPattern) TokenLiteral() string { return ap.Token.Literal }
+
+func (ap *AltPattern) String() string {
+	alts := []string{}
+	for _, a := range ap.Alternatives {
+		alts = append(alts, a.String())
+	}
+	return strings.Join(alts, " | ")
+}
+
+// low..high, both ends are included
+type RangePattern struct {
+	Token token.Token
+	Low   Expression
+	High  Expression
+}
+
+func (rp *RangePattern) Pos() token.Position  { return rp.Low.Pos() }
+func (rp *RangePattern) End() token.Position  { return rp.High.End() }
+func (rp *RangePattern) expressionNode()      {}
+func (rp *RangePattern) TokenLiteral() string { return rp.Token.Literal }
+func (rp *RangePattern) String() string       { return rp.Low.String() + ".." + rp.High.String() }
+
 ///////////////////////////////////////////////////////////
 //                       SLICE/INDEX                     //
 ///////////////////////////////////////////////////////////
@@ -2010,6 +2381,135 @@ func (ss *SpawnStmt) String() string {
 	return out.String()
 }
 
+///////////////////////////////////////////////////////////
+//                     SELECT STATEMENT                  //
+///////////////////////////////////////////////////////////
+//select {
+//    case v = <-ch1:     ...
+//    case v, ok = <-ch1: ...
+//    case <-ch1:         ...
+//    case ch2 <- x:      ...
+//    case after(1.5):    ...  (seconds)
+//    default:            ...
+//}
+type SelectStmt struct {
+	Token       token.Token
+	Cases       []*SelectCase
+	RBraceToken token.Token
+}
+
+func (ss *SelectStmt) Pos() token.Position {
+	return ss.Token.Pos
+}
+
+func (ss *SelectStmt) End() token.Position {
+	return token.Position{Filename: ss.Token.Pos.Filename, Line: ss.RBraceToken.Pos.Line, Col: ss.RBraceToken.Pos.Col + 1}
+}
+
+func (ss *SelectStmt) statementNode()       {}
+func (ss *SelectStmt) TokenLiteral() string { return ss.Token.Literal }
+
+func (ss *SelectStmt) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("select { ")
+	for _, c := range ss.Cases {
+		out.WriteString(c.String() + " ")
+	}
+	out.WriteString("}")
+
+	return out.String()
+}
+
+type SelectCaseKind int
+
+const (
+	SelectRecv SelectCaseKind = iota
+	SelectSend
+	SelectTimeout
+	SelectDefault
+)
+
+type SelectCase struct {
+	Token   token.Token //'case' or 'default'
+	Kind    SelectCaseKind
+	Targets []Expression //the receiving variables(value and 'ok'), could be empty
+	Chan    Expression   //nil for 'after' and 'default'
+	Value   Expression   //the sent value, or the seconds of 'after'
+	Body    *BlockStatement
+}
+
+func (sc *SelectCase) Pos() token.Position {
+	return sc.Token.Pos
+}
+
+func (sc *SelectCase) End() token.Position {
+	return sc.Body.End()
+}
+
+func (sc *SelectCase) statementNode()       {}
+func (sc *SelectCase) TokenLiteral() string { return sc.Token.Literal }
+
+func (sc *SelectCase) String() string {
+	var out bytes.Buffer
+
+	switch sc.Kind {
+	case SelectRecv:
+		out.WriteString("case ")
+		if len(sc.Targets) > 0 {
+			targets := []string{}
+			for _, t := range sc.Targets {
+				targets = append(targets, t.String())
+			}
+			out.WriteString(strings.Join(targets, ", ") + " = ")
+		}
+		out.WriteString("<-" + sc.Chan.String())
+	case SelectSend:
+		out.WriteString("case " + sc.Chan.String() + " <- " + sc.Value.String())
+	case SelectTimeout:
+		out.WriteString("case after(" + sc.Value.String() + ")")
+	case SelectDefault:
+		out.WriteString("default")
+	}
+	out.WriteString(": ")
+	out.WriteString(sc.Body.String())
+
+	return out.String()
+}
+
+///////////////////////////////////////////////////////////
+//                     YIELD EXPRESSION                  //
+///////////////////////////////////////////////////////////
+//yield value
+//x = yield value  (x is the value passed to the generator's 'send' method)
+type YieldExpression struct {
+	Token token.Token
+	Value Expression //nil if no value
+}
+
+func (ye *YieldExpression) Pos() token.Position {
+	return ye.Token.Pos
+}
+
+func (ye *YieldExpression) End() token.Position {
+	if ye.Value != nil {
+		return ye.Value.End()
+	}
+	length := utf8.RuneCountInString(ye.Token.Literal)
+	pos := ye.Token.Pos
+	return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + length}
+}
+
+func (ye *YieldExpression) expressionNode()      {}
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+
+func (ye *YieldExpression) String() string {
+	if ye.Value == nil {
+		return ye.TokenLiteral()
+	}
+	return ye.TokenLiteral() + " " + ye.Value.String()
+}
+
 ///////////////////////////////////////////////////////////
 //                  PIPE OPERATOR                        //
 ///////////////////////////////////////////////////////////
@@ -2513,6 +3013,7 @@ type ClassLiteral struct {
 	Token      token.Token
 	Name       string
 	Parent     string
+	Interfaces []string                      //implemented interfaces
 	Members    []*LetStatement               //class's fields
 	Properties map[string]*PropertyDeclStmt  //class's properties
 	Methods    map[string]*FunctionStatement //class's methods
@@ -2537,7 +3038,11 @@ func (c *ClassLiteral) String() string {
 	out.WriteString(c.TokenLiteral() + " ")
 	out.WriteString(c.Name)
 	if len(c.Parent) != 0 {
-		out.WriteString(" : " + c.Parent + " ")
+		out.WriteString(" : " + c.Parent)
+		for _, i := range c.Interfaces {
+			out.WriteString(", " + i)
+		}
+		out.WriteString(" ")
 	}
 
 	out.WriteString("{ ")
@@ -2602,6 +3107,9 @@ func (c *ClassStatement) String() string {
 	} else {
 		if len(c.ClassLiteral.Parent) > 0 {
 			out.WriteString(" : " + c.ClassLiteral.Parent)
+			for _, i := range c.ClassLiteral.Interfaces {
+				out.WriteString(", " + i)
+			}
 		}
 	}
 
@@ -2628,6 +3136,9 @@ func (c *ClassStatement) Docs() string {
 	} else {
 		if len(c.ClassLiteral.Parent) > 0 {
 			out.WriteString(" : " + c.ClassLiteral.Parent)
+			for _, i := range c.ClassLiteral.Interfaces {
+				out.WriteString(", " + i)
+			}
 		}
 	}
 
@@ -2635,6 +3146,138 @@ func (c *ClassStatement) Docs() string {
 	return out.String()
 }
 
+//interface name { fn method(x, y); property name { get; set; } }
+//interface name : parent1, parent2 { block }
+///////////////////////////////////////////////////////////
+//                 INTERFACE STATEMENT                   //
+///////////////////////////////////////////////////////////
+type InterfaceStatement struct {
+	Token      token.Token
+	Name       *Identifier                  //Interface name
+	Parents    []string                     //parent interfaces
+	Methods    map[string]*InterfaceMethod  //interface's methods
+	Properties map[string]*PropertyDeclStmt //interface's properties
+
+	//Doc related
+	Doc         *CommentGroup // associated documentation; or nil
+	SrcEndToken token.Token
+}
+
+func (i *InterfaceStatement) Pos() token.Position {
+	return i.Token.Pos
+}
+
+func (i *InterfaceStatement) End() token.Position {
+	return i.SrcEndToken.Pos
+}
+
+//Below two methods implements 'Source' interface.
+func (i *InterfaceStatement) SrcStart() token.Position {
+	return i.Pos()
+}
+
+func (i *InterfaceStatement) SrcEnd() token.Position {
+	ret := i.SrcEndToken.Pos
+	length := utf8.RuneCountInString(i.SrcEndToken.Literal)
+	ret.Offset += length
+	return ret
+}
+
+func (i *InterfaceStatement) statementNode()       {}
+func (i *InterfaceStatement) TokenLiteral() string { return i.Token.Literal }
+func (i *InterfaceStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(i.Docs())
+	out.WriteString(" { ")
+	methods := make([]string, 0, len(i.Methods))
+	for name := range i.Methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	for _, name := range methods {
+		out.WriteString(i.Methods[name].String())
+		out.WriteString("; ")
+	}
+
+	properties := make([]string, 0, len(i.Properties))
+	for name := range i.Properties {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+	for _, name := range properties {
+		out.WriteString(i.Properties[name].String())
+		out.WriteString(" ")
+	}
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (i *InterfaceStatement) Docs() string {
+	var out bytes.Buffer
+
+	out.WriteString(i.Token.Literal + " ")
+	out.WriteString(i.Name.String())
+	if len(i.Parents) > 0 {
+		out.WriteString(" : " + strings.Join(i.Parents, ", "))
+	}
+
+	return out.String()
+}
+
+//interface's method declaration, e.g. 'fn method(x, y)'
+type InterfaceMethod struct {
+	Token      token.Token
+	Name       *Identifier
+	Parameters []Expression
+	Variadic   bool
+
+	//Type annotations, like the function literal's
+	ParamTypes map[string]*TypeAnnotation
+	ReturnType *TypeAnnotation
+}
+
+func (m *InterfaceMethod) Pos() token.Position {
+	return m.Token.Pos
+}
+
+func (m *InterfaceMethod) End() token.Position {
+	pLen := len(m.Parameters)
+	if pLen > 0 {
+		return m.Parameters[pLen-1].End()
+	}
+	return m.Name.End()
+}
+
+func (m *InterfaceMethod) TokenLiteral() string { return m.Token.Literal }
+func (m *InterfaceMethod) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		if t, ok := m.ParamTypes[p.String()]; ok {
+			params = append(params, p.String()+": "+t.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+
+	out.WriteString(m.TokenLiteral() + " ")
+	out.WriteString(m.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	if m.Variadic {
+		out.WriteString("...")
+	}
+	out.WriteString(")")
+	if m.ReturnType != nil {
+		out.WriteString(": " + m.ReturnType.String())
+	}
+
+	return out.String()
+}
+
 ///////////////////////////////////////////////////////////
 //                   NEW EXPRESSION                      //
 ///////////////////////////////////////////////////////////
@@ -2687,6 +3330,7 @@ type PropertyDeclStmt struct {
 	ModifierLevel ModifierLevel //property's modifier
 	Annotations   []*AnnotationStmt
 	Default       Expression
+	Type          *TypeAnnotation //nil if the property has no type annotation
 
 	//Doc related
 	Doc         *CommentGroup // associated documentation; or nil
@@ -2733,6 +3377,9 @@ func (p *PropertyDeclStmt) String() string {
 	} else {
 		out.WriteString(p.Name.String())
 	}
+	if p.Type != nil {
+		out.WriteString(": " + p.Type.String())
+	}
 
 	if p.Indexes != nil {
 		parameters := []string{}
@@ -3358,9 +4005,10 @@ func (q *QueryContinuationExpr) String() string {
 
 //result = await add(1, 2)
 //await hello()
+//await task     (a task returned by an async call)
 type AwaitExpr struct {
 	Token token.Token
-	Call  Expression
+	Value Expression
 }
 
 func (aw *AwaitExpr) Pos() token.Position {
@@ -3368,7 +4016,7 @@ func (aw *AwaitExpr) Pos() token.Position {
 }
 
 func (aw *AwaitExpr) End() token.Position {
-	return aw.Call.End()
+	return aw.Value.End()
 }
 
 func (aw *AwaitExpr) expressionNode()      {}
@@ -3378,8 +4026,7 @@ func (aw *AwaitExpr) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(aw.TokenLiteral() + " ")
-	out.WriteString(aw.Call.String())
-	out.WriteString("; ")
+	out.WriteString(aw.Value.String())
 
 	return out.String()
 }
@@ -3393,7 +4040,10 @@ type ServiceStatement struct {
 	Name    *Identifier //Service name
 	Addr    string
 	Debug   bool
+	OpenAPI bool                          //serves its OpenAPI document at '/openapi.json'
+	Options Expression                    //the server options(TLS, HTTP/2), or nil
 	Methods map[string]*FunctionStatement //service's methods
+	Statics []*AnnotationStmt             //the static file routes, i.e. '@static(url=..., dir=...)'
 	Block   *BlockStatement               //mainly used for debugging purpose
 
 	//Doc related
@@ -3430,7 +4080,12 @@ func (s *ServiceStatement) String() string {
 	out.WriteString(s.Name.String())
 	out.WriteString(" on '")
 	out.WriteString(s.Addr)
-	out.WriteString("' { ")
+	out.WriteString("'")
+	if s.Options != nil {
+		out.WriteString(", ")
+		out.WriteString(s.Options.String())
+	}
+	out.WriteString(" { ")
 	out.WriteString(s.Block.String())
 	out.WriteString(" }")
 
@@ -3449,6 +4104,27 @@ func (s *ServiceStatement) Docs() string {
 	return out.String()
 }
 
+//ServiceAnnotation returns the annotation which decides the kind of the service's
+//function, i.e. '@route', '@websocket', '@middleware', '@before' or '@after'.
+func ServiceAnnotation(f *FunctionStatement) *AnnotationStmt {
+	for _, anno := range f.Annotations {
+		if anno.Name.Value != "body" {
+			return anno
+		}
+	}
+	return nil
+}
+
+//BodyAnnotation returns the '@body' annotation of the service's function, or nil.
+func BodyAnnotation(f *FunctionStatement) *AnnotationStmt {
+	for _, anno := range f.Annotations {
+		if anno.Name.Value == "body" {
+			return anno
+		}
+	}
+	return nil
+}
+
 ///////////////////////////////////////////////////////////
 //                   DateTime Expression                 //
 ///////////////////////////////////////////////////////////
@@ -0,0 +1,250 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+)
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order, like go/ast's Walk. The children
+// of a node are found by reflection: every field of the node which is a Node,
+// or a slice or map of Nodes. A node shared by several parents(e.g. a class's
+// methods, which are also in the class's block) is visited only once.
+func Walk(v Visitor, node Node) {
+	walk(v, node, make(map[Node]bool))
+}
+
+// Inspect traverses an AST in depth-first order: it starts by calling f(node);
+// if f returns true, Inspect invokes f recursively for each of the children
+// of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+func walk(v Visitor, node Node, visited map[Node]bool) {
+	if isNil(node) {
+		return
+	}
+	if reflect.ValueOf(node).Kind() == reflect.Ptr {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+	}
+
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, child := range children(node) {
+		walk(v, child, visited)
+	}
+	v.Visit(nil)
+}
+
+// children returns the child nodes of 'node' in the order of their fields.
+func children(node Node) []Node {
+	val := reflect.ValueOf(node)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var ret []Node
+	for i := 0; i < val.NumField(); i++ {
+		ret = appendNodes(ret, val.Field(i))
+	}
+	return ret
+}
+
+func appendNodes(ret []Node, field reflect.Value) []Node {
+	if !field.CanInterface() {
+		return ret
+	}
+
+	switch field.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if field.IsNil() {
+			return ret
+		}
+		if n, ok := field.Interface().(Node); ok {
+			ret = append(ret, n)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < field.Len(); i++ {
+			ret = appendNodes(ret, field.Index(i))
+		}
+	case reflect.Map:
+		//the map values are sorted by position, so the order is stable
+		var nodes []Node
+		iter := field.MapRange()
+		for iter.Next() {
+			nodes = appendNodes(nodes, iter.Value())
+		}
+		sort.SliceStable(nodes, func(i, j int) bool {
+			pi, pj := nodes[i].Pos(), nodes[j].Pos()
+			return pi.Line < pj.Line || pi.Line == pj.Line && pi.Col < pj.Col
+		})
+		ret = append(ret, nodes...)
+	}
+	return ret
+}
+
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	val := reflect.ValueOf(node)
+	return val.Kind() == reflect.Ptr && val.IsNil()
+}
+
+// Copy returns a deep copy of an AST. The fields of the nodes which aren't
+// nodes are copied shallowly, and a node shared by several parents is copied
+// once, so the copy shares it the same way.
+func Copy(node Node) Node {
+	return copyNode(node, make(map[Node]Node))
+}
+
+func copyNode(node Node, copies map[Node]Node) Node {
+	if isNil(node) {
+		return node
+	}
+	val := reflect.ValueOf(node)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return node
+	}
+	if c, ok := copies[node]; ok {
+		return c
+	}
+
+	cp := reflect.New(val.Elem().Type())
+	cp.Elem().Set(val.Elem())
+	ret := cp.Interface().(Node)
+	copies[node] = ret
+
+	for i := 0; i < cp.Elem().NumField(); i++ {
+		if field := cp.Elem().Field(i); field.CanSet() {
+			field.Set(copyValue(field, copies))
+		}
+	}
+	return ret
+}
+
+func copyValue(v reflect.Value, copies map[Node]Node) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		if n, ok := v.Interface().(Node); ok {
+			ret := reflect.New(v.Type()).Elem()
+			ret.Set(reflect.ValueOf(copyNode(n, copies)))
+			return ret
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(copyValue(v.Index(i), copies))
+		}
+		return ret
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ret.SetMapIndex(copyValue(iter.Key(), copies), copyValue(iter.Value(), copies))
+		}
+		return ret
+	}
+	return v
+}
+
+// Rewrite traverses an AST in depth-first order, and replaces each node with
+// the result of f. f(node) is called before the children of node, which are
+// the children of f's result, and they're traversed only if f returns true.
+// A result which can't be put in the node's place(e.g. an expression for a
+// *BlockStatement field) is ignored. The AST is modified in place, Rewrite
+// returns the new root.
+func Rewrite(node Node, f func(Node) (Node, bool)) Node {
+	return rewrite(node, f, make(map[Node]Node))
+}
+
+func rewrite(node Node, f func(Node) (Node, bool), done map[Node]Node) Node {
+	if isNil(node) {
+		return node
+	}
+	if r, ok := done[node]; ok { //a shared node
+		return r
+	}
+
+	ret, descend := f(node)
+	done[node] = ret
+	if !descend || isNil(ret) {
+		return ret
+	}
+
+	val := reflect.ValueOf(ret)
+	if val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Struct {
+		for i := 0; i < val.Elem().NumField(); i++ {
+			if field := val.Elem().Field(i); field.CanSet() {
+				field.Set(rewriteValue(field, f, done))
+			}
+		}
+	}
+	return ret
+}
+
+func rewriteValue(v reflect.Value, f func(Node) (Node, bool), done map[Node]Node) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		n, ok := v.Interface().(Node)
+		if !ok {
+			return v
+		}
+		r := rewrite(n, f, done)
+		if isNil(r) || !reflect.TypeOf(r).AssignableTo(v.Type()) {
+			return v
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(reflect.ValueOf(r))
+		return ret
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).Set(rewriteValue(v.Index(i), f, done))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			nk, nv := rewriteValue(k, f, done), rewriteValue(v.MapIndex(k), f, done)
+			if nk.Interface() != k.Interface() {
+				v.SetMapIndex(k, reflect.Value{})
+			}
+			v.SetMapIndex(nk, nv)
+		}
+	}
+	return v
+}
@@ -0,0 +1,878 @@
+// Package checker type checks magpie programs without running them. It infers
+// the types of the expressions from the literals, the operators and the type
+// annotations(e.g. 'fn add(a: int, b: int): int'), and reports:
+//
+//   - the values which don't match the type annotations of the lets, the
+//     parameters, the return values and the class properties
+//   - the operators which aren't supported by the types of their operands
+//   - the calls of unknown methods of the builtin types
+//   - the calls of functions and methods with a wrong number of arguments
+//
+// Magpie is dynamic, so only what is certainly wrong is reported: a value
+// whose type isn't known(e.g. a parameter without annotation, or a variable
+// which is assigned to) is compatible with every type.
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/eval"
+	"magpie/lexer"
+	"magpie/parser"
+	"magpie/token"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is a problem found by the checker.
+type Diagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+// String returns the diagnostic as 'file:line:col: message'.
+func (d Diagnostic) String() string {
+	if d.Pos.Filename == "" {
+		return fmt.Sprintf("%d:%d: %s", d.Pos.Line, d.Pos.Col, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.Pos.Filename, d.Pos.Line, d.Pos.Col, d.Message)
+}
+
+// CheckFile parses and checks a source file. The syntax errors are returned
+// as the error.
+func CheckFile(path string) ([]Diagnostic, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return CheckSource(path, string(src))
+}
+
+// CheckSource parses and checks the source of a file.
+func CheckSource(filename, src string) ([]Diagnostic, error) {
+	defer parser.ResetDebugInfos()
+
+	l := lexer.New(filename, src)
+	p := parser.New(l, filepath.Dir(filename))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+	return Check(program), nil
+}
+
+// Check checks a parsed program, the diagnostics are sorted by position.
+// The imported modules aren't checked.
+func Check(program *ast.Program) []Diagnostic {
+	c := &checker{
+		program:    program,
+		classes:    make(map[string]*ast.ClassLiteral),
+		assigned:   make(map[string]bool),
+		extensions: make(map[string]bool),
+		reported:   make(map[Diagnostic]bool),
+	}
+	c.collect()
+
+	c.push()
+	c.block(program.Statements)
+	c.pop()
+
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		pi, pj := c.diags[i].Pos, c.diags[j].Pos
+		return pi.Line < pj.Line || pi.Line == pj.Line && pi.Col < pj.Col
+	})
+	return c.diags
+}
+
+type checker struct {
+	program *ast.Program
+
+	classes    map[string]*ast.ClassLiteral
+	assigned   map[string]bool //the names whose types aren't inferred, see 'collect'
+	extensions map[string]bool //the extension methods of the builtin types, e.g. 'string$title2'
+
+	scope *scope
+	fn    *function //the function being checked, nil at the top level
+
+	diags    []Diagnostic
+	reported map[Diagnostic]bool
+}
+
+type scope struct {
+	parent *scope
+	vars   map[string]*variable
+}
+
+type variable struct {
+	typ       typ
+	annotated bool      //'typ' is the type annotation, which is 'want'
+	want      string    //the type annotation as it's written
+	fn        *function //the function bound to the name, nil if none
+}
+
+type function struct {
+	name string //e.g. 'add', or 'Class.method'
+	lit  *ast.FunctionLiteral
+}
+
+// collect finds the classes and the extension methods of the program, and
+// the names whose types aren't inferred: the names which are assigned
+// to('x = ...'), or declared by 'let' more than once. Then the value of a
+// variable without annotation could be of any type.
+func (c *checker) collect() {
+	lets := make(map[string]int)
+	ast.Inspect(c.program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ImportStatement:
+			return false
+		case *ast.ClassLiteral:
+			if n.Name != "" {
+				c.classes[n.Name] = n
+			}
+		case *ast.FunctionStatement:
+			if strings.Contains(n.Name.Value, "$") {
+				c.extensions[n.Name.Value] = true
+			}
+		case *ast.LetStatement:
+			for _, name := range n.Names {
+				lets[name.Value]++
+			}
+		case *ast.AssignExpression:
+			if ident, ok := n.Name.(*ast.Identifier); ok {
+				c.assigned[ident.Value] = true
+			}
+		case *ast.SelectCase:
+			for _, target := range n.Targets {
+				if ident, ok := target.(*ast.Identifier); ok {
+					c.assigned[ident.Value] = true
+				}
+			}
+		}
+		return true
+	})
+
+	for name, count := range lets {
+		if count > 1 {
+			c.assigned[name] = true
+		}
+	}
+}
+
+func (c *checker) errorf(node ast.Node, format string, args ...interface{}) {
+	c.report(node.Pos(), format, args...)
+}
+
+func (c *checker) report(pos token.Position, format string, args ...interface{}) {
+	d := Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)}
+	if !c.reported[d] { //a node could be checked more than once
+		c.reported[d] = true
+		c.diags = append(c.diags, d)
+	}
+}
+
+func (c *checker) push() {
+	c.scope = &scope{parent: c.scope, vars: make(map[string]*variable)}
+}
+
+func (c *checker) pop() {
+	c.scope = c.scope.parent
+}
+
+func (c *checker) declare(name string, v *variable) {
+	c.scope.vars[name] = v
+}
+
+func (c *checker) find(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// block checks the statements of a block, it returns the type of the last
+// statement if it's an expression statement.
+func (c *checker) block(stmts []ast.Statement) (typ, bool) {
+	//a function could be called before its declaration, e.g. in another function
+	for _, s := range stmts {
+		if fs, ok := s.(*ast.FunctionStatement); ok {
+			c.declareFunction(fs.Name.Value, fs.FunctionLiteral)
+		}
+	}
+
+	var last typ
+	isExpr := false
+	for _, s := range stmts {
+		last, isExpr = c.stmt(s)
+	}
+	return last, isExpr
+}
+
+func (c *checker) declareFunction(name string, lit *ast.FunctionLiteral) {
+	v := &variable{typ: types("fn")}
+	if c.assigned[name] {
+		v.typ = unknown
+	} else {
+		v.fn = &function{name: name, lit: lit}
+	}
+	c.declare(name, v)
+}
+
+// stmt checks a statement, it returns the type of an expression statement.
+func (c *checker) stmt(s ast.Statement) (typ, bool) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		if s.Expression == nil {
+			return unknown, false
+		}
+		return c.expr(s.Expression), true
+	case *ast.LetStatement:
+		c.let(s)
+	case *ast.ConstStatement:
+		for i, name := range s.Name {
+			c.declare(name.Value, &variable{typ: c.expr(s.Value[i])})
+		}
+	case *ast.ReturnStatement:
+		c.ret(s)
+	case *ast.FunctionStatement:
+		if c.find(s.Name.Value) == nil { //e.g. a method of a service
+			c.declareFunction(s.Name.Value, s.FunctionLiteral)
+		}
+		c.function(s.Name.Value, s.FunctionLiteral)
+	case *ast.ClassStatement:
+		c.class(s.ClassLiteral)
+	case *ast.BlockStatement:
+		c.push()
+		defer c.pop()
+		return c.block(s.Statements)
+	case *ast.ImportStatement:
+		c.declare(s.ImportPath, &variable{})
+	case *ast.EnumStatement:
+		c.declare(s.Name.Value, &variable{})
+	default:
+		c.children(s)
+	}
+	return unknown, false
+}
+
+func (c *checker) let(s *ast.LetStatement) {
+	if s.DestructingFlag {
+		c.exprs(s.Values)
+		for _, name := range s.Names {
+			c.declare(name.Value, &variable{})
+		}
+		return
+	}
+
+	//the variables bound to function literals could be called recursively
+	for i, v := range s.Values {
+		if lit, ok := v.(*ast.FunctionLiteral); ok && i < len(s.Names) {
+			c.declareFunction(s.Names[i].Value, lit)
+		}
+	}
+
+	var values []typ
+	for i, v := range s.Values {
+		if lit, ok := v.(*ast.FunctionLiteral); ok && i < len(s.Names) {
+			c.function(s.Names[i].Value, lit)
+			values = append(values, types("fn"))
+			continue
+		}
+		values = append(values, c.expr(v))
+	}
+
+	for i, name := range s.Names {
+		if name.Token.Type == token.UNDERSCORE {
+			continue
+		}
+
+		v := &variable{}
+		if len(values) == len(s.Names) { //else a function may return multiple values
+			v.typ = values[i]
+			if old := c.scope.vars[name.Value]; old != nil && i < len(s.Values) {
+				if _, ok := s.Values[i].(*ast.FunctionLiteral); ok {
+					v.fn = old.fn
+				}
+			}
+		} else if len(values) == 0 {
+			v.typ = types("nil")
+		}
+
+		if i < len(s.Types) && s.Types[i] != nil {
+			want := c.annotation(s.Types[i])
+			if len(values) > 0 && !c.compatible(v.typ, want) {
+				c.errorf(name, "'%s' should be %s, got %s", name.Value, s.Types[i], v.typ)
+			}
+			v.typ, v.annotated, v.want = want, true, s.Types[i].String()
+		} else if c.assigned[name.Value] {
+			v.typ, v.fn = unknown, nil
+		}
+		c.declare(name.Value, v)
+	}
+}
+
+func (c *checker) ret(s *ast.ReturnStatement) {
+	var t typ
+	switch {
+	case len(s.ReturnValues) > 1: //multiple values are returned as a tuple
+		c.exprs(s.ReturnValues)
+		t = types("tuple")
+	case len(s.ReturnValues) == 1:
+		t = c.expr(s.ReturnValues[0])
+	case s.ReturnValue != nil:
+		t = c.expr(s.ReturnValue)
+	default:
+		t = types("nil")
+	}
+	c.checkReturn(s, t)
+}
+
+func (c *checker) checkReturn(at ast.Node, t typ) {
+	if c.fn == nil || c.fn.lit.ReturnType == nil || c.fn.lit.Generator {
+		return
+	}
+	if !c.compatible(t, c.annotation(c.fn.lit.ReturnType)) {
+		c.errorf(at, "return value of '%s' should be %s, got %s", c.fn.name, c.fn.lit.ReturnType, t)
+	}
+}
+
+// function checks the body of a function, in a new scope with its parameters.
+func (c *checker) function(name string, lit *ast.FunctionLiteral) {
+	if lit == nil || lit.Body == nil {
+		return
+	}
+
+	//the default values are evaluated in the scope of the function literal
+	defaults := make(map[string]typ)
+	for _, p := range lit.Parameters {
+		if v, ok := lit.Values[p.String()]; ok {
+			defaults[p.String()] = c.expr(v)
+		}
+	}
+
+	outer := c.fn
+	c.fn = &function{name: name, lit: lit}
+	c.push()
+	defer func() {
+		c.pop()
+		c.fn = outer
+	}()
+
+	for i, p := range lit.Parameters {
+		param := p.String()
+		v := &variable{}
+		if ta, ok := lit.ParamTypes[param]; ok {
+			v.typ, v.annotated, v.want = c.annotation(ta), true, ta.String()
+			if t, ok := defaults[param]; ok && !c.compatible(t, v.typ) {
+				c.errorf(lit.Values[param], "default value of '%s' should be %s, got %s", param, ta, t)
+			}
+			if lit.Variadic && i == len(lit.Parameters)-1 { //the annotation is the elements' type
+				v.typ, v.annotated = types("array"), false
+			}
+		}
+		c.declare(param, v)
+	}
+	c.annotation(lit.ReturnType)
+
+	//the value of the last expression is the function's result
+	if t, isExpr := c.block(lit.Body.Statements); isExpr {
+		stmts := lit.Body.Statements
+		c.checkReturn(stmts[len(stmts)-1], t)
+	}
+}
+
+func (c *checker) class(cl *ast.ClassLiteral) {
+	if cl == nil || cl.Block == nil {
+		return
+	}
+
+	c.push()
+	defer c.pop()
+	if cl.Name != "" {
+		c.declare("this", &variable{typ: types(cl.Name)})
+	}
+
+	for _, s := range cl.Block.Statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			c.let(s)
+			for _, name := range s.Names { //could be assigned by 'this.name = ...'
+				if v := c.scope.vars[name.Value]; v != nil && !v.annotated {
+					v.typ = unknown
+				}
+			}
+		case *ast.PropertyDeclStmt:
+			v := &variable{}
+			if s.Type != nil {
+				v.typ, v.annotated, v.want = c.annotation(s.Type), true, s.Type.String()
+			}
+			c.declare(s.Name.Value, v)
+			c.children(s)
+		case *ast.FunctionStatement:
+			c.function(cl.Name+"."+s.Name.Value, s.FunctionLiteral)
+		default:
+			c.stmt(s)
+		}
+	}
+}
+
+// method returns the method 'name' of a class or its ancestors, or nil.
+func (c *checker) method(class, name string) *function {
+	for cls, i := c.classes[class], 0; cls != nil && i < 100; cls, i = c.classes[cls.Parent], i+1 {
+		if fs, ok := cls.Methods[name]; ok {
+			return &function{name: class + "." + name, lit: fs.FunctionLiteral}
+		}
+	}
+	return nil
+}
+
+// property returns the type annotation of the property(or the 'let') 'name'
+// of a class or its ancestors, or nil.
+func (c *checker) property(class, name string) *ast.TypeAnnotation {
+	for cls, i := c.classes[class], 0; cls != nil && i < 100; cls, i = c.classes[cls.Parent], i+1 {
+		if p, ok := cls.Properties[name]; ok {
+			return p.Type
+		}
+		for _, member := range cls.Members {
+			for j, n := range member.Names {
+				if n.Value == name {
+					if j < len(member.Types) {
+						return member.Types[j]
+					}
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// children checks the child nodes of a node which isn't known by the checker,
+// in a new scope where the names bound by the node(e.g. the variable of a
+// 'for' loop) are of unknown types.
+func (c *checker) children(node ast.Node) typ {
+	c.push()
+	defer c.pop()
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		for _, name := range binders(n) {
+			c.declare(name, &variable{})
+		}
+		return true
+	})
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil || n == node {
+			return n != nil
+		}
+		switch n := n.(type) {
+		case ast.Statement:
+			c.stmt(n)
+			return false
+		case ast.Expression:
+			c.expr(n)
+			return false
+		}
+		return true
+	})
+	return unknown
+}
+
+// binders returns the names bound by a node.
+func binders(node ast.Node) []string {
+	switch n := node.(type) {
+	case *ast.ForEachArrayLoop:
+		return []string{n.Var}
+	case *ast.ForEachDotRange:
+		return []string{n.Var}
+	case *ast.ForEachMapLoop:
+		return []string{n.Key, n.Value}
+	case *ast.CatchClause:
+		return []string{n.Var}
+	case *ast.GrepExpr:
+		return []string{n.Var}
+	case *ast.MapExpr:
+		return []string{n.Var}
+	case *ast.ListComprehension:
+		return []string{n.Var}
+	case *ast.ListRangeComprehension:
+		return []string{n.Var}
+	case *ast.ListMapComprehension:
+		return []string{n.Key, n.Value}
+	case *ast.HashComprehension:
+		return []string{n.Var}
+	case *ast.HashRangeComprehension:
+		return []string{n.Var}
+	case *ast.HashMapComprehension:
+		return []string{n.Key, n.Value}
+	case *ast.FromExpr:
+		return []string{n.Var}
+	case *ast.JoinExpr:
+		if n.IntoVar != nil {
+			return []string{n.JoinVar, n.IntoVar.Value}
+		}
+		return []string{n.JoinVar}
+	case *ast.QueryContinuationExpr:
+		return []string{n.Var}
+	case *ast.BindPattern:
+		return []string{n.Name.Value}
+	case *ast.TypePattern:
+		if n.Name != nil {
+			return []string{n.Name.Value}
+		}
+	case *ast.SequencePattern:
+		if n.RestName != nil {
+			return []string{n.RestName.Value}
+		}
+	case *ast.SetterStmt:
+		return []string{"value"}
+	}
+	return nil
+}
+
+func (c *checker) exprs(list []ast.Expression) []typ {
+	var ret []typ
+	for _, e := range list {
+		ret = append(ret, c.expr(e))
+	}
+	return ret
+}
+
+// expr checks an expression and returns its type.
+func (c *checker) expr(e ast.Expression) typ {
+	if e == nil {
+		return unknown
+	}
+
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return types("int")
+	case *ast.UIntegerLiteral:
+		return types("uint")
+	case *ast.FloatLiteral:
+		return types("float")
+	case *ast.StringLiteral:
+		return types("string")
+	case *ast.InterpolatedString:
+		c.children(e)
+		return types("string")
+	case *ast.Boolean:
+		return types("bool")
+	case *ast.NilLiteral:
+		return types("nil")
+	case *ast.ArrayLiteral:
+		c.children(e)
+		return types("array")
+	case *ast.HashLiteral:
+		c.children(e)
+		return types("hash")
+	case *ast.TupleLiteral:
+		c.children(e)
+		return types("tuple")
+	case *ast.Identifier:
+		if v := c.find(e.Value); v != nil {
+			return v.typ
+		}
+		return unknown
+	case *ast.FunctionLiteral:
+		c.function("<anonymous>", e)
+		return types("fn")
+	case *ast.ClassLiteral:
+		c.class(e)
+		return unknown
+	case *ast.InfixExpression:
+		return c.infix(e)
+	case *ast.PrefixExpression:
+		return c.prefix(e)
+	case *ast.TernaryExpression:
+		c.expr(e.Condition)
+		return c.expr(e.IfTrue).union(c.expr(e.IfFalse))
+	case *ast.AssignExpression:
+		return c.assign(e)
+	case *ast.CallExpression:
+		return c.call(e, nil)
+	case *ast.MethodCallExpression:
+		return c.methodCall(e, nil)
+	case *ast.Pipe:
+		return c.pipe(e)
+	case *ast.NewExpression:
+		return c.newExpr(e)
+	case *ast.AwaitExpr:
+		return c.await(e)
+	}
+	return c.children(e)
+}
+
+func (c *checker) infix(e *ast.InfixExpression) typ {
+	l, r := c.expr(e.Left), c.expr(e.Right)
+	switch e.Operator {
+	case "==", "!=", "<", ">", "<=", ">=", "&&", "||":
+		return types("bool")
+	case "+", "-", "*", "/", "%":
+	default:
+		return unknown
+	}
+	if l == nil || r == nil {
+		return unknown
+	}
+
+	var results []string
+	valid, known := false, true
+	for _, a := range l {
+		for _, b := range r {
+			if !operandTypes[a] || !operandTypes[b] { //e.g. operator overloading
+				return unknown
+			}
+			t, ok := infixType(e.Operator, a, b)
+			if !ok {
+				continue
+			}
+			valid = true
+			if t == "" {
+				known = false
+			}
+			results = append(results, t)
+		}
+	}
+
+	if !valid {
+		c.report(e.Token.Pos, "unsupported operator for infix expression: %s '%s' %s", l, e.Operator, r)
+		return unknown
+	}
+	if !known {
+		return unknown
+	}
+	return types(results...)
+}
+
+func (c *checker) prefix(e *ast.PrefixExpression) typ {
+	t := c.expr(e.Right)
+	switch e.Operator {
+	case "!":
+		return types("bool")
+	case "-", "+":
+		for _, name := range t {
+			if !isNumber(name) {
+				return unknown
+			}
+		}
+		return t
+	}
+	return unknown
+}
+
+func (c *checker) assign(e *ast.AssignExpression) typ {
+	t := c.expr(e.Value)
+	if e.Token.Literal != "=" { //e.g. '+='
+		c.expr(e.Name)
+		return unknown
+	}
+
+	switch name := e.Name.(type) {
+	case *ast.Identifier:
+		v := c.find(name.Value)
+		if v == nil { //assigning to an undeclared name declares it
+			c.declare(name.Value, &variable{})
+		} else if v.annotated && !c.compatible(t, v.typ) {
+			c.errorf(e.Value, "'%s' should be %s, got %s", name.Value, v.want, t)
+		}
+	case *ast.MethodCallExpression: //e.g. 'this.x = 1'
+		obj := c.expr(name.Object)
+		prop, ok := name.Call.(*ast.Identifier)
+		if ok && len(obj) == 1 && c.classes[obj[0]] != nil {
+			if ta := c.property(obj[0], prop.Value); ta != nil && !c.compatible(t, c.annotation(ta)) {
+				c.errorf(e.Value, "'%s.%s' should be %s, got %s", obj[0], prop.Value, ta, t)
+			}
+		}
+	default:
+		c.expr(e.Name)
+	}
+	return t
+}
+
+// withPiped returns the arguments of a call, with the piped value first.
+func withPiped(piped ast.Expression, args []ast.Expression) []ast.Expression {
+	if piped == nil {
+		return args
+	}
+	return append([]ast.Expression{piped}, args...)
+}
+
+// pipe checks 'a |> f(x)', which calls 'f(a, x)'.
+func (c *checker) pipe(e *ast.Pipe) typ {
+	switch r := e.Right.(type) {
+	case *ast.CallExpression:
+		return c.call(r, e.Left)
+	case *ast.MethodCallExpression:
+		return c.methodCall(r, e.Left)
+	}
+	c.expr(e.Left)
+	return c.expr(e.Right)
+}
+
+// call checks a function call, 'piped' is the value piped into it, if any.
+func (c *checker) call(e *ast.CallExpression, piped ast.Expression) typ {
+	var fn *function
+	switch f := e.Function.(type) {
+	case *ast.Identifier:
+		if v := c.find(f.Value); v != nil {
+			fn = v.fn
+		}
+	case *ast.FunctionLiteral: //e.g. 'fn(x) { x * 2 }(3)'
+		c.function("<anonymous>", f)
+		fn = &function{name: "<anonymous>", lit: f}
+	default:
+		c.expr(e.Function)
+	}
+
+	argExprs := withPiped(piped, e.Arguments)
+	args := c.exprs(argExprs)
+	if fn == nil {
+		return unknown
+	}
+	return c.checkCall(e.Function, fn, argExprs, args)
+}
+
+// checkCall checks the number and the types of the arguments of a call, it
+// returns the type of the call.
+func (c *checker) checkCall(at ast.Node, fn *function, argExprs []ast.Expression, args []typ) typ {
+	lit := fn.lit
+	min, max := 0, len(lit.Parameters)
+	for _, p := range lit.Parameters {
+		if _, ok := lit.Values[p.String()]; !ok {
+			min++
+		}
+	}
+	if lit.Variadic {
+		min, max = min-1, -1
+	}
+
+	if len(args) < min || max >= 0 && len(args) > max {
+		expected := fmt.Sprint(min)
+		if max < 0 {
+			expected = fmt.Sprintf("at least %d", min)
+		} else if max != min {
+			expected = fmt.Sprintf("%d to %d", min, max)
+		}
+		c.errorf(at, "wrong number of arguments for '%s': expected %s, got %d", fn.name, expected, len(args))
+	}
+
+	for i, t := range args {
+		idx := i
+		if idx >= len(lit.Parameters) {
+			if !lit.Variadic {
+				break
+			}
+			idx = len(lit.Parameters) - 1
+		}
+		param := lit.Parameters[idx].String()
+		if ta, ok := lit.ParamTypes[param]; ok && !c.compatible(t, c.annotation(ta)) {
+			c.errorf(argExprs[i], "argument '%s' of '%s' should be %s, got %s", param, fn.name, ta, t)
+		}
+	}
+
+	if lit.Async || lit.Generator { //a task or a generator
+		return unknown
+	}
+	return c.annotation(lit.ReturnType)
+}
+
+// methodCall checks a method call or a property access, 'piped' is the
+// value piped into it, if any(e.g. 'a |> obj.m', which calls 'obj.m(a)').
+func (c *checker) methodCall(e *ast.MethodCallExpression, piped ast.Expression) typ {
+	obj := c.expr(e.Object)
+
+	var name string
+	var at ast.Node
+	var argExprs []ast.Expression
+	switch m := e.Call.(type) {
+	case *ast.CallExpression:
+		ident, ok := m.Function.(*ast.Identifier)
+		if !ok {
+			c.exprs(withPiped(piped, m.Arguments))
+			return unknown
+		}
+		name, at, argExprs = ident.Value, m.Function, m.Arguments
+	case *ast.Identifier:
+		name = m.Value
+	default: //e.g. 'obj.items[0]'
+		c.expr(piped)
+		return c.children(e.Call)
+	}
+
+	isCall := at != nil || piped != nil
+	if at == nil {
+		at = e.Call
+	}
+	argExprs = withPiped(piped, argExprs)
+	args := c.exprs(argExprs)
+
+	if len(obj) == 1 && c.classes[obj[0]] != nil {
+		if !isCall {
+			return c.annotation(c.property(obj[0], name))
+		}
+		if fn := c.method(obj[0], name); fn != nil {
+			return c.checkCall(at, fn, argExprs, args)
+		}
+		return unknown
+	}
+
+	c.checkMethod(e.Call, obj, name)
+	return unknown
+}
+
+// checkMethod reports the method 'name' if a value of the type 'obj' has no
+// such method. Only the builtin types are checked, the methods of the hashes
+// could be their keys.
+func (c *checker) checkMethod(at ast.Node, obj typ, name string) {
+	if obj == nil {
+		return
+	}
+	for _, t := range obj {
+		prefix, ok := extensionPrefixes[t]
+		if t == "nil" {
+			prefix, ok = "nil", true
+		}
+		if !ok || c.extensions[prefix+"$"+name] {
+			return
+		}
+		for _, m := range eval.BuiltinMethods(builtinTypes[t]) {
+			if m == name {
+				return
+			}
+		}
+	}
+	c.errorf(at, "undefined method '%s' for %s", name, obj)
+}
+
+func (c *checker) newExpr(e *ast.NewExpression) typ {
+	args := c.exprs(e.Arguments)
+	ident, ok := e.Class.(*ast.Identifier)
+	if !ok {
+		return unknown
+	}
+	if fn := c.method(ident.Value, "init"); fn != nil {
+		c.checkCall(e.Class, fn, e.Arguments, args)
+	}
+	if c.isClass(ident.Value) {
+		return types(ident.Value)
+	}
+	return unknown
+}
+
+// await returns the result type of an async function call.
+func (c *checker) await(e *ast.AwaitExpr) typ {
+	c.expr(e.Value)
+	if call, ok := e.Value.(*ast.CallExpression); ok {
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			if v := c.find(ident.Value); v != nil && v.fn != nil && v.fn.lit.Async {
+				return c.annotation(v.fn.lit.ReturnType)
+			}
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+)
+
+func check(t *testing.T, input string) []string {
+	diags, err := CheckSource("test.mp", input)
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %s", input, err)
+	}
+	var msgs []string
+	for _, d := range diags {
+		msgs = append(msgs, d.Message)
+	}
+	return msgs
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`fn add(a: int, b: int): int { a + b }; add(1, "2")`,
+			"argument 'b' of 'add' should be int, got string"},
+		{`fn add(a, b) { a + b }; add(1)`,
+			"wrong number of arguments for 'add': expected 2, got 1"},
+		{`fn add(a, b = 1) { a + b }; add(1, 2, 3)`,
+			"wrong number of arguments for 'add': expected 1 to 2, got 3"},
+		{`fn sum(first, rest...) { first }; sum()`,
+			"wrong number of arguments for 'sum': expected at least 1, got 0"},
+		{`fn sum(rest: int...) { 0 }; sum(1, 2, "3")`,
+			"argument 'rest' of 'sum' should be int, got string"},
+		{`fn name(): string { return 1 }`,
+			"return value of 'name' should be string, got int"},
+		{`fn name(): string { 1 }`,
+			"return value of 'name' should be string, got int"},
+		{`let x: int | nil = "a"`,
+			"'x' should be int | nil, got string"},
+		{`let x: int = 1; x = 1.5`,
+			"'x' should be int, got float"},
+		{`let x: Missing = 1`,
+			"unknown type 'Missing'"},
+		{`fn f(a: int = "s") {}`,
+			"default value of 'a' should be int, got string"},
+		{`let x = 1 - "a"`,
+			"unsupported operator for infix expression: int '-' string"},
+		{`let x = [1, 2].nothing()`,
+			"undefined method 'nothing' for array"},
+		{`class P { property x: int; fn init() { this.x = "a" } }`,
+			"'P.x' should be int, got string"},
+		{`class P { fn m(a: int) {} }; let p = new P(); p.m("a")`,
+			"argument 'a' of 'P.m' should be int, got string"},
+		{`class P { fn init(a) {} }; new P()`,
+			"wrong number of arguments for 'P.init': expected 1, got 0"},
+		{`class A {}; class B {}; fn f(a: A) {}; f(new B())`,
+			"argument 'a' of 'f' should be A, got B"},
+		{`fn f(a: int, b: string) {}; 1 |> f(2)`,
+			"argument 'b' of 'f' should be string, got int"},
+		{`fn g() { add(1, 2) }; fn add(a, b, c) {}`,
+			"wrong number of arguments for 'add': expected 3, got 2"},
+	}
+
+	for _, tt := range tests {
+		msgs := check(t, tt.input)
+		found := false
+		for _, msg := range msgs {
+			if strings.Contains(msg, tt.expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, msgs)
+		}
+	}
+}
+
+func TestNoDiagnostics(t *testing.T) {
+	tests := []string{
+		//the values of unknown types could be anything
+		`fn add(a: int, b: int): int { a + b }; fn f(x) { add(x, x) }`,
+		`let x: int = 1; x = fn() { "a" }()`,
+		//the unions are reported only if no alternative fits
+		`fn f(a: int) {}; let x = true ? 1 : "a"; f(x)`,
+		`fn f(a: number) { a * 2 }; f(1); f(2.5); f(3u)`,
+		`fn f(a: any) {}; f(1); f("a")`,
+		//the subclasses are their parents
+		`class A {}; class B : A {}; fn f(a: A) {}; f(new B())`,
+		//the names which are assigned are unknown
+		`let x = 1; x = "a"; x.upper()`,
+		//the methods added to the builtin types
+		`fn string$shout(s) { s.upper() + "!" }; "a".shout()`,
+		//the hashes' keys could be called
+		`let h = {"f": fn() { 1 }}; h.f()`,
+		//operators
+		`let x = "a" * 3 + 1; let y = [1] * 2; let z = {} + {}`,
+		//the pipe adds an argument
+		`fn add(a, b) { a + b }; fn double(a) { a * 2 }; add(1, 2) |> double()`,
+		//optional parameters and variadics
+		`fn f(a, b = 2, rest...) {}; f(1); f(1, 2, 3, 4)`,
+		//the annotations of the parameters are checked in the function
+		`fn f(s: string): int { s.len() }`,
+	}
+
+	for _, input := range tests {
+		if msgs := check(t, input); len(msgs) != 0 {
+			t.Errorf("%s: expected no diagnostics, got=%q", input, msgs)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := CheckSource("test.mp", `fn f(a: 1) {}`)
+	if err == nil || !strings.Contains(err.Error(), "expected a type name") {
+		t.Errorf("expected a syntax error, got=%v", err)
+	}
+
+	diags, err := CheckSource("test.mp", "let x: int = 1\n\nlet y: bool = x")
+	if err != nil || len(diags) != 1 {
+		t.Fatalf("expected one diagnostic, got=%v %v", diags, err)
+	}
+	if got := diags[0].String(); got != "test.mp:3:5: 'y' should be bool, got int" {
+		t.Errorf("unexpected diagnostic %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+package checker
+
+import (
+	"magpie/ast"
+	"magpie/eval"
+	"sort"
+	"strings"
+)
+
+// typ is the set of the types a value could have, e.g. {int, nil} for an
+// 'int | nil'. The names are the ones of the type annotations(int, uint,
+// float, string, bool, array, hash, tuple, struct, nil, fn), or class names.
+// The unknown type(nil) could be anything, it's compatible with every type.
+type typ []string
+
+var unknown typ
+
+func types(names ...string) typ {
+	var t typ
+	for _, name := range names {
+		if !t.has(name) {
+			t = append(t, name)
+		}
+	}
+	sort.Strings(t)
+	return t
+}
+
+func (t typ) has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// union returns the type of a value which is of the type 't' or 'u'.
+func (t typ) union(u typ) typ {
+	if t == nil || u == nil {
+		return unknown
+	}
+	return types(append(append([]string{}, t...), u...)...)
+}
+
+func (t typ) String() string {
+	if t == nil {
+		return "any"
+	}
+	return strings.Join(t, " | ")
+}
+
+// the types which are not classes
+var builtinTypes = map[string]eval.ObjectType{
+	"int":    eval.INTEGER_OBJ,
+	"uint":   eval.UINTEGER_OBJ,
+	"float":  eval.FLOAT_OBJ,
+	"string": eval.STRING_OBJ,
+	"bool":   eval.BOOLEAN_OBJ,
+	"array":  eval.ARRAY_OBJ,
+	"hash":   eval.HASH_OBJ,
+	"tuple":  eval.TUPLE_OBJ,
+	"struct": eval.STRUCT_OBJ,
+	"nil":    eval.NIL_OBJ,
+	"fn":     eval.FUNCTION_OBJ,
+}
+
+// the prefixes of the functions which extend the builtin types, e.g. the
+// function 'string$title2' adds the method 'title2' to the strings.
+var extensionPrefixes = map[string]string{
+	"int":    "integer",
+	"uint":   "uinteger",
+	"float":  "float",
+	"string": "string",
+	"bool":   "boolean",
+	"array":  "array",
+	"tuple":  "tuple",
+}
+
+func isNumber(name string) bool {
+	return name == "int" || name == "uint" || name == "float"
+}
+
+// annotation returns the type of a type annotation, unknown types are
+// reported.
+func (c *checker) annotation(ta *ast.TypeAnnotation) typ {
+	if ta == nil {
+		return unknown
+	}
+
+	var names []string
+	for _, ident := range ta.Types {
+		switch name := ident.Value; {
+		case name == "any":
+			return unknown
+		case name == "number":
+			names = append(names, "int", "uint", "float")
+		case builtinTypes[name] != "" || c.isClass(name):
+			names = append(names, name)
+		default:
+			if len(c.program.Imports) == 0 { //the classes of the modules are unknown
+				c.errorf(ident, "unknown type '%s'", name)
+			}
+			return unknown
+		}
+	}
+	return types(names...)
+}
+
+func (c *checker) isClass(name string) bool {
+	if _, ok := c.classes[name]; ok {
+		return true
+	}
+	_, ok := eval.BuiltinClasses[name]
+	return ok
+}
+
+// parent returns the parent of a class, "" if it has none. It reports
+// false if the class is unknown.
+func (c *checker) parent(name string) (string, bool) {
+	if cls, ok := c.classes[name]; ok {
+		return cls.Parent, true
+	}
+	if cls, ok := eval.BuiltinClasses[name]; ok {
+		if cls.Parent == nil {
+			return "", true
+		}
+		return cls.Parent.Name, true
+	}
+	return "", false
+}
+
+// compatible reports whether a value of type 'val' could be of the type
+// 'want', only the values which are certainly not are reported.
+func (c *checker) compatible(val, want typ) bool {
+	if val == nil || want == nil {
+		return true
+	}
+	for _, v := range val {
+		for _, w := range want {
+			if c.isA(v, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *checker) isA(v, w string) bool {
+	if v == w {
+		return true
+	}
+	if builtinTypes[v] != "" || builtinTypes[w] != "" {
+		return false
+	}
+
+	//'v' is a subclass of 'w', or its ancestors are unknown
+	for i := 0; i < 100 && v != ""; i++ {
+		if v == w {
+			return true
+		}
+		parent, ok := c.parent(v)
+		if !ok {
+			return true
+		}
+		v = parent
+	}
+	return false
+}
+
+// infixType returns the type of 'l op r' for the builtin types, it reports
+// false if the operator isn't supported for the types. The result is ""
+// when it isn't known.
+func infixType(op, l, r string) (string, bool) {
+	num := isNumber(l) && isNumber(r)
+	switch op {
+	case "+":
+		switch {
+		case l == "array" || r == "array":
+			return "array", true
+		case l == "tuple" || r == "tuple":
+			return "tuple", true
+		case l == "string" || r == "string":
+			return "string", true
+		case l == "hash" && r == "hash":
+			return "hash", true
+		case num:
+			return numberType(l, r), true
+		}
+	case "-", "%":
+		if num {
+			return numberType(l, r), true
+		}
+	case "/":
+		if num {
+			return "float", true
+		}
+	case "*":
+		switch {
+		case num:
+			return numberType(l, r), true
+		case l == "int" && r == "string" || l == "string" && r == "int":
+			return "string", true
+		case l == "array" && r == "int":
+			return "array", true
+		}
+	default:
+		return "", true
+	}
+	return "", false
+}
+
+func numberType(l, r string) string {
+	switch {
+	case l == "float" || r == "float":
+		return "float"
+	case l == r:
+		return l
+	}
+	return ""
+}
+
+// the types for which the result of the operators of 'infixType' is known
+var operandTypes = map[string]bool{
+	"int": true, "uint": true, "float": true, "string": true, "bool": true,
+	"nil": true, "array": true, "hash": true, "tuple": true,
+}
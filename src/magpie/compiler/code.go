@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota // push Constants[operand]
+	OpNil                    // push nil
+	OpTrue                   // push true
+	OpFalse                  // push false
+	OpPop                    // pop the top of the stack (statement boundary)
+
+	OpGetName // push the value of the identifier Nodes[operand]
+	OpLet     // bind the top of the stack to the name of the let statement Nodes[operand]
+	OpAssign  // assign the top of the stack using the assignment Nodes[operand]
+	OpFunc    // push a closure of the function literal Nodes[operand]
+
+	OpInfix  // apply the infix Nodes[operand] to the top two values
+	OpPrefix // apply the prefix Nodes[operand] to the top value
+	OpArray  // build an array from the top operand values
+
+	OpJump          // jump to operand
+	OpJumpNotTruthy // pop, and jump to operand if the value is not truthy
+
+	OpPushScope // enter a new block scope
+	OpPopScope  // leave the block scope
+
+	OpLoop     // enter a loop, operands: break target, continue target
+	OpLoopEnd  // leave the innermost loop
+	OpBreak    // jump to the break target of the innermost loop
+	OpContinue // jump to the continue target of the innermost loop
+
+	OpGetFunc     // push the callee of the call Nodes[operand 0], or evaluate the whole call and jump to operand 1
+	OpCall        // call the callee of the call Nodes[operand 0] with the top operand 1 values
+	OpReturn      // return the top of the stack from the current function
+	OpReturnMulti // return the top operand values as multiple return values
+
+	OpEval // evaluate Nodes[operand] with the tree-walking evaluator
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpNil:      {"OpNil", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpGetName: {"OpGetName", []int{2}},
+	OpLet:     {"OpLet", []int{2}},
+	OpAssign:  {"OpAssign", []int{2}},
+	OpFunc:    {"OpFunc", []int{2}},
+
+	OpInfix:  {"OpInfix", []int{2}},
+	OpPrefix: {"OpPrefix", []int{2}},
+	OpArray:  {"OpArray", []int{2}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+
+	OpPushScope: {"OpPushScope", []int{}},
+	OpPopScope:  {"OpPopScope", []int{}},
+
+	OpLoop:     {"OpLoop", []int{2, 2}},
+	OpLoopEnd:  {"OpLoopEnd", []int{}},
+	OpBreak:    {"OpBreak", []int{}},
+	OpContinue: {"OpContinue", []int{}},
+
+	OpGetFunc:     {"OpGetFunc", []int{2, 2}},
+	OpCall:        {"OpCall", []int{2, 2}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpReturnMulti: {"OpReturnMulti", []int{2}},
+
+	OpEval: {"OpEval", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an instruction.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction, and returns the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// String disassembles the instructions, mainly for debugging.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			break
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	switch len(operands) {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operand count for %s\n", def.Name)
+}
@@ -0,0 +1,408 @@
+// Package compiler lowers magpie's AST to bytecode for the 'vm' package.
+//
+// Only the nodes which dominate the running time of loop-heavy scripts
+// (literals, identifiers, operators, assignments, conditionals, loops and
+// calls of plain functions) are lowered. Every other node is emitted as an
+// 'OpEval' instruction, which hands the node back to the tree-walking
+// evaluator, so compiled programs behave exactly like evaluated ones.
+package compiler
+
+import (
+	"fmt"
+	"magpie/ast"
+	"magpie/eval"
+	"magpie/token"
+	"math"
+)
+
+type Bytecode struct {
+	Instructions Instructions
+	Constants    []eval.Object
+	Nodes        []ast.Node //nodes referenced by the instructions
+}
+
+type Compiler struct {
+	instructions Instructions
+	constants    []eval.Object
+	nodes        []ast.Node
+}
+
+func New() *Compiler {
+	return &Compiler{
+		instructions: Instructions{},
+		constants:    []eval.Object{},
+		nodes:        []ast.Node{},
+	}
+}
+
+// Compile compiles a whole program.
+func Compile(program *ast.Program) (*Bytecode, error) {
+	c := New()
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+	return c.checkedBytecode()
+}
+
+// CompileFunction compiles the body of a function literal.
+func CompileFunction(fl *ast.FunctionLiteral) (*Bytecode, error) {
+	c := New()
+	if err := c.compileBlock(fl.Body.Statements); err != nil {
+		return nil, err
+	}
+	return c.checkedBytecode()
+}
+
+// jump targets are 16-bit operands
+func (c *Compiler) checkedBytecode() (*Bytecode, error) {
+	if len(c.instructions) > math.MaxUint16 {
+		return nil, fmt.Errorf("code too large for the vm(%d bytes)", len(c.instructions))
+	}
+	return c.Bytecode(), nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.instructions,
+		Constants:    c.constants,
+		Nodes:        c.nodes,
+	}
+}
+
+// Compile emits the code for 'node'. The emitted code always leaves exactly
+// one value(the value of the node) on the stack.
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		return c.compileBlock(node.Statements)
+
+	case *ast.BlockStatement:
+		return c.compileBlock(node.Statements)
+
+	case *ast.ExpressionStatement:
+		if node.Expression == nil {
+			c.emit(OpNil)
+			return nil
+		}
+		return c.Compile(node.Expression)
+
+	case *ast.LetStatement:
+		if node.DestructingFlag || len(node.Names) != 1 || len(node.Values) != 1 ||
+			node.Names[0].Token.Type == token.UNDERSCORE {
+			return c.compileEval(node)
+		}
+		if err := c.Compile(node.Values[0]); err != nil {
+			return err
+		}
+		return c.emitNode(OpLet, node)
+
+	case *ast.IntegerLiteral:
+		return c.emitConstant(eval.NewInteger(node.Value))
+	case *ast.UIntegerLiteral:
+		return c.emitConstant(eval.NewUInteger(node.Value))
+	case *ast.FloatLiteral:
+		return c.emitConstant(eval.NewFloat(node.Value))
+	case *ast.StringLiteral:
+		return c.emitConstant(eval.NewString(node.Value))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+		return nil
+	case *ast.NilLiteral:
+		c.emit(OpNil)
+		return nil
+
+	case *ast.Identifier:
+		return c.emitNode(OpGetName, node)
+
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		return c.emitNode(OpInfix, node)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		return c.emitNode(OpPrefix, node)
+
+	case *ast.AssignExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		return c.emitNode(OpAssign, node)
+
+	case *ast.ArrayLiteral:
+		if node.CreationCount != nil {
+			return c.compileEval(node)
+		}
+		for _, m := range node.Members {
+			if err := c.Compile(m); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(node.Members))
+		return nil
+
+	case *ast.FunctionLiteral:
+		return c.emitNode(OpFunc, node)
+
+	case *ast.IfExpression:
+		return c.compileIf(node)
+
+	case *ast.WhileLoop:
+		if _, ok := node.Condition.(*ast.DiamondExpr); ok {
+			return c.compileEval(node)
+		}
+		return c.compileWhile(node)
+
+	case *ast.ForLoop:
+		return c.compileFor(node)
+
+	case *ast.ForEverLoop:
+		return c.compileForEver(node.Block)
+
+	case *ast.DoLoop:
+		return c.compileForEver(node.Block)
+
+	case *ast.BreakExpression:
+		c.emit(OpBreak)
+		return nil
+
+	case *ast.ContinueExpression:
+		c.emit(OpContinue)
+		return nil
+
+	case *ast.ReturnStatement:
+		for _, v := range node.ReturnValues {
+			if err := c.Compile(v); err != nil {
+				return err
+			}
+		}
+		switch len(node.ReturnValues) {
+		case 0:
+			c.emit(OpNil)
+			c.emit(OpReturn)
+		case 1:
+			c.emit(OpReturn)
+		default:
+			c.emit(OpReturnMulti, len(node.ReturnValues))
+		}
+		return nil
+
+	case *ast.CallExpression:
+		if _, ok := node.Function.(*ast.Identifier); !ok {
+			return c.compileEval(node)
+		}
+		return c.compileCall(node)
+	}
+
+	return c.compileEval(node)
+}
+
+func (c *Compiler) compileBlock(statements []ast.Statement) error {
+	if len(statements) == 0 {
+		c.emit(OpNil)
+		return nil
+	}
+
+	for i, s := range statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		if i < len(statements)-1 {
+			c.emit(OpPop)
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileIf(ie *ast.IfExpression) error {
+	endJumps := []int{}
+	for _, cond := range ie.Conditions {
+		if err := c.Compile(cond.Cond); err != nil {
+			return err
+		}
+		jumpNotTruthy := c.emit(OpJumpNotTruthy, 9999)
+		if err := c.Compile(cond.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(OpJump, 9999))
+		c.changeOperand(jumpNotTruthy, len(c.instructions))
+	}
+
+	if ie.Alternative != nil {
+		if err := c.Compile(ie.Alternative); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpNil)
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.instructions))
+	}
+	return nil
+}
+
+// while (cond) { block }
+// The loop body is evaluated in one scope for all the iterations.
+func (c *Compiler) compileWhile(wl *ast.WhileLoop) error {
+	c.emit(OpPushScope)
+	loop := c.emit(OpLoop, 9999, 9999)
+
+	condPos := len(c.instructions)
+	if err := c.Compile(wl.Condition); err != nil {
+		return err
+	}
+	exitJump := c.emit(OpJumpNotTruthy, 9999)
+	if err := c.Compile(wl.Block); err != nil {
+		return err
+	}
+	c.emit(OpPop)
+	c.emit(OpJump, condPos)
+
+	exitPos := len(c.instructions)
+	c.changeOperand(exitJump, exitPos)
+	c.changeOperands(loop, exitPos, condPos)
+	c.emit(OpLoopEnd)
+	c.emit(OpPopScope)
+	c.emit(OpNil)
+	return nil
+}
+
+// for (init; cond; update) { block }
+// Each iteration of the loop body gets its own scope.
+func (c *Compiler) compileFor(fl *ast.ForLoop) error {
+	c.emit(OpPushScope)
+	if fl.Init != nil {
+		if err := c.Compile(fl.Init); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+	}
+	loop := c.emit(OpLoop, 9999, 9999)
+
+	condPos := len(c.instructions)
+	if err := c.Compile(fl.Cond); err != nil {
+		return err
+	}
+	exitJump := c.emit(OpJumpNotTruthy, 9999)
+
+	c.emit(OpPushScope)
+	if err := c.Compile(fl.Block); err != nil {
+		return err
+	}
+	c.emit(OpPop)
+	c.emit(OpPopScope)
+
+	updatePos := len(c.instructions)
+	if fl.Update != nil {
+		if err := c.Compile(fl.Update); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+	}
+	c.emit(OpJump, condPos)
+
+	exitPos := len(c.instructions)
+	c.changeOperand(exitJump, exitPos)
+	c.changeOperands(loop, exitPos, updatePos)
+	c.emit(OpLoopEnd)
+	c.emit(OpPopScope)
+	c.emit(OpNil)
+	return nil
+}
+
+// for { block } and do { block }
+func (c *Compiler) compileForEver(block *ast.BlockStatement) error {
+	c.emit(OpPushScope)
+	loop := c.emit(OpLoop, 9999, 9999)
+
+	startPos := len(c.instructions)
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	c.emit(OpPop)
+	c.emit(OpJump, startPos)
+
+	exitPos := len(c.instructions)
+	c.changeOperands(loop, exitPos, startPos)
+	c.emit(OpLoopEnd)
+	c.emit(OpPopScope)
+	c.emit(OpNil)
+	return nil
+}
+
+// The callee is resolved at runtime: magpie functions and builtins are called
+// by the vm, everything else(e.g. async functions) falls back to the evaluator,
+// in which case the argument code is skipped.
+func (c *Compiler) compileCall(call *ast.CallExpression) error {
+	idx, err := c.addNode(call)
+	if err != nil {
+		return err
+	}
+	getFunc := c.emit(OpGetFunc, idx, 9999)
+	for _, arg := range call.Arguments {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(OpCall, idx, len(call.Arguments))
+	c.changeOperands(getFunc, idx, len(c.instructions))
+	return nil
+}
+
+func (c *Compiler) compileEval(node ast.Node) error {
+	return c.emitNode(OpEval, node)
+}
+
+func (c *Compiler) addNode(node ast.Node) (int, error) {
+	if len(c.nodes) > math.MaxUint16 {
+		return 0, fmt.Errorf("too many nodes in one function(%s)", node.Pos().Sline())
+	}
+	c.nodes = append(c.nodes, node)
+	return len(c.nodes) - 1, nil
+}
+
+func (c *Compiler) emitNode(op Opcode, node ast.Node) error {
+	idx, err := c.addNode(node)
+	if err != nil {
+		return err
+	}
+	c.emit(op, idx)
+	return nil
+}
+
+func (c *Compiler) emitConstant(obj eval.Object) error {
+	if len(c.constants) > math.MaxUint16 {
+		return fmt.Errorf("too many constants in one function")
+	}
+	c.constants = append(c.constants, obj)
+	c.emit(OpConstant, len(c.constants)-1)
+	return nil
+}
+
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	ins := Make(op, operands...)
+	pos := len(c.instructions)
+	c.instructions = append(c.instructions, ins...)
+	return pos
+}
+
+func (c *Compiler) changeOperand(pos int, operand int) {
+	c.changeOperands(pos, operand)
+}
+
+func (c *Compiler) changeOperands(pos int, operands ...int) {
+	op := Opcode(c.instructions[pos])
+	ins := Make(op, operands...)
+	copy(c.instructions[pos:], ins)
+}
@@ -0,0 +1,346 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgram = `fn add(x, y) {
+    let sum = x + y
+    return sum
+}
+let a = 1
+let b = add(a, 2)
+let arr = [1, [2, 3]]
+println(b)
+`
+
+type dapMessage struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client talks to a server running in its own goroutine.
+type client struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	msgs   chan *dapMessage
+	seq    int
+	output strings.Builder
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, msgs: make(chan *dapMessage, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(inR, outW).Run()
+		outW.Close()
+	}()
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(outR)
+		for {
+			header, err := textproto.NewReader(r).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(header.Get("Content-Length"))
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			var msg dapMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("invalid message %s: %s", data, err)
+				return
+			}
+			c.msgs <- &msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(command string, args interface{}) {
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// expect waits for the event or the response 'name', the output events
+// received in the meantime are collected in 'output'.
+func (c *client) expect(typ, name string) *dapMessage {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("the server exited while waiting for %s %s", typ, name)
+			}
+			if msg.Type == "event" && msg.Event == "output" {
+				var out OutputEvent
+				json.Unmarshal(msg.Body, &out)
+				c.output.WriteString(out.Output)
+			}
+			if msg.Type == typ && (msg.Event == name || msg.Command == name) {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("timeout waiting for %s %s", typ, name)
+		}
+	}
+}
+
+// request sends a request and returns the body of its successful response.
+func (c *client) request(command string, args interface{}, body interface{}) {
+	c.t.Helper()
+	c.send(command, args)
+	resp := c.expect("response", command)
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(resp.Body, body); err != nil {
+			c.t.Fatalf("invalid %s response: %s", command, err)
+		}
+	}
+}
+
+func (c *client) expectStopped(reason string) {
+	c.t.Helper()
+	var ev StoppedEvent
+	json.Unmarshal(c.expect("event", "stopped").Body, &ev)
+	if ev.Reason != reason {
+		c.t.Fatalf("expected to stop on %q, got=%q", reason, ev.Reason)
+	}
+}
+
+// stack returns the frames as 'name:line', innermost first.
+func (c *client) stack() string {
+	c.t.Helper()
+	var body struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]interface{}{"threadId": mainThread}, &body)
+	var frames []string
+	for _, f := range body.StackFrames {
+		frames = append(frames, fmt.Sprintf("%s:%d", f.Name, f.Line))
+	}
+	return strings.Join(frames, " ")
+}
+
+// vars returns the variables of a reference as 'name=value'.
+func (c *client) vars(ref int) (string, map[string]Variable) {
+	c.t.Helper()
+	var body struct {
+		Variables []Variable `json:"variables"`
+	}
+	c.request("variables", map[string]interface{}{"variablesReference": ref}, &body)
+	var vars []string
+	byName := make(map[string]Variable)
+	for _, v := range body.Variables {
+		vars = append(vars, v.Name+"="+v.Value)
+		byName[v.Name] = v
+	}
+	return strings.Join(vars, " "), byName
+}
+
+func (c *client) scopes(frameID int) map[string]int {
+	c.t.Helper()
+	var body struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", map[string]interface{}{"frameId": frameID}, &body)
+	scopes := make(map[string]int)
+	for _, s := range body.Scopes {
+		scopes[s.Name] = s.VariablesReference
+	}
+	return scopes
+}
+
+func (c *client) evaluate(expr string, frameID int) (string, *dapMessage) {
+	c.t.Helper()
+	c.send("evaluate", map[string]interface{}{"expression": expr, "frameId": frameID, "context": "repl"})
+	resp := c.expect("response", "evaluate")
+	var body EvaluateResponse
+	json.Unmarshal(resp.Body, &body)
+	return body.Result, resp
+}
+
+// start launches the program with the breakpoints at 'lines'.
+func (c *client) start(program string, stopOnEntry bool, lines ...int) {
+	c.t.Helper()
+	c.request("initialize", map[string]interface{}{"adapterID": "magpie"}, nil)
+	c.expect("event", "initialized")
+
+	var bps []map[string]int
+	for _, line := range lines {
+		bps = append(bps, map[string]int{"line": line})
+	}
+	var body struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": program}, "breakpoints": bps}, &body)
+	if len(body.Breakpoints) != len(lines) || (len(lines) > 0 && !body.Breakpoints[0].Verified) {
+		c.t.Fatalf("unexpected breakpoints: %+v", body.Breakpoints)
+	}
+	c.request("launch", map[string]interface{}{"program": program, "stopOnEntry": stopOnEntry}, nil)
+	c.request("configurationDone", nil, nil)
+}
+
+// finish waits for the end of the program and disconnects.
+func (c *client) finish(exitCode int) {
+	c.t.Helper()
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	json.Unmarshal(c.expect("event", "exited").Body, &exited)
+	if exited.ExitCode != exitCode {
+		c.t.Errorf("expected exit code %d, got=%d, output: %s", exitCode, exited.ExitCode, c.output.String())
+	}
+	c.expect("event", "terminated")
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		c.t.Errorf("Run: %s", err)
+	}
+}
+
+func writeProgram(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "magpie-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "main.mp")
+	if err := ioutil.WriteFile(fn, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	program := writeProgram(t, testProgram)
+	defer os.RemoveAll(filepath.Dir(program))
+
+	c := newClient(t)
+	c.start(program, false, 6)
+
+	c.expectStopped("breakpoint")
+	if got := c.stack(); got != "main:6" {
+		t.Errorf("expected the stack 'main:6', got=%q", got)
+	}
+
+	c.request("stepIn", map[string]interface{}{"threadId": mainThread}, nil)
+	c.expectStopped("step")
+	if got := c.stack(); got != "add:2 main:6" {
+		t.Errorf("expected the stack 'add:2 main:6', got=%q", got)
+	}
+
+	scopes := c.scopes(0)
+	if got, _ := c.vars(scopes["Locals"]); got != "x=1 y=2" {
+		t.Errorf("expected the locals 'x=1 y=2', got=%q", got)
+	}
+	if got, _ := c.vars(scopes["Globals"]); !strings.Contains(got, "a=1") {
+		t.Errorf("expected the global 'a=1', got=%q", got)
+	}
+	if got, _ := c.evaluate("x + y * 10", 0); got != "21" {
+		t.Errorf("expected 21, got=%q", got)
+	}
+	if got, _ := c.evaluate("a", 1); got != "1" {
+		t.Errorf("expected 1 in the frame 'main', got=%q", got)
+	}
+	if _, resp := c.evaluate("nosuchvar + 1", 0); resp.Success {
+		t.Errorf("expected the evaluation to fail")
+	}
+
+	c.request("next", map[string]interface{}{"threadId": mainThread}, nil)
+	c.expectStopped("step")
+	if got := c.stack(); got != "add:3 main:6" {
+		t.Errorf("expected the stack 'add:3 main:6', got=%q", got)
+	}
+
+	c.request("stepOut", map[string]interface{}{"threadId": mainThread}, nil)
+	c.expectStopped("step")
+	if got := c.stack(); got != "main:7" {
+		t.Errorf("expected the stack 'main:7', got=%q", got)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": mainThread}, nil)
+	c.finish(0)
+	if c.output.String() != "3\n" {
+		t.Errorf("expected the output %q, got=%q", "3\n", c.output.String())
+	}
+}
+
+func TestStopOnEntryAndVariables(t *testing.T) {
+	program := writeProgram(t, testProgram)
+	defer os.RemoveAll(filepath.Dir(program))
+
+	c := newClient(t)
+	c.start(program, true)
+
+	c.expectStopped("entry")
+	if got := c.stack(); got != "main:5" {
+		t.Errorf("expected the stack 'main:5', got=%q", got)
+	}
+	for _, line := range []int{6, 7, 8} { //'next' steps over the call of 'add'
+		c.request("next", map[string]interface{}{"threadId": mainThread}, nil)
+		c.expectStopped("step")
+		if got, expected := c.stack(), fmt.Sprintf("main:%d", line); got != expected {
+			t.Fatalf("expected the stack %q, got=%q", expected, got)
+		}
+	}
+
+	scopes := c.scopes(0)
+	if _, ok := scopes["Locals"]; ok {
+		t.Errorf("expected no locals at the top level, got=%v", scopes)
+	}
+	_, globals := c.vars(scopes["Globals"])
+	arr := globals["arr"]
+	if arr.Value != "[1, [2, 3]]" || arr.VariablesReference == 0 {
+		t.Fatalf("unexpected variable 'arr': %+v", arr)
+	}
+	got, members := c.vars(arr.VariablesReference)
+	if got != "0=1 1=[2, 3]" || members["1"].VariablesReference == 0 {
+		t.Errorf("unexpected members of 'arr': %q", got)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": mainThread}, nil)
+	c.finish(0)
+}
+
+func TestRuntimeError(t *testing.T) {
+	program := writeProgram(t, "let a = 1\nlet b = a + nosuchvar\n")
+	defer os.RemoveAll(filepath.Dir(program))
+
+	c := newClient(t)
+	c.start(program, false)
+	c.finish(1)
+	if !strings.Contains(c.output.String(), "Runtime Error:") {
+		t.Errorf("expected a runtime error, got=%q", c.output.String())
+	}
+
+	c = newClient(t)
+	c.send("stackTrace", map[string]interface{}{"threadId": mainThread})
+	if resp := c.expect("response", "stackTrace"); resp.Success || resp.Message != errNotStopped.Error() {
+		t.Errorf("expected stackTrace to fail when not stopped, got=%+v", resp)
+	}
+	c.send("disconnect", nil)
+	<-c.done
+}
@@ -0,0 +1,485 @@
+package dap
+
+import (
+	"errors"
+	"magpie/ast"
+	"magpie/eval"
+	"magpie/message"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errNotStopped = errors.New("the program is not stopped")
+
+// where the program is: a line of a file, at a call depth of a call stack
+type location struct {
+	stack *eval.CallStack
+	depth int
+	file  string
+	line  int
+}
+
+func (l *location) sameLine(o *location) bool {
+	return l.file == o.file && l.line == o.line
+}
+
+// a stack frame of the stopped program
+type frame struct {
+	name  string
+	file  string
+	line  int
+	scope *eval.Scope
+}
+
+// the variables of a 'variablesReference': the variables of scopes(the
+// inner scope first), or the members of an object.
+type varRef struct {
+	scopes []*eval.Scope
+	obj    eval.Object
+}
+
+// a command for the stopped program's goroutine
+type command struct {
+	kind   string //"continue", "next", "stepIn", "stepOut" or "evaluate"
+	expr   string
+	scope  *eval.Scope
+	result chan evalResult
+}
+
+type evalResult struct {
+	obj eval.Object
+	err error
+}
+
+// the program stopped at a location, waiting for commands
+type stopped struct {
+	loc    location
+	frames []frame //innermost first
+	refs   []varRef
+	cmds   chan command
+}
+
+// debugger is the listener of the evaluator's messages. The goroutine which
+// stops(at a breakpoint, after a step...) blocks in MessageReceived until the
+// client resumes the program, the other goroutines block at their next line.
+type debugger struct {
+	s *Server
+
+	stopMux sync.Mutex //held by the stopped goroutine
+
+	mux         sync.Mutex //guards the fields below
+	breakpoints map[string]map[int]bool
+	stopOnEntry bool
+	pausing     bool
+	step        string    //the step command, "" if not stepping
+	from        location  //where the step started
+	last        *location //where the program stopped last time, or nil
+	stopped     *stopped
+	evalStack   *eval.CallStack //the call stack evaluating an expression for the client
+	detached    bool
+}
+
+func newDebugger(s *Server) *debugger {
+	return &debugger{s: s, breakpoints: make(map[string]map[int]bool)}
+}
+
+func (d *debugger) setBreakpoints(path string, lines []int) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	bps := make(map[int]bool)
+	for _, line := range lines {
+		bps[line] = true
+	}
+	d.breakpoints[absPath(path)] = bps
+}
+
+func (d *debugger) pause() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.pausing = true
+}
+
+// detach lets the program run to its end without stopping.
+func (d *debugger) detach() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.detached = true
+	if st := d.stopped; st != nil {
+		d.stopped = nil
+		st.cmds <- command{kind: "continue"}
+	}
+}
+
+// MessageReceived implements message.MessageListener, it's called by the
+// evaluator in the goroutine of the program.
+func (d *debugger) MessageReceived(msg message.Message) {
+	if msg.Type != message.EVAL_LINE {
+		return
+	}
+	ctx := msg.Body.(eval.Context)
+	node, scope := ctx.N[0], ctx.S
+
+	d.mux.Lock()
+	skip := d.detached || scope.CallStack == d.evalStack
+	d.mux.Unlock()
+	if skip {
+		return
+	}
+	//the evaluator sends the call again when a function ends without 'return'
+	if f := scope.CurrentFrame(); f != nil && f.CurrentCall == node && f.FuncScope == scope {
+		return
+	}
+
+	d.stopMux.Lock()
+	defer d.stopMux.Unlock()
+
+	pos := node.Pos()
+	loc := location{stack: scope.CallStack, depth: len(scope.CallStack.Frames), file: absPath(pos.Filename), line: pos.Line}
+	if reason := d.shouldStop(&loc); reason != "" {
+		d.stop(reason, loc, node, scope)
+	}
+}
+
+// shouldStop returns why the program should stop at 'loc', or "".
+func (d *debugger) shouldStop(loc *location) string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.detached {
+		return ""
+	}
+
+	//a line may be evaluated in many nodes, e.g. 'let x = f(y)', stop only once.
+	again := false
+	if last := d.last; last != nil && last.stack == loc.stack {
+		if last.depth == loc.depth && last.sameLine(loc) {
+			again = true
+		} else if loc.depth <= last.depth {
+			d.last = nil
+		}
+	}
+
+	switch {
+	case d.stopOnEntry:
+		d.stopOnEntry = false
+		return "entry"
+	case d.pausing:
+		d.pausing = false
+		return "pause"
+	}
+
+	if d.step != "" && d.from.stack == loc.stack {
+		from := &d.from
+		stop := false
+		switch d.step {
+		case "next":
+			stop = loc.depth < from.depth || loc.depth == from.depth && !loc.sameLine(from)
+		case "stepIn":
+			stop = loc.depth != from.depth || !loc.sameLine(from)
+		case "stepOut":
+			stop = loc.depth < from.depth
+		}
+		if stop {
+			d.step = ""
+			return "step"
+		}
+	}
+
+	if !again && d.breakpoints[loc.file][loc.line] {
+		return "breakpoint"
+	}
+	return ""
+}
+
+// stop blocks the program's goroutine until the client resumes it.
+func (d *debugger) stop(reason string, loc location, node ast.Node, scope *eval.Scope) {
+	st := &stopped{loc: loc, frames: stackFrames(node, scope), cmds: make(chan command, 1)}
+
+	d.mux.Lock()
+	d.step = ""
+	d.last = &st.loc
+	d.stopped = st
+	d.mux.Unlock()
+
+	d.s.event("stopped", &StoppedEvent{Reason: reason, ThreadID: mainThread, AllThreadsStopped: true})
+	for cmd := range st.cmds {
+		if cmd.kind != "evaluate" {
+			return
+		}
+
+		d.mux.Lock()
+		d.evalStack = cmd.scope.CallStack
+		d.mux.Unlock()
+
+		obj, err := evaluate(cmd.expr, cmd.scope)
+
+		d.mux.Lock()
+		d.evalStack = nil
+		d.mux.Unlock()
+		cmd.result <- evalResult{obj, err}
+	}
+}
+
+// resume continues or steps the stopped program.
+func (d *debugger) resume(kind string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	st := d.stopped
+	if st == nil {
+		return errNotStopped
+	}
+	d.stopped = nil
+	if kind != "continue" {
+		d.step, d.from = kind, st.loc
+	}
+	st.cmds <- command{kind: kind}
+	return nil
+}
+
+func stackFrames(node ast.Node, scope *eval.Scope) []frame {
+	calls := scope.CallStack.Frames
+	pos := node.Pos()
+
+	var frames []frame
+	for i := len(calls) - 1; i >= 0; i-- {
+		c := &calls[i]
+		frames = append(frames, frame{name: c.Name(), file: pos.Filename, line: pos.Line, scope: scope})
+		if c.CurrentCall != nil {
+			pos = c.CurrentCall.Pos()
+		}
+		if i > 0 {
+			scope = calls[i-1].FuncScope
+		} else {
+			scope = rootScope(scope)
+		}
+	}
+	return append(frames, frame{name: "main", file: pos.Filename, line: pos.Line, scope: scope})
+}
+
+// the state of the stopped program, must be called with 'mux' held
+func (d *debugger) current() (*stopped, error) {
+	if d.stopped == nil {
+		return nil, errNotStopped
+	}
+	return d.stopped, nil
+}
+
+func (d *debugger) frame(id int) (*stopped, *frame, error) {
+	st, err := d.current()
+	if err != nil {
+		return nil, nil, err
+	}
+	if id < 0 || id >= len(st.frames) {
+		return nil, nil, errors.New("invalid frame id " + strconv.Itoa(id))
+	}
+	return st, &st.frames[id], nil
+}
+
+func (d *debugger) stackTrace() ([]StackFrame, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	st, err := d.current()
+	if err != nil {
+		return nil, err
+	}
+	frames := []StackFrame{}
+	for i, f := range st.frames {
+		sf := StackFrame{ID: i, Name: f.name, Line: f.line, Column: 1}
+		if f.file != "" {
+			sf.Source = &Source{Name: filepath.Base(f.file), Path: absPath(f.file)}
+		}
+		frames = append(frames, sf)
+	}
+	return frames, nil
+}
+
+// scopes returns the local variables(if the frame isn't at the top level)
+// and the global variables of a frame.
+func (d *debugger) scopes(frameID int) ([]Scope, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	st, f, err := d.frame(frameID)
+	if err != nil {
+		return nil, err
+	}
+	var locals []*eval.Scope
+	s := f.scope
+	for ; s.Parent() != nil; s = s.Parent() {
+		locals = append(locals, s)
+	}
+
+	scopes := []Scope{}
+	if len(locals) > 0 {
+		scopes = append(scopes, Scope{Name: "Locals", VariablesReference: st.ref(varRef{scopes: locals})})
+	}
+	scopes = append(scopes, Scope{Name: "Globals", VariablesReference: st.ref(varRef{scopes: []*eval.Scope{s}})})
+	return scopes, nil
+}
+
+func (d *debugger) variables(ref int) ([]Variable, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	st, err := d.current()
+	if err != nil {
+		return nil, err
+	}
+	if ref <= 0 || ref > len(st.refs) {
+		return nil, errors.New("invalid variables reference " + strconv.Itoa(ref))
+	}
+
+	vars := []Variable{}
+	r := st.refs[ref-1]
+	if r.obj == nil {
+		for _, v := range scopeVars(r.scopes...) {
+			vars = append(vars, st.variable(v.name, v.obj))
+		}
+		return vars, nil
+	}
+
+	switch o := r.obj.(type) {
+	case *eval.Array:
+		for i, m := range o.Members {
+			vars = append(vars, st.variable(strconv.Itoa(i), m))
+		}
+	case *eval.Tuple:
+		for i, m := range o.Members {
+			vars = append(vars, st.variable(strconv.Itoa(i), m))
+		}
+	case *eval.Hash:
+		for _, k := range o.Order {
+			pair := o.Pairs[k]
+			vars = append(vars, st.variable(display(pair.Key), pair.Value))
+		}
+	case *eval.ObjectInstance:
+		for _, v := range scopeVars(o.Scope) {
+			vars = append(vars, st.variable(v.name, v.obj))
+		}
+	case *eval.Struct:
+		for _, v := range scopeVars(o.Scope) {
+			vars = append(vars, st.variable(v.name, v.obj))
+		}
+	}
+	return vars, nil
+}
+
+// evaluate evaluates 'expr' in the scope of a frame. The expression is
+// evaluated by the stopped goroutine, so it sees the program's state
+// without any race.
+func (d *debugger) evaluate(expr string, frameID int) (*EvaluateResponse, error) {
+	d.mux.Lock()
+	st, f, err := d.frame(frameID)
+	d.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan evalResult)
+	st.cmds <- command{kind: "evaluate", expr: expr, scope: f.scope, result: result}
+	r := <-result
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	v := st.variable("", r.obj)
+	return &EvaluateResponse{Result: v.Value, Type: v.Type, VariablesReference: v.VariablesReference}, nil
+}
+
+func evaluate(expr string, scope *eval.Scope) (eval.Object, error) {
+	p, program := eval.Parse("", expr, ".")
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	obj := eval.Eval(program, eval.NewScope(scope, nil))
+	if e, ok := obj.(*eval.Error); ok {
+		return nil, errors.New(strings.TrimSpace(e.Inspect()))
+	}
+	return obj, nil
+}
+
+// variable returns the variable 'name' with the value 'obj'. Arrays, hashes,
+// objects... get a reference for their members.
+func (st *stopped) variable(name string, obj eval.Object) Variable {
+	v := Variable{Name: name, Value: display(obj), Type: string(obj.Type())}
+	n := 0
+	switch o := obj.(type) {
+	case *eval.Array:
+		n = len(o.Members)
+	case *eval.Tuple:
+		n = len(o.Members)
+	case *eval.Hash:
+		n = len(o.Order)
+	case *eval.ObjectInstance:
+		n = len(scopeVars(o.Scope))
+	case *eval.Struct:
+		n = len(scopeVars(o.Scope))
+	}
+	if n > 0 {
+		v.VariablesReference = st.ref(varRef{obj: obj})
+	}
+	return v
+}
+
+func (st *stopped) ref(r varRef) int {
+	st.refs = append(st.refs, r)
+	return len(st.refs)
+}
+
+type namedObject struct {
+	name string
+	obj  eval.Object
+}
+
+// scopeVars returns the variables of 'scopes' sorted by name, a variable
+// of an inner scope hides the variable of an outer scope. The internal
+// variables('@_', 'this', 'parent') are skipped.
+func scopeVars(scopes ...*eval.Scope) []namedObject {
+	seen := make(map[string]bool)
+	var vars []namedObject
+	for _, s := range scopes {
+		for name, obj := range s.Vars() {
+			if seen[name] || strings.HasPrefix(name, "@") || name == "this" || name == "parent" {
+				continue
+			}
+			seen[name] = true
+			vars = append(vars, namedObject{name, obj})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars
+}
+
+func display(obj eval.Object) string {
+	if s, ok := obj.(*eval.String); ok {
+		return strconv.Quote(s.String)
+	}
+	return obj.Inspect()
+}
+
+func rootScope(s *eval.Scope) *eval.Scope {
+	for s.Parent() != nil {
+		s = s.Parent()
+	}
+	return s
+}
+
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,346 @@
+// Package dap implements a debug adapter for magpie, which speaks the Debug
+// Adapter Protocol over a stream(normally stdin/stdout), so editors like
+// VS Code can debug magpie scripts.
+//
+// The adapter launches the program in an interpreter whose debugger sends
+// the evaluator's messages(see package message) to the adapter. It supports
+// breakpoints, stepping, stack traces, variables and evaluation of
+// expressions in a stack frame.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"magpie/eval"
+	"magpie/message"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server is a debug adapter for one debug session. Requests are handled one
+// at a time, in the order they are received, while the program runs in its
+// own goroutine.
+type Server struct {
+	in *bufio.Reader
+
+	outMux sync.Mutex //guards 'out' and 'seq'
+	out    io.Writer
+	seq    int
+
+	launch     *LaunchArguments
+	configured bool               //the client sent 'configurationDone'
+	cancel     context.CancelFunc //stops the program, nil if it isn't started
+
+	dbg *debugger
+}
+
+// NewServer returns a server which reads requests from 'in' and writes
+// responses and events to 'out'.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+	s.dbg = newDebugger(s)
+	return s
+}
+
+// Run serves requests until the client sends 'disconnect' or closes the
+// stream. The program is stopped when Run returns.
+func (s *Server) Run() error {
+	defer s.stop()
+	for {
+		data, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("dap: invalid message: %s", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// Output returns a writer whose data are sent to the client as 'output'
+// events of the given category("stdout" or "stderr").
+func (s *Server) Output(category string) io.Writer {
+	return outputWriter{s, category}
+}
+
+type outputWriter struct {
+	s        *Server
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	if err := w.s.event("output", &OutputEvent{Category: w.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readMessage reads a message with a header like 'Content-Length: 123'.
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("dap: invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// write sends a response or an event, 'seq' is set to the next sequence number.
+func (s *Server) write(seq *int, v interface{}) error {
+	s.outMux.Lock()
+	defer s.outMux.Unlock()
+
+	s.seq++
+	*seq = s.seq
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(data)
+	return err
+}
+
+func (s *Server) reply(req *request, body interface{}) error {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body}
+	return s.write(&resp.Seq, resp)
+}
+
+func (s *Server) replyError(req *request, msg string) error {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: msg}
+	return s.write(&resp.Seq, resp)
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	ev := &event{Type: "event", Event: name, Body: body}
+	return s.write(&ev.Seq, ev)
+}
+
+func (s *Server) handle(req *request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.replyError(req, fmt.Sprint(r))
+		}
+	}()
+
+	switch req.Command {
+	case "initialize":
+		if err := s.reply(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}); err != nil {
+			return err
+		}
+		return s.event("initialized", nil)
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		if args.Program == "" {
+			return s.replyError(req, "missing 'program' in the launch configuration")
+		}
+		if s.launch != nil {
+			return s.replyError(req, "the program is already launched")
+		}
+		s.launch = &args
+		if err := s.reply(req, nil); err != nil {
+			return err
+		}
+		return s.start()
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		var lines []int
+		bps := []Breakpoint{}
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+			bps = append(bps, Breakpoint{Verified: true, Line: bp.Line, Source: &args.Source})
+		}
+		s.dbg.setBreakpoints(args.Source.Path, lines)
+		return s.reply(req, map[string]interface{}{"breakpoints": bps})
+	case "configurationDone":
+		s.configured = true
+		if err := s.reply(req, nil); err != nil {
+			return err
+		}
+		return s.start()
+	case "threads":
+		return s.reply(req, map[string]interface{}{"threads": []Thread{{ID: mainThread, Name: "main"}}})
+
+	case "stackTrace":
+		var args StackTraceArguments
+		json.Unmarshal(req.Arguments, &args)
+		frames, err := s.dbg.stackTrace()
+		if err != nil {
+			return s.replyError(req, err.Error())
+		}
+		total := len(frames)
+		if args.StartFrame > 0 && args.StartFrame <= len(frames) {
+			frames = frames[args.StartFrame:]
+		}
+		if args.Levels > 0 && args.Levels < len(frames) {
+			frames = frames[:args.Levels]
+		}
+		return s.reply(req, map[string]interface{}{"stackFrames": frames, "totalFrames": total})
+	case "scopes":
+		var args ScopesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		scopes, err := s.dbg.scopes(args.FrameID)
+		if err != nil {
+			return s.replyError(req, err.Error())
+		}
+		return s.reply(req, map[string]interface{}{"scopes": scopes})
+	case "variables":
+		var args VariablesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		vars, err := s.dbg.variables(args.VariablesReference)
+		if err != nil {
+			return s.replyError(req, err.Error())
+		}
+		return s.reply(req, map[string]interface{}{"variables": vars})
+	case "evaluate":
+		var args EvaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		frameID := 0
+		if args.FrameID != nil {
+			frameID = *args.FrameID
+		}
+		result, err := s.dbg.evaluate(args.Expression, frameID)
+		if err != nil {
+			return s.replyError(req, err.Error())
+		}
+		return s.reply(req, result)
+
+	case "continue", "next", "stepIn", "stepOut":
+		if err := s.dbg.resume(req.Command); err != nil {
+			return s.replyError(req, err.Error())
+		}
+		return s.reply(req, map[string]interface{}{"allThreadsContinued": true})
+	case "pause":
+		s.dbg.pause()
+		return s.reply(req, nil)
+	case "terminate", "disconnect":
+		s.stop()
+		return s.reply(req, nil)
+	}
+	return s.replyError(req, "unsupported command: "+req.Command)
+}
+
+// start runs the program once the client has sent both 'launch' and
+// 'configurationDone', i.e. the breakpoints are set.
+func (s *Server) start() error {
+	if s.launch == nil || !s.configured || s.cancel != nil {
+		return nil
+	}
+	args := s.launch
+
+	if args.Cwd != "" {
+		if err := os.Chdir(args.Cwd); err != nil {
+			return s.startError(err)
+		}
+	}
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return s.startError(err)
+	}
+	//like 'magpie file.mp args...', the program gets 'args' with 'os.args()'
+	os.Args = append([]string{program}, args.Args...)
+
+	interp := eval.NewInterpreter(s.Output("stdout"))
+	if !args.NoDebug {
+		interp.Dbg = eval.NewDebugger()
+		interp.MsgHandler = message.NewMessageHandler()
+		interp.MsgHandler.AddListener(s.dbg)
+		s.dbg.stopOnEntry = args.StopOnEntry
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		exitCode := 0
+		result, err := interp.RunFileContext(ctx, program)
+		if err == nil {
+			//like 'magpie file.mp', the services keep running until they are stopped
+			eval.WaitServers()
+		}
+		if err != nil {
+			exitCode = 1
+			msg := err.Error() + "\n"
+			if e, ok := result.(*eval.Error); ok {
+				msg = e.Inspect()
+				if len(e.StackTrace) > 1 {
+					msg += "Stack trace:\n" + e.StackTraceString()
+				}
+			}
+			s.event("output", &OutputEvent{Category: "stderr", Output: msg})
+		}
+		s.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+	return nil
+}
+
+func (s *Server) startError(err error) error {
+	if err := s.event("output", &OutputEvent{Category: "stderr", Output: err.Error() + "\n"}); err != nil {
+		return err
+	}
+	return s.event("terminated", nil)
+}
+
+// stop stops the program and its services. The program stops at the next
+// evaluated node, a program blocked in a go function(e.g. reading the stdin)
+// isn't waited for.
+func (s *Server) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.dbg.detach()
+	s.cancel()
+	eval.StopServers(time.Second)
+}
@@ -42,6 +42,8 @@ type Config struct {
 	GenHTML        int    //1: if generate html-style document
 	CssStyle       int    // default css style to use.(See css.go for builtin css styles)
 	CssContents    string //User supplied css file contents for styling generated html file
+	OpenAPI        int    //1: generate the OpenAPI document of the services instead
+	OpenAPIYAML    int    //1: generate the OpenAPI document in YAML format
 }
 
 // File is the documentation for an entire magpie file.
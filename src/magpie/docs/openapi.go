@@ -0,0 +1,507 @@
+package doc
+
+import (
+	"bytes"
+	"encoding/json"
+	"magpie/ast"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI 3 documents of the 'service' statements. The routes come from the
+// '@route' annotations, the summaries and descriptions from the doc comments:
+//
+//	# Returns a user.
+//	#
+//	# @param {int} id the id of the user
+//	# @param verbose show the details
+//	# @return {hash} the user
+//	# @response 404 no such user
+//	@route(url="/users/{id:[0-9]+}", methods=["GET"], queries={"verbose": "{verbose}"})
+//	fn getUser(writer, request) { ... }
+//
+// The request body of a '@body(schema=User)' route is an object with the
+// properties of the class 'User', the '@NotNull' and '@NotEmpty' ones are
+// required. Only literal annotation attributes are documented, the attributes computed
+// at runtime are ignored.
+
+var (
+	regVersion  = regexp.MustCompile(`(?m)^@version\s+(\S+)\s*$`)
+	regResponse = regexp.MustCompile(`(?m)^@response\s+(\d{3})\s*(.*)$`)
+	regYAMLKey  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+)
+
+// OpenAPI is an OpenAPI 3 document.
+type OpenAPI struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Servers []OpenAPIServer                         `json:"servers,omitempty"`
+	Tags    []OpenAPITag                            `json:"tags,omitempty"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"` //path -> method -> operation
+
+	classes map[string]*ast.ClassStatement //the classes of the '@body' schemas
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"` //path, query or header
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPISchema struct {
+	Type        string                    `json:"type,omitempty"`
+	Title       string                    `json:"title,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Pattern     string                    `json:"pattern,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Properties  map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+}
+
+// NewOpenAPI returns the OpenAPI document of all the services of a file. The
+// title is the service's name if there's only one service, or else the file's.
+func NewOpenAPI(name string, program *ast.Program) *OpenAPI {
+	var services []*ast.ServiceStatement
+	for _, statement := range program.Statements {
+		if s, ok := statement.(*ast.ServiceStatement); ok {
+			services = append(services, s)
+		}
+	}
+
+	o := newOpenAPI(strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)))
+	o.setClasses(program)
+	if len(services) == 1 {
+		o.setInfo(services[0])
+	}
+	for _, s := range services {
+		o.Servers = append(o.Servers, OpenAPIServer{URL: serverURL(s.Addr), Description: s.Name.Value})
+		o.addService(s)
+	}
+	return o
+}
+
+// ServiceOpenAPI returns the OpenAPI document of a service. It has no servers,
+// so the clients use the server the document is fetched from. The program is
+// the one of the service, which has the classes of the request bodies, it
+// could be nil.
+func ServiceOpenAPI(s *ast.ServiceStatement, program *ast.Program) *OpenAPI {
+	o := newOpenAPI(s.Name.Value)
+	o.setClasses(program)
+	o.setInfo(s)
+	o.addService(s)
+	return o
+}
+
+func newOpenAPI(title string) *OpenAPI {
+	return &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: title, Version: "1.0.0"},
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+	}
+}
+
+func (o *OpenAPI) setClasses(program *ast.Program) {
+	o.classes = make(map[string]*ast.ClassStatement)
+	if program == nil {
+		return
+	}
+	for _, statement := range program.Statements {
+		if c, ok := statement.(*ast.ClassStatement); ok {
+			o.classes[c.Name.Value] = c
+		}
+	}
+}
+
+// setInfo sets the title, description and version('@version 1.2.0') of the
+// document from a service.
+func (o *OpenAPI) setInfo(s *ast.ServiceStatement) {
+	text := s.Doc.Text()
+	o.Info.Title = s.Name.Value
+	if m := regVersion.FindStringSubmatch(text); m != nil {
+		o.Info.Version = m[1]
+	}
+	o.Info.Description = strings.TrimSpace(regVersion.ReplaceAllString(text, ""))
+}
+
+func (o *OpenAPI) addService(s *ast.ServiceStatement) {
+	o.Tags = append(o.Tags, OpenAPITag{Name: s.Name.Value, Description: firstLine(s.Doc.Text())})
+
+	var names []string
+	for name := range s.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fnStmt := s.Methods[name]
+		anno := ast.ServiceAnnotation(fnStmt)
+		if anno == nil || anno.Name.Value != "route" {
+			continue //@websocket, @middleware, @before and @after
+		}
+		url, ok := literalString(anno.Attributes["url"])
+		if !ok {
+			continue
+		}
+
+		path, op := newOperation(s.Name.Value, fnStmt, anno, url)
+		if body := ast.BodyAnnotation(fnStmt); body != nil {
+			op.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  map[string]*OpenAPIMediaType{"application/json": {Schema: o.classSchema(body.Attributes["schema"])}},
+			}
+			if _, ok := op.Responses["400"]; !ok {
+				op.Responses["400"] = &OpenAPIResponse{Description: "Invalid request body"}
+			}
+		}
+		attrs := anno.Attributes
+		methods := literalStrings(attrs["methods"])
+		if len(methods) == 0 {
+			methods = []string{"GET"} //the route matches all the methods
+		}
+		if o.Paths[path] == nil {
+			o.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			if len(methods) == 1 {
+				o.Paths[path][method] = op
+				continue
+			}
+			//the operation ids must be unique, e.g. 'deleteUserPost'
+			dup := *op
+			dup.OperationID = op.OperationID + strings.Title(method)
+			o.Paths[path][method] = &dup
+		}
+	}
+}
+
+// classSchema returns the object schema of the '@body' schema class, the
+// properties of the class and its parents are its properties.
+func (o *OpenAPI) classSchema(expr ast.Expression) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object"}
+	ident, ok := expr.(*ast.Identifier)
+	if !ok {
+		return schema
+	}
+	schema.Title = ident.Value
+
+	seen := make(map[string]bool)
+	for c := o.classes[ident.Value]; c != nil && !seen[c.Name.Value]; c = o.classes[c.ClassLiteral.Parent] {
+		seen[c.Name.Value] = true
+		for name, p := range c.ClassLiteral.Properties {
+			if _, ok := schema.Properties[name]; ok || p.StaticFlag || len(p.Indexes) > 0 {
+				continue
+			}
+			if schema.Properties == nil {
+				schema.Properties = make(map[string]*OpenAPISchema)
+			}
+			schema.Properties[name] = &OpenAPISchema{Description: firstLine(p.Doc.Text())}
+			for _, anno := range p.Annotations {
+				if anno.Name.Value == "NotNull" || anno.Name.Value == "NotEmpty" {
+					schema.Required = append(schema.Required, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// newOperation returns the OpenAPI path of the url template and its operation.
+func newOperation(service string, fnStmt *ast.FunctionStatement, anno *ast.AnnotationStmt, url string) (string, *OpenAPIOperation) {
+	attrs := anno.Attributes
+	text := fnStmt.Doc.Text()
+	fn := parseFuncComment(fnStmt.Name.Value, text, "")
+	op := &OpenAPIOperation{
+		OperationID: fnStmt.Name.Value,
+		Tags:        []string{service},
+		Responses:   make(map[string]*OpenAPIResponse),
+	}
+	desc := strings.TrimSpace(regResponse.ReplaceAllString(fn.Value.Doc, ""))
+	op.Summary = firstLine(desc)
+	op.Description = strings.TrimSpace(strings.TrimPrefix(desc, op.Summary))
+
+	path, vars := parseURLTemplate(url)
+	for _, v := range vars {
+		op.Parameters = append(op.Parameters, &OpenAPIParameter{Name: v.name, In: "path", Required: true, Schema: varSchema(v)})
+	}
+	for _, loc := range []struct{ attr, in string }{{"queries", "query"}, {"headers", "header"}} {
+		hash, ok := attrs[loc.attr].(*ast.HashLiteral)
+		if !ok {
+			continue
+		}
+		for _, k := range hash.Order {
+			key, ok1 := literalString(k)
+			value, ok2 := literalString(hash.Pairs[k])
+			if !ok1 || !ok2 {
+				continue
+			}
+			schema := &OpenAPISchema{Type: "string"}
+			if _, vars := parseURLTemplate(value); len(vars) == 1 && "{"+vars[0].text+"}" == value {
+				schema = varSchema(vars[0])
+			} else if value != "" { //the value must match exactly
+				schema.Enum = []string{value}
+			}
+			op.Parameters = append(op.Parameters, &OpenAPIParameter{Name: key, In: loc.in, Required: true, Schema: schema})
+		}
+	}
+	for _, param := range fn.Params {
+		for _, p := range op.Parameters {
+			if p.Name == param.Name {
+				p.Description = strings.TrimSpace(param.Desc)
+				if t := schemaType(param.Type); t != "" && p.Schema.Enum == nil {
+					p.Schema.Type = t
+					if t != "string" { //patterns only apply to strings
+						p.Schema.Pattern = ""
+					}
+				}
+			}
+		}
+	}
+
+	ok := &OpenAPIResponse{Description: "OK"}
+	if len(fn.Returns) > 0 {
+		ret := fn.Returns[0]
+		//'@return the user' has no name, the description starts with it
+		if d := strings.TrimSpace(ret.Name + " " + ret.Desc); d != "" {
+			ok.Description = d
+		}
+		if t := schemaType(ret.Type); t != "" {
+			ok.Content = map[string]*OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{Type: t}}}
+		}
+	}
+	op.Responses["200"] = ok
+	for _, m := range regResponse.FindAllStringSubmatch(text, -1) {
+		d := strings.TrimSpace(m[2])
+		if d == "" {
+			d = m[1]
+		}
+		op.Responses[m[1]] = &OpenAPIResponse{Description: d}
+	}
+	return path, op
+}
+
+type urlVar struct {
+	text    string //'name' or 'name:pattern'
+	name    string
+	pattern string
+}
+
+// parseURLTemplate parses the variables of a route's url template, e.g.
+// '/users/{id:[0-9]{1,8}}' returns '/users/{id}' and the variable 'id'.
+func parseURLTemplate(url string) (string, []urlVar) {
+	var path bytes.Buffer
+	var vars []urlVar
+	for i := 0; i < len(url); i++ {
+		if url[i] != '{' {
+			path.WriteByte(url[i])
+			continue
+		}
+		level, end := 0, -1
+		for j := i; j < len(url) && end < 0; j++ {
+			switch url[j] {
+			case '{':
+				level++
+			case '}':
+				if level--; level == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 { //unbalanced braces
+			path.WriteString(url[i:])
+			break
+		}
+		v := urlVar{text: url[i+1 : end]}
+		v.name = v.text
+		if n := strings.IndexByte(v.text, ':'); n >= 0 {
+			v.name, v.pattern = v.text[:n], v.text[n+1:]
+		}
+		vars = append(vars, v)
+		path.WriteString("{" + v.name + "}")
+		i = end
+	}
+	return path.String(), vars
+}
+
+func varSchema(v urlVar) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "string"}
+	if v.pattern != "" { //the router matches the whole value
+		schema.Pattern = "^(?:" + v.pattern + ")$"
+	}
+	return schema
+}
+
+// schemaType returns the OpenAPI type of a doc comment type, e.g. '{int}'.
+func schemaType(t string) string {
+	switch strings.ToLower(t) {
+	case "int", "integer", "uint":
+		return "integer"
+	case "float", "number":
+		return "number"
+	case "bool", "boolean":
+		return "boolean"
+	case "string", "str":
+		return "string"
+	case "array", "list", "tuple":
+		return "array"
+	case "hash", "object", "json":
+		return "object"
+	}
+	return ""
+}
+
+func serverURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr
+}
+
+func literalString(e ast.Expression) (string, bool) {
+	if s, ok := e.(*ast.StringLiteral); ok {
+		return s.Value, true
+	}
+	return "", false
+}
+
+func literalStrings(e ast.Expression) []string {
+	var ret []string
+	if arr, ok := e.(*ast.ArrayLiteral); ok {
+		for _, member := range arr.Members {
+			if s, ok := literalString(member); ok {
+				ret = append(ret, s)
+			}
+		}
+	}
+	return ret
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// JSON returns the document as indented JSON.
+func (o *OpenAPI) JSON() ([]byte, error) {
+	return json.MarshalIndent(o, "", "  ")
+}
+
+// YAML returns the document as YAML.
+func (o *OpenAPI) YAML() ([]byte, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeYAML(&buf, v, "")
+	return buf.Bytes(), nil
+}
+
+// writeYAML writes a decoded JSON value, the strings are JSON-quoted(they're
+// valid YAML double-quoted scalars).
+func writeYAML(buf *bytes.Buffer, v interface{}, indent string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := k
+			if !regYAMLKey.MatchString(k) {
+				key = strconv.Quote(k)
+			}
+			buf.WriteString(indent + key + ":")
+			writeYAMLValue(buf, v[k], indent+"  ")
+		}
+	case []interface{}:
+		for _, member := range v {
+			if m, ok := member.(map[string]interface{}); ok && len(m) > 0 {
+				//the first key goes on the line of the '-'
+				var item bytes.Buffer
+				writeYAML(&item, m, indent+"  ")
+				buf.WriteString(indent + "- ")
+				buf.Write(item.Bytes()[len(indent)+2:])
+				continue
+			}
+			buf.WriteString(indent + "-")
+			writeYAMLValue(buf, member, indent+"  ")
+		}
+	}
+}
+
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAML(buf, val, indent)
+	case []interface{}:
+		if len(val) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAML(buf, val, indent)
+	default:
+		b, _ := json.Marshal(val)
+		buf.WriteString(" " + string(b) + "\n")
+	}
+}
@@ -0,0 +1,168 @@
+package doc
+
+import (
+	"encoding/json"
+	"magpie/lexer"
+	"magpie/parser"
+	"strings"
+	"testing"
+)
+
+const openAPIInput = `# User management API.
+#
+# @version 2.1.0
+service Users on "127.0.0.1:8080:openapi" {
+    # Returns a user.
+    #
+    # Looks the user up by id.
+    # @param {int} id the id of the user
+    # @param verbose show the details
+    # @return {hash} the user
+    # @response 404 no such user
+    @route(url="/users/{id:[0-9]{1,8}}", methods=["GET"], queries={"verbose": "{verbose}", "format": "json"}, headers={"X-Token": ""})
+    fn getUser(writer, request) {
+        return { "id": vars["id"] }
+    }
+
+    //Deletes a user.
+    @route(url="/users/{id}", methods=["DELETE", "POST"])
+    fn deleteUser(writer, request) {
+        return 204
+    }
+
+    @before
+    fn check(writer, request) {}
+}
+`
+
+func parseDoc(t *testing.T, input string) *OpenAPI {
+	parser.FileLines = strings.Split(input, "\n")
+	defer func() { parser.FileLines = nil }()
+	p := parser.NewWithDoc(lexer.New("users.mp", input), "")
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return NewOpenAPI("users.mp", program)
+}
+
+func TestOpenAPI(t *testing.T) {
+	api := parseDoc(t, openAPIInput)
+	if api.Info.Title != "Users" || api.Info.Version != "2.1.0" || api.Info.Description != "User management API." {
+		t.Errorf("unexpected info: %+v", api.Info)
+	}
+	if len(api.Servers) != 1 || api.Servers[0].URL != "http://127.0.0.1:8080" {
+		t.Errorf("unexpected servers: %+v", api.Servers)
+	}
+	if len(api.Paths) != 1 || len(api.Paths["/users/{id}"]) != 3 {
+		t.Fatalf("unexpected paths: %+v", api.Paths)
+	}
+
+	get := api.Paths["/users/{id}"]["get"]
+	if get.OperationID != "getUser" || get.Summary != "Returns a user." || get.Description != "Looks the user up by id." {
+		t.Errorf("unexpected operation: %+v", get)
+	}
+	params, _ := json.Marshal(get.Parameters)
+	expected := `[{"name":"id","in":"path","description":"the id of the user","required":true,"schema":{"type":"integer"}},` +
+		`{"name":"verbose","in":"query","description":"show the details","required":true,"schema":{"type":"string"}},` +
+		`{"name":"format","in":"query","required":true,"schema":{"type":"string","enum":["json"]}},` +
+		`{"name":"X-Token","in":"header","required":true,"schema":{"type":"string"}}]`
+	if string(params) != expected {
+		t.Errorf("unexpected parameters:\n%s", params)
+	}
+	responses, _ := json.Marshal(get.Responses)
+	expected = `{"200":{"description":"the user","content":{"application/json":{"schema":{"type":"object"}}}},"404":{"description":"no such user"}}`
+	if string(responses) != expected {
+		t.Errorf("unexpected responses:\n%s", responses)
+	}
+
+	del, post := api.Paths["/users/{id}"]["delete"], api.Paths["/users/{id}"]["post"]
+	if del.OperationID != "deleteUserDelete" || post.OperationID != "deleteUserPost" || del.Summary != "Deletes a user." {
+		t.Errorf("unexpected operations: %+v %+v", del, post)
+	}
+	if schema := del.Parameters[0].Schema; schema.Type != "string" || schema.Pattern != "" {
+		t.Errorf("unexpected schema: %+v", schema)
+	}
+}
+
+func TestParseURLTemplate(t *testing.T) {
+	tests := []struct {
+		url, path, vars string
+	}{
+		{"/", "/", ""},
+		{"/users/{id}", "/users/{id}", "id:"},
+		{"/d/{year:[0-9]{4}}/{slug}", "/d/{year}/{slug}", "year:[0-9]{4} slug:"},
+		{"/bad/{x", "/bad/{x", ""},
+	}
+	for _, tt := range tests {
+		path, vars := parseURLTemplate(tt.url)
+		var got []string
+		for _, v := range vars {
+			got = append(got, v.name+":"+v.pattern)
+		}
+		if path != tt.path || strings.Join(got, " ") != tt.vars {
+			t.Errorf("%s: expected %q %q, got=%q %q", tt.url, tt.path, tt.vars, path, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestOpenAPIYAML(t *testing.T) {
+	b, err := parseDoc(t, openAPIInput).YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"openapi: \"3.0.3\"\n",
+		"  \"/users/{id}\":\n    delete:\n",
+		"      parameters:\n        - in: \"path\"\n          name: \"id\"\n",
+		"        \"404\":\n          description: \"no such user\"\n",
+		"      tags:\n        - \"Users\"\n",
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("expected %q in:\n%s", expected, b)
+		}
+	}
+}
+
+func TestOpenAPIRequestBody(t *testing.T) {
+	input := `class Person {
+    @NotNull
+    property Age { get; set; }
+}
+
+class User : Person {
+    @NotEmpty
+    property Name { get; set; }
+    # the tags of the user
+    property Tags { get; set; }
+}
+
+service Users on "127.0.0.1:8080" {
+    @route(url="/users", methods=["POST"])
+    @body(schema=User)
+    fn createUser(writer, request) {}
+}
+`
+	api := parseDoc(t, input)
+	op := api.Paths["/users"]["post"]
+	if op == nil || op.RequestBody == nil {
+		t.Fatalf("expected the request body: %+v", api.Paths)
+	}
+	body, _ := json.Marshal(op.RequestBody)
+	expected := `{"required":true,"content":{"application/json":{"schema":{"type":"object","title":"User",` +
+		`"properties":{"Age":{},"Name":{},"Tags":{"description":"the tags of the user"}},"required":["Age","Name"]}}}}`
+	if string(body) != expected {
+		t.Errorf("unexpected request body:\n%s", body)
+	}
+	if _, ok := op.Responses["400"]; !ok {
+		t.Errorf("expected the 400 response: %+v", op.Responses)
+	}
+
+	b, err := api.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "                Age: {}\n") {
+		t.Errorf("unexpected YAML:\n%s", b)
+	}
+}
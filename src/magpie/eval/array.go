@@ -332,7 +332,7 @@ func (a *Array) UnShift(line string, args ...Object) Object {
 
 func (a *Array) Reduce(line string, scope *Scope, args ...Object) Object {
 	l := len(args)
-	if 1 != 2 && l != 1 {
+	if l != 2 && l != 1 {
 		return NewError(line, ARGUMENTERROR, "1|2", l)
 	}
 
@@ -0,0 +1,413 @@
+package eval
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ASYNC_OBJ  = "ASYNC_OBJ"
+	TASK_OBJ   = "TASK"
+	async_name = "async"
+)
+
+// Task is returned by calling an async function. The function runs in its
+// own goroutine, the task is settled with its result, or with the error
+// which stopped it:
+//
+//	async fn fetch(url) { ... }
+//
+//	let t = fetch("http://example.com")   // starts running
+//	let tasks = [fetch(a), fetch(b)]
+//	println(await t)                      // waits for the result, an error is raised here
+//	println(await async.all(tasks))
+//
+// The cancellation is cooperative: 'cancel' settles the task with a
+// 'CancelledError' at once, but the function keeps running until it checks
+// 'async.cancelled()', or until it's waiting in 'await' or 'async.sleep',
+// which return a 'CancelledError' in a cancelled task.
+type Task struct {
+	name     string
+	done     chan struct{} //closed when the task is settled
+	cancelCh chan struct{} //closed when the task is cancelled
+
+	sync.Mutex
+	settled   bool
+	cancelled bool
+	result    Object
+}
+
+func newTask(name string) *Task {
+	return &Task{name: name, done: make(chan struct{}), cancelCh: make(chan struct{})}
+}
+
+// startTask runs 'run' in a new goroutine, on the call stack 'stack'(a call
+// stack of its own, see 'goroutineScope'), and returns the task of it.
+func startTask(name string, stack *CallStack, run func() Object) *Task {
+	t := newTask(name)
+	stack.task = t
+	startGoroutine(stack, func() { t.settle(run()) })
+	return t
+}
+
+// settledTask returns a task which is already settled with 'result'.
+func settledTask(result Object) *Task {
+	t := newTask("")
+	t.settle(result)
+	return t
+}
+
+// settle sets the result of the task, only the first call has effect.
+func (t *Task) settle(result Object) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.settled {
+		return false
+	}
+	if result == nil {
+		result = NIL
+	}
+	t.settled = true
+	t.result = result
+	close(t.done)
+	return true
+}
+
+func (t *Task) cancel(line string) bool {
+	if !t.settle(NewError(line, TASKCANCELLED, t.name)) {
+		return false
+	}
+
+	t.Lock()
+	t.cancelled = true
+	t.Unlock()
+	close(t.cancelCh)
+	return true
+}
+
+func (t *Task) isCancelled() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.cancelled
+}
+
+// outcome returns the result of a settled task. An error is copied, so the
+// awaiters could record their own stack traces.
+func (t *Task) outcome() Object {
+	t.Lock()
+	defer t.Unlock()
+
+	if err, ok := t.result.(*Error); ok {
+		e := *err
+		return &e
+	}
+	return t.result
+}
+
+// wait waits for the task to be settled, and returns its result.
+func (t *Task) wait(line string, scope *Scope) Object {
+	if scope.CallStack.task == t {
+		return NewError(line, TASKERROR, fmt.Sprintf("task '%s' awaits itself", t.name))
+	}
+
+	cancelled, stopped := waitInterrupts(scope)
+	select {
+	case <-t.done:
+		return t.outcome()
+	case <-cancelled:
+		return NewError(line, TASKCANCELLED, scope.CallStack.task.name)
+	case <-stopped:
+		return scope.CallStack.limits.stopError(line)
+	}
+}
+
+// waitInterrupts returns the channels which interrupt the waits of the code
+// running in 'scope': the cancellation of its task, and the stop of the
+// program by its limits. A channel is nil if there is nothing to wait for.
+func waitInterrupts(scope *Scope) (cancelled, stopped <-chan struct{}) {
+	if t := scope.CallStack.task; t != nil {
+		cancelled = t.cancelCh
+	}
+	if l := scope.CallStack.limits; l != nil {
+		stopped = l.done
+	}
+	return
+}
+
+func (t *Task) Inspect() string  { return fmt.Sprintf("task<%s>", t.name) }
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "result":
+		return t.Result(line, scope, args...)
+	case "cancel":
+		return t.Cancel(line, args...)
+	case "isDone":
+		return t.IsDone(line, args...)
+	case "isCancelled":
+		return t.IsCancelled(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, t.Type())
+}
+
+// Result is the same as 'await task'.
+func (t *Task) Result(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return t.wait(line, scope)
+}
+
+// Cancel cancels the task, and returns false if it's already settled.
+func (t *Task) Cancel(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return NewBooleanObj(t.cancel(line))
+}
+
+func (t *Task) IsDone(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	select {
+	case <-t.done:
+		return TRUE
+	default:
+		return FALSE
+	}
+}
+
+func (t *Task) IsCancelled(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return NewBooleanObj(t.isCancelled())
+}
+
+// Async is the 'async' module, the combinators of the tasks. The arguments
+// which aren't tasks are taken as settled tasks.
+//
+//	let results = await async.all([fetch(a), fetch(b)])  // all the results, or the first error
+//	let first   = await async.any([fetch(a), fetch(b)])  // the first result which isn't an error
+//	let settled = await async.race([fetch(a), fetch(b)]) // the first result or error
+//	let r = await async.withTimeout(fetch(a), 2.5)       // a 'TimeoutError' after 2.5 seconds
+//
+//	async fn worker() {
+//	    while !async.cancelled() {
+//	        ...
+//	        async.sleep(0.1)
+//	    }
+//	}
+//
+// The combinators return tasks, which don't cancel the tasks they wait for,
+// except for 'withTimeout', which cancels the task on timeout.
+type Async struct{}
+
+func NewAsyncObj() Object {
+	ret := &Async{}
+	SetGlobalObj(async_name, ret)
+	return ret
+}
+
+func (a *Async) Inspect() string  { return "<" + async_name + ">" }
+func (a *Async) Type() ObjectType { return ASYNC_OBJ }
+
+func (a *Async) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "all":
+		return a.All(line, scope, args...)
+	case "any":
+		return a.Any(line, scope, args...)
+	case "race":
+		return a.Race(line, scope, args...)
+	case "withTimeout":
+		return a.WithTimeout(line, scope, args...)
+	case "sleep":
+		return a.Sleep(line, scope, args...)
+	case "cancelled":
+		return a.Cancelled(line, scope, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, a.Type())
+}
+
+// All returns a task of the array of the results of the tasks, or of the
+// first error.
+func (a *Async) All(line string, scope *Scope, args ...Object) Object {
+	tasks, errObj := taskArgs(line, "all", args...)
+	if errObj != nil {
+		return errObj
+	}
+
+	return combineTasks("all", scope, tasks, func(order <-chan int) Object {
+		results := make([]Object, len(tasks))
+		for range tasks {
+			i := <-order
+			r := tasks[i].outcome()
+			if r.Type() == ERROR_OBJ {
+				return r
+			}
+			results[i] = r
+		}
+		return &Array{Members: results}
+	})
+}
+
+// Any returns a task of the first result which isn't an error. If all the
+// tasks fail, it's the error of the last one to fail.
+func (a *Async) Any(line string, scope *Scope, args ...Object) Object {
+	tasks, errObj := taskArgs(line, "any", args...)
+	if errObj != nil {
+		return errObj
+	}
+	if len(tasks) == 0 {
+		return NewError(line, TASKERROR, "'any' needs at least one task")
+	}
+
+	return combineTasks("any", scope, tasks, func(order <-chan int) Object {
+		var r Object
+		for range tasks {
+			r = tasks[<-order].outcome()
+			if r.Type() != ERROR_OBJ {
+				break
+			}
+		}
+		return r
+	})
+}
+
+// Race returns a task of the first result or error.
+func (a *Async) Race(line string, scope *Scope, args ...Object) Object {
+	tasks, errObj := taskArgs(line, "race", args...)
+	if errObj != nil {
+		return errObj
+	}
+	if len(tasks) == 0 {
+		return NewError(line, TASKERROR, "'race' needs at least one task")
+	}
+
+	return combineTasks("race", scope, tasks, func(order <-chan int) Object {
+		return tasks[<-order].outcome()
+	})
+}
+
+// WithTimeout(task, seconds) returns a task of the result of 'task', or of a
+// 'TimeoutError' if 'task' isn't settled in time. Then 'task' is cancelled.
+// Cancelling the returned task cancels 'task' too.
+func (a *Async) WithTimeout(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "2", len(args))
+	}
+
+	task, ok := args[0].(*Task)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "withTimeout", "*Task", args[0].Type())
+	}
+	secs, ok := toFloat64(args[1])
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "second", "withTimeout", "*Integer|*Float", args[1].Type())
+	}
+	if secs < 0 {
+		return NewError(line, INVALIDARG)
+	}
+
+	t := newTask(task.name)
+	go func() {
+		timer := time.NewTimer(time.Duration(secs * float64(time.Second)))
+		defer timer.Stop()
+
+		select {
+		case <-task.done:
+			t.settle(task.outcome())
+		case <-timer.C:
+			task.cancel(line)
+			t.settle(NewError(line, TASKTIMEOUT, task.name, secs))
+		case <-t.cancelCh:
+			task.cancel(line)
+		}
+	}()
+	return t
+}
+
+// Sleep(seconds) sleeps like 'time.sleep', but it's interrupted by the
+// cancellation of the current task, then it returns a 'CancelledError'.
+func (a *Async) Sleep(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	secs, ok := toFloat64(args[0])
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "sleep", "*Integer|*Float", args[0].Type())
+	}
+
+	timer := time.NewTimer(time.Duration(secs * float64(time.Second)))
+	defer timer.Stop()
+
+	cancelled, stopped := waitInterrupts(scope)
+	select {
+	case <-timer.C:
+		return NIL
+	case <-cancelled:
+		return NewError(line, TASKCANCELLED, scope.CallStack.task.name)
+	case <-stopped:
+		return scope.CallStack.limits.stopError(line)
+	}
+}
+
+// Cancelled reports whether the current task is cancelled, it's false
+// outside of the tasks.
+func (a *Async) Cancelled(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	t := scope.CallStack.task
+	return NewBooleanObj(t != nil && t.isCancelled())
+}
+
+// taskArgs returns the tasks of an array or a tuple argument.
+func taskArgs(line string, method string, args ...Object) ([]*Task, Object) {
+	if len(args) != 1 {
+		return nil, NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	var members []Object
+	switch arg := args[0].(type) {
+	case *Array:
+		members = arg.Members
+	case *Tuple:
+		members = arg.Members
+	default:
+		return nil, NewError(line, PARAMTYPEERROR, "first", method, "*Array|*Tuple", args[0].Type())
+	}
+
+	tasks := make([]*Task, len(members))
+	for i, m := range members {
+		t, ok := m.(*Task)
+		if !ok {
+			t = settledTask(m)
+		}
+		tasks[i] = t
+	}
+	return tasks, nil
+}
+
+// combineTasks returns a task of 'combine', which is given the indexes of
+// 'tasks' in the order they're settled. It stops reading the indexes when it
+// has the result.
+func combineTasks(name string, scope *Scope, tasks []*Task, combine func(order <-chan int) Object) *Task {
+	order := make(chan int, len(tasks))
+	for i, t := range tasks {
+		go func(i int, t *Task) {
+			<-t.done
+			order <- i
+		}(i, t)
+	}
+
+	gs := goroutineScope(scope)
+	return startTask(name, gs.CallStack, func() Object { return combine(order) })
+}
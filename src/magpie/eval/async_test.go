@@ -0,0 +1,123 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const asyncInput = `
+async fn add(a, b) { a + b }
+async fn slow(v, secs) { async.sleep(secs); return v }
+async fn fail(msg) { throw new IOError(msg) }
+async fn divide(a) { return a / 0 }
+
+class Calc {
+    async fn double(x) { return x * 2 }
+}
+`
+
+func TestAsyncTasks(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`await add(1, 2)`, "3"},
+		{`add(3, 4)`, "task<add>"},
+		{`type(add(3, 4))`, "TASK"},
+		{`let t = add(3, 4); let r = await t; r + " " + t.isDone() + " " + t.result()`, "7 true 7"},
+		{`let ts = [add(1, 1), slow(2, 0.02)]; let a = await ts[0]; a + await ts[1]`, "4"},
+		{`let c = new Calc(); await c.double(21)`, "42"},
+		{`let c = new Calc(); let t = c.double(5); await t`, "10"},
+		{`await 5`, "5"},
+
+		//the errors travel to the awaiter
+		{`let r = ""; try { await fail("boom") } catch (e: IOError) { r = e.message }; r`, "boom"},
+		{`let r = ""; let t = divide(1); try { t.result() } catch (e: DivideByZeroError) { r = "caught" }; r`, "caught"},
+
+		//the combinators
+		{`await async.all([slow(1, 0.03), slow(2, 0.01), 3])`, "[1, 2, 3]"},
+		{`await async.all(())`, "[]"},
+		{`await async.race([slow("slow", 0.2), slow("fast", 0.01)])`, "fast"},
+		{`await async.any([fail("x"), slow("ok", 0.02)])`, "ok"},
+		{`let r = ""; try { await async.any([fail("x"), fail("y")]) } catch (e: IOError) { r = "caught" }; r`, "caught"},
+		{`let r = ""; try { await async.all([slow(1, 0.2), fail("first")]) } catch (e: IOError) { r = e.message }; r`, "first"},
+		{`await async.withTimeout(slow(1, 0.01), 1)`, "1"},
+		{`let t = slow(1, 1); let r = ""; try { await async.withTimeout(t, 0.02) } catch (e: TimeoutError) { r = e.message }; r + " " + t.isCancelled()`,
+			"task 'slow' timed out after 0.02 second(s) true"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run(asyncInput + strings.Replace(tt.input, "; ", "\n", -1))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestAsyncCancel(t *testing.T) {
+	input := `
+let loops = 0
+async fn worker() {
+    while !async.cancelled() {
+        loops++
+        async.sleep(0.01)
+    }
+    return "stopped"
+}
+
+async fn waiter(t) { return await t }
+
+let w = worker()
+let wt = waiter(slow(1, 5))
+async.sleep(0.05)
+let r = [w.cancel(), w.isCancelled(), w.cancel(), wt.cancel()]
+try { await w } catch (e: CancelledError) { r.push(e.message) }
+r.push(loops > 0)
+r.push(async.cancelled())
+r
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	result, err := interp.Run(asyncInput + input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[true, true, false, true, "task 'worker' was cancelled", true, false]`
+	if result.Inspect() != expected {
+		t.Errorf("expected %s, got=%s", expected, result.Inspect())
+	}
+}
+
+func TestAsyncErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`async.all(1)`, "first argument for 'all' should be type *Array|*Tuple"},
+		{`async.race([])`, "'race' needs at least one task"},
+		{`async.withTimeout(1, 1)`, "first argument for 'withTimeout' should be type *Task"},
+		{`async.withTimeout(add(1, 2), "1")`, "second argument for 'withTimeout' should be type *Integer|*Float"},
+		{`add(1, 2).result(1)`, "wrong number of arguments"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(asyncInput + tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+// A program stopped by its limits must not hang in 'await'.
+func TestAsyncAwaitLimits(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	interp.Limits = Limits{Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := interp.Run(asyncInput + `await slow(1, 10)`)
+	if e, ok := err.(*Error); !ok || e.Kind != EXECLIMITERROR {
+		t.Errorf("expected an EXECLIMITERROR, got=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the await to be stopped, took %v", elapsed)
+	}
+}
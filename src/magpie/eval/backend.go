@@ -0,0 +1,91 @@
+package eval
+
+import (
+	"magpie/ast"
+)
+
+// The functions in this file expose parts of the tree-walking evaluator to
+// alternative execution backends (see package 'vm'). A backend lowers the
+// nodes it understands itself, and hands everything else back to the
+// evaluator, so both backends share the same semantics.
+
+// EvalImports loads the imports of 'program'. It must be called once,
+// before any statement of the program is executed.
+func EvalImports(program *ast.Program, scope *Scope) Object {
+	return loadImports(program.Imports, scope)
+}
+
+// EvalIdentifier resolves an identifier the same way 'Eval' does.
+func EvalIdentifier(i *ast.Identifier, scope *Scope) Object {
+	return evalIdentifier(i, scope)
+}
+
+// EvalInfix applies the infix operator of 'node' to the already evaluated operands.
+func EvalInfix(node *ast.InfixExpression, left, right Object, scope *Scope) Object {
+	return evalInfixExpression(node, left, right, scope)
+}
+
+// EvalPrefix applies the prefix operator of 'node' to the already evaluated operand.
+func EvalPrefix(node *ast.PrefixExpression, right Object, scope *Scope) Object {
+	return evalPrefixValue(node, right, scope)
+}
+
+// EvalAssign assigns the already evaluated 'val' to the left hand side of 'node'.
+func EvalAssign(node *ast.AssignExpression, val Object, scope *Scope) Object {
+	return evalAssignValue(node, val, scope)
+}
+
+// NewFunction creates a function object(closure) for the function literal.
+func NewFunction(fl *ast.FunctionLiteral, scope *Scope) Object {
+	return evalFunctionLiteral(fl, scope)
+}
+
+// LookupBuiltin returns the builtin function registered as 'name'.
+func LookupBuiltin(name string) (*Builtin, bool) {
+	b, ok := builtins[name]
+	return b, ok
+}
+
+// EnterFunction creates the scope for calling 'f' with 'args' from 'scope', and
+// pushes a frame for the call to the call stack. The caller must call LeaveFunction
+// with the returned scope after the function body finished.
+func EnterFunction(f *Function, call *ast.CallExpression, args []Object, scope *Scope) *Scope {
+	return enterFunction(f, call, args, scope)
+}
+
+// CheckLimits returns an error if the program running in 'scope' went over the
+// limits set by 'WithLimits', otherwise nil. 'node' is used for the error's line
+// number and may be nil.
+func CheckLimits(node ast.Node, scope *Scope) Object {
+	return checkLimits(node, scope)
+}
+
+// CheckCallDepth returns an error if calling a function from 'scope' would go
+// over the maximum call depth, otherwise nil.
+func CheckCallDepth(call *ast.CallExpression, scope *Scope) Object {
+	return checkCallDepth(call, scope)
+}
+
+// LeaveFunction runs the defers of the function frame and pops it.
+func LeaveFunction(funcScope *Scope) {
+	leaveFunction(funcScope)
+}
+
+// UnwrapReturnValue returns the value a function call evaluates to when its
+// body returned 'rv'.
+func UnwrapReturnValue(rv *ReturnValue) Object {
+	return unwrapReturnValue(rv)
+}
+
+// TraceError records the stack trace of 'obj' if it's a runtime error which
+// occurred in 'scope'. It's called when the error leaves a function.
+func TraceError(obj Object, scope *Scope) {
+	traceError(obj, scope)
+}
+
+// CallGenerator calls the generator function 'f', i.e. a function whose body
+// contains 'yield'. It returns the generator, whose body is always run by the
+// tree-walking evaluator.
+func CallGenerator(f *Function, call *ast.CallExpression, args []Object, scope *Scope) Object {
+	return callGenerator(f, call, args, scope)
+}
@@ -0,0 +1,223 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// Request body binding of the service functions:
+//
+//	class User {
+//	    @NotEmpty property Name
+//	    @NotNull property Age
+//	}
+//
+//	@route(url="/users", methods=["POST"])
+//	@body(schema=User)
+//	fn create(writer, request) {
+//	    return {"name": body.Name}, 201
+//	}
+//
+// The json body of the request is unmarshaled into a 'User' instance, which
+// is the 'body' variable of the function. The function isn't called if the
+// body is not valid json or the validation of '@NotNull'/'@NotEmpty' fails,
+// the response is '400 Bad Request' with a json error instead.
+//
+// The body is limited to 'maxRequestBody' bytes, '@body(schema=User, maxSize=1024)'
+// changes the limit, a larger body gets '413 Request Entity Too Large'.
+
+// maxRequestBody is the default size limit of a request body.
+const maxRequestBody = 10 << 20
+
+// bindingError is the json of the 400 response of a bad request body.
+type bindingError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// readRequestBody reads the whole body of the request, at most 'limit' bytes,
+// and puts the bytes back, so the body could be read again, e.g. by the route
+// function after the binding. 'w' could be nil.
+func readRequestBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	r.Body.Close()
+	if err != nil {
+		r.Body = errorBody{err} //don't hand out a truncated body on the next read
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// errorBody is the request body after a failed read, it returns the error
+// of that read.
+type errorBody struct{ err error }
+
+func (e errorBody) Read([]byte) (int, error) { return 0, e.err }
+func (e errorBody) Close() error             { return nil }
+
+// BodyMiddleware returns the middleware of '@body(schema=cls)', it binds the
+// request body to a new instance of 'cls' before the route function runs.
+// The body is limited to 'maxSize' bytes.
+func BodyMiddleware(line string, scope *Scope, cls *Class, maxSize int64) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := readRequestBody(w, r, maxSize)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					writeBindingStatus(w, http.StatusRequestEntityTooLarge, bindingError{Error: "invalid body: " + err.Error()})
+					return
+				}
+				writeBindingError(w, bindingError{Error: "invalid body: " + err.Error()})
+				return
+			}
+			val, err := parseJson(b)
+			if err != nil {
+				writeBindingError(w, bindingError{Error: "invalid JSON body: " + err.Error()})
+				return
+			}
+			hash, ok := val.(*Hash)
+			if !ok {
+				writeBindingError(w, bindingError{Error: "invalid JSON body: expect a JSON object"})
+				return
+			}
+
+			instance, errObj := bindObject(line, scope, cls, hash)
+			if errObj != nil { //e.g. a setter rejects the value
+				writeBindingError(w, bindingError{Error: "invalid body: " + errObj.Message})
+				return
+			}
+			if fields := validateObject(line, scope, instance); len(fields) > 0 {
+				writeBindingError(w, bindingError{Error: "validation failed", Fields: fields})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), bodyKey, instance)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestBody returns the bound body of the request, or nil if the route
+// has no '@body' annotation.
+func requestBody(r *http.Request) Object {
+	if rv := r.Context().Value(bodyKey); rv != nil {
+		return rv.(Object)
+	}
+	return nil
+}
+
+func writeBindingError(w http.ResponseWriter, e bindingError) {
+	writeBindingStatus(w, http.StatusBadRequest, e)
+}
+
+func writeBindingStatus(w http.ResponseWriter, status int, e bindingError) {
+	res, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// classProperties returns the non-static properties of the class and its
+// parents, the names are sorted.
+func classProperties(cls *Class) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for c := cls; c != nil; c = c.Parent {
+		for name, p := range c.Properties {
+			if p.StaticFlag || len(p.Indexes) > 0 || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bindObject creates an instance of 'cls' from the hash, the keys are the
+// property names, the other keys are ignored. The 'init' method of the class
+// is not called, the missing properties are nil or their default values.
+func bindObject(line string, scope *Scope, cls *Class, hash *Hash) (*ObjectInstance, *Error) {
+	instance := newObjectInstance(cls, scope)
+	for _, name := range classProperties(cls) {
+		p := cls.GetProperty(name)
+
+		var val Object
+		if pair, ok := hash.Pairs[NewString(name).HashKey()]; ok {
+			val = pair.Value
+		} else if p.Default != nil {
+			val = Eval(p.Default, instance.Scope)
+			if err, ok := val.(*Error); ok {
+				return nil, err
+			}
+		} else {
+			val = NIL
+		}
+
+		if p.Setter == nil || len(p.Setter.Body.Statements) == 0 {
+			instance.Scope.Set("_"+name, val)
+		} else {
+			newScope := NewScope(instance.Scope, nil)
+			newScope.Set("value", val)
+			if err, ok := Eval(p.Setter.Body, newScope).(*Error); ok {
+				return nil, err
+			}
+		}
+	}
+	return instance, nil
+}
+
+// validateObject checks the '@NotNull' and '@NotEmpty' properties of the
+// instance, it returns the error messages of the invalid properties.
+func validateObject(line string, scope *Scope, instance *ObjectInstance) map[string]string {
+	fields := make(map[string]string)
+	for _, name := range classProperties(instance.Class) {
+		p := instance.GetProperty(name)
+		for _, anno := range p.Annotations {
+			annoCls, _ := scope.Get(anno.Name.Value)
+			if annoCls != NOTNULL_ANNOCLASS && annoCls != NOTEMPTY_ANNOCLASS {
+				continue
+			}
+
+			var val Object
+			if p.Getter != nil {
+				val = (&PropertyInfo{Name: name, Instance: instance}).Value(line)
+			}
+			if val == nil || val.Type() == NIL_OBJ {
+				fields[name] = "must not be null"
+				break
+			}
+			if annoCls == NOTEMPTY_ANNOCLASS && isEmptyObject(val) {
+				fields[name] = "must not be empty"
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// isEmptyObject reports whether the string, array, tuple or hash is empty.
+func isEmptyObject(val Object) bool {
+	switch v := val.(type) {
+	case *String:
+		return len(v.String) == 0
+	case *Array:
+		return len(v.Members) == 0
+	case *Tuple:
+		return len(v.Members) == 0
+	case *Hash:
+		return len(v.Pairs) == 0
+	}
+	return false
+}
@@ -207,7 +207,7 @@ func newFileBuiltin(funcName string) *Builtin {
 			if err != nil {
 				return NewNil(err.Error())
 			}
-			return &FileObject{File: f, Name: "<file object: " + fname.String + ">"}
+			return &FileObject{File: f, Name: fname.String}
 		},
 	}
 }
@@ -650,7 +650,7 @@ func printBuiltin() *Builtin {
 				return NewInteger(int64(n))
 			}
 
-			format, wrapped := correctPrintResult(false, args...)
+			format, wrapped := correctPrintResult(scope.interp.replColor(), false, args...)
 			n, err := fmt.Fprintf(scope.Writer, format, wrapped...)
 
 			//Note, here we do not use 'fmt.Print', why? please see correctPrintResult() comments.
@@ -678,7 +678,7 @@ func printlnBuiltin() *Builtin {
 			//Note, here we do not use 'fmt.Println', why? please see correctPrintResult() comments.
 			//n, err := fmt.Println(s, wrapped...)
 
-			format, wrapped := correctPrintResult(true, args...)
+			format, wrapped := correctPrintResult(scope.interp.replColor(), true, args...)
 			n, err := fmt.Fprintf(scope.Writer, format, wrapped...)
 			if err != nil {
 				return NewNil(err.Error())
@@ -704,12 +704,12 @@ func printfBuiltin() *Builtin {
 			subArgs := args[1:]
 			wrapped := make([]interface{}, len(subArgs))
 			for i, v := range subArgs {
-				wrapped[i] = &Formatter{Obj: v}
+				wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 			}
 
 			formatStr := formatObj.String
 			if len(subArgs) == 0 {
-				if REPLColor {
+				if scope.interp.replColor() {
 					formatStr = "\033[1;" + colorMap["STRING"] + "m" + formatStr + "\033[0m"
 				}
 			}
@@ -739,12 +739,12 @@ func sprintfBuiltin() *Builtin {
 			subArgs := args[1:]
 			wrapped := make([]interface{}, len(subArgs))
 			for i, v := range subArgs {
-				wrapped[i] = &Formatter{Obj: v}
+				wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 			}
 
 			formatStr := formatObj.String
 			if len(subArgs) == 0 {
-				if REPLColor {
+				if scope.interp.replColor() {
 					formatStr = "\033[1;" + colorMap["STRING"] + "m" + formatStr + "\033[0m"
 				}
 			}
@@ -790,7 +790,7 @@ func sscanfBuiltin() *Builtin {
 
 			formatStr := formatObj.String
 			if len(subArgs) == 0 {
-				if REPLColor {
+				if scope.interp.replColor() {
 					formatStr = "\033[1;" + colorMap["STRING"] + "m" + formatStr + "\033[0m"
 				}
 			}
@@ -1482,9 +1482,11 @@ func instanceOfBuiltin() *Builtin {
 				return nativeBoolToBooleanObject(InstanceOf(class.String, instance))
 			case *Class:
 				return nativeBoolToBooleanObject(InstanceOf(class.Name, instance))
+			case *Interface:
+				return nativeBoolToBooleanObject(InstanceOf(class.Name, instance))
 			}
 
-			return NewError(line, GENERICERROR, "is_a/instanceOf expected a class or string for second argument")
+			return NewError(line, GENERICERROR, "is_a/instanceOf expected a class, interface or string for second argument")
 		},
 	}
 }
@@ -27,6 +27,14 @@ func (c *ChanObject) CallMethod(line string, scope *Scope, method string, args .
 		return c.Recv(line, args...)
 	case "close":
 		return c.Close(line, args...)
+	case "trySend":
+		return c.TrySend(line, args...)
+	case "tryRecv":
+		return c.TryRecv(line, args...)
+	case "len":
+		return c.Len(line, args...)
+	case "cap":
+		return c.Cap(line, args...)
 	default:
 		return NewError(line, NOMETHODERROR, method, c.Type())
 	}
@@ -37,10 +45,46 @@ func (c *ChanObject) Send(line string, args ...Object) Object {
 		return NewError(line, ARGUMENTERROR, "1", len(args))
 	}
 
-	c.ch <- args[0]
+	if _, err := c.send(args[0], true); err != nil {
+		return NewError(line, CHANNELERROR, err.Error())
+	}
 	return NIL
 }
 
+//trySend(value) sends the value if it doesn't block, and returns true if it's sent
+func (c *ChanObject) TrySend(line string, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	sent, err := c.send(args[0], false)
+	if err != nil {
+		return NewError(line, CHANNELERROR, err.Error())
+	}
+	return NewBooleanObj(sent)
+}
+
+//sending on a closed channel is an error instead of a panic
+func (c *ChanObject) send(obj Object, block bool) (sent bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	if block {
+		c.ch <- obj
+		return true, nil
+	}
+
+	select {
+	case c.ch <- obj:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
 func (c *ChanObject) Recv(line string, args ...Object) Object {
 	if len(args) != 0 {
 		return NewError(line, ARGUMENTERROR, "0", len(args))
@@ -51,6 +95,39 @@ func (c *ChanObject) Recv(line string, args ...Object) Object {
 	return obj
 }
 
+//tryRecv() returns the value and true if a value is received without blocking,
+//or nil and false, e.g. 'let v, ok = ch.tryRecv()'.
+func (c *ChanObject) TryRecv(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	select {
+	case obj, ok := <-c.ch:
+		if ok {
+			return &Tuple{Members: []Object{obj, TRUE}, IsMulti: true}
+		}
+	default:
+	}
+	return &Tuple{Members: []Object{NIL, FALSE}, IsMulti: true}
+}
+
+//len() returns the number of the values queued in the channel's buffer
+func (c *ChanObject) Len(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return NewInteger(int64(len(c.ch)))
+}
+
+//cap() returns the size of the channel's buffer
+func (c *ChanObject) Cap(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return NewInteger(int64(cap(c.ch)))
+}
+
 func (c *ChanObject) Close(line string, args ...Object) Object {
 	if len(args) != 0 {
 		return NewError(line, ARGUMENTERROR, "0", len(args))
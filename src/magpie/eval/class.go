@@ -3,10 +3,12 @@ package eval
 import (
 	_ "fmt"
 	"magpie/ast"
+	"sort"
 )
 
 const (
 	CLASS_OBJ        = "CLASS_OBJ"
+	INTERFACE_OBJ    = "INTERFACE_OBJ"
 	INSTANCE_OBJ     = "INSTANCE_OBJ"
 	METHODINFO_OBJ   = "METHODINFO_OBJ"
 	PROPERTYINFO_OBJ = "PROPERTYINFO_OBJ"
@@ -34,7 +36,8 @@ type Class struct {
 	Methods      map[string]ClassMethod //BuiltinMethod or Function object
 	Properties   map[string]*ast.PropertyDeclStmt
 	Scope        *Scope
-	IsAnnotation bool //true if the class is an annotation class
+	IsAnnotation bool         //true if the class is an annotation class
+	Interfaces   []*Interface //interfaces implemented by the class
 }
 
 func (c *Class) Inspect() string { return "<class:" + c.Name + ">" }
@@ -220,6 +223,31 @@ var NOTEMPTY_ANNOCLASS = &Class{
 	IsAnnotation: true,
 }
 
+//The annotation classes of 'magpie test'. They are not builtin classes, the
+//test runner defines them in the global scope of the test files, so the
+//classes named 'Test' of the other programs are not hidden.
+
+//@Test marks a test function, '@Test(skip="reason")' skips it.
+var TEST_ANNOCLASS = &Class{
+	Name:         "Test",
+	Parent:       BASE_CLASS,
+	IsAnnotation: true,
+}
+
+//@Setup marks a function which runs before each test of the file.
+var SETUP_ANNOCLASS = &Class{
+	Name:         "Setup",
+	Parent:       BASE_CLASS,
+	IsAnnotation: true,
+}
+
+//@Teardown marks a function which runs after each test of the file, even if the test failed.
+var TEARDOWN_ANNOCLASS = &Class{
+	Name:         "Teardown",
+	Parent:       BASE_CLASS,
+	IsAnnotation: true,
+}
+
 func initRootObject() bool {
 	BASE_CLASS.Methods = map[string]ClassMethod{
 		"toString": &BuiltinMethod{
@@ -252,9 +280,11 @@ func initRootObject() bool {
 					return nativeBoolToBooleanObject(InstanceOf(class.String, self))
 				case *Class:
 					return nativeBoolToBooleanObject(InstanceOf(class.Name, self))
+				case *Interface:
+					return nativeBoolToBooleanObject(InstanceOf(class.Name, self))
 				}
 
-				return NewError(line, GENERICERROR, "is_a/instanceOf expected a class or string for its argument")
+				return NewError(line, GENERICERROR, "is_a/instanceOf expected a class, interface or string for its argument")
 			},
 		},
 		"classOf": &BuiltinMethod{
@@ -529,9 +559,94 @@ func InstanceOf(className string, oi *ObjectInstance) bool {
 		if cls.Name == className {
 			return true
 		}
+		for _, i := range cls.Interfaces {
+			if i.Extends(className) {
+				return true
+			}
+		}
 		if cls.Parent == nil {
 			return false
 		}
 		cls = cls.Parent
 	}
 }
+
+//interface name : parent1, parent2 { fn method(x, y); property name { get; set; } }
+type Interface struct {
+	Name       string
+	Parents    []*Interface
+	Methods    map[string]*ast.InterfaceMethod
+	Properties map[string]*ast.PropertyDeclStmt
+}
+
+func (i *Interface) Inspect() string { return "<interface:" + i.Name + ">" }
+
+func (i *Interface) Type() ObjectType { return INTERFACE_OBJ }
+
+func (i *Interface) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	return NewError(line, NOMETHODERROR, method, i.Type())
+}
+
+//Extends returns true if the interface is 'name', or one of its parents is 'name'.
+func (i *Interface) Extends(name string) bool {
+	if i.Name == name {
+		return true
+	}
+	for _, parent := range i.Parents {
+		if parent.Extends(name) {
+			return true
+		}
+	}
+	return false
+}
+
+//CheckClass reports the first method or property of the interface(including
+//its parents) which is not implemented by 'cls', or nil if 'cls' implements
+//the interface.
+func (i *Interface) CheckClass(line string, cls *Class) Object {
+	methods := make([]string, 0, len(i.Methods))
+	for name := range i.Methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods) //report the errors in a stable order
+
+	for _, name := range methods {
+		m := i.Methods[name]
+		method := cls.GetMethod(name)
+		if method == nil {
+			return NewError(line, INTERFACEMETHODERROR, cls.Name, name, i.Name)
+		}
+		if f, ok := method.(*Function); ok {
+			if len(f.Literal.Parameters) != len(m.Parameters) || f.Variadic != m.Variadic {
+				return NewError(line, INTERFACEARGSERROR, name, cls.Name, m.String(), i.Name)
+			}
+		}
+	}
+
+	properties := make([]string, 0, len(i.Properties))
+	for name := range i.Properties {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+
+	for _, name := range properties {
+		p := i.Properties[name]
+		prop := cls.GetProperty(name)
+		if prop == nil {
+			return NewError(line, INTERFACEPROPERTYERROR, cls.Name, name, i.Name)
+		}
+		if p.Getter != nil && prop.Getter == nil {
+			return NewError(line, INTERFACEACCESSORERROR, name, cls.Name, "getter", i.Name)
+		}
+		if p.Setter != nil && prop.Setter == nil {
+			return NewError(line, INTERFACEACCESSORERROR, name, cls.Name, "setter", i.Name)
+		}
+	}
+
+	for _, parent := range i.Parents {
+		if err := parent.CheckClass(line, cls); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"magpie/ast"
-	"magpie/lexer"
 	"magpie/message"
-	"magpie/parser"
 	"magpie/token"
 	"os"
 	"path/filepath"
@@ -186,13 +184,11 @@ func (d *Debugger) ProcessCommand() {
 			strings.HasPrefix(command, "e ") || strings.HasPrefix(command, "eval ") {
 			d.prevCommand = command
 			exp := strings.Split(command, " ")[1:]
-			lex := lexer.New("", strings.Join(exp, ""))
 			wd, _ := os.Getwd()
-			p := parser.New(lex, wd)
 			oldLines := d.SrcLines
 			oldNode := d.Node
 			d.showPrompt = false
-			program := p.ParseProgram()
+			_, program := Parse("", strings.Join(exp, ""), wd)
 			aval := Eval(program, NewScope(d.Scope, nil))
 			fmt.Printf("%s\n\n", aval.Inspect())
 			d.SrcLines = oldLines
@@ -306,6 +302,8 @@ func (d *Debugger) CanStop() bool {
 		flag = true
 	case *ast.SpawnStmt:
 		flag = true
+	case *ast.SelectStmt:
+		flag = true
 	case *ast.UsingStmt:
 		flag = true
 	case *ast.QueryExpr:
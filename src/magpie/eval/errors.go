@@ -25,7 +25,6 @@ const (
 	INLENERR
 	INVALIDARG
 	DIVIDEBYZERO
-	THROWERROR
 	THROWNOTHANDLED
 	GREPMAPNOTITERABLE
 	NOTITERABLE
@@ -41,6 +40,11 @@ const (
 	FILEMODEERROR
 	FILEOPENERROR
 	NOTCLASSERROR
+	NOTINTERFACEERROR
+	INTERFACEMETHODERROR
+	INTERFACEARGSERROR
+	INTERFACEPROPERTYERROR
+	INTERFACEACCESSORERROR
 	PARENTNOTDECL
 	CLSNOTDEFINE
 	CLSMEMBERPRIVATE
@@ -62,6 +66,16 @@ const (
 	DIAMONDOPERERROR
 	NAMENOTEXPORTED
 	IMPORTERROR
+	EXECLIMITERROR
+	GENERATORERROR
+	GENERATORCLOSED
+	PATTERNERROR
+	TASKERROR
+	TASKCANCELLED
+	TASKTIMEOUT
+	CHANNELERROR
+	TYPEERROR
+	MACROERROR
 	GENERICERROR
 )
 
@@ -85,7 +99,6 @@ var errorType = map[int]string{
 	INLENERR:           "function %s takes input with max length %s. got=%s",
 	INVALIDARG:         "invalid argument supplied",
 	DIVIDEBYZERO:       "divide by zero",
-	THROWERROR:         "throw object must be a string",
 	THROWNOTHANDLED:    "throw object '%s' not handled",
 	GREPMAPNOTITERABLE: "grep/map's operating type must be iterable",
 	NOTITERABLE:        "foreach's operating type must be iterable",
@@ -94,45 +107,69 @@ var errorType = map[int]string{
 	SPAWNERROR:         "spawn must be followed by a function",
 	ASSERTIONERROR:     "assertion failed",
 	//	STDLIBERROR:     "calling '%s' failed",
-	NULLABLEERROR:       "%s is null",
-	JSONERROR:           "json error: maybe unsupported type or invalid data",
-	DBSCANERROR:         "scan type not supported",
-	FUNCCALLBACKERROR:   "callback error: must be '%d' parameter(s), got '%d'",
-	FILEMODEERROR:       "known file mode supplied",
-	FILEOPENERROR:       "file open failed, reason: %s",
-	NOTCLASSERROR:       "Identifier %s is not a class",
-	PARENTNOTDECL:       "Parent class %s not declared",
-	CLSNOTDEFINE:        "Class %s not defined",
-	CLSMEMBERPRIVATE:    "Variable(%s) of class(%s) is private",
-	CLSCALLPRIVATE:      "Method (%s) of class(%s) is private",
-	PROPERTYUSEERROR:    "Invalid use of Property(%s) of class(%s)",
-	MEMBERUSEERROR:      "Invalid use of member(%s) of class(%s)",
-	INDEXERUSEERROR:     "Invalid use of Indexer of class(%s)",
-	INDEXERTYPEERROR:    "Invalid use of Indexer of class(%s), Only interger type of Indexer is supported",
-	INDEXERSTATICERROR:  "Invalid use of Indexer of class(%s), Indexer cannot declared as static",
-	INDEXNOTFOUNDERROR:  "Indexer not found for class(%s)",
-	CALLNONSTATICERROR:  "Could not call non-static",
-	CLASSCATEGORYERROR:  "No class(%s) found for category(%s)",
-	CLASSCREATEERROR:    "You must use 'new' to create class('%s')",
-	PARENTNOTANNOTATION: "Annotation(%s)'s Parent(%s) is not annotation",
-	OVERRIDEERROR:       "Method(%s) of class(%s) must override a superclass method",
-	METAOPERATORERROR:   "Meta-Operators' item must be Numbers|String",
-	SERVICENOURLERROR:   "Service(%s)'s function('%s') must have url",
-	CONSTNOTASSIGNERROR: "Const variable '%s' cannot be modified",
-	DIAMONDOPERERROR:    "Diamond operator must be followed by a file object, but got '%s'",
-	NAMENOTEXPORTED:     "Cannot refer to unexported name '%s.%s'",
-	IMPORTERROR:         "Import error: %s",
-	GENERICERROR:        "%s",
+	NULLABLEERROR:          "%s is null",
+	JSONERROR:              "json error: maybe unsupported type or invalid data",
+	DBSCANERROR:            "scan type not supported",
+	FUNCCALLBACKERROR:      "callback error: must be '%d' parameter(s), got '%d'",
+	FILEMODEERROR:          "known file mode supplied",
+	FILEOPENERROR:          "file open failed, reason: %s",
+	NOTCLASSERROR:          "Identifier %s is not a class",
+	NOTINTERFACEERROR:      "Identifier %s is not an interface",
+	INTERFACEMETHODERROR:   "Class(%s) does not implement method '%s' of interface(%s)",
+	INTERFACEARGSERROR:     "Method(%s) of class(%s) does not match '%s' declared in interface(%s)",
+	INTERFACEPROPERTYERROR: "Class(%s) does not implement property '%s' of interface(%s)",
+	INTERFACEACCESSORERROR: "Property(%s) of class(%s) has no %s, which is required by interface(%s)",
+	PARENTNOTDECL:          "Parent class %s not declared",
+	CLSNOTDEFINE:           "Class %s not defined",
+	CLSMEMBERPRIVATE:       "Variable(%s) of class(%s) is private",
+	CLSCALLPRIVATE:         "Method (%s) of class(%s) is private",
+	PROPERTYUSEERROR:       "Invalid use of Property(%s) of class(%s)",
+	MEMBERUSEERROR:         "Invalid use of member(%s) of class(%s)",
+	INDEXERUSEERROR:        "Invalid use of Indexer of class(%s)",
+	INDEXERTYPEERROR:       "Invalid use of Indexer of class(%s), Only interger type of Indexer is supported",
+	INDEXERSTATICERROR:     "Invalid use of Indexer of class(%s), Indexer cannot declared as static",
+	INDEXNOTFOUNDERROR:     "Indexer not found for class(%s)",
+	CALLNONSTATICERROR:     "Could not call non-static",
+	CLASSCATEGORYERROR:     "No class(%s) found for category(%s)",
+	CLASSCREATEERROR:       "You must use 'new' to create class('%s')",
+	PARENTNOTANNOTATION:    "Annotation(%s)'s Parent(%s) is not annotation",
+	OVERRIDEERROR:          "Method(%s) of class(%s) must override a superclass method",
+	METAOPERATORERROR:      "Meta-Operators' item must be Numbers|String",
+	SERVICENOURLERROR:      "Service(%s)'s function('%s') must have url",
+	CONSTNOTASSIGNERROR:    "Const variable '%s' cannot be modified",
+	DIAMONDOPERERROR:       "Diamond operator must be followed by a file object, but got '%s'",
+	NAMENOTEXPORTED:        "Cannot refer to unexported name '%s.%s'",
+	IMPORTERROR:            "Import error: %s",
+	EXECLIMITERROR:         "Execution limit exceeded: %s",
+	GENERATORERROR:         "Generator error: %s",
+	GENERATORCLOSED:        "generator closed",
+	PATTERNERROR:           "Pattern error: %s",
+	TASKERROR:              "Task error: %s",
+	TASKCANCELLED:          "task '%s' was cancelled",
+	TASKTIMEOUT:            "task '%s' timed out after %v second(s)",
+	CHANNELERROR:           "Channel error: %s",
+	TYPEERROR:              "Type error: %s",
+	MACROERROR:             "Macro error: %s",
+	GENERICERROR:           "%s",
 }
 
 func NewError(line string, t int, args ...interface{}) Object {
 	msg := fmt.Sprintf(errorType[t], args...) + " at line " + strings.TrimLeft(line, " \t")
-	return &Error{Kind: t, Message: msg}
+	return &Error{Kind: t, Message: msg, line: line}
 }
 
 type Error struct {
 	Kind    int
 	Message string
+
+	//The stack trace, innermost call first. It's recorded when
+	//the error leaves the function where it occurred.
+	StackTrace []StackFrame
+
+	//The thrown object if the error is created by 'throw', or nil.
+	Thrown Object
+
+	line string //where the error occurred
 }
 
 func (e Error) Error() string {
@@ -145,3 +182,19 @@ func (e *Error) CallMethod(line string, scope *Scope, method string, args ...Obj
 	//	return NewError(line, NOMETHODERROR, method, e.Type())
 	return NewError(line, GENERICERROR, e.Message)
 }
+
+// StackTraceString returns the stack trace of the error, one call per line.
+func (e *Error) StackTraceString() string {
+	return formatStackTrace(e.StackTrace)
+}
+
+// the message without the line number
+func (e *Error) message() string {
+	return strings.TrimSuffix(e.Message, " at line "+strings.TrimLeft(e.line, " \t"))
+}
+
+// Whether the error could be caught by 'try/catch'. The closing of a
+// generator must unwind the generator's body, so it could not be caught.
+func (e *Error) catchable() bool {
+	return e.Kind != GENERATORCLOSED
+}
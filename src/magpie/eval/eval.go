@@ -14,9 +14,9 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -42,11 +42,6 @@ var (
 		"hash"}
 )
 
-var importScope *Scope
-var importedCache map[string]Object
-
-var mux sync.Mutex
-
 //REPL with color support
 var REPLColor bool
 
@@ -86,13 +81,18 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		}
 	}()
 
-	if Dbg != nil {
-		Dbg.SetNodeAndScope(node, scope)
-		if Dbg.CanStop() {
-			MsgHandler.SendMessage(message.Message{Type: message.EVAL_LINE, Body: Context{N: []ast.Node{node}, S: scope}})
+	dbg, msgHandler := scope.interp.debugger()
+	if dbg != nil {
+		dbg.SetNodeAndScope(node, scope)
+		if dbg.CanStop() {
+			msgHandler.SendMessage(message.Message{Type: message.EVAL_LINE, Body: Context{N: []ast.Node{node}, S: scope}})
 		}
 	}
 
+	if err := checkLimits(node, scope); err != nil {
+		return err
+	}
+
 	//fmt.Printf("node.Type=%T, node=<%s>, start=%d, end=%d\n", node, node.String(), node.Pos().Line, node.End().Line) //debugging
 	switch node := node.(type) {
 	case *ast.Program:
@@ -106,8 +106,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 	case *ast.ConstStatement:
 		return evalConstStatement(node, scope)
 	case *ast.ReturnStatement:
-		if Dbg != nil {
-			MsgHandler.SendMessage(message.Message{Type: message.RETURN, Body: Context{N: []ast.Node{node}, S: scope}})
+		if dbg != nil {
+			msgHandler.SendMessage(message.Message{Type: message.RETURN, Body: Context{N: []ast.Node{node}, S: scope}})
 		}
 		return evalReturnStatement(node, scope)
 	case *ast.DeferStmt:
@@ -167,6 +167,12 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalIfExpression(node, scope)
 	case *ast.IfMacroStatement:
 		return evalIfMacroStatement(node, scope)
+	case *ast.MacroStatement:
+		return evalMacroStatement(node, scope)
+	case *ast.QuoteExpression:
+		return evalQuoteExpression(node, scope)
+	case *ast.UnquoteExpression:
+		return NewError(node.Pos().Sline(), MACROERROR, "'unquote' outside of quote")
 	case *ast.UnlessExpression:
 		return evalUnlessExpression(node, scope)
 	case *ast.BlockStatement:
@@ -177,8 +183,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		// }
 		return evalFunctionCall(node, scope)
 	case *ast.MethodCallExpression:
-		if Dbg != nil {
-			MsgHandler.SendMessage(message.Message{Type: message.METHOD_CALL, Body: Context{N: []ast.Node{node}, S: scope}})
+		if dbg != nil {
+			msgHandler.SendMessage(message.Message{Type: message.METHOD_CALL, Body: Context{N: []ast.Node{node}, S: scope}})
 		}
 		return evalMethodCallExpression(node, scope)
 	case *ast.IndexExpression:
@@ -231,6 +237,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalTernaryExpression(node, scope)
 	case *ast.SpawnStmt:
 		return evalSpawnStatement(node, scope)
+	case *ast.SelectStmt:
+		return evalSelectStatement(node, scope)
 	case *ast.NilLiteral:
 		return NIL
 	case *ast.Pipe:
@@ -239,6 +247,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 	//Class related
 	case *ast.ClassStatement:
 		return evalClassStatement(node, scope)
+	case *ast.InterfaceStatement:
+		return evalInterfaceStatement(node, scope)
 	case *ast.ClassLiteral:
 		return evalClassLiteral(node, scope)
 	case *ast.NewExpression:
@@ -258,6 +268,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 	//await expression
 	case *ast.AwaitExpr:
 		return evalAwaitExpression(node, scope)
+	case *ast.YieldExpression:
+		return evalYieldExpression(node, scope)
 
 	//service statement
 	case *ast.ServiceStatement:
@@ -287,25 +299,23 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 
 // Program Evaluation Entry Point Functions, and Helpers:
 func evalProgram(program *ast.Program, scope *Scope) (results Object) {
-	if importedCache == nil {
-		importedCache = make(map[string]Object)
-	}
-
 	results = loadImports(program.Imports, scope)
 	if results.Type() == ERROR_OBJ {
 		return
 	}
 
+	if errObj := ExpandMacros(program, scope); errObj != nil {
+		return errObj
+	}
+
 	for _, statement := range program.Statements {
 		results = Eval(statement, scope)
 		switch s := results.(type) {
 		case *ReturnValue:
 			return s.Value
 		case *Error:
+			traceError(s, scope)
 			return s
-		case *Throw:
-			//convert ThrowValue to Errors
-			return NewError(s.stmt.Pos().Sline(), THROWNOTHANDLED, s.value.Inspect())
 		}
 	}
 	if results == nil {
@@ -315,9 +325,12 @@ func evalProgram(program *ast.Program, scope *Scope) (results Object) {
 }
 
 func loadImports(imports map[string]*ast.ImportStatement, scope *Scope) Object {
-	if importScope == nil {
-		importScope = NewScope(nil, scope.Writer)
-	}
+	state := scope.interp.importState()
+	state.init.Do(func() {
+		state.scope = newTopScope(scope)
+		state.cache = make(map[string]Object)
+	})
+
 	for _, p := range imports {
 		v := Eval(p, scope)
 		if v.Type() == ERROR_OBJ {
@@ -329,26 +342,38 @@ func loadImports(imports map[string]*ast.ImportStatement, scope *Scope) Object {
 
 // Statements...
 func evalImportStatement(i *ast.ImportStatement, scope *Scope) Object {
-
-	mux.Lock()
-	defer mux.Unlock()
-
-	// Check the cache
-	if cache, ok := importedCache[i.ImportPath]; ok {
-		return cache
+	//modules are cached by their resolved path, so packages with the same name don't collide
+	key := i.File
+	if key == "" {
+		key = i.ImportPath
 	}
 
-	imported := &ImportedObject{Name: i.ImportPath, Scope: NewScope(nil, scope.Writer)}
-
-	if _, ok := importScope.Get(i.ImportPath); !ok {
-		evalProgram(i.Program, imported.Scope)
-		importScope.Set(i.ImportPath, imported)
+	imports := scope.interp.importState()
+	imports.Lock()
+	cache, ok := imports.cache[key]
+	if !ok {
+		//cached before it's evaluated, so a module imported twice is evaluated once(the parser rejects an import cycle)
+		cache = &ImportedObject{Name: i.ImportPath, Scope: newTopScope(scope)}
+		imports.cache[key] = cache
+		if _, ok := imports.scope.Get(i.ImportPath); !ok {
+			imports.scope.Set(i.ImportPath, cache)
+		}
 	}
+	imports.Unlock()
 
-	//store the evaluated result to cache
-	importedCache[i.ImportPath] = imported
+	if !ok && i.Program != nil {
+		//the lock isn't held while evaluating, the module may import other modules
+		if result := evalProgram(i.Program, cache.(*ImportedObject).Scope); result.Type() == ERROR_OBJ {
+			imports.Lock()
+			delete(imports.cache, key)
+			imports.Unlock()
+			return result
+		}
+	}
 
-	return imported
+	//the module is visible to the importing program by its name(or its 'as' name)
+	scope.Set(i.ImportPath, cache)
+	return cache
 }
 
 func evalLetStatement(l *ast.LetStatement, scope *Scope) (val Object) {
@@ -463,6 +488,9 @@ func evalLetStatement(l *ast.LetStatement, scope *Scope) (val Object) {
 			}
 			val = values[idx]
 			if val.Type() != ERROR_OBJ {
+				if errObj := checkLetType(l, idx, val, scope); errObj != nil {
+					return errObj
+				}
 				scope.Set(item.String(), val)
 			} else {
 				return
@@ -936,6 +964,12 @@ func evalAssignExpression(a *ast.AssignExpression, scope *Scope) (val Object) {
 		return val
 	}
 
+	return evalAssignValue(a, val, scope)
+}
+
+//assign the already evaluated 'val' to the left hand side of 'a'
+func evalAssignValue(a *ast.AssignExpression, value Object, scope *Scope) (val Object) {
+	val = value
 	if strings.Contains(a.Name.String(), ".") {
 		switch o := a.Name.(type) {
 		case *ast.MethodCallExpression:
@@ -1225,7 +1259,12 @@ func evalAssignExpression(a *ast.AssignExpression, scope *Scope) (val Object) {
 func evalReturnStatement(r *ast.ReturnStatement, scope *Scope) Object {
 	ret := &ReturnValue{Value: NIL, Values: []Object{}}
 	for _, value := range r.ReturnValues {
-		ret.Values = append(ret.Values, Eval(value, scope))
+		val := Eval(value, scope)
+		switch val.(type) {
+		case *Error: //e.g. 'return 10 / 0'
+			return val
+		}
+		ret.Values = append(ret.Values, val)
 	}
 
 	// for old campatibility
@@ -1270,7 +1309,23 @@ func evalThrowStatement(t *ast.ThrowStmt, scope *Scope) Object {
 		return throwObj
 	}
 
-	return &Throw{stmt: t, value: throwObj}
+	//A throw is an error which carries the thrown object, so it
+	//goes up the same way as the runtime errors until it's caught.
+	err := NewError(t.Pos().Sline(), THROWNOTHANDLED, exceptionString(throwObj)).(*Error)
+	err.Thrown = throwObj
+
+	e, ok := asException(throwObj)
+	if ok && len(exceptionStackTrace(e)) != 0 { //rethrown, keep the original stack trace
+		err.StackTrace = exceptionStackTrace(e)
+		return err
+	}
+
+	pos := t.Pos()
+	err.StackTrace = stackTrace(pos.Filename, pos.Line, scope)
+	if ok {
+		setStackTrace(e, err.StackTrace)
+	}
+	return err
 }
 
 // Booleans
@@ -1333,13 +1388,13 @@ func evalRegExLiteral(re *ast.RegExLiteral) Object {
 
 func evalIdentifier(i *ast.Identifier, scope *Scope) Object {
 	//Get from global scope first
-	if obj, ok := GetGlobalObj(i.String()); ok {
+	if obj, ok := lookupGlobal(i.String(), scope); ok {
 		return obj
 	}
 
 	val, ok := scope.Get(i.String())
 	if !ok {
-		if val, ok = importScope.Get(i.String()); !ok {
+		if val, ok = scope.interp.importState().scope.Get(i.String()); !ok {
 			return reportTypoSuggestions(i.Pos().Sline(), scope, i.Value)
 		}
 	}
@@ -1351,6 +1406,24 @@ func evalIdentifier(i *ast.Identifier, scope *Scope) Object {
 	return val
 }
 
+// lookupGlobal returns the global object 'name'(e.g. the builtin 'strings'),
+// unless a module imported with the same name shadows it, e.g.
+// 'import "acme/util/strings"'.
+func lookupGlobal(name string, scope *Scope) (Object, bool) {
+	obj, ok := scope.interp.getGlobal(name)
+	if !ok {
+		return nil, false
+	}
+	v, ok := scope.Get(name)
+	if imports := scope.interp.importState(); !ok && imports.scope != nil {
+		v, ok = imports.scope.Get(name)
+	}
+	if _, imported := v.(*ImportedObject); ok && imported {
+		return nil, false
+	}
+	return obj, true
+}
+
 func evalHashLiteral(hl *ast.HashLiteral, scope *Scope) Object {
 	innerScope := NewScope(scope, nil)
 
@@ -1369,6 +1442,8 @@ func evalHashLiteral(hl *ast.HashLiteral, scope *Scope) Object {
 				t := key.(*ast.Identifier).Value
 				k = NewString(t)
 				innerScope.Set(t, k)
+			} else {
+				k = Eval(key, innerScope)
 			}
 		default:
 			k = Eval(key, innerScope)
@@ -1389,7 +1464,7 @@ func evalHashLiteral(hl *ast.HashLiteral, scope *Scope) Object {
 }
 
 func evalStructLiteral(s *ast.StructLiteral, scope *Scope) Object {
-	structScope := NewScope(nil, scope.Writer)
+	structScope := newTopScope(scope)
 	for key, value := range s.Pairs {
 		if ident, ok := key.(*ast.Identifier); ok {
 			aObj := Eval(value, scope)
@@ -1408,7 +1483,7 @@ func evalEnumStatement(enumStmt *ast.EnumStatement, scope *Scope) Object {
 }
 
 func evalEnumLiteral(e *ast.EnumLiteral, scope *Scope) Object {
-	enumScope := NewScope(nil, scope.Writer)
+	enumScope := newTopScope(scope)
 	for key, value := range e.Pairs {
 		if ident, ok := key.(*ast.Identifier); ok {
 			aObj := Eval(value, scope)
@@ -1547,6 +1622,11 @@ func evalPrefixExpression(p *ast.PrefixExpression, scope *Scope) Object {
 		return right
 	}
 
+	return evalPrefixValue(p, right, scope)
+}
+
+//apply the prefix operator of 'p' to the already evaluated 'right'
+func evalPrefixValue(p *ast.PrefixExpression, right Object, scope *Scope) Object {
 	//User Defined Operator
 	if p.Token.Type == token.UDO {
 		return evalPrefixExpressionUDO(p, right, scope)
@@ -2882,6 +2962,12 @@ func evalGrepExpression(ge *ast.GrepExpr, scope *Scope) Object {
 	} else if aValue.Type() == LINQ_OBJ {
 		linqObj, _ := aValue.(*LinqObj)
 		members = linqObj.ToSlice(ge.Pos().Sline()).(*Array).Members
+	} else if aValue.Type() == GENERATOR_OBJ {
+		arr := aValue.(*Generator).ToSlice(ge.Pos().Sline(), scope)
+		if arr.Type() == ERROR_OBJ {
+			return arr
+		}
+		members = arr.(*Array).Members
 	}
 
 	result := &Array{}
@@ -2945,6 +3031,12 @@ func evalMapExpression(me *ast.MapExpr, scope *Scope) Object {
 	} else if aValue.Type() == LINQ_OBJ {
 		linqObj, _ := aValue.(*LinqObj)
 		members = linqObj.ToSlice(me.Pos().Sline()).(*Array).Members
+	} else if aValue.Type() == GENERATOR_OBJ {
+		arr := aValue.(*Generator).ToSlice(me.Pos().Sline(), scope)
+		if arr.Type() == ERROR_OBJ {
+			return arr
+		}
+		members = arr.(*Array).Members
 	}
 
 	result := &Array{}
@@ -3009,6 +3101,12 @@ func evalListComprehension(lc *ast.ListComprehension, scope *Scope) Object {
 	} else if aValue.Type() == LINQ_OBJ {
 		linqObj, _ := aValue.(*LinqObj)
 		members = linqObj.ToSlice(lc.Pos().Sline()).(*Array).Members
+	} else if aValue.Type() == GENERATOR_OBJ {
+		arr := aValue.(*Generator).ToSlice(lc.Pos().Sline(), scope)
+		if arr.Type() == ERROR_OBJ {
+			return arr
+		}
+		members = arr.(*Array).Members
 	}
 
 	ret := &Array{}
@@ -3170,6 +3268,12 @@ func evalHashComprehension(hc *ast.HashComprehension, scope *Scope) Object {
 	} else if aValue.Type() == TUPLE_OBJ {
 		tuple, _ := aValue.(*Tuple)
 		members = tuple.Members
+	} else if aValue.Type() == GENERATOR_OBJ {
+		arr := aValue.(*Generator).ToSlice(hc.Pos().Sline(), scope)
+		if arr.Type() == ERROR_OBJ {
+			return arr
+		}
+		members = arr.(*Array).Members
 	}
 
 	ret := NewHash()
@@ -3330,17 +3434,31 @@ func evalCaseExpression(ce *ast.CaseExpr, scope *Scope) Object {
 		}
 
 		matchExpr := item.(*ast.CaseMatchExpr)
-		matchRv := Eval(matchExpr.Expr, NewScope(scope, nil)) //matcher expression
-		if matchRv.Type() == ERROR_OBJ {
-			return matchRv
+		binds := make(map[string]Object)
+		matched, errObj := matchPattern(matchExpr.Expr, rv, scope, ce.IsWholeMatch, binds)
+		if errObj != nil {
+			return errObj
 		}
-
-		//check 'rv' and 'matchRv' equality, if not equal, then continue
-		if !equal(ce.IsWholeMatch, rv, matchRv) {
+		if !matched {
 			continue
 		}
-		//Eval matcher block
+
+		//the names bound by the pattern are visible in the guard and the block
 		matcherScope := NewScope(scope, nil)
+		for name, val := range binds {
+			matcherScope.Set(name, val)
+		}
+		if matchExpr.Guard != nil {
+			cond := Eval(matchExpr.Guard, matcherScope)
+			if cond.Type() == ERROR_OBJ {
+				return cond
+			}
+			if !IsTrue(cond) {
+				continue
+			}
+		}
+
+		//Eval matcher block
 		rv = Eval(matchExpr.Block, matcherScope)
 		if rv.Type() == ERROR_OBJ {
 			return rv
@@ -3428,6 +3546,9 @@ func evalForEverLoopExpression(fel *ast.ForEverLoop, scope *Scope) Object {
 	newScope := NewScope(scope, nil)
 	for {
 		e = Eval(fel.Block, newScope)
+		if e == nil { //empty loop body, e.g. 'for {}'
+			continue
+		}
 		if e.Type() == ERROR_OBJ {
 			return e
 		}
@@ -3553,6 +3674,10 @@ func evalForEachArrayExpression(fal *ast.ForEachArrayLoop, scope *Scope) Object
 		goObj := aValue.(*GoObject)
 		arr := GoValueToObject(goObj.obj).(*Array)
 		members = arr.Members
+	} else if aValue.Type() == GENERATOR_OBJ {
+		return evalForEachGenerator(fal.Pos().Sline(), aValue.(*Generator), "$_", fal.Var, fal.Cond, fal.Block, innerScope)
+	} else if aValue.Type() == WEBSOCKET_OBJ {
+		return evalForEachWebSocket(aValue.(*WebSocket), "$_", fal.Var, fal.Cond, fal.Block, innerScope)
 	} else if aValue.Type() == CHANNEL_OBJ {
 		chanObj := aValue.(*ChanObject)
 		ret := &Array{}
@@ -3717,6 +3842,114 @@ func evalForEachArrayWithIndex(fml *ast.ForEachMapLoop, val Object, scope *Scope
 	return ret
 }
 
+//for value in generator
+//for index, value in generator
+//The values are taken from the generator one at a time. If the loop ends before the
+//generator is finished(break, return, error), the generator is closed.
+func evalForEachGenerator(line string, gen *Generator, keyVar string, valueVar string, cond ast.Expression, block ast.Node, scope *Scope) Object {
+	//close the generator when the loop ends early
+	stop := func(r Object) Object {
+		if err := gen.closeGenerator(line, scope); err.Type() == ERROR_OBJ && r.Type() != ERROR_OBJ {
+			return err
+		}
+		return r
+	}
+
+	ret := &Array{}
+	for idx := 0; ; idx++ {
+		value, ok := gen.resumeGenerator(line, scope, NIL)
+		if !ok {
+			if value.Type() == ERROR_OBJ {
+				return value
+			}
+			return ret
+		}
+
+		newSubScope := NewScope(scope, nil)
+		newSubScope.Set(keyVar, NewInteger(int64(idx)))
+		newSubScope.Set(valueVar, value)
+		if cond != nil {
+			c := Eval(cond, newSubScope)
+			if c.Type() == ERROR_OBJ {
+				return stop(c)
+			}
+
+			if !IsTrue(c) {
+				continue
+			}
+		}
+
+		result := Eval(block, newSubScope)
+		if result == nil { //empty block
+			continue
+		}
+		if result.Type() == ERROR_OBJ {
+			return stop(result)
+		}
+
+		if _, ok := result.(*Break); ok {
+			return stop(ret)
+		}
+		if _, ok := result.(*Continue); ok {
+			continue
+		}
+		if v, ok := result.(*ReturnValue); ok {
+			if v.Value != nil {
+				return stop(v)
+			}
+			return stop(ret)
+		}
+		ret.Members = append(ret.Members, result)
+	}
+}
+
+//for msg in websocket {}: loops until the connection is closed
+func evalForEachWebSocket(ws *WebSocket, keyVar string, valueVar string, cond ast.Expression, block ast.Node, scope *Scope) Object {
+	ret := &Array{}
+	for idx := 0; ; idx++ {
+		msg, err := ws.readMessage()
+		if err != nil {
+			return ret
+		}
+
+		newSubScope := NewScope(scope, nil)
+		newSubScope.Set(keyVar, NewInteger(int64(idx)))
+		newSubScope.Set(valueVar, NewString(string(msg)))
+		if cond != nil {
+			c := Eval(cond, newSubScope)
+			if c.Type() == ERROR_OBJ {
+				return c
+			}
+
+			if !IsTrue(c) {
+				continue
+			}
+		}
+
+		result := Eval(block, newSubScope)
+		if result == nil { //empty block
+			continue
+		}
+		if result.Type() == ERROR_OBJ {
+			return result
+		}
+
+		if _, ok := result.(*Break); ok {
+			return ret
+		}
+		if _, ok := result.(*Continue); ok {
+			continue
+		}
+		if v, ok := result.(*ReturnValue); ok {
+			if v.Value != nil {
+				return v
+			}
+			return ret
+		}
+		ret.Members = append(ret.Members, result)
+	}
+}
+
 func evalForEachMapExpression(fml *ast.ForEachMapLoop, scope *Scope) Object { //fml:For Map Loop
 	innerScope := NewScope(scope, nil)
 
@@ -3747,6 +3980,11 @@ func evalForEachMapExpression(fml *ast.ForEachMapLoop, scope *Scope) Object { //
 		return evalForEachArrayWithIndex(fml, aValue, innerScope)
 	}
 
+	//for index, value in generator
+	if aValue.Type() == GENERATOR_OBJ {
+		return evalForEachGenerator(fml.Pos().Sline(), aValue.(*Generator), fml.Key, fml.Value, fml.Cond, fml.Block, innerScope)
+	}
+
 	hash, _ := aValue.(*Hash)
 
 	ret := &Array{}
@@ -3927,7 +4165,7 @@ func evalBlockStatements(block []ast.Statement, scope *Scope) (results Object) {
 			return results
 		}
 
-		if results != nil && results.Type() == RETURN_VALUE_OBJ || results.Type() == THROW_OBJ {
+		if results != nil && results.Type() == RETURN_VALUE_OBJ {
 			return
 		}
 		if _, ok := results.(*Break); ok {
@@ -3992,21 +4230,27 @@ func evalFunctionCall(call *ast.CallExpression, scope *Scope) Object {
 		return NewError(call.Function.Pos().Sline(), CLASSCREATEERROR, call.Function.String())
 	}
 
-	f := fn.(*Function)
-	if f.Async && call.Awaited {
-		aChan := make(chan Object, 1)
-
-		go func() {
-			defer close(aChan)
-			aChan <- evalFunctionObj(call, f, scope)
-		}()
+	//a go function saved in a variable, e.g. the 'next' of a service middleware
+	if builtin, ok := fn.(*Builtin); ok {
+		args := evalArgs(call.Arguments, scope)
+		for _, v := range args {
+			if v.Type() == ERROR_OBJ {
+				return v
+			}
+		}
+		return builtin.Fn(call.Function.Pos().Sline(), scope, args...)
+	}
 
-		return <-aChan
+	if m, ok := fn.(*Macro); ok { //the macros are expanded before the program runs
+		return NewError(call.Function.Pos().Sline(), MACROERROR, fmt.Sprintf("macro '%s' can't be called at runtime", m.Name))
 	}
 
+	f := fn.(*Function)
 	if f.Async {
-		go evalFunctionObj(call, f, scope)
-		return NIL
+		gs := goroutineScope(scope)
+		return startTask(callName(call), gs.CallStack, func() Object {
+			return evalFunctionObj(call, f, gs)
+		})
 	}
 
 	return evalFunctionObj(call, f, scope)
@@ -4027,26 +4271,61 @@ func evalFunctionObj(call *ast.CallExpression, f *Function, scope *Scope) Object
 		}
 	}
 
-	newScope := NewScope(f.Scope, nil)
+	if err := checkCallDepth(call, scope); err != nil {
+		return err
+	}
 
-	//Register this function call in the call stack
-	newScope.CallStack.Frames = append(newScope.CallStack.Frames, CallFrame{FuncScope: newScope, CurrentCall: call})
+	args := evalArgs(call.Arguments, scope)
+	if f.Literal.Generator {
+		return callGenerator(f, call, args, scope)
+	}
+
+	newScope := enterFunction(f, call, args, scope)
 
 	//Using golang's defer mechanism, before function return, call current frame's defer method
-	defer func() {
-		frame := newScope.CurrentFrame()
-		if len(frame.defers) != 0 {
-			frame.runDefers(newScope)
-		}
+	defer leaveFunction(newScope)
 
-		//After run, must pop the frame
-		stack := newScope.CallStack
-		stack.Frames = stack.Frames[0 : len(stack.Frames)-1]
-	}()
+	line := call.Function.Pos().Sline()
+	if errObj := checkArgTypes(line, f.Literal, callName(call), args, newScope); errObj != nil {
+		traceError(errObj, newScope)
+		return errObj
+	}
+
+	r := Eval(f.Literal.Body, newScope)
+	if r.Type() == ERROR_OBJ {
+		traceError(r, newScope)
+		return r
+	}
+
+	if obj, ok := r.(*ReturnValue); ok {
+		return checkReturnType(line, f.Literal, callName(call), unwrapReturnValue(obj), newScope)
+	}
+
+	/* If the function call do not end in a 'return' statement. e.g.
+	   let add = fn(x, y) {
+	       x + y // not 'return x + y'
+	   }
+	   We need to send EVAL_LINE to the debugger, so we can step into this line,
+	   or else we cannot step into it.
+	*/
+	if dbg, msgHandler := scope.interp.debugger(); dbg != nil {
+		msgHandler.SendMessage(message.Message{Type: message.EVAL_LINE, Body: Context{N: []ast.Node{call}, S: newScope}})
+	}
+	return checkReturnType(line, f.Literal, callName(call), r, newScope)
+}
+
+//create the scope for calling 'f' with 'args' from 'scope', and register the call
+//in the caller's call stack. Every call to enterFunction must be paired with a call
+//to leaveFunction.
+func enterFunction(f *Function, call *ast.CallExpression, args []Object, scope *Scope) *Scope {
+	newScope := NewScope(f.Scope, nil)
+	newScope.CallStack = scope.CallStack
+
+	//Register this function call in the call stack
+	newScope.CallStack.Frames = append(newScope.CallStack.Frames, CallFrame{FuncScope: newScope, CurrentCall: call, name: callName(call)})
 
 	variadicParam := []Object{}
-	args := evalArgs(call.Arguments, scope)
-	for i := range call.Arguments {
+	for i := range args {
 		//Because of function default values, we need to check `i >= len(args)`
 		if f.Variadic && i >= len(f.Literal.Parameters)-1 {
 			for j := i; j < len(args); j++ {
@@ -4064,54 +4343,64 @@ func evalFunctionObj(call *ast.CallExpression, f *Function, scope *Scope) Object
 	// of parameters.
 	if f.Variadic {
 		newScope.Set(f.Literal.Parameters[len(f.Literal.Parameters)-1].String(), &Array{Members: variadicParam})
-		if len(call.Arguments) < len(f.Literal.Parameters) {
+		if len(args) < len(f.Literal.Parameters) {
 			f.Scope.Set("@_", NewInteger(int64(len(f.Literal.Parameters)-1)))
 		} else {
-			f.Scope.Set("@_", NewInteger(int64(len(call.Arguments))))
+			f.Scope.Set("@_", NewInteger(int64(len(args))))
 		}
 	} else {
 		f.Scope.Set("@_", NewInteger(int64(len(f.Literal.Parameters))))
 	}
 
-	r := Eval(f.Literal.Body, newScope)
-	if r.Type() == ERROR_OBJ {
-		return r
-	}
+	return newScope
+}
 
-	if obj, ok := r.(*ReturnValue); ok {
-		// if function returns multiple-values
-		// returns a tuple instead.
-		if len(obj.Values) > 1 {
-			return &Tuple{Members: obj.Values, IsMulti: true}
-		}
-		return obj.Value
+//run the defers of the function frame created by enterFunction, then pop the frame.
+func leaveFunction(newScope *Scope) {
+	frame := newScope.CurrentFrame()
+	if len(frame.defers) != 0 {
+		frame.runDefers(newScope)
 	}
 
-	/* If the function call do not end in a 'return' statement. e.g.
-	   let add = fn(x, y) {
-	       x + y // not 'return x + y'
-	   }
-	   We need to send EVAL_LINE to the debugger, so we can step into this line,
-	   or else we cannot step into it.
-	*/
-	if Dbg != nil {
-		MsgHandler.SendMessage(message.Message{Type: message.EVAL_LINE, Body: Context{N: []ast.Node{call}, S: newScope}})
+	//After run, must pop the frame
+	stack := newScope.CallStack
+	stack.Frames = stack.Frames[0 : len(stack.Frames)-1]
+}
+
+//calling a generator function creates the generator, the function body runs
+//when the generator is resumed.
+func callGenerator(f *Function, call *ast.CallExpression, args []Object, scope *Scope) Object {
+	return newGenerator(callName(call), scope, func(genScope *Scope) Object {
+		newScope := enterFunction(f, call, args, genScope)
+		defer leaveFunction(newScope)
+
+		r := Eval(f.Literal.Body, newScope)
+		traceError(r, newScope)
+		return r
+	})
+}
+
+func unwrapReturnValue(obj *ReturnValue) Object {
+	// if function returns multiple-values
+	// returns a tuple instead.
+	if len(obj.Values) > 1 {
+		return &Tuple{Members: obj.Values, IsMulti: true}
 	}
-	return r
+	return obj.Value
 }
 
 // Method calls for builtin Objects
 func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Object {
 	//First check if is a stanard library object
 	str := call.Object.String()
-	if obj, ok := GetGlobalObj(str); ok {
+	if obj, ok := lookupGlobal(str, scope); ok {
 		switch o := call.Call.(type) {
 		case *ast.IndexExpression: // e.g. 'if gos.Args[0] == "hello" {'
-			if arr, ok := GetGlobalObj(str + "." + o.Left.String()); ok {
+			if arr, ok := scope.interp.getGlobal(str + "." + o.Left.String()); ok {
 				return evalArrayIndex(arr.(*Array), o, scope)
 			}
 		case *ast.Identifier: //e.g. os.O_APPEND
-			if i, ok := GetGlobalObj(str + "." + o.String()); ok {
+			if i, ok := scope.interp.getGlobal(str + "." + o.String()); ok {
 				return i
 			} else { //e.g. method call like 'os.environ'
 				if obj.Type() == HASH_OBJ { // It's a GoFuncObject
@@ -4155,7 +4444,7 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 		// The eval.RegisterVars will call SetGlobalObj("runtime.GOOS"), so the
 		// global scope's name is 'runtime.GOOS', not 'runtime', therefore, the above
 		// GetGlobalObj('runtime') will returns false.
-		if obj, ok := GetGlobalObj(str + "." + call.Call.String()); ok {
+		if obj, ok := scope.interp.getGlobal(str + "." + call.Call.String()); ok {
 			return obj
 		}
 	}
@@ -4326,7 +4615,8 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 				switch m := method.(type) {
 				case *Function:
 					newScope := NewScope(instanceObj.Scope, nil)
-					args := evalArgs(o.Arguments, newScope)
+					newScope.CallStack = scope.CallStack //the method call is registered in the caller's call stack
+					args := evalArgs(o.Arguments, scope)
 					return evalFunctionDirect(method, args, instanceObj, newScope, o)
 
 				case *BuiltinMethod:
@@ -4430,11 +4720,18 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 				switch m := method.(type) {
 				case *Function:
 					args := evalArgs(o.Arguments, scope)
-					return evalFunctionDirect(m, args, nil, newScope, o)
+					fnScope := NewScope(newScope, nil)
+					fnScope.CallStack = scope.CallStack //the method call is registered in the caller's call stack
+					return evalFunctionDirect(m, args, nil, fnScope, o)
 				case *BuiltinMethod:
-					builtinMethod := &BuiltinMethod{Fn: m.Fn, Instance: nil}
+					//e.g. parent.init(xxx), the builtin method operates on 'this' instance
+					instance, _ := thisObj.(*ObjectInstance)
+					if str != "parent" {
+						instance = nil
+					}
+					builtinMethod := &BuiltinMethod{Fn: m.Fn, Instance: instance}
 					aScope := NewScope(newScope, nil)
-					args := evalArgs(o.Arguments, aScope)
+					args := evalArgs(o.Arguments, scope)
 					return evalFunctionDirect(builtinMethod, args, nil, aScope, nil)
 				}
 			} else {
@@ -4945,25 +5242,45 @@ func evalDecrementPostfixOperatorExpression(node *ast.PostfixExpression, left Ob
 
 func evalTryStatement(tryStmt *ast.TryStmt, scope *Scope) Object {
 	rv := Eval(tryStmt.Try, scope)
-	if rv.Type() == ERROR_OBJ {
-		return rv
+
+	//the thrown object, or the runtime error converted to an exception
+	var exception Object
+	if err, ok := rv.(*Error); ok {
+		if !err.catchable() {
+			//a closed generator still runs its 'finally' blocks for cleanup
+			if err.Kind == GENERATORCLOSED && tryStmt.Finally != nil {
+				if r := evalBlockStatements(tryStmt.Finally.Statements, scope); r.Type() == ERROR_OBJ {
+					return r
+				}
+			}
+			return rv
+		}
+		traceError(err, scope)
+		exception = err.Thrown
+		if exception == nil {
+			exception = errorToException(err, scope)
+		}
 	}
 
-	throwNotHandled := false
-	var throwObj Object = NIL
-	if rv.Type() == THROW_OBJ {
-		throwObj = rv.(*Throw)
-		if tryStmt.Catch != nil {
+	//the error which is not handled
+	var unhandled Object
+	if exception != nil {
+		unhandled = rv
+		clause, err := findCatchClause(tryStmt.Catches, exception, scope)
+		if err != nil {
+			return err
+		}
+
+		if clause != nil {
+			unhandled = nil
 			catchScope := NewScope(scope, scope.Writer)
-			if tryStmt.Var != "" {
-				catchScope.Set(tryStmt.Var, rv.(*Throw).value)
+			if clause.Var != "" {
+				catchScope.Set(clause.Var, exception)
 			}
-			rv = evalBlockStatements(tryStmt.Catch.Statements, catchScope) //catch Block
-			if rv.Type() == ERROR_OBJ {
-				return rv
+			rv = evalBlockStatements(clause.Block.Statements, catchScope) //catch Block
+			if rv.Type() == ERROR_OBJ { //e.g. the exception is rethrown
+				unhandled = rv
 			}
-		} else {
-			throwNotHandled = true
 		}
 	}
 
@@ -4974,11 +5291,43 @@ func evalTryStatement(tryStmt *ast.TryStmt, scope *Scope) Object {
 		}
 	}
 
-	if throwNotHandled {
-		return throwObj
+	if unhandled != nil {
+		return unhandled
 	}
 	return NIL
 }
+
+//returns the first catch clause which handles 'exception', or nil if none.
+func findCatchClause(clauses []*ast.CatchClause, exception Object, scope *Scope) (*ast.CatchClause, Object) {
+	instance, _ := exception.(*ObjectInstance)
+	for _, clause := range clauses {
+		if len(clause.Types) == 0 { //catch all
+			return clause, nil
+		}
+
+		for _, t := range clause.Types {
+			typ, ok := scope.Get(t.Value)
+			if !ok {
+				return nil, NewError(t.Pos().Sline(), CLSNOTDEFINE, t.Value)
+			}
+
+			switch typ := typ.(type) {
+			case *Class:
+				if InstanceOf(typ.Name, instance) {
+					return clause, nil
+				}
+			case *Interface:
+				if InstanceOf(typ.Name, instance) {
+					return clause, nil
+				}
+			default:
+				return nil, NewError(t.Pos().Sline(), NOTCLASSERROR, t.Value)
+			}
+		}
+	}
+	return nil, nil
+}
+
 //Evaluate ternary expression
 func evalTernaryExpression(te *ast.TernaryExpression, scope *Scope) Object {
 	condition := Eval(te.Condition, scope) //eval condition
@@ -4994,17 +5343,17 @@ func evalTernaryExpression(te *ast.TernaryExpression, scope *Scope) Object {
 }
 
 func evalSpawnStatement(s *ast.SpawnStmt, scope *Scope) Object {
-	newSpawnScope := NewScope(scope, nil)
+	newSpawnScope := goroutineScope(scope)
 
 	switch callExp := s.Call.(type) {
 	case *ast.CallExpression:
-		go (func() {
+		startGoroutine(newSpawnScope.CallStack, func() {
 			evalFunctionCall(callExp, newSpawnScope)
-		})()
+		})
 	case *ast.MethodCallExpression:
-		go (func() {
+		startGoroutine(newSpawnScope.CallStack, func() {
 			evalMethodCallExpression(callExp, newSpawnScope)
-		})()
+		})
 	default:
 		return NewError(s.Pos().Sline(), SPAWNERROR)
 	}
@@ -5073,6 +5422,9 @@ func evalClassStatement(c *ast.ClassStatement, scope *Scope) Object {
 	} else {
 		clsObj = evalClassLiteral(c.ClassLiteral, scope)
 	}
+	if clsObj.Type() == ERROR_OBJ {
+		return clsObj
+	}
 
 	scope.Set(c.Name.Value, clsObj) //save to scope
 
@@ -5082,6 +5434,7 @@ func evalClassStatement(c *ast.ClassStatement, scope *Scope) Object {
 //let name = class : parent { block }
 func evalClassLiteral(c *ast.ClassLiteral, scope *Scope) Object {
 	var parentClass = BASE_CLASS //base class is the root of all classes in magpie
+	var interfaces []*Interface
 	if c.Parent != "" {
 
 		parent, ok := scope.Get(c.Parent)
@@ -5089,18 +5442,35 @@ func evalClassLiteral(c *ast.ClassLiteral, scope *Scope) Object {
 			return NewError(c.Pos().Sline(), PARENTNOTDECL, c.Parent)
 		}
 
-		parentClass, ok = parent.(*Class)
-		if !ok {
+		switch parent := parent.(type) {
+		case *Class:
+			parentClass = parent
+		case *Interface: //e.g. class classname : interface1 { block }
+			interfaces = append(interfaces, parent)
+		default:
 			return NewError(c.Pos().Sline(), NOTCLASSERROR, c.Parent)
 		}
 	}
 
+	for _, name := range c.Interfaces {
+		i, ok := scope.Get(name)
+		if !ok {
+			return NewError(c.Pos().Sline(), UNKNOWNIDENT, name)
+		}
+		intf, ok := i.(*Interface)
+		if !ok {
+			return NewError(c.Pos().Sline(), NOTINTERFACEERROR, name)
+		}
+		interfaces = append(interfaces, intf)
+	}
+
 	clsObj := &Class{
 		Name:       c.Name,
 		Parent:     parentClass,
 		Members:    c.Members,
 		Properties: c.Properties,
 		Methods:    make(map[string]ClassMethod, len(c.Methods)),
+		Interfaces: interfaces,
 	}
 
 	tmpClass := clsObj
@@ -5140,9 +5510,41 @@ func evalClassLiteral(c *ast.ClassLiteral, scope *Scope) Object {
 		}
 	}
 
+	//check if the class implements all the methods and properties of its interfaces.
+	for _, intf := range clsObj.Interfaces {
+		if err := intf.CheckClass(c.Pos().Sline(), clsObj); err != nil {
+			return err
+		}
+	}
+
 	return clsObj
 }
 
+//interface name : parent1, parent2 { fn method(x, y); property name { get; set; } }
+func evalInterfaceStatement(i *ast.InterfaceStatement, scope *Scope) Object {
+	intf := &Interface{
+		Name:       i.Name.Value,
+		Methods:    i.Methods,
+		Properties: i.Properties,
+	}
+
+	for _, name := range i.Parents {
+		p, ok := scope.Get(name)
+		if !ok {
+			return NewError(i.Pos().Sline(), UNKNOWNIDENT, name)
+		}
+		parent, ok := p.(*Interface)
+		if !ok {
+			return NewError(i.Pos().Sline(), NOTINTERFACEERROR, name)
+		}
+		intf.Parents = append(intf.Parents, parent)
+	}
+
+	scope.Set(i.Name.Value, intf) //save to scope
+
+	return NIL
+}
+
 func evalClassLiterlForAnno(c *ast.ClassLiteral, scope *Scope) Object {
 	var parentClass = BASE_CLASS //base class is the root of all classes in magpie
 	if c.Parent != "" {
@@ -5188,6 +5590,28 @@ func evalNewExpression(n *ast.NewExpression, scope *Scope) Object {
 		return NewError(n.Pos().Sline(), NOTCLASSERROR, n.Class)
 	}
 
+	instance := newObjectInstance(clsObj, scope)
+
+	//Is it has a constructor ?
+	init := clsObj.GetMethod("init")
+	if init == nil {
+		return instance
+	}
+
+	args := evalArgs(n.Arguments, scope)
+	if len(args) == 1 && args[0].Type() == ERROR_OBJ {
+		return args[0]
+	}
+
+	ret := evalFunctionDirect(init, args, instance, instance.Scope, nil)
+	if ret.Type() == ERROR_OBJ {
+		return ret //return the error object
+	}
+	return instance
+}
+
+//create an instance of 'clsObj', with its members evaluated, but the constructor not called.
+func newObjectInstance(clsObj *Class, scope *Scope) *ObjectInstance {
 	tmpClass := clsObj
 	classChain := make([]*Class, 0, 3)
 	classChain = append(classChain, clsObj)
@@ -5211,22 +5635,6 @@ func evalNewExpression(n *ast.NewExpression, scope *Scope) Object {
 	instance := &ObjectInstance{Class: clsObj, Scope: newScope.parentScope}
 	instance.Scope.Set("this", instance)        //make 'this' refer to instance
 	instance.Scope.Set("parent", classChain[1]) //make 'parent' refer to instance's parent
-
-	//Is it has a constructor ?
-	init := clsObj.GetMethod("init")
-	if init == nil {
-		return instance
-	}
-
-	args := evalArgs(n.Arguments, scope)
-	if len(args) == 1 && args[0].Type() == ERROR_OBJ {
-		return args[0]
-	}
-
-	ret := evalFunctionDirect(init, args, instance, instance.Scope, nil)
-	if ret.Type() == ERROR_OBJ {
-		return ret //return the error object
-	}
 	return instance
 }
 
@@ -5320,32 +5728,52 @@ func evalFunctionDirect(fn Object, args []Object, instance *ObjectInstance, scop
 			newScope.Set("@_", NewInteger(int64(len(fn.Literal.Parameters))))
 		}
 
-		if fn.Async && call.Awaited {
-			aChan := make(chan Object, 1)
+		if fn.Literal.Generator {
+			return newGenerator(methodName(call, scope), scope, func(genScope *Scope) Object {
+				newScope.CallStack = genScope.CallStack
+				if call != nil {
+					newScope.CallStack.Frames = append(newScope.CallStack.Frames, CallFrame{FuncScope: newScope, CurrentCall: call, name: methodName(call, scope)})
+					defer leaveFunction(newScope)
+				}
+
+				r := Eval(fn.Literal.Body, newScope)
+				traceError(r, newScope)
+				return r
+			})
+		}
 
-			go func() {
-				defer close(aChan)
+		line := fn.Literal.Pos().Sline()
+		if call != nil {
+			line = call.Function.Pos().Sline()
+		}
+		name := methodName(call, scope)
 
+		if fn.Async {
+			newScope.CallStack = newScope.CallStack.fork()
+			return startTask(name, newScope.CallStack, func() Object {
+				if errObj := checkArgTypes(line, fn.Literal, name, args, newScope); errObj != nil {
+					return errObj
+				}
 				results := Eval(fn.Literal.Body, newScope)
 				if obj, ok := results.(*ReturnValue); ok {
-					// if function returns multiple-values
-					// returns a tuple instead.
-					if len(obj.Values) > 1 {
-						results = &Tuple{Members: obj.Values, IsMulti: true}
-					} else {
-						results = obj.Value
-					}
+					return checkReturnType(line, fn.Literal, name, unwrapReturnValue(obj), newScope)
 				}
+				traceError(results, newScope)
+				return checkReturnType(line, fn.Literal, name, results, newScope)
+			})
+		}
 
-				aChan <- results
-			}()
+		if call != nil { //method call, register it in the call stack
+			if err := checkCallDepth(call, scope); err != nil {
+				return err
+			}
 
-			return <-aChan
+			newScope.CallStack.Frames = append(newScope.CallStack.Frames, CallFrame{FuncScope: newScope, CurrentCall: call, name: name})
+			defer leaveFunction(newScope)
 		}
 
-		if fn.Async {
-			go Eval(fn.Literal.Body, newScope)
-			return NIL
+		if errObj := checkArgTypes(line, fn.Literal, name, args, newScope); errObj != nil {
+			return errObj
 		}
 
 		//newScope.DebugPrint("    ") //debug
@@ -5354,16 +5782,23 @@ func evalFunctionDirect(fn Object, args []Object, instance *ObjectInstance, scop
 			// if function returns multiple-values
 			// returns a tuple instead.
 			if len(obj.Values) > 1 {
-				return &Tuple{Members: obj.Values, IsMulti: true}
+				return checkReturnType(line, fn.Literal, name, &Tuple{Members: obj.Values, IsMulti: true}, newScope)
 			}
-			return obj.Value
+			return checkReturnType(line, fn.Literal, name, obj.Value, newScope)
 		}
 
-		return results
+		if call != nil {
+			traceError(results, newScope)
+		}
+		return checkReturnType(line, fn.Literal, name, results, newScope)
 	case *Builtin:
 		return fn.Fn("", scope, args...)
 	case *BuiltinMethod:
-		return fn.Fn("", fn.Instance, scope, args...)
+		self := fn.Instance
+		if self == nil { //e.g. builtin 'init' called by 'new'
+			self = instance
+		}
+		return fn.Fn("", self, scope, args...)
 	}
 
 	return NewError("", GENERICERROR, fn.Type()+" is not a function")
@@ -5608,49 +6043,100 @@ func constructFuncLiteral(value string, expr ast.Expression, tokenType token.Tok
 //               LINQ EVALUATION LOGIC(END)
 //========================================================
 
+//'await task' waits for the task and returns its result, the other values are returned as is.
 func evalAwaitExpression(a *ast.AwaitExpr, scope *Scope) Object {
-	switch fn := a.Call.(type) {
-	case *ast.CallExpression:
-		fn.Awaited = true
-		return evalFunctionCall(fn, scope)
-	case *ast.MethodCallExpression:
-		fn.Call.(*ast.CallExpression).Awaited = true
-		return evalMethodCallExpression(fn, scope)
-	default:
-		//should never reach this line, because the parser will check type call type
-		return NIL
+	val := Eval(a.Value, scope)
+	if task, ok := val.(*Task); ok {
+		return task.wait(a.Pos().Sline(), scope)
 	}
+	return val
 }
 
 func evalServiceStatement(s *ast.ServiceStatement, scope *Scope) Object {
 	//note: map's value is not important
 	var routeMap = map[string]bool{
-		"url":     true,
-		"methods": true,
-		"host":    true,
-		"schemes": true,
-		"headers": true,
-		"queries": true,
+		"url":        true,
+		"methods":    true,
+		"host":       true,
+		"schemes":    true,
+		"headers":    true,
+		"queries":    true,
+		"middleware": true,
+		"before":     true,
+		"after":      true,
 	}
 
 	svcObj := NewService(s.Addr).(*ServiceObj)
 
+	//the functions in source order, so the middlewares are chained in order,
+	//and the routes could refer to any function of the service.
+	var fnStmts []*ast.FunctionStatement
 	for _, fnStmt := range s.Methods {
-		f := evalFunctionStatement(fnStmt, scope).(*Function)
+		fnStmts = append(fnStmts, fnStmt)
+	}
+	sort.Slice(fnStmts, func(i, j int) bool { return fnStmts[i].Pos().Offset < fnStmts[j].Pos().Offset })
+	fns := make([]*Function, len(fnStmts))
+	for i, fnStmt := range fnStmts {
+		fns[i] = evalFunctionStatement(fnStmt, scope).(*Function)
+	}
+
+	for i, fnStmt := range fnStmts {
+		f := fns[i]
+		anno := ast.ServiceAnnotation(fnStmt)
+		line := fnStmt.Pos().Sline()
+		if anno.Name.Value != "route" && anno.Name.Value != "websocket" { //@middleware, @before or @after
+			mw, err := ServiceMiddleware(line, scope, f, anno.Name.Value)
+			if err != nil {
+				return err
+			}
+			svcObj.Middlewares = append(svcObj.Middlewares, mw)
+			continue
+		}
+
+		var url *String
 		var methodArr *Array
 		var host *String
 		var schemes *String
 		var headers *Hash
 		var queries *Hash
-		var hasUrl bool
-		anno := fnStmt.Annotations[0]
+		mws, errObj := routeMiddlewares(line, scope, anno)
+		if errObj != nil {
+			return errObj
+		}
+
+		//request body binding: @body(schema=User), runs after the other route middlewares
+		if bodyAnno := ast.BodyAnnotation(fnStmt); bodyAnno != nil {
+			v, ok := bodyAnno.Attributes["schema"]
+			if !ok {
+				return NewError(line, GENERICERROR, "'@body' must have a schema")
+			}
+			val := Eval(v, scope)
+			if val.Type() == ERROR_OBJ {
+				return val
+			}
+			cls, ok := val.(*Class)
+			if !ok {
+				return NewError(line, PARAMTYPEERROR, "schema", "body", "*Class", val.Type())
+			}
+			maxSize := int64(maxRequestBody)
+			if v, ok := bodyAnno.Attributes["maxSize"]; ok {
+				val := Eval(v, scope)
+				if val.Type() == ERROR_OBJ {
+					return val
+				}
+				i, ok := val.(*Integer)
+				if !ok || i.Int64 <= 0 {
+					return NewError(line, GENERICERROR, "'@body' maxSize must be a positive integer")
+				}
+				maxSize = i.Int64
+			}
+			mws = append(mws, BodyMiddleware(line, scope, cls, maxSize))
+		}
+
 		for k, v := range anno.Attributes { //for each annotation attribute
 			if _, ok := routeMap[k]; ok {
 				if k == "url" {
-					hasUrl = true
-					val := Eval(v, scope).(*String)
-					//fmt.Printf("key=%s, val=%s, val.Type=%s\n", k, val.Inspect(), val.Type())
-					svcObj.HandleFunc(s.Pos().Sline(), scope, val, f)
+					url = Eval(v, scope).(*String)
 				} else if k == "methods" {
 					methodArr = Eval(v, scope).(*Array)
 				} else if k == "host" {
@@ -5667,9 +6153,27 @@ func evalServiceStatement(s *ast.ServiceStatement, scope *Scope) Object {
 			}
 		}
 
-		if !hasUrl {
+		if url == nil {
 			return NewError(s.Pos().Sline(), SERVICENOURLERROR, s.Name.Value, fnStmt.Name.Value)
 		}
+		if paramCount := len(f.Literal.Parameters); paramCount != 2 {
+			return NewError(line, FUNCCALLBACKERROR, 2, paramCount)
+		}
+		if anno.Name.Value == "websocket" {
+			var origins []string
+			if v, ok := anno.Attributes["origins"]; ok { //@websocket(url=..., origins=[...])
+				val := Eval(v, scope)
+				if val.Type() == ERROR_OBJ {
+					return val
+				}
+				if origins, errObj = websocketOrigins(line, "origins", "websocket", val); errObj != nil {
+					return errObj
+				}
+			}
+			svcObj.handleWebSocket(s.Pos().Sline(), scope, url.String, f, origins, mws)
+		} else {
+			svcObj.handle(s.Pos().Sline(), scope, url.String, f, mws)
+		}
 
 		if methodArr != nil {
 			svcObj.Methods(s.Pos().Sline(), methodArr.Members...)
@@ -5680,7 +6184,7 @@ func evalServiceStatement(s *ast.ServiceStatement, scope *Scope) Object {
 		}
 
 		if schemes != nil {
-			svcObj.Schemes(s.Pos().Sline(), host)
+			svcObj.Schemes(s.Pos().Sline(), schemes)
 		}
 
 		if headers != nil {
@@ -5692,9 +6196,132 @@ func evalServiceStatement(s *ast.ServiceStatement, scope *Scope) Object {
 		}
 	}
 
-	fmt.Fprintf(scope.Writer, ServiceHint, svcObj.Addr)
-	svcObj.Run(s.Pos().Sline(), NewBooleanObj(s.Debug))
-	return NIL
+	//@static(url="/assets", dir="./public", maxAge=3600), after the other routes
+	for _, anno := range s.Statics {
+		if errObj := evalStaticRoute(svcObj, anno, scope); errObj != nil {
+			return errObj
+		}
+	}
+
+	if s.OpenAPI {
+		if err := svcObj.handleOpenAPI(s); err != nil {
+			return NewError(s.Pos().Sline(), GENERICERROR, err.Error())
+		}
+	}
+
+	if s.Debug {
+		svcObj.Router.Use(LoggingMiddleware)
+	}
+
+	//service Hello on "0.0.0.0:8443", {"cert": "server.crt", "key": "server.key"} { ... }
+	if s.Options != nil {
+		val := Eval(s.Options, scope)
+		if val.Type() == ERROR_OBJ {
+			return val
+		}
+		opts, ok := val.(*Hash)
+		if !ok {
+			return NewError(s.Pos().Sline(), PARAMTYPEERROR, "options", "service", "*Hash", val.Type())
+		}
+		o, err := newServerOptions(opts, true)
+		if err != nil {
+			return NewError(s.Pos().Sline(), GENERICERROR, err.Error())
+		}
+		svcObj.options = o
+
+		//the templates are parsed again on each render in debug mode
+		if o.templates != "" {
+			if svcObj.views, err = newServiceViews(o.templates, s.Debug); err != nil {
+				return NewError(s.Pos().Sline(), GENERICERROR, err.Error())
+			}
+		}
+	}
+
+	//The service runs in the background, its name refers to its handle:
+	//  Hello.addr(), Hello.stop(5), Hello.wait()
+	scope.Set(s.Name.Value, svcObj)
+	if scope.interp.noListen() {
+		return svcObj
+	}
+	if err := svcObj.runner.start(svcObj.newServer()); err != nil {
+		return NewError(s.Pos().Sline(), GENERICERROR, err.Error())
+	}
+	fmt.Fprintf(scope.Writer, ServiceHint, svcObj.runner.addr())
+	return svcObj
+}
+
+// routeMiddlewares returns the route level middlewares of the annotation:
+// @route(url="/admin", middleware=[auth], before=check, after=log)
+func routeMiddlewares(line string, scope *Scope, anno *ast.AnnotationStmt) ([]MiddlewareFunc, Object) {
+	var mws []MiddlewareFunc
+	for _, kind := range []string{"middleware", "before", "after"} {
+		v, ok := anno.Attributes[kind]
+		if !ok {
+			continue
+		}
+		val := Eval(v, scope)
+		if val.Type() == ERROR_OBJ {
+			return nil, val
+		}
+		members := []Object{val}
+		if arr, ok := val.(*Array); ok {
+			members = arr.Members
+		}
+		for _, member := range members {
+			mwFn, ok := member.(*Function)
+			if !ok {
+				return nil, NewError(line, PARAMTYPEERROR, kind, anno.Name.Value, "*Function", member.Type())
+			}
+			mw, err := ServiceMiddleware(line, scope, mwFn, kind)
+			if err != nil {
+				return nil, err
+			}
+			mws = append(mws, mw)
+		}
+	}
+	return mws, nil
+}
+
+// evalStaticRoute registers the '@static(url=..., dir=...[, maxAge=...])' route
+// of the service, it could also have the route level middlewares.
+func evalStaticRoute(svcObj *ServiceObj, anno *ast.AnnotationStmt, scope *Scope) Object {
+	line := anno.Pos().Sline()
+	attrs := map[string]Object{}
+	for _, name := range []string{"url", "dir", "maxAge"} {
+		v, ok := anno.Attributes[name]
+		if !ok {
+			continue
+		}
+		val := Eval(v, scope)
+		if val.Type() == ERROR_OBJ {
+			return val
+		}
+		attrs[name] = val
+	}
+
+	url, ok := attrs["url"].(*String)
+	if !ok {
+		return NewError(line, GENERICERROR, "'@static' must have a 'url' string")
+	}
+	dir, ok := attrs["dir"].(*String)
+	if !ok {
+		return NewError(line, GENERICERROR, "'@static' must have a 'dir' string")
+	}
+	var maxAge int64
+	if v, ok := attrs["maxAge"]; ok {
+		i, ok := v.(*Integer)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "maxAge", "static", "*Integer", v.Type())
+		}
+		maxAge = i.Int64
+	}
+
+	mws, errObj := routeMiddlewares(line, scope, anno)
+	if errObj != nil {
+		return errObj
+	}
+	svcObj.handleStatic(url.String, dir.String, maxAge, mws)
+	return nil
 }
 
 //private method for evalate 'a..b' expression, and returns an array object
@@ -5782,10 +6409,10 @@ func evalRangeExpression(node ast.Node, startIdx Object, endIdx Object, scope *S
 func evalDiamondExpr(d *ast.DiamondExpr, scope *Scope) Object {
 	var obj Object
 	var ok bool
-	if obj, ok = GetGlobalObj(d.Value); !ok {
+	if obj, ok = scope.interp.getGlobal(d.Value); !ok {
 		obj, ok = scope.Get(d.Value)
 		if !ok {
-			if obj, ok = importScope.Get(d.Value); !ok {
+			if obj, ok = scope.interp.importState().scope.Get(d.Value); !ok {
 				return reportTypoSuggestions(d.Pos().Sline(), scope, d.Value)
 			}
 		}
@@ -2,6 +2,7 @@ package eval
 
 import (
 	"fmt"
+	"magpie/ast"
 	"magpie/lexer"
 	"magpie/parser"
 	"os"
@@ -18,7 +19,7 @@ func TestDoLoop(t *testing.T) {
 	}
 
 	for _, tt := range test {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -32,7 +33,7 @@ func TestWhileLoop(t *testing.T) {
 	}
 
 	for _, tt := range test {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -48,7 +49,7 @@ func TestReassignment(t *testing.T) {
 	}
 
 	for _, tt := range test {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -58,17 +59,17 @@ func TestFileObjects(t *testing.T) {
 		expected interface{}
 	}{
 		{`let f = open("../parser/test_files/module.mp");str(f)`, "<file object: ../parser/test_files/module.mp>"},
-		{`let f = open("../parser/test_files/module.mp");f.read()`, `import eval
+		{`let f = open("../parser/test_files/module.mp");f.read(42)`, `import eval
 import test
 import sub_package`},
-		{`let f = open("../parser/test_files/module.mp");f.readline()`, "import eval"},
-		{`let f = open("../parser/test_files/module.mp");f.readline();f.readline()`, "import test"},
-		{`let f = open("../parser/test_files/module.mp");f.readline();f.readline();f.readline()`, "import sub_package"},
+		{`let f = open("../parser/test_files/module.mp");f.readLine()`, "import eval"},
+		{`let f = open("../parser/test_files/module.mp");f.readLine();f.readLine()`, "import test"},
+		{`let f = open("../parser/test_files/module.mp");f.readLine();f.readLine();f.readLine()`, "import sub_package"},
 	}
 	d, _ := os.Getwd()
 	fmt.Println(d)
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case string:
 			testStringObject(t, evaluated, expected)
@@ -78,31 +79,7 @@ import sub_package`},
 
 func TestChainedCalled(t *testing.T) {
 	input := `[1,2,3].map(fn(x) { x + 1 }).map(fn(x) { x * 5 }).filter(fn(x) { x > 10 }).pop()`
-	testEval(input)
-}
-
-func TestStructObjects(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{`struct (a=>15).a`, 15},
-		{`let st = struct {a=>15}; type(addm(st, "get", fn() { self.a })) == "NIL"`, true},
-		{`let st = struct {a=>15}; addm(st, "get", fn() { self.a }); st.get()`, 15},
-		{`let st = struct {a=>15}; addm(st, "get", fn() { a }); type(st.get()) == "ERROR"`, true},
-	}
-
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		switch expected := tt.expected.(type) {
-		case int:
-			testIntegerObject(t, evaluated, int64(expected))
-		case bool:
-			testBooleanObject(t, evaluated, expected)
-		default:
-			t.Errorf("evaluted not %T. got=%T", evaluated, expected)
-		}
-	}
+	testEval(t, input)
 }
 
 //func TestImportObjects(t *testing.T) {
@@ -147,15 +124,15 @@ func TestStringMethods(t *testing.T) {
 		{`"string".find("g")`, 5},
 		{`"string".find("tr")`, 1},
 		{`"string".find("ng")`, 4},
-		{`"string".find("x")`, NIL},
-		{`"".find("stringstring")`, NIL},
-		{`"string".find("")`, NIL},
-		{`"string".find(1)`, NewError("0", INPUTERROR, INTEGER_OBJ, "find")},
-		{`"string".find([])`, NewError("0", INPUTERROR, ARRAY_OBJ, "find")},
+		{`"string".find("x")`, -1},
+		{`"".find("stringstring")`, -1},
+		{`"string".find("")`, 0},
+		{`"string".find(1)`, NewError("1", PARAMTYPEERROR, "first", "find", "*String", INTEGER_OBJ)},
+		{`"string".find([])`, NewError("1", PARAMTYPEERROR, "first", "find", "*String", ARRAY_OBJ)},
 		{`"string".reverse()`, "gnirts"},
 		{`"".reverse()`, ""},
 		{`"ab".reverse()`, "ba"},
-		{`"".reverse(1)`, NewError("0", ARGUMENTERROR, "0", 1)},
+		{`"".reverse(1)`, NewError("1", ARGUMENTERROR, "0", 1)},
 		{`"".upper()`, ""},
 		{`"abc".upper()`, "ABC"},
 		{`"a b c".upper()`, "A B C"},
@@ -166,26 +143,24 @@ func TestStringMethods(t *testing.T) {
 		{`" string".lstrip()`, "string"},
 		{`"strsing".lstrip("s")`, "trsing"},
 		{`" 	".lstrip()`, ""},
-		{`"
-			string".lstrip()`, "string"},
+		{`"\n\t\t\tstring".lstrip()`, "string"},
 		{`"` + string('\r') + `string".lstrip()`, "string"},
 		{`"string".lstrip("s")`, "tring"},
 		{`"string".lstrip("st")`, "ring"},
 		{`"ststring".lstrip("st")`, "ring"},
 		{`"string ".rstrip()`, "string"},
-		{`"` + string('\r') + string('\n') + "	 " + `".rstrip()`, ""},
+		{`"` + string('\r') + `\n	 ".rstrip()`, ""},
 		{`"string".rstrip()`, "string"},
 		{`"string".rstrip("g")`, "strin"},
 		{`"strging".rstrip("g")`, "strgin"},
 		{`"string".rstrip("ng")`, "stri"},
-		{`"string
-			".rstrip()`, "string"},
+		{`"string\n\t\t\t".rstrip()`, "string"},
 		// strip just calls lstrip and rstrip consecutively, we can
 		// have fewer tests here since the above is pretty comprehensive
 		// just make sure it calls both
 		{`" string ".strip()`, "string"},
 		{`"ssstringss".strip("s")`, "tring"},
-		{`let s = "1 2 3".split(); s[0] + s[1] + s[2]`, "123"},
+		{`let s = "1 2 3".split(" "); s[0] + s[1] + s[2]`, "123"},
 		{`let s = "1,2,3".split(","); s[0] + s[1] + s[2]`, "123"},
 		{`let s = "1&_2&_3&_".split("&_"); s[0] + s[1] + s[2] + s[3]`, "123"},
 		{`"abc".replace("a", "A")`, "Abc"},
@@ -194,17 +169,15 @@ func TestStringMethods(t *testing.T) {
 		{`"eee".count("e")`, 3},
 		{`"These are the days of summer".count("e")`, 5},
 		{`"These are the days of summer".count(" ")`, 5},
-		{`" ".join(["a", "b", "c"])`, "a b c"},
-		{`"!".join(["a", "b", "c"])`, "a!b!c"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
-		case *Null:
-			testNullObject(t, expected)
+		case *Nil:
+			testNullObject(t, evaluated)
 		case string:
 			testStringObject(t, evaluated, expected)
 		case *Error:
@@ -220,18 +193,29 @@ func TestStringIndexExpressions(t *testing.T) {
 		expected string
 	}{
 		{`"string"[0]`, "s"},
-		{`"string"[-1]`, "g"},
 		{`"string"[2]`, "r"},
 		{`"string"[0:]`, "string"},
 		{`"string"[1:]`, "tring"},
 		{`"string"[2:5]`, "rin"},
-		{`"string"[-5:-1]`, "trin"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testStringObject(t, evaluated, tt.expected)
 	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{`"string"[-1]`, "index error: '-1' out of range at line 1"},
+		{`"string"[-5:-1]`, "index error: '-5' out of range at line 1"},
+	}
+
+	for _, tt := range errTests {
+		evaluated := testEval(t, tt.input)
+		testErrorObject(t, evaluated, tt.expected)
+	}
 }
 
 func TestHashIndexExpressions(t *testing.T) {
@@ -239,17 +223,17 @@ func TestHashIndexExpressions(t *testing.T) {
 		input    string
 		expected interface{}
 	}{
-		{`{"foo": 5}["foo"]`, 5},
-		{`{"foo": 5}["bar"]`, nil},
-		{`let key = "foo";{"foo": 5}[key]`, 5},
-		{`{}["foo"]`, nil},
-		{`{5: 5}[5]`, 5},
-		{`{true: 5}[true]`, 5},
-		{`{false: 5}[false]`, 5},
+		{`let h = {"foo": 5}; h["foo"]`, 5},
+		{`let h = {"foo": 5}; h["bar"]`, nil},
+		{`let key = "foo"; let h = {"foo": 5}; h[key]`, 5},
+		{`let h = {}; h["foo"]`, nil},
+		{`let h = {5: 5}; h[5]`, 5},
+		{`let h = {true: 5}; h[true]`, 5},
+		{`let h = {false: 5}; h[false]`, 5},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -262,16 +246,17 @@ func TestHashIndexExpressions(t *testing.T) {
 func TestHashLiterals(t *testing.T) {
 	input := `
 	let two = "two";
-	{
+	let h = {
 		"one"        : 10 - 9,
 		two          : 1 + 1,
 		"thr" + "ee" : 6 /2,
 		4            : 4,
 		true         : 5,
 		false        : 6
-	}`
+	}
+	h`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	hash, ok := evaluated.(*Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T, (%+v)", evaluated, evaluated)
@@ -349,7 +334,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 		},
 		{
 			"[1, 2, 3][-1]",
-			3,
+			"index error: '-1' out of range at line 1",
 		},
 		{
 			"let myArray = [1, 2, 3, 4, 5]; let i = myArray[0:]; let mySlice = myArray[1:]; mySlice[0]",
@@ -365,23 +350,19 @@ func TestArrayIndexExpressions(t *testing.T) {
 		},
 		{
 			"let myArray = [1, 2, 3, 4, 5];let mySlice = myArray[:]; mySlice[-1]",
-			5,
+			"index error: '-1' out of range at line 1",
 		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integer, ok := tt.expected.(int)
-		if ok {
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			if err, ok := evaluated.(*Error); ok {
-				if err.Message != "index error: '3' out of range" {
-					t.Errorf("wrong error message. got=%s", err.Message)
-				}
-			} else {
-				t.Errorf("evaluated not array or error. got=%T", evaluated)
-			}
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
 		}
 	}
 }
@@ -389,7 +370,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	results, ok := evaluated.(*Array)
 	if !ok {
 		t.Fatalf("object is not Array. got=%T", evaluated)
@@ -408,11 +389,11 @@ func TestFunctionalMethods(t *testing.T) {
 		input    string
 		expected bool
 	}{
-		{`let a = [1,2].map(fn(x) {x + 1}); fn(x) { if (x[0] == 2) { if (x[1] == 3) { return true; }} else { return false }}(a)`, true},
-		{`let a = [1,2].filter(fn(x) {x == 1}); fn(x) { if (x.len() == 1) { if (x[0] == 1) { return true; }} else { return false }}(a)`, true},
+		{`let a = [1,2].map(fn(x) {x + 1}); let f = fn(x) { if (x[0] == 2) { if (x[1] == 3) { return true; }} else { return false }}; f(a)`, true},
+		{`let a = [1,2].filter(fn(x) {x == 1}); let f = fn(x) { if (x.len() == 1) { if (x[0] == 1) { return true; }} else { return false }}; f(a)`, true},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -422,20 +403,20 @@ func TestHashMethods(t *testing.T) {
 		input    string
 		expected interface{}
 	}{
-		{`{1:"a", 2:"b"}.pop(1)`, "a"},
-		{`let a = {1:"a", 2:"b"}; a.pop(1); str(a)`, `{2: b}`},
+		{`let a = {1:"a", 2:"b"}; a.pop(1)`, "a"},
+		{`let a = {1:"a", 2:"b"}; a.pop(1); str(a)`, `{2 : "b"}`},
 		{`let a = {1:"a", 2:"b"}.push(3, "c"); a[3]`, `c`},
 		{`let a = {1:"a", 2:"b"}; let b = {3:"c"} let c = a.merge(b); c[3]`, `c`},
-		{`let a = {1:"a", 2:"b"}; let b = {3:"c"} let c = a.merge(b); str(a[3])`, `null`},
-		{`let a = {1:"a", 2:"b"}; let b = {3:"c"} let c = a.merge(b); str(b[1])`, `null`},
-		{`let a = {"a":1}.map(fn(k, v){ {k.upper():v+1} } ); str(a)`, `{A: 2}`},
-		{`let a = {"a":1, "b":2}.filter(fn(k, v){ v > 1 } ); str(a)`, `{b: 2}`},
-		{`str({"a":1}.keys())`, `[a]`},
+		{`let a = {1:"a", 2:"b"}; let b = {3:"c"} let c = a.merge(b); str(a[3])`, `nil`},
+		{`let a = {1:"a", 2:"b"}; let b = {3:"c"} let c = a.merge(b); str(b[1])`, `nil`},
+		{`let a = {"a":1}.map(fn(k, v){ return {k.upper():v+1} } ); str(a)`, `{"A" : 2}`},
+		{`let a = {"a":1, "b":2}.filter(fn(k, v){ v > 1 } ); str(a)`, `{"b" : 2}`},
+		{`str({"a":1}.keys())`, `["a"]`},
 		{`str({"a":1}.values())`, `[1]`},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
@@ -459,7 +440,7 @@ func TestArrayMethods(t *testing.T) {
 		{`let a = [1,2,3].filter(fn(x) { x > 1}); str(a)`, `[2, 3]`},
 		{`let a = [1,2,3].map(fn(x) { x + 1}); str(a)`, `[2, 3, 4]`},
 		{`let a = [1,2,3].merge([4]); str(a)`, `[1, 2, 3, 4]`},
-		{`let a = ["a","b","c","d"].map(fn(x){ x.upper() }); str(a)`, `[A, B, C, D]`},
+		{`let a = ["a","b","c","d"].map(fn(x){ x.upper() }); str(a)`, `["A", "B", "C", "D"]`},
 		{`["a","b","c","d"].index("d")`, 3},
 		{`[1,1,1,2,3].count(1)`, 3},
 		{`[1,2,3,4,5].reduce(fn(x, y) { x + y})`, 15},
@@ -468,7 +449,7 @@ func TestArrayMethods(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
@@ -487,16 +468,16 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("four")`, 4},
 		{`len([1, 3, 5])`, 3},
 		{`len([1,2,3])`, 3},
-		{`"string".plus()`, "undefined method 'plus' for object STRING"},
-		{`"string".plus`, "undefined method 'string.plus' for object STRING"},
-		{`len("one", "two")`, "wrong number of arguments. expected=1, got=2"},
-		{`len(1)`, "undefined method 'len' for object INTEGER"},
+		{`"string".plus()`, "undefined method 'plus' for object STRING at line 1"},
+		{`"string".plus`, "undefined method 'plus' for object STRING at line 1"},
+		{`len("one", "two")`, "wrong number of arguments. expected=1, got=2 at line 1"},
+		{`len(1)`, "first argument for 'len' should be type *String|*Array|*Hash|*Nil. got=INTEGER at line 1"},
 		{`int("1")`, 1},
 		{`int("100")`, 100},
 		{`int(1)`, 1},
-		{`int("one")`, `unsupported input type 'STRING: one' for function or method: int`},
-		{`int([])`, `unsupported input type 'ARRAY' for function or method: int`},
-		{`int({})`, `unsupported input type 'HASH' for function or method: int`},
+		{`int("one")`, `unsupported input type 'STRING: one' for function or method: int at line 1`},
+		{`int([])`, `first argument for 'int' should be type *String|*Integer|*UInteger|*Boolean|*Float. got=ARRAY at line 1`},
+		{`int({})`, `first argument for 'int' should be type *String|*Integer|*UInteger|*Boolean|*Float. got=HASH at line 1`},
 		{`str(1)`, "1"},
 		{`str(true)`, `true`},
 		{`str(false)`, `false`},
@@ -513,7 +494,7 @@ func TestBuiltinFunction(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
@@ -543,7 +524,7 @@ func TestStringLiteral(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testStringObject(t, testEval(tt.input), tt.expected)
+		testStringObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -561,7 +542,7 @@ let ourFunction = fn(first) {
 
 ourFunction(20) + first + second;`
 
-	testIntegerObject(t, testEval(input), 70)
+	testIntegerObject(t, testEval(t, input), 70)
 }
 
 func TestFunctionApplication(t *testing.T) {
@@ -579,13 +560,13 @@ func TestFunctionApplication(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2 };"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 
 	fn, ok := evaluated.(*Function)
 	if !ok {
@@ -598,7 +579,7 @@ func TestFunctionObject(t *testing.T) {
 		t.Fatalf("parameter is not 'x'. got=%q", fn.Literal.Parameters[0])
 	}
 
-	expectedBody := "(x + 2)"
+	expectedBody := "(x + 2);"
 	if fn.Literal.Body.String() != expectedBody {
 		t.Fatalf("body is not '(x + 2). got=%q", fn.Literal.Body)
 	}
@@ -616,7 +597,7 @@ func TestLetStatements(t *testing.T) {
 	}
 
 	for _, tt := range test {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -627,31 +608,31 @@ func TestErrorHandling(t *testing.T) {
 	}{
 		{
 			"5 + true;",
-			"unsupported operator for infix expression: '+' and types INTEGER and BOOLEAN",
+			"unsupported operator for infix expression: INTEGER '+' BOOLEAN at line 1",
 		},
 		{
 			"5 + true; 5;",
-			"unsupported operator for infix expression: '+' and types INTEGER and BOOLEAN",
+			"unsupported operator for infix expression: INTEGER '+' BOOLEAN at line 1",
 		},
 		{
 			"-true",
-			"unsupported operator for prefix expression:'-' and type: BOOLEAN",
+			"unsupported operator for prefix expression:'(-true)' and type: BOOLEAN at line 1",
 		},
 		{
 			"true + false;",
-			"unsupported operator for infix expression: '+' and types BOOLEAN and BOOLEAN",
+			"unsupported operator for infix expression: BOOLEAN '+' BOOLEAN at line 1",
 		},
 		{
 			"true + false + true + false;",
-			"unsupported operator for infix expression: '+' and types BOOLEAN and BOOLEAN",
+			"unsupported operator for infix expression: BOOLEAN '+' BOOLEAN at line 1",
 		},
 		{
 			"5; true + false; 5",
-			"unsupported operator for infix expression: '+' and types BOOLEAN and BOOLEAN",
+			"unsupported operator for infix expression: BOOLEAN '+' BOOLEAN at line 1",
 		},
 		{
 			"if (10 > 1) { true + false; }",
-			"unsupported operator for infix expression: '+' and types BOOLEAN and BOOLEAN",
+			"unsupported operator for infix expression: BOOLEAN '+' BOOLEAN at line 1",
 		},
 		{
 			`
@@ -663,31 +644,35 @@ if (10 > 1) {
   return 1;
 }
 `,
-			"unsupported operator for infix expression: '+' and types BOOLEAN and BOOLEAN",
+			"unsupported operator for infix expression: BOOLEAN '+' BOOLEAN at line 4",
 		},
-		{"foobar", "unknown identifier: 'foobar' is not defined"},
+		{"foobar", "unknown identifier: 'foobar' is not defined at line 1"},
 		//{`"abc" + 2`, "unsupported operator for infix expression: '+' and types STRING and INTEGER"},
-		{`"abc" - "abc"`, "unsupported operator for infix expression: '-' and types STRING and STRING"},
-		{`"abc" * "abc"`, "unsupported operator for infix expression: '*' and types STRING and STRING"},
-		{`"abc" / "abc"`, "unsupported operator for infix expression: '/' and types STRING and STRING"},
-		{`"abc" > "abc"`, "unsupported operator for infix expression: '>' and types STRING and STRING"},
-		{`"abc" < "abc"`, "unsupported operator for infix expression: '<' and types STRING and STRING"},
-		{`{"name":"Magpie"}[fn(x) {x}];`, "key error: type FUNCTION is not hashable"},
+		{`"abc" - "abc"`, "unsupported operator for infix expression: STRING '-' STRING at line 1"},
+		{`"abc" * "abc"`, "unsupported operator for infix expression: STRING '*' STRING at line 1"},
+		{`let s = "abc"; s / "abc"`, "unsupported operator for infix expression: STRING '/' STRING at line 1"},
+		{`let h = {"name":"Magpie"}; h[fn(x) {x}];`, "key error: type FUNCTION is not hashable at line 1"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		errObj, ok := evaluated.(*Error)
-		if !ok {
-			t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
-			continue
-		}
-		if errObj.Message != tt.expectedMessage {
-			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
-		}
+		evaluated := testEval(t, tt.input)
+		testErrorObject(t, evaluated, tt.expectedMessage)
 	}
 }
 
+func testErrorObject(t *testing.T, obj Object, expected string) bool {
+	errObj, ok := obj.(*Error)
+	if !ok {
+		t.Errorf("no error object returned. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+		return false
+	}
+	return true
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -703,7 +688,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		if integer, ok := tt.expected.(int); ok {
 			testIntegerObject(t, evaluated, int64(integer))
 		} else {
@@ -726,7 +711,7 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -746,7 +731,7 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		if integer, ok := tt.expected.(int); ok {
 			testIntegerObject(t, evaluated, int64(integer))
 		} else {
@@ -773,7 +758,7 @@ func TestEvalBooleanLiterals(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -805,17 +790,19 @@ func TestEvalBooleanExpressions(t *testing.T) {
 		{"true or true", true},
 		{"true or false", true},
 		{`"string" and false`, false},
-		{`[] or false`, true},
+		{`[] or false`, false},
 		{`len([1,2,3]) > 2 and false`, false},
 		{`type([]) == "ARRAY" and len([1234]) == 4`, false},
 		{`type([]) == "ARRAY" and len("1234") == 4`, true},
 		{"(true and true) or (true or false)", true},
 		{"(true and true) and (true and false)", false},
-		{`!!"abc".find("d")`, false},
+		{`!!"abc".find("d")`, true},
+		{`"abc" > "abc"`, false},
+		{`"abc" < "abd"`, true},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -848,11 +835,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"5 * 2 + 10", 20},
 		{"5 + 2 * 10", 25},
 		{"20 + 2 * -10", 0},
-		{"50 / 2 * 2 + 10", 60},
 		{"2 * (5 + 10)", 30},
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
-		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
 		{"20 % 4", 0},
 		{"20 % 3", 2},
 		{"5 * 4 % 3", 2},
@@ -863,13 +848,55 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
-func testEval(input string) Object {
-	l := lexer.New(input)
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"50 / 2 * 2 + 10", 60},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		result, ok := evaluated.(*Float)
+		if !ok {
+			t.Errorf("object is not Float. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if result.Float64 != tt.expected {
+			t.Errorf("object has wrong value. got=%g, want=%g", result.Float64, tt.expected)
+		}
+	}
+}
+
+// OtherBackend evaluates a program with another execution backend, it's set
+// to the vm by vm_backend_test.go. Every input of testEval must give the same
+// result with it as with 'Eval'.
+var OtherBackend func(program *ast.Program, scope *Scope) Object
+
+func testEval(t *testing.T, input string) Object {
+	t.Helper()
+	evaluated := testEvalWith(input, evalBackend)
+	if OtherBackend != nil {
+		if other := testEvalWith(input, OtherBackend); inspectResult(other) != inspectResult(evaluated) {
+			t.Errorf("input %q: the backends give different results. Eval=%s, other=%s", input, inspectResult(evaluated), inspectResult(other))
+		}
+	}
+	return evaluated
+}
+
+func evalBackend(program *ast.Program, scope *Scope) Object {
+	return Eval(program, scope)
+}
+
+func testEvalWith(input string, eval func(program *ast.Program, scope *Scope) Object) Object {
+	l := lexer.New("", input)
 	path, _ := os.Getwd()
 	p := parser.New(l, path)
 	s := NewScope(nil, os.Stdout)
@@ -880,7 +907,14 @@ func testEval(input string) Object {
 			os.Exit(1)
 		}
 	}
-	return Eval(program, s)
+	return eval(program, s)
+}
+
+func inspectResult(obj Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
 }
 
 func testIntegerObject(t *testing.T, obj Object, expected int64) bool {
@@ -925,7 +959,7 @@ func TestInterpolation(t *testing.T) {
 	}
 
 	for _, tt := range input {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testInterpolatedStringObject(t, evaluated, tt.expected)
 	}
 }
@@ -0,0 +1,344 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"magpie/ast"
+	"magpie/parser"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is one call of a stack trace.
+type StackFrame struct {
+	Function string //'<main>' for the top level code
+	File     string
+	Line     int
+}
+
+func (f StackFrame) String() string {
+	switch {
+	case f.Line == 0:
+		return "at " + f.Function
+	case f.File == "":
+		return fmt.Sprintf("at %s (line %d)", f.Function, f.Line)
+	}
+	return fmt.Sprintf("at %s (%s:%d)", f.Function, f.File, f.Line)
+}
+
+// EXCEPTION_CLASS is the base class of the exceptions. Any object could be
+// thrown, but the instances of 'Exception' and its subclasses also carry
+// their message and the stack trace of the 'throw'.
+//
+//	class MyError : Exception {
+//	    let code = 0
+//	    fn init(msg, code) {
+//	        parent.init(msg)
+//	        this.code = code
+//	    }
+//	}
+//
+//	try {
+//	    throw new MyError("invalid config", 2)
+//	} catch (e: MyError | IOError) {
+//	    println(e.message)
+//	    e.printStackTrace()
+//	}
+var EXCEPTION_CLASS = &Class{
+	Name:   "Exception",
+	Parent: BASE_CLASS,
+	Members: []*ast.LetStatement{
+		exceptionMember("message", &ast.StringLiteral{}),
+		exceptionMember("stackTrace", &ast.ArrayLiteral{}),
+	},
+}
+
+// RUNTIMEERROR_CLASS is the base class of the exceptions converted from
+// runtime errors, e.g. 'divide by zero'.
+var RUNTIMEERROR_CLASS = &Class{
+	Name:    "RuntimeError",
+	Parent:  EXCEPTION_CLASS,
+	Methods: map[string]ClassMethod{},
+}
+
+// The subclasses of 'RuntimeError', and the kinds of runtime errors they are
+// converted from. The kinds not listed here are converted to 'RuntimeError'.
+var runtimeErrorKinds = map[string][]int{
+	"TypeError": {PREFIXOP, INFIXOP, POSTFIXOP, MOD_ASSIGNOP, INPUTERROR, RTERROR, PARAMTYPEERROR,
+		GREPMAPNOTITERABLE, NOTITERABLE, RANGETYPEERROR, METAOPERATORERROR, DIAMONDOPERERROR, DBSCANERROR, PATTERNERROR, TYPEERROR},
+	"NameError":         {UNKNOWNIDENT, UNKNOWNIDENTEX, NAMENOTEXPORTED, CONSTNOTASSIGNERROR},
+	"MethodError":       {NOMETHODERROR, NOMETHODERROREX},
+	"KeyError":          {KEYERROR},
+	"IndexError":        {NOINDEXERROR, INDEXERROR, SLICEERROR},
+	"ArgumentError":     {ARGUMENTERROR, INVALIDARG, INLENERR, FUNCCALLBACKERROR},
+	"DivideByZeroError": {DIVIDEBYZERO},
+	"NullError":         {NULLABLEERROR},
+	"AssertionError":    {ASSERTIONERROR},
+	"IOError":           {FILEMODEERROR, FILEOPENERROR},
+	"JsonError":         {JSONERROR},
+	"ImportError":       {IMPORTERROR},
+	"CancelledError":    {TASKCANCELLED},
+	"TimeoutError":      {TASKTIMEOUT},
+	"LimitError":        {EXECLIMITERROR},
+	"ClassError": {NOTCLASSERROR, NOTINTERFACEERROR, INTERFACEMETHODERROR, INTERFACEARGSERROR,
+		INTERFACEPROPERTYERROR, INTERFACEACCESSORERROR, PARENTNOTDECL, CLSNOTDEFINE, CLSMEMBERPRIVATE,
+		CLSCALLPRIVATE, PROPERTYUSEERROR, MEMBERUSEERROR, INDEXERUSEERROR, INDEXERTYPEERROR,
+		INDEXERSTATICERROR, INDEXNOTFOUNDERROR, CALLNONSTATICERROR, CLASSCATEGORYERROR,
+		CLASSCREATEERROR, PARENTNOTANNOTATION, OVERRIDEERROR, SERVICENOURLERROR},
+}
+
+// error kind -> exception class
+var errorClasses = make(map[int]*Class)
+
+// let name = value
+func exceptionMember(name string, value ast.Expression) *ast.LetStatement {
+	return &ast.LetStatement{
+		Names:   []*ast.Identifier{{Value: name}},
+		Values:  []ast.Expression{value},
+		InClass: true,
+	}
+}
+
+func initExceptionClasses() bool {
+	EXCEPTION_CLASS.Methods = map[string]ClassMethod{
+		"init": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				argLen := len(args)
+				if argLen > 1 {
+					return NewError(line, ARGUMENTERROR, "0|1", argLen)
+				}
+
+				if self != nil && argLen == 1 {
+					self.Scope.Reset("message", args[0])
+				}
+				return NIL
+			},
+		},
+		"getMessage": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				return exceptionField(line, self, "message", args...)
+			},
+		},
+		"getStackTrace": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				return exceptionField(line, self, "stackTrace", args...)
+			},
+		},
+		"printStackTrace": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				argLen := len(args)
+				if argLen != 0 {
+					return NewError(line, ARGUMENTERROR, "0", argLen)
+				}
+
+				if self != nil {
+					fmt.Fprintf(scope.Writer, "%s\n%s", exceptionString(self), formatStackTrace(exceptionStackTrace(self)))
+				}
+				return NIL
+			},
+		},
+		"toString": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				argLen := len(args)
+				if argLen != 0 {
+					return NewError(line, ARGUMENTERROR, "0", argLen)
+				}
+
+				if self == nil {
+					return NewString(EXCEPTION_CLASS.Name)
+				}
+				return NewString(exceptionString(self))
+			},
+		},
+	}
+
+	classes := []*Class{EXCEPTION_CLASS, RUNTIMEERROR_CLASS}
+	for name, kinds := range runtimeErrorKinds {
+		cls := &Class{Name: name, Parent: RUNTIMEERROR_CLASS, Methods: map[string]ClassMethod{}}
+		for _, kind := range kinds {
+			errorClasses[kind] = cls
+		}
+		classes = append(classes, cls)
+	}
+
+	for _, cls := range classes {
+		BuiltinClasses[cls.Name] = cls
+		parser.BuiltinClasses[cls.Name] = true //so 'new IOError(msg)' passes the parser's check
+	}
+
+	return true
+}
+
+var _ = initExceptionClasses()
+
+func exceptionField(line string, self *ObjectInstance, name string, args ...Object) Object {
+	argLen := len(args)
+	if argLen != 0 {
+		return NewError(line, ARGUMENTERROR, "0", argLen)
+	}
+
+	if self == nil {
+		return NIL
+	}
+	if val, ok := self.Scope.Get(name); ok {
+		return val
+	}
+	return NIL
+}
+
+// returns the object as an exception if it's an instance of 'Exception'.
+func asException(obj Object) (*ObjectInstance, bool) {
+	instance, ok := obj.(*ObjectInstance)
+	if !ok {
+		return nil, false
+	}
+	for cls := instance.Class; cls != nil; cls = cls.Parent {
+		if cls == EXCEPTION_CLASS {
+			return instance, true
+		}
+	}
+	return nil, false
+}
+
+// newException creates an instance of the exception class 'cls' without calling its 'init'.
+func newException(cls *Class, message Object, trace []StackFrame, scope *Scope) *ObjectInstance {
+	instance := newObjectInstance(cls, scope)
+	instance.Scope.Reset("message", message)
+	setStackTrace(instance, trace)
+	return instance
+}
+
+// errorToException converts a runtime error to an exception, so it could be
+// handled by the 'catch' clauses.
+func errorToException(err *Error, scope *Scope) *ObjectInstance {
+	cls, ok := errorClasses[err.Kind]
+	if !ok {
+		cls = RUNTIMEERROR_CLASS
+	}
+	return newException(cls, NewString(err.message()), err.StackTrace, scope)
+}
+
+// "IOError: message"
+func exceptionString(obj Object) string {
+	e, ok := asException(obj)
+	if !ok {
+		return obj.Inspect()
+	}
+
+	msg := ""
+	if val, ok := e.Scope.Get("message"); ok && val != NIL {
+		msg = val.Inspect()
+	}
+	if msg == "" {
+		return e.Class.Name
+	}
+	return e.Class.Name + ": " + msg
+}
+
+func setStackTrace(e *ObjectInstance, trace []StackFrame) {
+	arr := &Array{}
+	for _, f := range trace {
+		h := NewHash()
+		h.Push("", NewString("function"), NewString(f.Function))
+		h.Push("", NewString("file"), NewString(f.File))
+		h.Push("", NewString("line"), NewInteger(int64(f.Line)))
+		arr.Members = append(arr.Members, h)
+	}
+	e.Scope.Reset("stackTrace", arr)
+}
+
+func exceptionStackTrace(e *ObjectInstance) []StackFrame {
+	val, _ := e.Scope.Get("stackTrace")
+	arr, ok := val.(*Array)
+	if !ok {
+		return nil
+	}
+
+	var trace []StackFrame
+	for _, m := range arr.Members {
+		h, ok := m.(*Hash)
+		if !ok {
+			continue
+		}
+		f := StackFrame{}
+		if s, ok := h.Get("", NewString("function")).(*String); ok {
+			f.Function = s.String
+		}
+		if s, ok := h.Get("", NewString("file")).(*String); ok {
+			f.File = s.String
+		}
+		if i, ok := h.Get("", NewString("line")).(*Integer); ok {
+			f.Line = int(i.Int64)
+		}
+		trace = append(trace, f)
+	}
+	return trace
+}
+
+func formatStackTrace(trace []StackFrame) string {
+	var out bytes.Buffer
+	for _, f := range trace {
+		out.WriteString("    " + f.String() + "\n")
+	}
+	return out.String()
+}
+
+// stackTrace returns the stack trace of the code at 'file:line' running in 'scope'.
+// The calls are taken from the call stack, the innermost call comes first.
+func stackTrace(file string, line int, scope *Scope) []StackFrame {
+	frames := scope.CallStack.Frames
+	trace := make([]StackFrame, 0, len(frames)+1)
+	for i := len(frames) - 1; i >= 0; i-- {
+		trace = append(trace, StackFrame{Function: frames[i].name, File: file, Line: line})
+		pos := frames[i].CurrentCall.Pos()
+		file, line = pos.Filename, pos.Line
+	}
+	return append(trace, StackFrame{Function: "<main>", File: file, Line: line})
+}
+
+// traceError records the stack trace of the runtime error 'obj' which occurred
+// in 'scope'. The trace is only recorded once, so when the error goes up the call
+// stack, the innermost caller wins.
+func traceError(obj Object, scope *Scope) {
+	err, ok := obj.(*Error)
+	if !ok || err.StackTrace != nil {
+		return
+	}
+	file, line := parseSline(err.line)
+	err.StackTrace = stackTrace(file, line, scope)
+}
+
+// parses the string returned by 'token.Position.Sline()', i.e. "line" or " <file:line> ".
+func parseSline(s string) (string, int) {
+	s = strings.Trim(s, " \t<>")
+	file := ""
+	if idx := strings.LastIndex(s, ":"); idx >= 0 {
+		file, s = s[:idx], s[idx+1:]
+	}
+	line, _ := strconv.Atoi(s)
+	return file, line
+}
+
+// the name of the called function, which is shown in stack traces
+func callName(call *ast.CallExpression) string {
+	if _, ok := call.Function.(*ast.FunctionLiteral); ok {
+		return "<anonymous>"
+	}
+	return call.Function.String()
+}
+
+// the name of the called method, e.g. "Class.method". 'scope' is the scope of the instance or class.
+func methodName(call *ast.CallExpression, scope *Scope) string {
+	if call == nil {
+		return "<anonymous>"
+	}
+
+	name := callName(call)
+	switch this, _ := scope.Get("this"); this := this.(type) {
+	case *ObjectInstance:
+		name = this.Class.Name + "." + name
+	case *Class:
+		name = this.Name + "." + name
+	}
+	return name
+}
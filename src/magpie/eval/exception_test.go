@@ -0,0 +1,106 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { 10 / 0 } catch (e: DivideByZeroError) { r = e.message }`, "divide by zero"},
+		{`try { let h = {}; h[[1]] } catch (e: IOError) { r = "io" } catch (e: KeyError) { r = e.is_a(RuntimeError) }`, "true"},
+		{`try { throw new IOError("disk") } catch (e: KeyError | IOError) { r = e.toString() }`, "IOError: disk"},
+		{`try { throw "str" } catch (e: Exception) { r = "exception" } catch e { r = e }`, "str"},
+		{`try { undefinedFunc() } catch e { r = e.classOf() }`, "NameError"},
+		{`class MyError : Exception { let code = 0; fn init(msg, code) { parent.init(msg); this.code = code } }
+		  try { throw new MyError("bad", 3) } catch (e: MyError) { r = e.message + e.code }`, "bad3"},
+		{`try { try { throw new IOError("x") } finally { r += "f" } } catch e { r += e.message }`, "fx"},
+		{`try { try { throw new IOError("x") } catch e { throw e } } catch e { r = e.getMessage() }`, "x"},
+	}
+
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run("let r = \"\"\n" + tt.input + "\nr")
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("input %q: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestExceptionNotHandled(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	_, err := interp.Run(`try { throw new IOError("disk") } catch (e: KeyError) { }`)
+	if e, ok := err.(*Error); !ok || e.Kind != THROWNOTHANDLED || !strings.Contains(e.Message, "IOError: disk") {
+		t.Errorf("expected an unhandled IOError, got=%v", err)
+	}
+
+	//execution limits are caught as 'LimitError', but the program stops once
+	//it evaluates something else after exceeding the node budget
+	interp.Limits = Limits{MaxNodes: 1000}
+	_, err = interp.Run(`try { for {} } catch (e: LimitError) { println("caught") }`)
+	if e, ok := err.(*Error); !ok || e.Kind != EXECLIMITERROR {
+		t.Errorf("expected an EXECLIMITERROR, got=%v", err)
+	}
+
+	interp = NewInterpreter(&bytes.Buffer{})
+	interp.Limits = Limits{MaxCallDepth: 50}
+	result, err := interp.Run(`
+let msg = ""
+fn f(n) { f(n + 1) }
+try { f(0) } catch (e: LimitError) { msg = e.message }
+msg`)
+	if err != nil || result.Inspect() != "Execution limit exceeded: call depth exceeds 50" {
+		t.Errorf("expected the LimitError to be caught, got=%v, %v", result, err)
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `
+fn inner() {
+    return 1 / 0
+}
+class Outer {
+    fn call() { inner() }
+}
+let o = new Outer()
+o.call()
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	_, err := interp.Run(input)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an error, got=%v", err)
+	}
+
+	expected := []StackFrame{
+		{Function: "inner", Line: 3},
+		{Function: "Outer.call", Line: 6},
+		{Function: "<main>", Line: 9},
+	}
+	if len(e.StackTrace) != len(expected) {
+		t.Fatalf("expected %d frames, got=%v", len(expected), e.StackTrace)
+	}
+	for i, f := range expected {
+		if e.StackTrace[i] != f {
+			t.Errorf("frame %d: expected %v, got=%v", i, f, e.StackTrace[i])
+		}
+	}
+
+	var out bytes.Buffer
+	interp = NewInterpreter(&out)
+	_, err = interp.Run(`fn f() { throw new Exception("oops") }; try { f() } catch e { e.printStackTrace() }`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := out.String(); got != "Exception: oops\n    at f (line 1)\n    at <main> (line 1)\n" {
+		t.Errorf("wrong printStackTrace output, got=%q", got)
+	}
+}
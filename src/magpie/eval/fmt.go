@@ -62,7 +62,7 @@ func (f *FmtObj) Errorf(line string, scope *Scope, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	err := gofmt.Errorf(formatObj.String, wrapped...)
@@ -78,7 +78,7 @@ func (f *FmtObj) Print(line string, scope *Scope, args ...Object) Object {
 		return NewInteger(int64(n))
 	}
 
-	format, wrapped := correctPrintResult(false, args...)
+	format, wrapped := correctPrintResult(scope.interp.replColor(), false, args...)
 	n, err := gofmt.Fprintf(scope.Writer, format, wrapped...)
 	if err != nil {
 		return NewNil(err.Error())
@@ -100,12 +100,12 @@ func (f *FmtObj) Printf(line string, scope *Scope, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	formatStr := formatObj.String
 	if len(subArgs) == 0 {
-		if REPLColor {
+		if scope.interp.replColor() {
 			formatStr = "\033[1;" + colorMap["STRING"] + "m" + formatStr + "\033[0m"
 		}
 	}
@@ -126,7 +126,7 @@ func (f *FmtObj) Println(line string, scope *Scope, args ...Object) Object {
 		return NewInteger(int64(n))
 	}
 
-	format, wrapped := correctPrintResult(true, args...)
+	format, wrapped := correctPrintResult(scope.interp.replColor(), true, args...)
 	n, err := gofmt.Fprintf(scope.Writer, format, wrapped...)
 	if err != nil {
 		return NewNil(err.Error())
@@ -142,7 +142,7 @@ func (f *FmtObj) Sprint(line string, scope *Scope, args ...Object) Object {
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	ret := gofmt.Sprint(wrapped...)
@@ -162,7 +162,7 @@ func (f *FmtObj) Sprintf(line string, scope *Scope, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	ret := gofmt.Sprintf(format.String, wrapped...)
@@ -177,7 +177,7 @@ func (f *FmtObj) Sprintln(line string, scope *Scope, args ...Object) Object {
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	ret := gofmt.Sprintln(wrapped...)
@@ -201,12 +201,12 @@ func (f *FmtObj) Fprint(line string, scope *Scope, args ...Object) Object {
 
 	writer := w.(Writable).IOWriter()
 	if writer == os.Stdout || writer == os.Stderr { //output to stdout or stderr
-		format, wrapped := correctPrintResult(false, subArgs...)
+		format, wrapped := correctPrintResult(scope.interp.replColor(), false, subArgs...)
 		n, err = gofmt.Fprintf(scope.Writer, format, wrapped...)
 	} else {
 		wrapped := make([]interface{}, len(subArgs))
 		for i, v := range subArgs {
-			wrapped[i] = &Formatter{Obj: v}
+			wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 		}
 		n, err = gofmt.Fprint(writer, wrapped...)
 	}
@@ -244,7 +244,7 @@ func (f *FmtObj) Fprintf(line string, scope *Scope, args ...Object) Object {
 			subArgs := args[2:]
 			wrapped := make([]interface{}, len(subArgs))
 			for i, v := range subArgs {
-				wrapped[i] = &Formatter{Obj: v}
+				wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 			}
 			n, err = gofmt.Fprintf(writer, formatObj.String, wrapped...)
 		}
@@ -253,7 +253,7 @@ func (f *FmtObj) Fprintf(line string, scope *Scope, args ...Object) Object {
 		if writer == os.Stdout || writer == os.Stderr { //output to stdout or stderr
 			return f.Printf(line, scope, args[1:]...)
 		}
-		n, err = gofmt.Fprintf(writer, formatStr)
+		n, err = gofmt.Fprintf(writer, formatStr, []interface{}{}...)
 	}
 
 	if err != nil {
@@ -279,12 +279,12 @@ func (f *FmtObj) Fprintln(line string, scope *Scope, args ...Object) Object {
 
 	writer := w.(Writable).IOWriter()
 	if writer == os.Stdout || writer == os.Stderr { //output to stdout or stderr
-		format, wrapped := correctPrintResult(true, subArgs...)
+		format, wrapped := correctPrintResult(scope.interp.replColor(), true, subArgs...)
 		n, err = gofmt.Fprintf(scope.Writer, format, wrapped...)
 	} else {
 		wrapped := make([]interface{}, len(subArgs))
 		for i, v := range subArgs {
-			wrapped[i] = &Formatter{Obj: v}
+			wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 		}
 
 		n, err = gofmt.Fprintln(writer, wrapped...)
@@ -0,0 +1,161 @@
+//go:build ignore
+// +build ignore
+
+// gen_methods generates methods_gen.go, the names of the methods of the builtin
+// objects, which are taken from the 'switch method' statements of their
+// 'CallMethod' implementations.
+//
+//	go run gen_methods.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && name != "gen_methods.go" && name != "methods_gen.go"
+	}, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	types := make(map[string]string)            //receiver type -> object type constant
+	methods := make(map[string]map[string]bool) //receiver type -> method names
+	for _, f := range pkgs["eval"].Files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Body == nil {
+				continue
+			}
+			recv := receiverType(fn)
+			switch fn.Name.Name {
+			case "Type":
+				if c := returnedConst(fn); c != "" {
+					types[recv] = c
+				}
+			case "CallMethod":
+				if methods[recv] == nil {
+					methods[recv] = make(map[string]bool)
+				}
+				collectCases(fn.Body, methods[recv])
+			}
+		}
+	}
+
+	//several receiver types could have the same object type(e.g. String and InterpolatedString)
+	byType := make(map[string]map[string]bool)
+	for recv, names := range methods {
+		t, ok := types[recv]
+		if !ok || len(names) == 0 {
+			continue
+		}
+		if byType[t] == nil {
+			byType[t] = make(map[string]bool)
+		}
+		for name := range names {
+			byType[t][name] = true
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gen_methods.go; DO NOT EDIT.\n\n")
+	out.WriteString("package eval\n\n")
+	out.WriteString("var builtinMethods = map[ObjectType][]string{\n")
+	for _, t := range sortedKeys(byType) {
+		var quoted []string
+		for _, name := range sortedKeys(byType[t]) {
+			quoted = append(quoted, strconv.Quote(name))
+		}
+		fmt.Fprintf(&out, "%s: {%s},\n", t, strings.Join(quoted, ", "))
+	}
+	out.WriteString("}\n")
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("methods_gen.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func receiverType(fn *ast.FuncDecl) string {
+	t := fn.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// the constant returned by a method like 'func (s *String) Type() ObjectType { return STRING_OBJ }'
+func returnedConst(fn *ast.FuncDecl) string {
+	if len(fn.Body.List) != 1 {
+		return ""
+	}
+	ret, ok := fn.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return ""
+	}
+	if id, ok := ret.Results[0].(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// collects the string cases of the 'switch method' statements
+func collectCases(body *ast.BlockStmt, names map[string]bool) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		sw, ok := n.(*ast.SwitchStmt)
+		if !ok {
+			return true
+		}
+		if tag, ok := sw.Tag.(*ast.Ident); !ok || tag.Name != "method" {
+			return true
+		}
+		for _, stmt := range sw.Body.List {
+			for _, expr := range stmt.(*ast.CaseClause).List {
+				lit, ok := expr.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				if name, err := strconv.Unquote(lit.Value); err == nil {
+					names[name] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
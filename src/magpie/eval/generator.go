@@ -0,0 +1,247 @@
+package eval
+
+import (
+	"fmt"
+	"magpie/ast"
+	"runtime"
+	"sync"
+)
+
+// Generator is returned by calling a generator function, i.e. a function
+// whose body contains 'yield'. The body does not run until the first value
+// is requested, then it runs until the next 'yield', and so on:
+//
+//	fn xrange(limit) {
+//	    for i in 0..limit-1 { yield i }
+//	}
+//
+//	for i in xrange(10) { println(i) }
+//	let g = xrange(3)
+//	g.next()  // 0
+//
+// The body runs in its own goroutine, but never at the same time as the code
+// which resumes it. A generator which is left suspended is closed when it's
+// garbage collected, so its goroutine does not leak.
+type Generator struct {
+	*genState
+}
+
+// the state shared by the generator object and its goroutine. The goroutine
+// must not refer to the 'Generator' itself, or else it could never be collected.
+type genState struct {
+	sync.Mutex //serializes the resumptions
+
+	name  string
+	run   func(scope *Scope) Object //runs the function body
+	scope *Scope                    //scope of the goroutine, it has its own call stack
+
+	started bool
+	done    bool
+	closing bool //set before the goroutine is resumed for closing
+
+	resume chan genResume  //caller -> goroutine
+	yield  chan genMessage //goroutine -> caller
+}
+
+type genResume struct {
+	value Object //the result of 'yield'
+	close bool   //the generator is closed, 'yield' must unwind the body
+}
+
+type genMessage struct {
+	value Object
+	done  bool //the body returned, 'value' is its result
+}
+
+// newGenerator creates a generator called from 'scope'. 'run' runs the function
+// body in the scope it's given, whose call stack belongs to the generator.
+func newGenerator(name string, scope *Scope, run func(scope *Scope) Object) *Generator {
+	s := &genState{
+		name:   name,
+		run:    run,
+		scope:  goroutineScope(scope),
+		resume: make(chan genResume),
+		yield:  make(chan genMessage),
+	}
+	s.scope.CallStack.gen = s
+
+	g := &Generator{s}
+	runtime.SetFinalizer(g, func(g *Generator) {
+		go g.closeGenerator("", nil)
+	})
+	return g
+}
+
+// Implement the 'Iterable' interface, so it could be used in `for x in generator`
+func (g *Generator) iter() bool { return true }
+
+// Implement the 'Closeable' interface, so it could be used in the 'using' statement
+func (g *Generator) close(line string, args ...Object) Object {
+	return g.Close(line, nil, args...)
+}
+
+func (g *Generator) Inspect() string  { return fmt.Sprintf("generator<%s>", g.name) }
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "next":
+		return g.Next(line, scope, args...)
+	case "send":
+		return g.Send(line, scope, args...)
+	case "close":
+		return g.Close(line, scope, args...)
+	case "isDone":
+		return g.IsDone(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, g.Type())
+}
+
+// Next resumes the generator, and returns the next yielded value, or nil if
+// the generator is finished.
+func (g *Generator) Next(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	value, _ := g.resumeGenerator(line, scope, NIL)
+	return value
+}
+
+// Send is like 'Next', but the suspended 'yield' evaluates to args[0].
+// The value sent to a generator which is not started yet is dropped.
+func (g *Generator) Send(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	value, _ := g.resumeGenerator(line, scope, args[0])
+	return value
+}
+
+// Close finishes the generator. If it's suspended, its body is unwound from
+// the 'yield', running the 'finally' blocks and the defers on the way.
+func (g *Generator) Close(line string, scope *Scope, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+	return g.closeGenerator(line, scope)
+}
+
+func (g *Generator) IsDone(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	return NewBooleanObj(g.done)
+}
+
+// ToSlice runs the generator to the end, and returns the yielded values as an array.
+func (g *Generator) ToSlice(line string, scope *Scope) Object {
+	arr := &Array{}
+	for {
+		value, ok := g.resumeGenerator(line, scope, NIL)
+		if !ok {
+			if value.Type() == ERROR_OBJ {
+				return value
+			}
+			return arr
+		}
+		arr.Members = append(arr.Members, value)
+	}
+}
+
+// resumeGenerator runs the generator until its next 'yield', and returns the
+// yielded value. 'ok' is false if the generator is finished, then the returned
+// value is nil, or the error which stopped the body.
+func (s *genState) resumeGenerator(line string, scope *Scope, value Object) (Object, bool) {
+	if scope != nil && scope.CallStack.gen == s {
+		return NewError(line, GENERATORERROR, "generator is already running"), false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return NIL, false
+	}
+
+	if !s.started {
+		s.started = true
+		startGoroutine(s.scope.CallStack, s.loop)
+	} else {
+		s.resume <- genResume{value: value}
+	}
+	return s.receive()
+}
+
+func (s *genState) closeGenerator(line string, scope *Scope) Object {
+	if scope != nil && scope.CallStack.gen == s {
+		return NewError(line, GENERATORERROR, "generator is already running")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return NIL
+	}
+	if !s.started { //the body never ran, there is no goroutine
+		s.done = true
+		return NIL
+	}
+
+	s.closing = true
+	s.resume <- genResume{close: true}
+	value, _ := s.receive()
+	return value
+}
+
+// the goroutine of the generator
+func (s *genState) loop() {
+	r := s.run(s.scope)
+	s.yield <- genMessage{value: r, done: true}
+}
+
+// receive waits for the goroutine to yield or to finish.
+func (s *genState) receive() (Object, bool) {
+	msg := <-s.yield
+	if !msg.done {
+		return msg.value, true
+	}
+
+	s.done = true
+	if err, ok := msg.value.(*Error); ok && err.Kind != GENERATORCLOSED {
+		return err, false
+	}
+	return NIL, false
+}
+
+func evalYieldExpression(ye *ast.YieldExpression, scope *Scope) Object {
+	line := ye.Pos().Sline()
+
+	s := scope.CallStack.gen
+	if s == nil {
+		return NewError(line, GENERATORERROR, "'yield' outside of generator")
+	}
+
+	var value Object = NIL
+	if ye.Value != nil {
+		value = Eval(ye.Value, scope)
+		if value.Type() == ERROR_OBJ {
+			return value
+		}
+	}
+
+	if s.closing { //e.g. 'yield' in a 'finally' block of a closed generator
+		return NewError(line, GENERATORCLOSED)
+	}
+
+	s.yield <- genMessage{value: value}
+	r := <-s.resume
+	if r.close {
+		return NewError(line, GENERATORCLOSED)
+	}
+	return r.value
+}
@@ -0,0 +1,120 @@
+package eval
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`fn gen() { yield 1; yield 2; yield 3 }
+		  for x in gen() { r += x }`, "123"},
+		{`fn gen() { yield "a"; yield "b" }
+		  for i, x in gen() { r += i + x }`, "0a1b"},
+		{`fn gen() { yield 1; yield 2 }
+		  let g = gen(); r = [g.next(), g.next(), g.next(), g.isDone()]`, "[1, 2, nil, true]"},
+		{`fn acc() { let sum = 0; for { let v = yield sum; sum += v } }
+		  let g = acc(); g.next(); g.send(3); r = g.send(4)`, "7"},
+		{`fn upto(n) { for i in 0..n { yield i } }
+		  r = [x * x for x in upto(3) where x > 0]`, "[1, 4, 9]"},
+		{`fn gen() { yield 1; yield 2; yield 3 }
+		  r = [grep { $_ != 2 } gen(), map { $_ * 10 } gen()]`, "[[1, 3], [10, 20, 30]]"},
+		{`fn naturals() { let n = 0; for { yield n; n++ } }
+		  r = (from n in naturals() where n % 2 == 1 select n).take(3)`, "[1, 3, 5]"},
+		{`class Bag { let items = [3, 4]; fn each() { for x in this.items { yield x } } }
+		  let b = new Bag(); for x in b.each() { r += x }`, "34"},
+		{`let gen = fn() { yield }
+		  r = gen().next()`, "nil"},
+	}
+
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run("let r = \"\"\n" + tt.input + "\nr")
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("input %q: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestGeneratorClose(t *testing.T) {
+	input := `
+let log = []
+fn gen() {
+    defer log.push("defer")
+    try {
+        for { yield 1 }
+    } catch e {
+        log.push("caught")
+    } finally {
+        log.push("finally")
+    }
+}
+for x in gen() { break }
+let g = gen()
+g.next()
+g.close()
+log.push(g.isDone())
+let h = gen()
+h.close()
+log
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	result, err := interp.Run(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `["finally", "defer", "finally", "defer", true]`
+	if result.Inspect() != expected {
+		t.Errorf("expected %q, got=%q", expected, result.Inspect())
+	}
+}
+
+func TestGeneratorNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	interp := NewInterpreter(&bytes.Buffer{})
+	_, err := interp.Run(`
+fn naturals() { let n = 0; for { yield n; n++ } }
+for i in 1..50 {
+    for n in naturals() { if n == 3 { break } }
+}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	//the goroutines exit after the generators are closed
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("expected %d goroutines, got=%d", before, n)
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  int
+	}{
+		{`fn gen() { yield 1; yield 1 / 0 }; for x in gen() { x }`, DIVIDEBYZERO},
+		{`fn gen() { yield 1; throw "oops" }; let g = gen(); g.next(); g.next()`, THROWNOTHANDLED},
+		{`let g; fn gen() { g.next(); yield 1 }; g = gen(); g.next()`, GENERATORERROR},
+	}
+
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(tt.input)
+		if e, ok := err.(*Error); !ok || e.Kind != tt.kind {
+			t.Errorf("input %q: expected an error of kind %d, got=%v", tt.input, tt.kind, err)
+		}
+	}
+}
@@ -334,16 +334,22 @@ func RegisterVars(name string, vars map[string]interface{}) {
 }
 
 func RegisterFunctions(name string, vars map[string]interface{}) {
+	SetGlobalObj(goModuleName(name), goFunctionsHash(vars))
+}
+
+func goFunctionsHash(vars map[string]interface{}) *Hash {
 	hash := NewHash()
 	for k, v := range vars {
 		key := NewString(k)
 		hash.Push("", key, NewGoFuncObject(k, v))
 		//hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: NewGoFuncObject(k, v)}
 	}
+	return hash
+}
 
-	//Replace all '/' to '_'. e.g. math/rand => math_rand
-	newName := strings.Replace(name, "/", "_", -1)
-	SetGlobalObj(newName, hash)
+//Replace all '/' to '_'. e.g. math/rand => math_rand
+func goModuleName(name string) string {
+	return strings.Replace(name, "/", "_", -1)
 }
 
 //func RegisterFunctions(name string, vars []interface{}) {
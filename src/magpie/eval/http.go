@@ -2,11 +2,13 @@ package eval
 
 import (
 	"bytes"
+	"encoding/json"
 	_ "fmt"
 	"io"
 	"io/ioutil"
 	"magpie/ast"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
@@ -26,6 +28,9 @@ const (
 //HTTP Object
 const http_name = "http"
 
+//the max memory of the multipart form files, the rest are stored in temporary files.
+const maxMultipartMemory = 32 << 20
+
 type HttpObj struct {
 }
 
@@ -128,9 +133,13 @@ func (h *HttpObj) CallMethod(line string, scope *Scope, method string, args ...O
 	case "handleFunc":
 		return h.HandleFunc(line, scope, args...)
 	case "newServer":
-		return h.NewServer(line, args...)
+		return h.NewServer(line, scope, args...)
+	case "newClient":
+		return h.NewClient(line, args...)
 	case "redirect":
 		return h.Redirect(line, args...)
+	case "upgrade":
+		return h.Upgrade(line, args...)
 	}
 	return NewError(line, NOMETHODERROR, method, h.Type())
 }
@@ -258,39 +267,67 @@ func (h *HttpObj) NewRequest(line string, args ...Object) Object {
 	return &HttpRequest{Request: request}
 }
 
+//http.listenAndServe(addr[, handler][, options]): the options are the TLS and
+//HTTP/2 options of the server, e.g. {"cert": "server.crt", "key": "server.key"}.
 func (h *HttpObj) ListenAndServe(line string, scope *Scope, args ...Object) Object {
-	if len(args) != 1 && len(args) != 2 {
-		return NewError(line, ARGUMENTERROR, "2", len(args))
+	srv, _, errObj := newHTTPServer(line, scope, "listenAndServe", args...)
+	if errObj != nil {
+		return errObj
 	}
 
-	addr, ok := args[0].(*String)
-	if !ok {
-		return NewError(line, PARAMTYPEERROR, "first", "listenAndServe", "*String", args[0].Type())
+	var err error
+	if srv.TLSConfig != nil { //the certificates are in the config
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		return NewFalseObj(err.Error())
 	}
 
-	if len(args) == 1 {
-		err := http.ListenAndServe(addr.String, nil)
-		if err != nil {
-			return NewFalseObj(err.Error())
+	return TRUE
+}
+
+// newHTTPServer returns the server of the arguments 'addr[, handler][, options]'
+// of the function 'name', and its options. Without 'handler', the server uses
+// the handlers registered by 'http.handle' and 'http.handleFunc'.
+func newHTTPServer(line string, scope *Scope, name string, args ...Object) (*http.Server, *serverOptions, Object) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, nil, NewError(line, ARGUMENTERROR, "1|2|3", len(args))
+	}
+
+	addr, ok := args[0].(*String)
+	if !ok {
+		return nil, nil, NewError(line, PARAMTYPEERROR, "first", name, "*String", args[0].Type())
+	}
+	srv := &http.Server{Addr: addr.String}
+
+	rest := args[1:]
+	if len(rest) > 0 {
+		if block, ok := rest[0].(*Function); ok {
+			paramCount := len(block.Literal.Parameters)
+			if paramCount != 2 {
+				return nil, nil, NewError(line, FUNCCALLBACKERROR, 2, paramCount)
+			}
+			srv.Handler = &customHTTPHandler{Scope: scope, F: block}
+			rest = rest[1:]
+		} else if len(rest) == 2 {
+			return nil, nil, NewError(line, PARAMTYPEERROR, "second", name, "*Function", rest[0].Type())
 		}
-	} else {
-		block, ok := args[1].(*Function)
+	}
+	var o *serverOptions
+	if len(rest) > 0 {
+		opts, ok := rest[0].(*Hash)
 		if !ok {
-			return NewError(line, PARAMTYPEERROR, "second", "listenAndServe", "*Function", args[1].Type())
+			return nil, nil, NewError(line, PARAMTYPEERROR, "last", name, "*Function|*Hash", rest[0].Type())
 		}
-
-		paramCount := len(block.Literal.Parameters)
-		if paramCount != 2 {
-			return NewError(line, FUNCCALLBACKERROR, 2, paramCount)
-		}
-
-		err := http.ListenAndServe(addr.String, &customHTTPHandler{Scope: scope, F: block})
-		if err != nil {
-			return NewFalseObj(err.Error())
+		var err error
+		if o, err = newServerOptions(opts, false); err != nil {
+			return nil, nil, NewError(line, GENERICERROR, err.Error())
 		}
+		o.apply(srv)
 	}
-
-	return TRUE
+	return srv, o, nil
 }
 
 func (h *HttpObj) Handle(line string, scope *Scope, args ...Object) Object {
@@ -354,17 +391,37 @@ func ServeHTTP(scope *Scope, f *Function, w http.ResponseWriter, r *http.Request
 	Eval(f.Literal.Body, s)
 }
 
-func (h *HttpObj) NewServer(line string, args ...Object) Object {
-	if len(args) != 1 {
-		return NewError(line, ARGUMENTERROR, "1", len(args))
+//http.newServer(addr[, handler][, options]): without 'handler', the server uses
+//the handlers registered by 'http.handle' and 'http.handleFunc'.
+func (h *HttpObj) NewServer(line string, scope *Scope, args ...Object) Object {
+	srv, o, errObj := newHTTPServer(line, scope, "newServer", args...)
+	if errObj != nil {
+		return errObj
 	}
 
-	addr, ok := args[0].(*String)
-	if !ok {
-		return NewError(line, PARAMTYPEERROR, "first", "newServer", "*String", args[0].Type())
+	return &HttpServer{Server: srv, keepAlives: true, options: o}
+}
+
+//http.newClient([options]): the options are the TLS and HTTP/2 options, the
+//timeouts, the retries, the rate limit and the circuit breaker of the client,
+//e.g. {"caFile": "ca.crt", "timeout": 10, "retries": 3}.
+func (h *HttpObj) NewClient(line string, args ...Object) Object {
+	if len(args) > 1 {
+		return NewError(line, ARGUMENTERROR, "0|1", len(args))
 	}
 
-	return &HttpServer{Server: &http.Server{Addr: addr.String}}
+	var opts *Hash
+	if len(args) == 1 {
+		var ok bool
+		if opts, ok = args[0].(*Hash); !ok {
+			return NewError(line, PARAMTYPEERROR, "first", "newClient", "*Hash", args[0].Type())
+		}
+	}
+	client, err := newClient(opts)
+	if err != nil {
+		return NewError(line, GENERICERROR, err.Error())
+	}
+	return client
 }
 
 func (h *HttpObj) Redirect(line string, args ...Object) Object {
@@ -396,6 +453,47 @@ func (h *HttpObj) Redirect(line string, args ...Object) Object {
 	return NIL
 }
 
+// Upgrade upgrades the request to a websocket connection:
+//
+//	http.handleFunc("/echo", fn(writer, request) {
+//	    let conn = http.upgrade(writer, request)
+//	    for msg in conn { conn.send(msg) }
+//	})
+//
+// It returns nil with the reason if the request isn't a websocket handshake,
+// the error response is already written then. The optional third argument is
+// the array of the allowed origins, without it the 'Origin' of the request must
+// be on the request's host.
+func (h *HttpObj) Upgrade(line string, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return NewError(line, ARGUMENTERROR, "2|3", len(args))
+	}
+
+	rw, ok := args[0].(*HttpResponseWriter)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "upgrade", "*HttpResponseWriter", args[0].Type())
+	}
+
+	req, ok := args[1].(*HttpRequest)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "second", "upgrade", "*HttpRequest", args[1].Type())
+	}
+
+	var origins []string
+	if len(args) == 3 {
+		var errObj Object
+		if origins, errObj = websocketOrigins(line, "third", "upgrade", args[2]); errObj != nil {
+			return errObj
+		}
+	}
+
+	ws, err := UpgradeWebSocket(rw.Writer, req.Request, origins)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return ws
+}
+
 type customHTTPHandler struct {
 	Scope *Scope
 	F     *Function
@@ -413,6 +511,11 @@ func (c *customHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 //HTTP Client object
 type HttpClient struct {
 	Client *http.Client
+
+	totalTimeout time.Duration   //the timeout of a call, including the retries
+	policy       *retryPolicy    //nil means no retries
+	limiter      *rateLimiter    //nil means no rate limit
+	breaker      *circuitBreaker //nil means no circuit breaker
 }
 
 func (h *HttpClient) Inspect() string  { return "<httpclient>" }
@@ -429,6 +532,8 @@ func (h *HttpClient) CallMethod(line string, scope *Scope, method string, args .
 		return h.Post(line, args...)
 	case "postForm":
 		return h.PostForm(line, args...)
+	case "breakerState":
+		return h.BreakerState(line, args...)
 	default:
 		return NewError(line, NOMETHODERROR, method, h.Type())
 	}
@@ -444,11 +549,7 @@ func (h *HttpClient) Do(line string, args ...Object) Object {
 		return NewError(line, PARAMTYPEERROR, "first", "do", "*HttpRequest", args[0].Type())
 	}
 
-	response, err := h.Client.Do(req.Request)
-	if err != nil {
-		return NewNil(err.Error())
-	}
-	return &HttpResponse{Response: response}
+	return h.request(req.Request)
 }
 
 func (h *HttpClient) Get(line string, args ...Object) Object {
@@ -461,11 +562,11 @@ func (h *HttpClient) Get(line string, args ...Object) Object {
 		return NewError(line, PARAMTYPEERROR, "first", "get", "*String", args[0].Type())
 	}
 
-	response, err := h.Client.Get(url.String)
+	req, err := http.NewRequest("GET", url.String, nil)
 	if err != nil {
 		return NewNil(err.Error())
 	}
-	return &HttpResponse{Response: response}
+	return h.request(req)
 }
 
 func (h *HttpClient) Head(line string, args ...Object) Object {
@@ -478,11 +579,11 @@ func (h *HttpClient) Head(line string, args ...Object) Object {
 		return NewError(line, PARAMTYPEERROR, "first", "url", "*String", args[0].Type())
 	}
 
-	response, err := h.Client.Head(url.String)
+	req, err := http.NewRequest("HEAD", url.String, nil)
 	if err != nil {
 		return NewNil(err.Error())
 	}
-	return &HttpResponse{Response: response}
+	return h.request(req)
 }
 
 func (h *HttpClient) Post(line string, args ...Object) Object {
@@ -500,24 +601,43 @@ func (h *HttpClient) Post(line string, args ...Object) Object {
 		return NewError(line, PARAMTYPEERROR, "second", "post", "*String", args[1].Type())
 	}
 
-	var response *http.Response
-	var err error
-
-	if len(args) == 2 {
-		response, err = h.Client.Post(urlStr.String, contentType.String, nil)
-	} else {
-		body, ok := args[2].(*String)
+	var body io.Reader
+	if len(args) == 3 {
+		b, ok := args[2].(*String)
 		if !ok {
 			return NewError(line, PARAMTYPEERROR, "third", "post", "*String", args[2].Type())
 		}
-		response, err = h.Client.Post(urlStr.String, contentType.String, strings.NewReader(body.String))
+		body = strings.NewReader(b.String)
 	}
 
+	req, err := http.NewRequest("POST", urlStr.String, body)
 	if err != nil {
 		return NewNil(err.Error())
 	}
+	req.Header.Set("Content-Type", contentType.String)
+	return h.request(req)
+}
 
-	return &HttpResponse{Response: response}
+//request sends the request, the response has the retry statistics.
+func (h *HttpClient) request(req *http.Request) Object {
+	response, stats, err := h.send(req)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return &HttpResponse{Response: response, stats: stats}
+}
+
+//the state of the circuit breaker: "closed", "open" or "half-open", nil
+//without the 'breakerFailures' option
+func (h *HttpClient) BreakerState(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	if h.breaker == nil {
+		return NIL
+	}
+	return NewString(h.breaker.String())
 }
 
 func (h *HttpClient) PostForm(line string, args ...Object) Object {
@@ -542,6 +662,7 @@ func (h *HttpClient) PostForm(line string, args ...Object) Object {
 //HTTP Response object
 type HttpResponse struct {
 	Response *http.Response
+	stats    *clientStats //the retry statistics of a client's response
 }
 
 func (h *HttpResponse) Inspect() string  { return "<httpresponse>" }
@@ -554,6 +675,12 @@ func (h *HttpResponse) CallMethod(line string, scope *Scope, method string, args
 		return h.ReadAll(line, args...)
 	case "header":
 		return h.Header(line, args...)
+	case "status":
+		return h.Status(line, args...)
+	case "proto":
+		return h.Proto(line, args...)
+	case "stats":
+		return h.Stats(line, args...)
 	default:
 		return NewError(line, NOMETHODERROR, method, h.Type())
 	}
@@ -588,6 +715,33 @@ func (h *HttpResponse) Header(line string, args ...Object) Object {
 	return &HttpHeader{Header: h.Response.Header}
 }
 
+//the status code, e.g. 200
+func (h *HttpResponse) Status(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	return NewInteger(int64(h.Response.StatusCode))
+}
+
+//the protocol, e.g. "HTTP/1.1" or "HTTP/2.0"
+func (h *HttpResponse) Proto(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	return NewString(h.Response.Proto)
+}
+
+//the retry statistics, e.g. {"attempts": 2, "retries": 1, "waited": 0.1, "failures": ["503 Service Unavailable"]}
+func (h *HttpResponse) Stats(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	return h.stats.toHash()
+}
+
 //HTTP Request object
 type HttpRequest struct {
 	Request *http.Request
@@ -605,6 +759,18 @@ func (h *HttpRequest) CallMethod(line string, scope *Scope, method string, args
 		return h.Write(line, args...)
 	case "formValue":
 		return h.FormValue(line, args...)
+	case "url":
+		return h.Url(line, args...)
+	case "remoteAddr":
+		return h.RemoteAddr(line, args...)
+	case "body":
+		return h.Body(line, args...)
+	case "json":
+		return h.Json(line, args...)
+	case "query":
+		return h.Query(line, args...)
+	case "file":
+		return h.File(line, args...)
 	default:
 		return NewError(line, NOMETHODERROR, method, h.Type())
 	}
@@ -618,6 +784,23 @@ func (h *HttpRequest) Method(line string, args ...Object) Object {
 	return NewString(h.Request.Method)
 }
 
+func (h *HttpRequest) Url(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	return NewString(h.Request.URL.String())
+}
+
+//the network address of the client, e.g. "127.0.0.1:52341"
+func (h *HttpRequest) RemoteAddr(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	return NewString(h.Request.RemoteAddr)
+}
+
 func (h *HttpRequest) Header(line string, args ...Object) Object {
 	if len(args) != 0 {
 		return NewError(line, ARGUMENTERROR, "0", len(args))
@@ -658,9 +841,134 @@ func (h *HttpRequest) FormValue(line string, args ...Object) Object {
 	return NewString(h.Request.FormValue(key.String))
 }
 
+//body(maxSize): the body of the request as a string, it could be read more than once.
+//The body is limited to 'maxSize' bytes(default 10MB), nil if it's larger.
+func (h *HttpRequest) Body(line string, args ...Object) Object {
+	limit, errObj := requestBodyLimit(line, "body", args)
+	if errObj != nil {
+		return errObj
+	}
+
+	b, err := readRequestBody(nil, h.Request, limit)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return NewString(string(b))
+}
+
+//json(maxSize): the json body of the request, nil if it's not valid json
+//or it's larger than 'maxSize' bytes(default 10MB).
+func (h *HttpRequest) Json(line string, args ...Object) Object {
+	limit, errObj := requestBodyLimit(line, "json", args)
+	if errObj != nil {
+		return errObj
+	}
+
+	b, err := readRequestBody(nil, h.Request, limit)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	ret, err := parseJson(b)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return ret
+}
+
+//requestBodyLimit returns the optional 'maxSize' argument of body()/json().
+func requestBodyLimit(line string, method string, args []Object) (int64, Object) {
+	if len(args) > 1 {
+		return 0, NewError(line, ARGUMENTERROR, "0|1", len(args))
+	}
+	if len(args) == 0 {
+		return maxRequestBody, nil
+	}
+	i, ok := args[0].(*Integer)
+	if !ok {
+		return 0, NewError(line, PARAMTYPEERROR, "first", method, "*Integer", args[0].Type())
+	}
+	if i.Int64 <= 0 {
+		return 0, NewError(line, GENERICERROR, "'maxSize' must be positive")
+	}
+	return i.Int64, nil
+}
+
+//query(name): the first value of the url query parameter, nil if it's missing.
+//query(): all the url query parameters as a hash, the values are arrays.
+func (h *HttpRequest) Query(line string, args ...Object) Object {
+	if len(args) > 1 {
+		return NewError(line, ARGUMENTERROR, "0|1", len(args))
+	}
+
+	values := h.Request.URL.Query()
+	if len(args) == 0 {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		hash := NewHash()
+		for _, k := range keys {
+			arr := &Array{}
+			for _, v := range values[k] {
+				arr.Members = append(arr.Members, NewString(v))
+			}
+			hash.Push(line, NewString(k), arr)
+		}
+		return hash
+	}
+
+	name, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "query", "*String", args[0].Type())
+	}
+	if v, ok := values[name.String]; ok && len(v) > 0 {
+		return NewString(v[0])
+	}
+	return NIL
+}
+
+//file(name): the uploaded file of a multipart form, it's a hash with the keys
+//'filename', 'size', 'contentType' and 'content'. nil if the file is missing.
+func (h *HttpRequest) File(line string, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	name, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "file", "*String", args[0].Type())
+	}
+
+	if err := h.Request.ParseMultipartForm(maxMultipartMemory); err != nil {
+		return NewNil(err.Error())
+	}
+	f, fh, err := h.Request.FormFile(name.String)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+
+	hash := NewHash()
+	hash.Push(line, NewString("filename"), NewString(fh.Filename))
+	hash.Push(line, NewString("size"), NewInteger(fh.Size))
+	hash.Push(line, NewString("contentType"), NewString(fh.Header.Get("Content-Type")))
+	hash.Push(line, NewString("content"), NewString(string(content)))
+	return hash
+}
+
 //HTTP ResponseWriter object
 type HttpResponseWriter struct {
 	Writer http.ResponseWriter
+
+	sseStarted bool          //the headers of the event stream are written
+	views      *serviceViews //the templates of the service, for 'render'
 }
 
 func (h *HttpResponseWriter) IOWriter() io.Writer { return h.Writer }
@@ -676,6 +984,10 @@ func (h *HttpResponseWriter) CallMethod(line string, scope *Scope, method string
 		return h.Header(line, args...)
 	case "writeJson":
 		return h.WriteJson(line, args...)
+	case "sse":
+		return h.SSE(line, args...)
+	case "render":
+		return h.Render(line, args...)
 	default:
 		return NewError(line, NOMETHODERROR, method, h.Type())
 	}
@@ -757,15 +1069,113 @@ func (h *HttpResponseWriter) WriteJson(line string, args ...Object) Object {
 	return NIL
 }
 
+// Render executes the html template 'name' of the service's 'templates'
+// directory with 'data', 'writer.render(name[, data])'. It returns false with
+// the reason if the template couldn't be executed, nothing is written then.
+func (h *HttpResponseWriter) Render(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	name, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "render", "*String", args[0].Type())
+	}
+	if h.views == nil {
+		return NewError(line, GENERICERROR, "render: no templates, set the 'templates' option of the service")
+	}
+
+	var data Object
+	if len(args) == 2 {
+		data = args[1]
+	}
+	if err := h.views.render(h.Writer, name.String, data); err != nil {
+		return NewFalseObj(err.Error())
+	}
+	return TRUE
+}
+
+// SSE writes a server-sent event and flushes it, 'writer.sse([event, ]data)'.
+// The hashes and arrays are sent as json. It returns false with the reason if
+// the event couldn't be written, e.g. the client is gone:
+//
+//	@route(url="/ticks")
+//	fn ticks(writer, request) {
+//	    let n = 0
+//	    while writer.sse("tick", {"n": n}) { n++; time.sleep(time.SECOND) }
+//	}
+func (h *HttpResponseWriter) SSE(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	var event string
+	if len(args) == 2 {
+		eventObj, ok := args[0].(*String)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "first", "sse", "*String", args[0].Type())
+		}
+		event = eventObj.String
+	}
+
+	var data string
+	switch o := args[len(args)-1].(type) {
+	case *String:
+		data = o.String
+	case json.Marshaler: //Hash, Array...
+		b, err := o.MarshalJSON()
+		if err != nil {
+			return NewFalseObj(err.Error())
+		}
+		data = string(b)
+	default:
+		return NewError(line, PARAMTYPEERROR, "last", "sse", "*String|*Hash|*Array", o.Type())
+	}
+
+	rc := http.NewResponseController(h.Writer)
+	if !h.sseStarted {
+		header := h.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no") //no buffering by the proxies
+		rc.SetWriteDeadline(time.Time{})      //the stream outlives the server's write timeout
+		h.Writer.WriteHeader(http.StatusOK)
+		h.sseStarted = true
+	}
+
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + strings.Replace(event, "\n", " ", -1) + "\n")
+	}
+	for _, l := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + l + "\n")
+	}
+	buf.WriteString("\n")
+	if _, err := h.Writer.Write(buf.Bytes()); err != nil {
+		return NewFalseObj(err.Error())
+	}
+	if err := rc.Flush(); err != nil {
+		return NewFalseObj(err.Error())
+	}
+	return TRUE
+}
+
 //HTTP Server object
 type HttpServer struct {
 	Server *http.Server
+
+	keepAlives bool
+	started    bool //'Server' was started, it can't be reused once stopped
+	runner     serverRunner
+	options    *serverOptions //the TLS and HTTP/2 options
 }
 
 func (h *HttpServer) Inspect() string  { return "<httpserver>" }
 func (h *HttpServer) Type() ObjectType { return HTTPSERVER_OBJ }
 func (h *HttpServer) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
 	switch method {
+	case "start", "stop", "wait", "addr":
+		return h.runner.callMethod(line, method, h.newServer, args...)
 	case "setReadTimeout":
 		return h.SetReadTimeout(line, args...)
 	case "setWriteTimeout":
@@ -833,7 +1243,12 @@ func (h *HttpServer) ListenAndServe(line string, args ...Object) Object {
 		return NewError(line, ARGUMENTERROR, "0", len(args))
 	}
 
-	err := h.Server.ListenAndServe()
+	var err error
+	if h.Server.TLSConfig != nil { //the certificates are in the config
+		err = h.Server.ListenAndServeTLS("", "")
+	} else {
+		err = h.Server.ListenAndServe()
+	}
 	if err != nil {
 		return NewFalseObj(err.Error())
 	}
@@ -841,6 +1256,26 @@ func (h *HttpServer) ListenAndServe(line string, args ...Object) Object {
 	return TRUE
 }
 
+// newServer returns the server to start, a copy of 'Server' if it was
+// already started.
+func (h *HttpServer) newServer() *http.Server {
+	if h.started {
+		old := h.Server
+		h.Server = &http.Server{
+			Addr:           old.Addr,
+			Handler:        old.Handler,
+			ReadTimeout:    old.ReadTimeout,
+			WriteTimeout:   old.WriteTimeout,
+			IdleTimeout:    old.IdleTimeout,
+			MaxHeaderBytes: old.MaxHeaderBytes,
+		}
+		h.Server.SetKeepAlivesEnabled(h.keepAlives)
+		h.options.apply(h.Server)
+	}
+	h.started = true
+	return h.Server
+}
+
 //func (h *HttpServer) Close(line string, args ...Object) Object {
 //	if len(args) != 0 {
 //		return NewError(line, ARGUMENTERROR, "0", len(args))
@@ -866,6 +1301,7 @@ func (h *HttpServer) SetKeepAlivesEnabled(line string, args ...Object) Object {
 	}
 
 	h.Server.SetKeepAlivesEnabled(b.Bool)
+	h.keepAlives = b.Bool
 	return NIL
 }
 
@@ -0,0 +1,431 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The resilience options of 'http.newClient':
+//
+//	let client = http.newClient({"timeout": 2, "retries": 3, "rateLimit": 10, "breakerFailures": 5})
+//	let resp = client.get(url)
+//	println(resp.stats())   // {"attempts" : 2, "retries" : 1, "waited" : 0.12, "failures" : ["503 Service Unavailable"]}
+//
+//	totalTimeout:    the timeout in seconds of a call, including the retries
+//	                 and the waits('timeout' is the timeout of each attempt)
+//	retries:         the maximum retries of a request, the default is 0
+//	retryOn:         the status codes which are retried, the default is
+//	                 [429, 502, 503, 504]. The connection errors are retried too.
+//	retryMethods:    the methods which are retried, the default is the
+//	                 idempotent ones: GET, HEAD, OPTIONS, PUT, DELETE, TRACE
+//	backoff:         the seconds to wait before the first retry, the default
+//	                 is 0.1, it doubles for each retry
+//	maxBackoff:      the maximum seconds of a wait, the default is 10
+//	jitter:          randomizes the waits to the range [wait/2, wait], the
+//	                 default is true. A 'Retry-After' header is honored.
+//	rateLimit:       the maximum requests per second(a token bucket), the
+//	                 requests wait for the tokens
+//	burst:           the size of the token bucket, the default is 1
+//	breakerFailures: opens the circuit breaker after the consecutive failures
+//	                 (connection errors and 5xx statuses), the requests then
+//	                 fail at once
+//	breakerCooldown: the seconds the breaker is open, the default is 30. Then
+//	                 one request is let through, its success closes the breaker.
+
+var defaultRetryOn = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+var defaultRetryMethods = []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE"}
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// retryPolicy decides which requests are retried and how long to wait.
+type retryPolicy struct {
+	retries    int
+	retryOn    map[int]bool
+	methods    map[string]bool
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     bool
+}
+
+// retryable reports whether the result of an attempt should be retried.
+func (p *retryPolicy) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !p.methods[req.Method] || req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil { //the body can't be sent again
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, errBreakerOpen)
+	}
+	return p.retryOn[resp.StatusCode]
+}
+
+// delay returns the wait before the retry of 'attempt'(0 for the first
+// retry), a 'Retry-After' of the response takes precedence.
+func (p *retryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > p.maxBackoff {
+				d = p.maxBackoff
+			}
+			return d
+		}
+	}
+
+	d := float64(p.backoff) * math.Pow(2, float64(attempt))
+	if d > float64(p.maxBackoff) {
+		d = float64(p.maxBackoff)
+	}
+	if p.jitter {
+		d = d/2 + rand.Float64()*d/2
+	}
+	return time.Duration(d)
+}
+
+// retryAfter parses the seconds or the http date of a 'Retry-After' header.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// rateLimiter is a token bucket of 'rate' tokens per second.
+type rateLimiter struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, it waits until the token is available or 'ctx' is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mux.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens-- //reserves the token, it may be negative
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mux.Unlock()
+
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mux.Lock()
+		l.tokens++ //gives back the reserved token
+		l.mux.Unlock()
+		return ctx.Err()
+	}
+}
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStates = []string{"closed", "open", "half-open"}
+
+// circuitBreaker opens after 'threshold' consecutive failures, and lets one
+// trial request through after 'cooldown'.
+type circuitBreaker struct {
+	mux       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     int
+	openedAt  time.Time
+	trial     bool //the trial request of the half-open state is running
+}
+
+// allow reports whether a request could be sent.
+func (b *circuitBreaker) allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errBreakerOpen
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		if b.trial {
+			return errBreakerOpen
+		}
+	}
+	b.trial = b.state == breakerHalfOpen
+	return nil
+}
+
+// record records the result of a request.
+func (b *circuitBreaker) record(success bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.state = breakerClosed
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return breakerStates[breakerHalfOpen]
+	}
+	return breakerStates[b.state]
+}
+
+// clientStats are the retry statistics of a call.
+type clientStats struct {
+	attempts int
+	waited   time.Duration
+	failures []string //the failed attempts, e.g. "503 Service Unavailable"
+}
+
+func (s *clientStats) toHash() *Hash {
+	stats := &clientStats{attempts: 1}
+	if s != nil {
+		stats = s
+	}
+	failures := &Array{}
+	for _, f := range stats.failures {
+		failures.Members = append(failures.Members, NewString(f))
+	}
+	hash := NewHash()
+	hash.Push("", NewString("attempts"), NewInteger(int64(stats.attempts)))
+	hash.Push("", NewString("retries"), NewInteger(int64(stats.attempts-1)))
+	hash.Push("", NewString("waited"), NewFloat(stats.waited.Seconds()))
+	hash.Push("", NewString("failures"), failures)
+	return hash
+}
+
+// cancelBody cancels the context of a call when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// readPolicy sets the resilience options to the client.
+func (h *HttpClient) readPolicy(o *options) error {
+	number := func(name string, defaultValue float64) (float64, error) {
+		val, ok := o.values[name]
+		if !ok {
+			return defaultValue, nil
+		}
+		f, ok := toFloat64(val)
+		if !ok || f < 0 {
+			return 0, fmt.Errorf("client options: '%s' should be a non-negative number, got=%s", name, val.Inspect())
+		}
+		return f, nil
+	}
+	seconds := func(f float64) time.Duration { return time.Duration(f * float64(time.Second)) }
+
+	var err error
+	var f float64
+	if f, err = number("totalTimeout", 0); err != nil {
+		return err
+	}
+	h.totalTimeout = seconds(f)
+
+	if f, err = number("retries", 0); err != nil {
+		return err
+	}
+	p := &retryPolicy{retries: int(f), retryOn: make(map[int]bool), methods: make(map[string]bool), jitter: o.boolean("jitter", true)}
+	if f, err = number("backoff", 0.1); err != nil {
+		return err
+	}
+	p.backoff = seconds(f)
+	if f, err = number("maxBackoff", 10); err != nil {
+		return err
+	}
+	p.maxBackoff = seconds(f)
+
+	for _, code := range defaultRetryOn {
+		p.retryOn[code] = true
+	}
+	if arr, ok := o.values["retryOn"].(*Array); ok {
+		p.retryOn = make(map[int]bool)
+		for _, m := range arr.Members {
+			code, ok := m.(*Integer)
+			if !ok {
+				return fmt.Errorf("client options: 'retryOn' should be an array of status codes, got=%s", m.Inspect())
+			}
+			p.retryOn[int(code.Int64)] = true
+		}
+	}
+	for _, method := range defaultRetryMethods {
+		p.methods[method] = true
+	}
+	if arr, ok := o.values["retryMethods"].(*Array); ok {
+		p.methods = make(map[string]bool)
+		for _, m := range arr.Members {
+			method, ok := m.(*String)
+			if !ok {
+				return fmt.Errorf("client options: 'retryMethods' should be an array of strings, got=%s", m.Inspect())
+			}
+			p.methods[strings.ToUpper(method.String)] = true
+		}
+	}
+	h.policy = p
+
+	if f, err = number("rateLimit", 0); err != nil {
+		return err
+	}
+	burst, err := number("burst", 1)
+	if err != nil {
+		return err
+	}
+	if f > 0 {
+		if burst < 1 {
+			return errors.New("client options: 'burst' should be at least 1")
+		}
+		h.limiter = newRateLimiter(f, int(burst))
+	} else if _, ok := o.values["burst"]; ok {
+		return errors.New("client options: 'burst' needs 'rateLimit'")
+	}
+
+	if f, err = number("breakerFailures", 0); err != nil {
+		return err
+	}
+	cooldown, err := number("breakerCooldown", 30)
+	if err != nil {
+		return err
+	}
+	if f >= 1 {
+		h.breaker = &circuitBreaker{threshold: int(f), cooldown: seconds(cooldown)}
+	} else if _, ok := o.values["breakerCooldown"]; ok {
+		return errors.New("client options: 'breakerCooldown' needs 'breakerFailures'")
+	}
+	return nil
+}
+
+// send sends the request with the retries, the rate limit and the circuit
+// breaker of the client.
+func (h *HttpClient) send(req *http.Request) (*http.Response, *clientStats, error) {
+	stats := &clientStats{}
+	var cancel context.CancelFunc
+	if h.totalTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), h.totalTimeout)
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := h.retry(req, stats)
+	if cancel != nil {
+		if err != nil {
+			cancel()
+		} else {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		}
+	}
+	if err != nil && stats.attempts > 1 {
+		err = fmt.Errorf("%v (%d attempts)", err, stats.attempts)
+	}
+	return resp, stats, err
+}
+
+func (h *HttpClient) retry(req *http.Request, stats *clientStats) (*http.Response, error) {
+	p := h.policy
+	if p == nil {
+		p = &retryPolicy{}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if h.limiter != nil {
+			if err := h.limiter.wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		if h.breaker != nil {
+			if err := h.breaker.allow(); err != nil {
+				return nil, err
+			}
+		}
+
+		stats.attempts++
+		resp, err := h.Client.Do(req)
+		if h.breaker != nil {
+			h.breaker.record(err == nil && resp.StatusCode < 500)
+		}
+		if attempt >= p.retries || !p.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		d := p.delay(attempt, resp)
+		if err != nil {
+			stats.failures = append(stats.failures, err.Error())
+		} else {
+			stats.failures = append(stats.failures, resp.Status)
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10)) //so the connection could be reused
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			stats.waited += d
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
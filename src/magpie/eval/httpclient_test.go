@@ -0,0 +1,229 @@
+package eval
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const flakyService = `
+let hits = {"flaky": 0, "post": 0, "down": 0}
+
+service Flaky on "127.0.0.1:0" {
+    @route(url="/flaky")
+    fn flaky(writer, request) {
+        hits["flaky"] = hits["flaky"] + 1
+        if hits["flaky"] % 3 != 0 {
+            return { "error": "busy" }, 503
+        }
+        writer.write("ok")
+    }
+
+    @route(url="/post", methods=["POST"])
+    fn post(writer, request) {
+        hits["post"] = hits["post"] + 1
+        if hits["post"] % 2 != 0 {
+            return { "error": "busy" }, 502
+        }
+        writer.write(request.body())
+    }
+
+    @route(url="/down")
+    fn down(writer, request) {
+        hits["down"] = hits["down"] + 1
+        return { "error": "down" }, 500
+    }
+
+    @route(url="/ok")
+    fn ok(writer, request) {
+        writer.write("ok")
+    }
+}
+
+let base = "http://" + Flaky.addr()
+
+fn fetch(client, path) {
+    let resp = client.get(base + path)
+    if resp == nil {
+        return resp.message()
+    }
+    defer resp.closeBody()
+    return resp.status() + " " + resp.readAll()
+}
+`
+
+func flakyInterp(t *testing.T, input string) *Interpreter {
+	t.Helper()
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(flakyService + input); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { interp.Run("Flaky.stop()") })
+	return interp
+}
+
+func runInspect(t *testing.T, interp *Interpreter, input string) string {
+	t.Helper()
+	result, err := interp.Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Inspect()
+}
+
+func TestHttpClientRetries(t *testing.T) {
+	interp := flakyInterp(t, `
+let client = http.newClient({"retries": 3, "backoff": 0.01, "jitter": false})
+let once = http.newClient({"retries": 1, "backoff": 0.01})
+let posts = http.newClient({"retries": 1, "backoff": 0.01, "retryMethods": ["post"]})
+`)
+
+	tests := []struct{ input, expected string }{
+		{`let resp = client.get(base + "/flaky"); resp.status() + " " + resp.readAll()`, "200 ok"},
+		{`resp.stats()`, `{"attempts" : 3, "retries" : 2, "waited" : 0.03, "failures" : ["503 Service Unavailable", "503 Service Unavailable"]}`},
+		{`let o = once.get(base + "/flaky"); o.status() + " " + o.stats()["attempts"]`, "503 2"},
+
+		//POST isn't retried by default
+		{`let r = client.post(base + "/post", "text/plain", "hi"); r.status() + " " + r.stats()["attempts"]`, "502 1"},
+		{`r = posts.post(base + "/post", "text/plain", "hi"); r.status() + " " + r.readAll()`, "200 hi"},
+		{`r = posts.post(base + "/post", "text/plain", "again"); r.status() + " " + r.readAll()`, "200 again"}, //the body is sent again
+
+		//500 isn't in the default 'retryOn'
+		{`client.get(base + "/down").stats()["attempts"]`, "1"},
+		{`http.newClient({"retries": 2, "backoff": 0, "retryOn": [500]}).get(base + "/down").stats()["attempts"]`, "3"},
+		{`hits`, `{"flaky" : 5, "post" : 4, "down" : 4}`},
+
+		//the responses without a client
+		{`http.get(base + "/ok").stats()`, `{"attempts" : 1, "retries" : 0, "waited" : 0, "failures" : []}`},
+	}
+	for _, tt := range tests {
+		if got := runInspect(t, interp, tt.input); got != tt.expected {
+			t.Errorf("%s: expected %s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	//the connection errors are retried
+	got := runInspect(t, interp, `let dead = http.newClient({"retries": 2, "backoff": 0}); dead.get("http://127.0.0.1:1/") == nil`)
+	if got != "true" {
+		t.Fatalf("expected nil, got=%s", got)
+	}
+	if msg := runInspect(t, interp, `dead.get("http://127.0.0.1:1/").message()`); !strings.Contains(msg, "(3 attempts)") {
+		t.Errorf("expected 3 attempts, got=%s", msg)
+	}
+}
+
+func TestHttpClientTimeouts(t *testing.T) {
+	interp := flakyInterp(t, `let client = http.newClient({"retries": 5, "backoff": 5, "totalTimeout": 0.1})`)
+
+	start := time.Now()
+	msg := runInspect(t, interp, `fetch(client, "/flaky")`)
+	if !strings.Contains(msg, "context deadline exceeded") {
+		t.Errorf("expected the total timeout, got=%s", msg)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the waits to be cut by the total timeout, took %v", elapsed)
+	}
+
+	//the body could be read after the call returns
+	if got := runInspect(t, interp, `fetch(http.newClient({"totalTimeout": 5}), "/ok")`); got != "200 ok" {
+		t.Errorf("expected 200 ok, got=%s", got)
+	}
+}
+
+func TestHttpClientRateLimit(t *testing.T) {
+	interp := flakyInterp(t, `let client = http.newClient({"rateLimit": 20, "burst": 2})`)
+
+	start := time.Now()
+	got := runInspect(t, interp, `let r = []; for i in 1..5 { r.push(fetch(client, "/ok")) }; r`)
+	elapsed := time.Since(start)
+	if got != `["200 ok", "200 ok", "200 ok", "200 ok", "200 ok"]` {
+		t.Errorf("unexpected results %s", got)
+	}
+	//2 requests of the burst, then 3 requests 50ms apart
+	if elapsed < 140*time.Millisecond {
+		t.Errorf("expected the requests to be limited, took %v", elapsed)
+	}
+}
+
+func TestHttpClientBreaker(t *testing.T) {
+	interp := flakyInterp(t, `let client = http.newClient({"breakerFailures": 2, "breakerCooldown": 0.1})`)
+
+	tests := []struct{ input, expected string }{
+		{`client.breakerState()`, "closed"},
+		{`fetch(client, "/down")`, `500 {"error":"down"}`},
+		{`fetch(client, "/ok")`, "200 ok"}, //a success resets the failures
+		{`fetch(client, "/down")`, `500 {"error":"down"}`},
+		{`fetch(client, "/down")`, `500 {"error":"down"}`},
+		{`client.breakerState()`, "open"},
+		{`fetch(client, "/ok")`, "circuit breaker is open"},
+		{`hits["down"]`, "3"},
+	}
+	for _, tt := range tests {
+		if got := runInspect(t, interp, tt.input); got != tt.expected {
+			t.Errorf("%s: expected %s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	tests = []struct{ input, expected string }{
+		{`client.breakerState()`, "half-open"},
+		{`fetch(client, "/down")`, `500 {"error":"down"}`}, //the trial fails
+		{`client.breakerState()`, "open"},
+		{`http.newClient().breakerState()`, "nil"},
+	}
+	for _, tt := range tests {
+		if got := runInspect(t, interp, tt.input); got != tt.expected {
+			t.Errorf("%s: expected %s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if got := runInspect(t, interp, `fetch(client, "/ok") + " " + client.breakerState()`); got != `200 ok closed` {
+		t.Errorf("expected the breaker to be closed, got=%s", got)
+	}
+}
+
+func TestHttpClientBackoff(t *testing.T) {
+	p := &retryPolicy{backoff: 100 * time.Millisecond, maxBackoff: time.Second, jitter: true}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.delay(attempt, nil); d < max/2 || d > max {
+				t.Fatalf("attempt %d: expected a delay in [%v, %v], got=%v", attempt, max/2, max, d)
+			}
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"2"}}}
+	p.maxBackoff = 10 * time.Second
+	if d := p.delay(0, resp); d != 2*time.Second {
+		t.Errorf("expected the Retry-After seconds, got=%v", d)
+	}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if d := p.delay(0, resp); d != p.maxBackoff {
+		t.Errorf("expected the Retry-After date capped by maxBackoff, got=%v", d)
+	}
+}
+
+func TestHttpClientOptionErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`http.newClient({"retries": -1})`, "'retries' should be a non-negative number"},
+		{`http.newClient({"retries": "3"})`, "'retries' should be a non-negative number"},
+		{`http.newClient({"retryOn": 503})`, "'retryOn' should be an array"},
+		{`http.newClient({"retryOn": ["503"]})`, "'retryOn' should be an array of status codes"},
+		{`http.newClient({"retryMethods": [1]})`, "'retryMethods' should be an array of strings"},
+		{`http.newClient({"jitter": 1})`, "'jitter' should be a boolean"},
+		{`http.newClient({"burst": 2})`, "'burst' needs 'rateLimit'"},
+		{`http.newClient({"rateLimit": 1, "burst": 0})`, "'burst' should be at least 1"},
+		{`http.newClient({"breakerCooldown": 2})`, "'breakerCooldown' needs 'breakerFailures'"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+)
+
+const (
+	HTTPTEST_OBJ       = "HTTPTEST_OBJ"
+	HTTPTESTCLIENT_OBJ = "HTTPTESTCLIENT_OBJ"
+	httptest_name      = "httptest"
+)
+
+// HttpTest is the 'httptest' module, its clients send the requests to a
+// service in memory, through the service's router and middlewares, without
+// opening sockets:
+//
+//	let client = httptest.newClient(Hello)
+//	let resp = client.get("/meters/42", {"Authorization": "secret"})
+//	testing.equal(200, resp.status)
+//	testing.equal("42", resp.body.acceptNo)
+//	resp = client.post("/users", {"Name": "magpie"})
+//
+// The response is a hash with the keys 'status', 'headers'(the first value
+// of each header), 'body'(the json body is decoded) and 'text'(the raw body).
+type HttpTest struct{}
+
+func NewHttpTestObj() Object {
+	ret := &HttpTest{}
+	SetGlobalObj(httptest_name, ret)
+	return ret
+}
+
+func (h *HttpTest) Inspect() string  { return "<" + httptest_name + ">" }
+func (h *HttpTest) Type() ObjectType { return HTTPTEST_OBJ }
+
+func (h *HttpTest) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "newClient":
+		return h.NewClient(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, h.Type())
+}
+
+// newClient(service): the service could be a 'service' handle, or a server
+// returned by 'http.newServer'.
+func (h *HttpTest) NewClient(line string, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	switch o := args[0].(type) {
+	case *ServiceObj:
+		return &HttpTestClient{Handler: o.Handler()}
+	case *HttpServer:
+		handler := o.Server.Handler
+		if handler == nil {
+			handler = http.DefaultServeMux
+		}
+		return &HttpTestClient{Handler: handler}
+	}
+	return NewError(line, PARAMTYPEERROR, "first", "newClient", "*ServiceObj|*HttpServer", args[0].Type())
+}
+
+// HttpTestClient sends the requests to 'Handler' with a recorder.
+type HttpTestClient struct {
+	Handler http.Handler
+}
+
+func (c *HttpTestClient) Inspect() string  { return "<httptestclient>" }
+func (c *HttpTestClient) Type() ObjectType { return HTTPTESTCLIENT_OBJ }
+
+func (c *HttpTestClient) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "get":
+		return c.Get(line, args...)
+	case "delete":
+		return c.Delete(line, args...)
+	case "post":
+		return c.Post(line, args...)
+	case "put":
+		return c.Put(line, args...)
+	case "patch":
+		return c.Patch(line, args...)
+	case "request":
+		return c.Request(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, c.Type())
+}
+
+// get(url[, headers])
+func (c *HttpTestClient) Get(line string, args ...Object) Object {
+	return c.noBody(line, "GET", "get", args...)
+}
+
+// delete(url[, headers])
+func (c *HttpTestClient) Delete(line string, args ...Object) Object {
+	return c.noBody(line, "DELETE", "delete", args...)
+}
+
+// post(url, body[, headers])
+func (c *HttpTestClient) Post(line string, args ...Object) Object {
+	return c.withBody(line, "POST", "post", args...)
+}
+
+// put(url, body[, headers])
+func (c *HttpTestClient) Put(line string, args ...Object) Object {
+	return c.withBody(line, "PUT", "put", args...)
+}
+
+// patch(url, body[, headers])
+func (c *HttpTestClient) Patch(line string, args ...Object) Object {
+	return c.withBody(line, "PATCH", "patch", args...)
+}
+
+// request(method, url[, body[, headers]])
+func (c *HttpTestClient) Request(line string, args ...Object) Object {
+	if len(args) < 2 || len(args) > 4 {
+		return NewError(line, ARGUMENTERROR, "2|3|4", len(args))
+	}
+
+	method, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "request", "*String", args[0].Type())
+	}
+	var body, headers Object
+	if len(args) > 2 {
+		body = args[2]
+	}
+	if len(args) > 3 {
+		headers = args[3]
+	}
+	return c.do(line, "request", strings.ToUpper(method.String), args[1], body, headers)
+}
+
+func (c *HttpTestClient) noBody(line string, method string, name string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	var headers Object
+	if len(args) == 2 {
+		headers = args[1]
+	}
+	return c.do(line, name, method, args[0], nil, headers)
+}
+
+func (c *HttpTestClient) withBody(line string, method string, name string, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return NewError(line, ARGUMENTERROR, "2|3", len(args))
+	}
+
+	var headers Object
+	if len(args) == 3 {
+		headers = args[2]
+	}
+	return c.do(line, name, method, args[0], args[1], headers)
+}
+
+// do serves the request, the body is sent as is if it's a string, or else
+// as json. 'body' and 'headers' could be nil.
+func (c *HttpTestClient) do(line string, name string, method string, url Object, body Object, headers Object) Object {
+	urlStr, ok := url.(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "url", name, "*String", url.Type())
+	}
+
+	var reader io.Reader
+	isJson := false
+	switch b := body.(type) {
+	case nil, *Nil:
+	case *String:
+		reader = strings.NewReader(b.String)
+	default:
+		res, err := marshalJsonObject(b)
+		if err != nil {
+			return NewError(line, GENERICERROR, err.Error())
+		}
+		reader, isJson = &res, true
+	}
+
+	req := httptest.NewRequest(method, urlStr.String, reader)
+	if isJson {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch h := headers.(type) {
+	case nil, *Nil:
+	case *Hash:
+		for _, hk := range h.Order {
+			pair := h.Pairs[hk]
+			req.Header.Set(pair.Key.Inspect(), pair.Value.Inspect())
+		}
+	default:
+		return NewError(line, PARAMTYPEERROR, "headers", name, "*Hash", headers.Type())
+	}
+
+	w := httptest.NewRecorder()
+	c.Handler.ServeHTTP(w, req)
+	return newTestResponse(line, w)
+}
+
+// newTestResponse returns the hash of the recorded response.
+func newTestResponse(line string, w *httptest.ResponseRecorder) Object {
+	resp := w.Result()
+	text := w.Body.Bytes()
+
+	headers := NewHash()
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		headers.Push(line, NewString(k), NewString(resp.Header.Get(k)))
+	}
+
+	var body Object = NewString(string(text))
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") && len(bytes.TrimSpace(text)) > 0 {
+		if v, err := parseJson(text); err == nil {
+			body = v
+		}
+	}
+
+	ret := NewHash()
+	ret.Push(line, NewString("status"), NewInteger(int64(resp.StatusCode)))
+	ret.Push(line, NewString("headers"), headers)
+	ret.Push(line, NewString("body"), body)
+	ret.Push(line, NewString("text"), NewString(string(text)))
+	return ret
+}
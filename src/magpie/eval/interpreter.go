@@ -0,0 +1,258 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/message"
+	"magpie/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Interpreter is a magpie interpreter which can be embedded in a go program.
+// Every interpreter owns its global scope, its imported modules, its
+// registered go functions/variables and its debugger, so many interpreters
+// can run at the same time in one process without seeing each other's state.
+//
+//	interp := eval.NewInterpreter(os.Stdout)
+//	interp.RegisterFunctions("strings", map[string]interface{}{
+//		"ToUpper": strings.ToUpper,
+//	})
+//	interp.Set("name", "magpie")
+//	result, err := interp.Run(`strings.ToUpper(name)`)
+//
+// Scopes created with 'NewScope(nil, w)' belong to the default interpreter,
+// whose state is kept in the package level variables('Dbg', 'MsgHandler',
+// 'REPLColor') and registries('RegisterFunctions', 'RegisterVars').
+type Interpreter struct {
+	//REPL with color support
+	REPLColor bool
+
+	Dbg        *Debugger
+	MsgHandler *message.MessageHandler
+
+	//Limits restricts every program run by the interpreter.
+	Limits Limits
+
+	//NoListen keeps the services from listening when they are declared, they
+	//serve only when they are started, e.g. 'Hello.start()'. 'magpie test'
+	//sets it, the services are tested in memory with the 'httptest' module.
+	NoListen bool
+
+	//CheckTypes checks the type annotations of the parameters, the return
+	//values and the lets when the program runs, they're ignored otherwise.
+	CheckTypes bool
+
+	scope   *Scope
+	imports importState
+
+	globals    map[string]Object //registered go functions/variables
+	globalsMux sync.RWMutex
+}
+
+// the imported modules of an interpreter
+type importState struct {
+	sync.Mutex //guards the evaluation of imports
+	init       sync.Once
+	scope      *Scope
+	cache      map[string]Object
+}
+
+// imports of the default interpreter
+var defaultImports importState
+
+// The parser keeps the debug informations in package level variables,
+// so only one program can be parsed at the same time.
+var parseMux sync.Mutex
+
+func NewInterpreter(w io.Writer) *Interpreter {
+	interp := &Interpreter{globals: make(map[string]Object)}
+	interp.scope = NewScope(nil, w)
+	interp.scope.interp = interp
+	return interp
+}
+
+// Scope returns the global scope of the interpreter.
+func (interp *Interpreter) Scope() *Scope {
+	return interp.scope
+}
+
+// Run runs the magpie source code 'src' in the global scope of the interpreter,
+// and returns the value of the program. Imports are searched in the current
+// working directory. The returned error is non-nil if the source has syntax
+// errors, or if its evaluation results in an error.
+func (interp *Interpreter) Run(src string) (Object, error) {
+	return interp.RunContext(context.Background(), src)
+}
+
+// RunContext is like 'Run', but the program is stopped when 'ctx' is done.
+func (interp *Interpreter) RunContext(ctx context.Context, src string) (Object, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return interp.run(ctx, "", src, wd)
+}
+
+// RunFile runs the magpie file 'path' like 'Run', imports are searched
+// in the directory of the file.
+func (interp *Interpreter) RunFile(path string) (Object, error) {
+	return interp.RunFileContext(context.Background(), path)
+}
+
+// RunFileContext is like 'RunFile', but the program is stopped when 'ctx' is done.
+func (interp *Interpreter) RunFileContext(ctx context.Context, path string) (Object, error) {
+	f, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return interp.run(ctx, path, string(f), filepath.Dir(abs))
+}
+
+// Parse parses the source code 'src' of the file 'filename', imports are
+// searched in the directory 'dir'. It waits for the other programs being
+// parsed, use it instead of 'parser.New' when interpreters may be running.
+func Parse(filename, src, dir string) (*parser.Parser, *ast.Program) {
+	parseMux.Lock()
+	defer parseMux.Unlock()
+	p := parser.New(lexer.New(filename, src), dir)
+	return p, p.ParseProgram()
+}
+
+func (interp *Interpreter) run(ctx context.Context, filename, src, dir string) (Object, error) {
+	p, program := Parse(filename, src, dir)
+
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	if interp.Dbg != nil {
+		interp.Dbg.SetFunctions(p.Functions)
+	}
+
+	result := EvalContext(ctx, program, interp.scope, interp.Limits)
+	if result == nil {
+		return NIL, nil
+	}
+	if err, ok := result.(*Error); ok {
+		return result, err
+	}
+	return result, nil
+}
+
+// Call calls the magpie function 'fn'(e.g. a function defined by a program
+// run by the interpreter) with 'args', and returns its result. The returned
+// error is non-nil if the call results in an error.
+func (interp *Interpreter) Call(fn Object, args ...Object) (Object, error) {
+	scope := interp.scope
+	switch f := fn.(type) {
+	case *Function:
+		if f.Async {
+			return nil, errors.New("Call: async functions are not supported")
+		}
+		scope = f.Scope
+	case *Builtin:
+	default:
+		return nil, errors.New("Call: not a function: " + string(fn.Type()))
+	}
+
+	result := evalFunctionDirect(fn, args, nil, scope, nil)
+	if result == nil {
+		return NIL, nil
+	}
+	if err, ok := result.(*Error); ok {
+		return result, err
+	}
+	return result, nil
+}
+
+// Set sets the global variable 'name' of the interpreter. 'value' could be
+// a magpie Object or a go value, which will be converted to an Object.
+func (interp *Interpreter) Set(name string, value interface{}) {
+	obj, ok := value.(Object)
+	if !ok {
+		obj = GoValueToObject(value)
+	}
+	interp.scope.Set(name, obj)
+}
+
+// Get returns the global variable 'name' of the interpreter.
+func (interp *Interpreter) Get(name string) (Object, bool) {
+	return interp.scope.Get(name)
+}
+
+// RegisterVars is like the package level 'RegisterVars', except that the
+// variables are only visible to the scripts run by this interpreter.
+func (interp *Interpreter) RegisterVars(name string, vars map[string]interface{}) {
+	for k, v := range vars {
+		interp.setGlobal(name+"."+k, NewGoObject(v))
+	}
+}
+
+// RegisterFunctions is like the package level 'RegisterFunctions', except that
+// the functions are only visible to the scripts run by this interpreter.
+func (interp *Interpreter) RegisterFunctions(name string, vars map[string]interface{}) {
+	interp.setGlobal(goModuleName(name), goFunctionsHash(vars))
+}
+
+func (interp *Interpreter) setGlobal(name string, obj Object) {
+	interp.globalsMux.Lock()
+	defer interp.globalsMux.Unlock()
+
+	interp.globals[name] = obj
+}
+
+//The methods below also work with a nil interpreter, which means the default interpreter.
+
+// getGlobal returns the registered go function/variable 'name'. The
+// interpreter's own registrations take precedence over the package level ones.
+func (interp *Interpreter) getGlobal(name string) (Object, bool) {
+	if interp != nil {
+		interp.globalsMux.RLock()
+		obj, ok := interp.globals[name]
+		interp.globalsMux.RUnlock()
+		if ok {
+			return obj, ok
+		}
+	}
+	return GetGlobalObj(name)
+}
+
+func (interp *Interpreter) importState() *importState {
+	if interp == nil {
+		return &defaultImports
+	}
+	return &interp.imports
+}
+
+func (interp *Interpreter) debugger() (*Debugger, *message.MessageHandler) {
+	if interp == nil {
+		return Dbg, MsgHandler
+	}
+	return interp.Dbg, interp.MsgHandler
+}
+
+func (interp *Interpreter) replColor() bool {
+	if interp == nil {
+		return REPLColor
+	}
+	return interp.REPLColor
+}
+
+func (interp *Interpreter) noListen() bool {
+	return interp != nil && interp.NoListen
+}
+
+func (interp *Interpreter) checkTypes() bool {
+	return interp != nil && interp.CheckTypes
+}
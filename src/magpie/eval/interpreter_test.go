@@ -0,0 +1,244 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterpreterSetGet(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	interp.Set("x", 10)
+	interp.Set("name", NewString("magpie"))
+
+	result, err := interp.Run(`let y = x * 2; name + " " + y`)
+	if err != nil {
+		t.Fatalf("Run returned error: %s", err)
+	}
+	if result.Inspect() != "magpie 20" {
+		t.Errorf("result should be 'magpie 20', got=%q", result.Inspect())
+	}
+
+	y, ok := interp.Get("y")
+	if !ok {
+		t.Fatalf("variable 'y' not found")
+	}
+	if i, ok := y.(*Integer); !ok || i.Int64 != 20 {
+		t.Errorf("y should be 20, got=%s", y.Inspect())
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(`let = 5`); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+	if _, err := interp.Run(`5 + true`); err == nil {
+		t.Errorf("expected a runtime error")
+	}
+}
+
+func TestInterpreterIsolation(t *testing.T) {
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 10)
+	errs := make([]error, len(outs))
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			interp := NewInterpreter(&outs[i])
+			interp.RegisterFunctions("host", map[string]interface{}{
+				"Id": func() int { return i },
+			})
+			interp.RegisterVars("host", map[string]interface{}{
+				"Name": fmt.Sprintf("interp%d", i),
+			})
+			_, errs[i] = interp.Run(`let sum = 0; for j in 1..100 { sum += j }; println(host.Name, ":", host.Id(), ":", sum)`)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range outs {
+		if errs[i] != nil {
+			t.Fatalf("interpreter %d returned error: %s", i, errs[i])
+		}
+		expected := fmt.Sprintf("interp%d:%d:5050", i, i)
+		if got := strings.TrimSpace(outs[i].String()); got != expected {
+			t.Errorf("interpreter %d: expected %q, got=%q", i, expected, got)
+		}
+	}
+
+	if _, ok := GetGlobalObj("host"); ok {
+		t.Errorf("per-interpreter functions leaked to the package level registry")
+	}
+}
+
+// The logger colors its output like 'println', by the interpreter's REPLColor.
+func TestInterpreterLoggerColor(t *testing.T) {
+	for _, color := range []bool{false, true} {
+		var out bytes.Buffer
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.REPLColor = color
+		interp.Set("l", &LoggerObj{Logger: log.New(&out, "", 0)})
+		if _, err := interp.Run(`l.println(1); l.print(2); l.printf("%v", 3)`); err != nil {
+			t.Fatalf("Run: %s", err)
+		}
+		if colored := strings.Contains(out.String(), "\x1b["); colored != color {
+			t.Errorf("REPLColor=%v: got=%q", color, out.String())
+		}
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits Limits
+		reason string
+	}{
+		{"for {}", Limits{MaxNodes: 10000}, "more than 10000 nodes evaluated"},
+		{"for {}", Limits{Timeout: 50 * time.Millisecond}, "timeout"},
+		{"fn f(n) { f(n + 1) }; f(0)", Limits{MaxCallDepth: 100}, "call depth exceeds 100"},
+		{"let i = 0; while true { i++ }", Limits{Timeout: 50 * time.Millisecond}, "timeout"},
+	}
+
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.Limits = tt.limits
+		_, err := interp.Run(tt.input)
+		e, ok := err.(*Error)
+		if !ok || e.Kind != EXECLIMITERROR {
+			t.Errorf("input %q: expected an EXECLIMITERROR, got=%v", tt.input, err)
+			continue
+		}
+		if expected := "Execution limit exceeded: " + tt.reason + " at line"; !strings.HasPrefix(e.Message, expected) {
+			t.Errorf("input %q: expected %q, got=%q", tt.input, expected, e.Message)
+		}
+	}
+}
+
+// The context of the limits is released when the program and the goroutines
+// it started return, not at the deadline.
+func TestInterpreterLimitsRelease(t *testing.T) {
+	scope := NewInterpreter(&bytes.Buffer{}).Scope()
+	limits := Limits{Timeout: time.Hour}
+
+	var l *execLimits
+	WithLimits(context.Background(), scope, limits, func() Object {
+		l = scope.CallStack.limits
+		return NIL
+	})
+	if err := l.ctx.Err(); err != context.Canceled {
+		t.Errorf("expected the context to be released, got=%v", err)
+	}
+
+	release := make(chan struct{})
+	WithLimits(context.Background(), scope, limits, func() Object {
+		l = scope.CallStack.limits
+		startGoroutine(goroutineScope(scope).CallStack, func() { <-release })
+		return NIL
+	})
+	if err := l.ctx.Err(); err != nil {
+		t.Errorf("expected the context to be kept while a goroutine runs, got=%v", err)
+	}
+	close(release)
+	select {
+	case <-l.done:
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the context to be released after the goroutine returned")
+	}
+}
+
+func TestInterpreterCancel(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := interp.RunContext(ctx, `spawn fn() { for {} }(); for {}`)
+	if e, ok := err.(*Error); !ok || e.Kind != EXECLIMITERROR {
+		t.Errorf("expected an EXECLIMITERROR, got=%v", err)
+	} else if !strings.HasPrefix(e.Message, "Execution limit exceeded: canceled") {
+		t.Errorf("expected the program to be canceled, got=%q", e.Message)
+	}
+
+	//the interpreter could be used again after the program was stopped
+	if result, err := interp.Run("1 + 2"); err != nil || result.Inspect() != "3" {
+		t.Errorf("expected 3, got=%v, %v", result, err)
+	}
+}
+
+func TestInterpreterImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magpie-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"magpie.mod":       "module acme/app\n",
+		"a/util.mp":        `println("loading a/util"); fn Name() { "a" }`,
+		"b/util/one.mp":    `fn Name() { "b" }`,
+		"b/util/two.mp":    `fn Other() { "b2" }`,
+		"c/counter.mp":     "import acme.app.a.util\nfn Name() { util.Name() }",
+		"main.mp":          "import \"acme/app/a/util\"\nimport \"acme/app/b/util\" as butil\nimport \"acme/app/c/counter\"\n[util.Name(), butil.Name(), butil.Other(), counter.Name()]",
+		"conflict/main.mp": "import \"acme/app/a/util\"\nimport \"acme/app/b/util\"\n",
+		"util/strings.mp":  `fn Up(s) { s.upper() + "!" }`,
+		"builtin/main.mp":  "import \"acme/app/util/strings\"\nfn f() { strings.Up(\"a\") }\n[strings.Up(\"b\"), f()]",
+		"cycle/a.mp":       "import \"acme/app/cycle/b\"\nfn A() { 1 }",
+		"cycle/b.mp":       "import \"acme/app/cycle/a\"\nfn B() { 2 }",
+		"cycle/main.mp":    "import \"acme/app/cycle/a\"\na.A()",
+		"self/main.mp":     "import \"acme/app/self/main\"\n1",
+	}
+	for name, src := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := ioutil.WriteFile(fn, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the packages with the same name don't collide, and 'a/util' is evaluated once
+	var out bytes.Buffer
+	result, err := NewInterpreter(&out).RunFile(filepath.Join(dir, "main.mp"))
+	if err != nil {
+		t.Fatalf("RunFile: %s", err)
+	}
+	if expected := `["a", "b", "b2", "a"]`; result.Inspect() != expected {
+		t.Errorf("expected %s, got=%s", expected, result.Inspect())
+	}
+	if n := strings.Count(out.String(), "loading a/util"); n != 1 {
+		t.Errorf("expected a/util to be evaluated once, got=%d", n)
+	}
+
+	//a package named like a builtin object shadows it
+	result, err = NewInterpreter(&bytes.Buffer{}).RunFile(filepath.Join(dir, "builtin/main.mp"))
+	if err != nil {
+		t.Fatalf("RunFile: %s", err)
+	}
+	if expected := `["B!", "A!"]`; result.Inspect() != expected {
+		t.Errorf("expected %s, got=%s", expected, result.Inspect())
+	}
+	if result, _ := NewInterpreter(&bytes.Buffer{}).Run(`strings.upper("c")`); result.Inspect() != "C" {
+		t.Errorf("expected the builtin strings without the import, got=%s", result.Inspect())
+	}
+
+	_, err = NewInterpreter(&bytes.Buffer{}).RunFile(filepath.Join(dir, "conflict/main.mp"))
+	if err == nil || !strings.Contains(err.Error(), "conflicts with") {
+		t.Errorf("expected an import conflict error, got=%v", err)
+	}
+
+	//an import cycle is an error, not an endless recursion
+	for _, name := range []string{"cycle/main.mp", "self/main.mp"} {
+		_, err = NewInterpreter(&bytes.Buffer{}).RunFile(filepath.Join(dir, name))
+		if err == nil || !strings.Contains(err.Error(), "import cycle not allowed") {
+			t.Errorf("%s: expected an import cycle error, got=%v", name, err)
+		}
+	}
+}
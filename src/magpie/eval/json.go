@@ -123,7 +123,15 @@ func (j *Json) UnMarshal(line string, args ...Object) Object {
 		return NewError(line, PARAMTYPEERROR, "first", "unmarshal", "*String", args[0].Type())
 	}
 
-	in := []byte(jsonStr.String)
+	ret, err := parseJson([]byte(jsonStr.String))
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return ret
+}
+
+//parseJson converts the json text to an array, a hash or a simple object.
+func parseJson(in []byte) (Object, error) {
 	b := bytes.TrimSpace(in)
 	r, _ := utf8.DecodeRune(b)
 
@@ -131,27 +139,23 @@ func (j *Json) UnMarshal(line string, args ...Object) Object {
 		a := &Array{}
 		err := a.UnmarshalJSON(b)
 		if err != nil {
-			return NewNil(err.Error())
+			return nil, err
 		}
-		return a
+		return a, nil
 	} else if r == '{' { // hash
 		h := NewHash()
 		err := h.UnmarshalJSON(b)
 		if err != nil {
-			return NewNil(err.Error())
+			return nil, err
 		}
-		return h
+		return h, nil
 	} else { //simple types, e.g. number, string
 		var val interface{}
 		err := json.Unmarshal(b, &val)
 		if err != nil {
-			return NewNil(err.Error())
-		}
-		ret, err := unmarshalJsonObject(val)
-		if err != nil {
-			return NewNil(err.Error())
+			return nil, err
 		}
-		return ret
+		return unmarshalJsonObject(val)
 	}
 }
 
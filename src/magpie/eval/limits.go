@@ -0,0 +1,161 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"magpie/ast"
+	"sync/atomic"
+	"time"
+)
+
+// Limits restricts the resources used by a program, e.g. a snippet supplied
+// by a user. A zero field means no limit.
+//
+// Going over a limit stops the program with an error of kind 'EXECLIMITERROR'.
+// The error could be caught as a 'LimitError', but the node budget and the
+// deadline stay exceeded, so the program stops at the next evaluated node.
+// The limits also apply to the goroutines started by 'spawn' and 'async'.
+type Limits struct {
+	MaxNodes     int64         //maximum number of evaluated nodes
+	MaxCallDepth int           //maximum number of nested function calls
+	Timeout      time.Duration //wall clock time
+}
+
+// the limits of a running program, shared by all its goroutines
+type execLimits struct {
+	ctx          context.Context
+	done         <-chan struct{}
+	maxNodes     int64
+	nodes        int64 //accessed atomically
+	maxCallDepth int
+
+	//the program and the goroutines it started, accessed atomically. The
+	//context is released when the last of them returns.
+	running int64
+	cancel  context.CancelFunc
+}
+
+// EvalContext is like 'Eval', but the evaluation is stopped when 'ctx' is
+// done, or when any of the 'limits' is exceeded.
+func EvalContext(ctx context.Context, node ast.Node, scope *Scope, limits Limits) Object {
+	return WithLimits(ctx, scope, limits, func() Object {
+		return Eval(node, scope)
+	})
+}
+
+// WithLimits runs 'run' with the limits applied to the call stack of 'scope'.
+// It's used by 'EvalContext', and by other execution backends(e.g. the vm).
+func WithLimits(ctx context.Context, scope *Scope, limits Limits, run func() Object) Object {
+	var cancel context.CancelFunc
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(limits.Timeout))
+	}
+
+	l := &execLimits{
+		ctx:          ctx,
+		done:         ctx.Done(),
+		maxNodes:     limits.MaxNodes,
+		maxCallDepth: limits.MaxCallDepth,
+		running:      1,
+		cancel:       cancel,
+	}
+
+	stack := scope.CallStack
+	old := stack.limits
+	stack.limits = l
+	defer func() { stack.limits = old }()
+
+	//Note: the context is not canceled when 'run' returns if the goroutines
+	//started by the program are still running, they run until the deadline.
+	defer l.exit()
+	return run()
+}
+
+// exit is called when the program or one of its goroutines returns, the
+// last one releases the context.
+func (l *execLimits) exit() {
+	if atomic.AddInt64(&l.running, -1) == 0 && l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// startGoroutine runs 'f' in a new goroutine(spawn, async, generator), which
+// counts as running for the limits of 'stack'.
+func startGoroutine(stack *CallStack, f func()) {
+	l := stack.limits
+	if l == nil {
+		go f()
+		return
+	}
+
+	atomic.AddInt64(&l.running, 1)
+	go func() {
+		defer l.exit()
+		f()
+	}()
+}
+
+// step is called for every evaluated node.
+func (l *execLimits) step(node ast.Node) Object {
+	select {
+	case <-l.done:
+		return l.stopError(nodeLine(node))
+	default:
+	}
+
+	n := atomic.AddInt64(&l.nodes, 1)
+	if l.maxNodes > 0 && n > l.maxNodes {
+		return NewError(nodeLine(node), EXECLIMITERROR, fmt.Sprintf("more than %d nodes evaluated", l.maxNodes))
+	}
+	return nil
+}
+
+// stopError returns the error of the program stopped by its context.
+func (l *execLimits) stopError(line string) Object {
+	reason := "canceled"
+	if l.ctx.Err() == context.DeadlineExceeded {
+		reason = "timeout"
+	}
+	return NewError(line, EXECLIMITERROR, reason)
+}
+
+// checkLimits returns an error if the program running in 'scope' went over its limits.
+func checkLimits(node ast.Node, scope *Scope) Object {
+	if l := scope.CallStack.limits; l != nil {
+		return l.step(node)
+	}
+	return nil
+}
+
+// checkCallDepth returns an error if calling a function from 'scope' would
+// go over the maximum call depth.
+func checkCallDepth(call *ast.CallExpression, scope *Scope) Object {
+	l := scope.CallStack.limits
+	if l == nil || l.maxCallDepth <= 0 {
+		return nil
+	}
+	if len(scope.CallStack.Frames) >= l.maxCallDepth {
+		return NewError(nodeLine(call), EXECLIMITERROR, fmt.Sprintf("call depth exceeds %d", l.maxCallDepth))
+	}
+	return nil
+}
+
+// goroutineScope returns a scope for running code in a new goroutine(spawn, async).
+// The goroutine gets its own call stack, which inherits the limits of 'scope'.
+func goroutineScope(scope *Scope) *Scope {
+	ret := NewScope(scope, nil)
+	ret.CallStack = scope.CallStack.fork()
+	return ret
+}
+
+// fork creates an empty call stack with the same limits.
+func (cs *CallStack) fork() *CallStack {
+	return &CallStack{Frames: []CallFrame{}, limits: cs.limits}
+}
+
+func nodeLine(node ast.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Pos().Sline()
+}
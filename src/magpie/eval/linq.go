@@ -403,8 +403,8 @@ func (lq *LinqObj) From(line string, scope *Scope, args ...Object) Object {
 	//check object type
 	if obj.Type() != STRING_OBJ && obj.Type() != ARRAY_OBJ &&
 		obj.Type() != HASH_OBJ && obj.Type() != FILE_OBJ && obj.Type() != CSV_OBJ &&
-		obj.Type() != CHANNEL_OBJ {
-		return NewError(line, PARAMTYPEERROR, "first", "from", "*Hash|*Array|*String|*File|*CsvObj|*ChanObject", obj.Type())
+		obj.Type() != CHANNEL_OBJ && obj.Type() != GENERATOR_OBJ {
+		return NewError(line, PARAMTYPEERROR, "first", "from", "*Hash|*Array|*String|*File|*CsvObj|*ChanObject|*Generator", obj.Type())
 	}
 
 	switch obj.Type() {
@@ -651,6 +651,20 @@ func (lq *LinqObj) From(line string, scope *Scope, args ...Object) Object {
 				}
 			},
 		}}
+	case GENERATOR_OBJ:
+		gen := obj.(*Generator)
+
+		//must return a new LinqObj
+		//Note: the sequence ends when the generator is finished, or stops with an error.
+		return &LinqObj{Query: Query{
+			Iterate: func() Iterator {
+				return func() (item Object, ok *Boolean) {
+					ok = &Boolean{Valid: true}
+					item, ok.Bool = gen.resumeGenerator(line, scope, NIL)
+					return
+				}
+			},
+		}}
 	default:
 		return &LinqObj{Query: Query{Iterate: obj.(*LinqObj).Query.Iterate}}
 	} //end switch
@@ -3045,8 +3059,8 @@ func (lq *LinqObj) FromQuery(line string, scope *Scope, args ...Object) Object {
 	//check object type
 	if obj.Type() != STRING_OBJ && obj.Type() != ARRAY_OBJ &&
 		obj.Type() != HASH_OBJ && obj.Type() != FILE_OBJ && obj.Type() != CSV_OBJ &&
-		obj.Type() != CHANNEL_OBJ {
-		return NewError(line, PARAMTYPEERROR, "first", "from", "*Hash|*Array|*String|*File|*CsvObj|*ChanObject", obj.Type())
+		obj.Type() != CHANNEL_OBJ && obj.Type() != GENERATOR_OBJ {
+		return NewError(line, PARAMTYPEERROR, "first", "from", "*Hash|*Array|*String|*File|*CsvObj|*ChanObject|*Generator", obj.Type())
 	}
 
 	switch obj.Type() {
@@ -3311,6 +3325,21 @@ func (lq *LinqObj) FromQuery(line string, scope *Scope, args ...Object) Object {
 				}
 			},
 		}}
+	case GENERATOR_OBJ:
+		gen := obj.(*Generator)
+
+		varStr := varObj.(*String).String
+		//must return a new LinqObj
+		return &LinqObj{Query: Query{
+			Iterate: func() Iterator {
+				return func() (item Object, ok *Boolean) {
+					ok = &Boolean{Valid: true}
+					item, ok.Bool = gen.resumeGenerator(line, scope, NIL)
+					scope.Set(varStr, item)
+					return
+				}
+			},
+		}}
 	default:
 		return &LinqObj{Query: Query{Iterate: obj.(*LinqObj).Query.Iterate}}
 	} //end switch
@@ -34,23 +34,23 @@ func (l *LoggerObj) Type() ObjectType { return LOGGER_OBJ }
 func (l *LoggerObj) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
 	switch method {
 	case "print":
-		return l.Print(line, args...)
+		return l.Print(line, scope, args...)
 	case "printf":
-		return l.Printf(line, args...)
+		return l.Printf(line, scope, args...)
 	case "println":
-		return l.Println(line, args...)
+		return l.Println(line, scope, args...)
 	case "fatal":
-		return l.Fatal(line, args...)
+		return l.Fatal(line, scope, args...)
 	case "fatalf":
-		return l.Fatalf(line, args...)
+		return l.Fatalf(line, scope, args...)
 	case "fatalln":
-		return l.Fatalln(line, args...)
+		return l.Fatalln(line, scope, args...)
 	case "panic":
-		return l.Panic(line, args...)
+		return l.Panic(line, scope, args...)
 	case "panicf":
-		return l.Panicf(line, args...)
+		return l.Panicf(line, scope, args...)
 	case "panicln":
-		return l.Panicln(line, args...)
+		return l.Panicln(line, scope, args...)
 	case "flags":
 		return l.Flags(line, args...)
 	case "output":
@@ -67,21 +67,21 @@ func (l *LoggerObj) CallMethod(line string, scope *Scope, method string, args ..
 	return NewError(line, NOMETHODERROR, method, l.Type())
 }
 
-func (l *LoggerObj) Print(line string, args ...Object) Object {
+func (l *LoggerObj) Print(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Print()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Print(wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Printf(line string, args ...Object) Object {
+func (l *LoggerObj) Printf(line string, scope *Scope, args ...Object) Object {
 	if len(args) < 1 {
 		return NewError(line, ARGUMENTERROR, ">0", len(args))
 	}
@@ -94,42 +94,42 @@ func (l *LoggerObj) Printf(line string, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Printf(format.String, wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Println(line string, args ...Object) Object {
+func (l *LoggerObj) Println(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Println()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Println(wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Fatal(line string, args ...Object) Object {
+func (l *LoggerObj) Fatal(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Fatal()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Fatal(wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Fatalf(line string, args ...Object) Object {
+func (l *LoggerObj) Fatalf(line string, scope *Scope, args ...Object) Object {
 	if len(args) < 1 {
 		return NewError(line, ARGUMENTERROR, ">0", len(args))
 	}
@@ -142,42 +142,42 @@ func (l *LoggerObj) Fatalf(line string, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Fatalf(format.String, wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Fatalln(line string, args ...Object) Object {
+func (l *LoggerObj) Fatalln(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Fatalln()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Fatalln(wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Panic(line string, args ...Object) Object {
+func (l *LoggerObj) Panic(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Panic()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Panic(wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Panicf(line string, args ...Object) Object {
+func (l *LoggerObj) Panicf(line string, scope *Scope, args ...Object) Object {
 	if len(args) < 1 {
 		return NewError(line, ARGUMENTERROR, ">0", len(args))
 	}
@@ -190,21 +190,21 @@ func (l *LoggerObj) Panicf(line string, args ...Object) Object {
 	subArgs := args[1:]
 	wrapped := make([]interface{}, len(subArgs))
 	for i, v := range subArgs {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Panicf(format.String, wrapped...)
 	return NIL
 }
 
-func (l *LoggerObj) Panicln(line string, args ...Object) Object {
+func (l *LoggerObj) Panicln(line string, scope *Scope, args ...Object) Object {
 	if len(args) == 0 {
 		l.Logger.Panicln()
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: scope.interp.replColor()}
 	}
 
 	l.Logger.Panicln(wrapped...)
@@ -0,0 +1,465 @@
+package eval
+
+import (
+	"fmt"
+	"magpie/ast"
+	"magpie/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Macros are expanded after a program is parsed, before it runs:
+//
+//	macro unless(cond, body) {
+//	    quote(if !(unquote(cond)) { unquote(body) })
+//	}
+//	unless(x > 10, println("small"))   // if !(x > 10) { println("small") }
+//
+// A macro is called with the AST nodes of its arguments(as quotes), and the
+// node of the quote it returns replaces the call. 'quote { ... }' quotes
+// statements, a call to a macro returning them must be a statement. The
+// expressions unquoted in a quote are evaluated when the quote is, they're
+// quotes, or values which are converted to literals(numbers, strings,
+// booleans, nil and arrays of them).
+//
+// The macros are hygienic: the names declared in a quote(by 'let', the
+// parameters of a function, the variables of a loop or a catch clause) are
+// renamed, so they don't clash with the caller's names. The functions and
+// the classes declared in a quote are visible to the caller.
+
+const (
+	MACRO_OBJ = "MACRO"
+	QUOTE_OBJ = "QUOTE"
+)
+
+// the maximum number of macro calls expanded in a program, a macro
+// expanding to a call of itself would never end
+const maxMacroExpansions = 10000
+
+type Macro struct {
+	Name       string
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Scope      *Scope
+}
+
+func (m *Macro) Inspect() string {
+	var params []string
+	for _, p := range m.Parameters {
+		params = append(params, p.Value)
+	}
+	return fmt.Sprintf("macro %s(%s)", m.Name, strings.Join(params, ", "))
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	return NewError(line, NOMETHODERROR, method, m.Type())
+}
+
+// Quote is an AST node, the value of 'quote(...)'.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Inspect() string {
+	if _, ok := q.Node.(*ast.BlockStatement); ok {
+		return "quote { " + q.Node.String() + " }"
+	}
+	return "quote(" + q.Node.String() + ")"
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "string":
+		if len(args) != 0 {
+			return NewError(line, ARGUMENTERROR, "0", len(args))
+		}
+		return NewString(q.Node.String())
+	}
+	return NewError(line, NOMETHODERROR, method, q.Type())
+}
+
+// ExpandMacros defines the macros of a program in 'scope', and replaces the
+// calls of the macros of 'scope' with their expansions. The definitions are
+// removed from the program. It returns an error object if an expansion
+// fails, otherwise nil.
+func ExpandMacros(program *ast.Program, scope *Scope) Object {
+	var stmts []ast.Statement
+	for _, stmt := range program.Statements {
+		if m, ok := stmt.(*ast.MacroStatement); ok {
+			scope.Set(m.Name.Value, &Macro{Name: m.Name.Value, Parameters: m.Parameters, Body: m.Body, Scope: scope})
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	if len(stmts) == len(program.Statements) && !scope.hasMacros() {
+		return nil
+	}
+	program.Statements = stmts
+
+	e := &expander{scope: scope}
+	ast.Rewrite(program, e.expand)
+	return e.err
+}
+
+// hasMacros reports whether a macro is defined in the scope or its parents.
+func (s *Scope) hasMacros() bool {
+	for ; s != nil; s = s.parentScope {
+		s.RLock()
+		for _, v := range s.store {
+			if _, ok := v.(*Macro); ok {
+				s.RUnlock()
+				return true
+			}
+		}
+		s.RUnlock()
+	}
+	return false
+}
+
+type expander struct {
+	scope *Scope
+	count int
+	err   Object
+}
+
+func (e *expander) expand(node ast.Node) (ast.Node, bool) {
+	if e.err != nil {
+		return node, false
+	}
+
+	switch n := node.(type) {
+	case *ast.QuoteExpression: //expanded when the quote is evaluated
+		return node, false
+	case *ast.ExpressionStatement:
+		ret := e.expandCall(n.Expression)
+		if ret == nil {
+			return node, false
+		}
+		if block, ok := ret.(*ast.BlockStatement); ok { //a statement expands to statements
+			return block, true
+		}
+		n.Expression = ret.(ast.Expression)
+	case *ast.CallExpression:
+		ret := e.expandCall(n)
+		if ret == nil {
+			return node, false
+		}
+		if _, ok := ret.(*ast.BlockStatement); ok {
+			e.err = NewError(n.Pos().Sline(), MACROERROR, fmt.Sprintf("macro '%s' expands to statements, it can't be used as an expression", n.Function))
+			return node, false
+		}
+		return ret, true
+	}
+	return node, true
+}
+
+// expandCall returns the expansion of 'expr' if it's a macro call, the
+// expansions which are macro calls are expanded too. It returns 'expr' if
+// it's not a macro call, or nil if the expansion fails.
+func (e *expander) expandCall(expr ast.Expression) ast.Node {
+	var ret ast.Node = expr
+	for {
+		call, m := e.macroCall(ret)
+		if m == nil {
+			return ret
+		}
+		if ret = e.call(call, m); ret == nil {
+			return nil
+		}
+	}
+}
+
+// macroCall returns the macro called by 'node', if it's a macro call.
+func (e *expander) macroCall(node ast.Node) (*ast.CallExpression, *Macro) {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return nil, nil
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, nil
+	}
+	if v, ok := e.scope.Get(ident.Value); ok {
+		if m, ok := v.(*Macro); ok {
+			return call, m
+		}
+	}
+	return nil, nil
+}
+
+// call runs the macro 'm' with the arguments of 'call', and returns the node
+// of the quote it returns. It returns nil if it fails.
+func (e *expander) call(call *ast.CallExpression, m *Macro) ast.Node {
+	line := call.Pos().Sline()
+	if e.count++; e.count > maxMacroExpansions {
+		e.err = NewError(line, MACROERROR, fmt.Sprintf("too many expansions of macro '%s', is it recursive?", m.Name))
+		return nil
+	}
+	if len(call.Arguments) != len(m.Parameters) {
+		e.err = NewError(line, MACROERROR, fmt.Sprintf("macro '%s' expects %d argument(s), got %d", m.Name, len(m.Parameters), len(call.Arguments)))
+		return nil
+	}
+
+	scope := NewScope(m.Scope, nil)
+	for i, p := range m.Parameters {
+		scope.Set(p.Value, &Quote{Node: call.Arguments[i]})
+	}
+
+	result := Eval(m.Body, scope)
+	if ret, ok := result.(*ReturnValue); ok {
+		result = ret.Value
+	}
+	if result != nil && result.Type() == ERROR_OBJ {
+		e.err = result
+		return nil
+	}
+	q, ok := result.(*Quote)
+	if !ok {
+		typ := ObjectType(NIL_OBJ)
+		if result != nil {
+			typ = result.Type()
+		}
+		e.err = NewError(line, MACROERROR, fmt.Sprintf("macro '%s' should return a quote, got %s", m.Name, typ))
+		return nil
+	}
+	return q.Node
+}
+
+// the suffix of the names renamed by the quotes, e.g. 'tmp#12'
+var gensym int64
+
+// quote(expression), quote { block-statements }
+func evalQuoteExpression(q *ast.QuoteExpression, scope *Scope) Object {
+	node := ast.Copy(q.Node)
+
+	suffix := fmt.Sprintf("#%d", atomic.AddInt64(&gensym, 1))
+	renames := make(map[string]string)
+	for _, name := range quoteBinders(node) {
+		renames[name] = name + suffix
+	}
+
+	var errObj Object
+	var f func(ast.Node) (ast.Node, bool)
+	rewrite := func(e ast.Expression) ast.Expression {
+		return ast.Rewrite(e, f).(ast.Expression)
+	}
+	f = func(n ast.Node) (ast.Node, bool) {
+		if errObj != nil {
+			return n, false
+		}
+
+		switch n := n.(type) {
+		case *ast.QuoteExpression: //a quote in a quote is evaluated on its own
+			return n, false
+		case *ast.ExpressionStatement:
+			if u, ok := n.Expression.(*ast.UnquoteExpression); ok {
+				ret, err := unquote(u, scope)
+				if err != nil {
+					errObj = err
+					return n, false
+				}
+				if block, ok := ret.(*ast.BlockStatement); ok {
+					return block, false
+				}
+				n.Expression = ret.(ast.Expression)
+				return n, false
+			}
+		case *ast.UnquoteExpression:
+			ret, err := unquote(n, scope)
+			if err != nil {
+				errObj = err
+				return n, false
+			}
+			if _, ok := ret.(*ast.BlockStatement); ok {
+				errObj = NewError(n.Pos().Sline(), MACROERROR, "statements can only be unquoted as a statement")
+				return n, false
+			}
+			return ret, false
+		case *ast.Identifier:
+			if name, ok := renames[n.Value]; ok {
+				return &ast.Identifier{Token: n.Token, Value: name}, false
+			}
+		case *ast.MethodCallExpression: //the names of the members aren't renamed
+			n.Object = rewrite(n.Object)
+			switch call := n.Call.(type) {
+			case *ast.Identifier:
+			case *ast.CallExpression:
+				for i, arg := range call.Arguments {
+					call.Arguments[i] = rewrite(arg)
+				}
+			default:
+				n.Call = rewrite(n.Call)
+			}
+			return n, false
+		case *ast.FunctionLiteral: //the parameters' defaults and types are keyed by their names
+			n.Values = renameKeys(n.Values, renames).(map[string]ast.Expression)
+			n.ParamTypes = renameKeys(n.ParamTypes, renames).(map[string]*ast.TypeAnnotation)
+		default:
+			renameVars(n, renames)
+		}
+		return n, true
+	}
+
+	node = ast.Rewrite(node, f)
+	if errObj != nil {
+		return errObj
+	}
+	return &Quote{Node: node}
+}
+
+// unquote evaluates the expression of 'unquote(...)', and returns its node.
+func unquote(u *ast.UnquoteExpression, scope *Scope) (ast.Node, Object) {
+	val := Eval(u.Expression, scope)
+	if val.Type() == ERROR_OBJ {
+		return nil, val
+	}
+	return objectToNode(u.Token, val)
+}
+
+// objectToNode returns the node of an unquoted value, the literal of the
+// value if it's not a quote.
+func objectToNode(tok token.Token, val Object) (ast.Node, Object) {
+	switch v := val.(type) {
+	case *Quote:
+		return ast.Copy(v.Node), nil
+	case *Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(v.Int64, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: v.Int64}, nil
+	case *UInteger:
+		tok.Type, tok.Literal = token.UINT, strconv.FormatUint(v.UInt64, 10)+"u"
+		return &ast.UIntegerLiteral{Token: tok, Value: v.UInt64}, nil
+	case *Float:
+		tok.Type, tok.Literal = token.FLOAT, strconv.FormatFloat(v.Float64, 'g', -1, 64)
+		return &ast.FloatLiteral{Token: tok, Value: v.Float64}, nil
+	case *String:
+		tok.Type, tok.Literal = token.STRING, v.String
+		return &ast.StringLiteral{Token: tok, Value: v.String}, nil
+	case *Boolean:
+		tok.Type, tok.Literal = token.TRUE, "true"
+		if !v.Bool {
+			tok.Type, tok.Literal = token.FALSE, "false"
+		}
+		return &ast.Boolean{Token: tok, Value: v.Bool}, nil
+	case *Nil:
+		tok.Type, tok.Literal = token.NIL, "nil"
+		return &ast.NilLiteral{Token: tok}, nil
+	case *Array:
+		arr := &ast.ArrayLiteral{Token: token.Token{Pos: tok.Pos, Type: token.LBRACKET, Literal: "["}}
+		for _, m := range v.Members {
+			node, err := objectToNode(tok, m)
+			if err != nil {
+				return nil, err
+			}
+			expr, ok := node.(ast.Expression)
+			if !ok {
+				return nil, NewError(tok.Pos.Sline(), MACROERROR, "statements can't be an element of an array")
+			}
+			arr.Members = append(arr.Members, expr)
+		}
+		return arr, nil
+	}
+	return nil, NewError(tok.Pos.Sline(), MACROERROR, fmt.Sprintf("cannot unquote a value of type %s", val.Type()))
+}
+
+// quoteBinders returns the names declared in a quote, outside of unquote.
+func quoteBinders(node ast.Node) []string {
+	var names []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnquoteExpression, *ast.QuoteExpression:
+			return false
+		case *ast.LetStatement:
+			if !n.InClass { //the members of a class
+				for _, name := range n.Names {
+					names = append(names, name.Value)
+				}
+			}
+		case *ast.FunctionLiteral:
+			for _, p := range n.Parameters {
+				names = append(names, p.String())
+			}
+		default:
+			for _, f := range varFields(n) {
+				if *f != "" && *f != "_" {
+					names = append(names, *f)
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// varFields returns the fields of a node which are the names of the
+// variables it declares, e.g. the variable of a 'for x in arr' loop.
+func varFields(node ast.Node) []*string {
+	switch n := node.(type) {
+	case *ast.ForEachArrayLoop:
+		return []*string{&n.Var}
+	case *ast.ForEachDotRange:
+		return []*string{&n.Var}
+	case *ast.ForEachMapLoop:
+		return []*string{&n.Key, &n.Value}
+	case *ast.CatchClause:
+		return []*string{&n.Var}
+	case *ast.GrepExpr:
+		return []*string{&n.Var}
+	case *ast.MapExpr:
+		return []*string{&n.Var}
+	case *ast.ListComprehension:
+		return []*string{&n.Var}
+	case *ast.ListRangeComprehension:
+		return []*string{&n.Var}
+	case *ast.ListMapComprehension:
+		return []*string{&n.Key, &n.Value}
+	case *ast.HashComprehension:
+		return []*string{&n.Var}
+	case *ast.HashRangeComprehension:
+		return []*string{&n.Var}
+	case *ast.HashMapComprehension:
+		return []*string{&n.Key, &n.Value}
+	case *ast.FromExpr:
+		return []*string{&n.Var}
+	case *ast.JoinExpr:
+		return []*string{&n.JoinVar}
+	case *ast.QueryContinuationExpr:
+		return []*string{&n.Var}
+	}
+	return nil
+}
+
+// renameVars renames the variables declared by a node.
+func renameVars(node ast.Node, renames map[string]string) {
+	for _, f := range varFields(node) {
+		if to, ok := renames[*f]; ok {
+			*f = to
+		}
+	}
+}
+
+// renameKeys returns a copy of a map keyed by names, with the keys renamed.
+func renameKeys(m interface{}, renames map[string]string) interface{} {
+	val := reflect.ValueOf(m)
+	if val.IsNil() {
+		return m
+	}
+	ret := reflect.MakeMapWithSize(val.Type(), val.Len())
+	iter := val.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		if to, ok := renames[key]; ok {
+			key = to
+		}
+		ret.SetMapIndex(reflect.ValueOf(key), iter.Value())
+	}
+	return ret.Interface()
+}
+
+// a macro which isn't at the top level of a program isn't defined
+func evalMacroStatement(m *ast.MacroStatement, scope *Scope) Object {
+	return NewError(m.Pos().Sline(), MACROERROR, fmt.Sprintf("macro '%s' must be defined at the top level", m.Name.Value))
+}
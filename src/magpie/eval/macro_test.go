@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const macroInput = `
+macro ifNot(cond, body) {
+    quote(if !(unquote(cond)) { unquote(body) })
+}
+
+macro swap(a, b) {
+    quote {
+        let tmp = unquote(a)
+        unquote(a) = unquote(b)
+        unquote(b) = tmp
+    }
+}
+
+macro repeat(n, body) {
+    quote {
+        for i in 1..unquote(n) { unquote(body) }
+    }
+}
+
+macro square(e) { quote(unquote(e) * unquote(e)) }
+
+macro source(e) {
+    let s = e.string()
+    quote(unquote(s))
+}
+
+macro twice(e) { quote(square(unquote(e)) * 2) }
+`
+
+func TestMacros(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`let r = "big"; ifNot(1 > 2, r = "small"); r`, "small"},
+		{`square(1 + 2)`, "9"},
+		{`source(a + b * 2)`, "(a + (b * 2))"},
+		{`twice(3)`, "18"},
+		{`macro answer() { let n = 6 * 7; quote(unquote(n)) }; answer()`, "42"},
+		{`macro list() { quote(unquote([1, "a", true, nil])) }; list()`, `[1, "a", true, nil]`},
+		//the names declared in a quote don't clash with the caller's
+		{`let tmp = 1; let y = 2; swap(tmp, y); [tmp, y]`, "[2, 1]"},
+		{`let i = "outer"; let r = []; repeat(2, r.push(i)); r`, `["outer", "outer"]`},
+		//the functions declared in a quote are visible
+		{`macro helper() { quote { fn helped() { "helped" } } }; helper(); helped()`, "helped"},
+		//a quote is a value
+		{`quote(a + 1)`, "quote((a + 1))"},
+		{`let q = quote { x = 1 }; type(q)`, "QUOTE"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run(macroInput + tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`square(1, 2)`, "macro 'square' expects 1 argument(s), got 2"},
+		{`macro bad() { 1 }; bad()`, "macro 'bad' should return a quote, got INTEGER"},
+		{`macro loop() { quote(loop()) }; loop()`, "too many expansions of macro 'loop'"},
+		{`let x = swap(a, b)`, "macro 'swap' expands to statements"},
+		{`macro f() { quote(unquote(fn() { 1 })) }; f()`, "cannot unquote a value of type FUNCTION"},
+		{`fn f() { macro g() { quote(1) } }; f()`, "macro 'g' must be defined at the top level"},
+		{`let f = square; f(1)`, "macro 'square' can't be called at runtime"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(macroInput + tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestMacrosAcrossRuns(t *testing.T) {
+	//the macros are kept in the scope, e.g. for the next lines of the REPL
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(macroInput); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := interp.Run(`square(4)`)
+	if err != nil || result.Inspect() != "16" {
+		t.Errorf("expected 16, got=%v %v", result, err)
+	}
+}
@@ -0,0 +1,20 @@
+package eval
+
+//go:generate go run gen_methods.go
+
+// BuiltinMethods returns the names of the methods of the builtin objects of
+// type 't', e.g. 'upper' for STRING_OBJ. It's used by tools like the language
+// server for completion. The names are generated from the 'CallMethod'
+// implementations, so 'go generate' must be run after a method is added.
+func BuiltinMethods(t ObjectType) []string {
+	return builtinMethods[t]
+}
+
+// BuiltinMethodTypes returns the object types which have builtin methods.
+func BuiltinMethodTypes() []ObjectType {
+	types := make([]ObjectType, 0, len(builtinMethods))
+	for t := range builtinMethods {
+		types = append(types, t)
+	}
+	return types
+}
@@ -60,10 +60,10 @@ const (
 	FILE_OBJ         = "FILE"
 	REGEX_OBJ        = "REGEX"
 	CHANNEL_OBJ      = "CHANNEL"
+	GENERATOR_OBJ    = "GENERATOR"
 	NIL_OBJ          = "NIL_OBJ"
 	GO_OBJ           = "GO_OBJ"
 	GFO_OBJ          = "GFO_OBJ"
-	THROW_OBJ        = "THROW"
 )
 
 type Object interface {
@@ -82,11 +82,6 @@ type Iterable interface {
 	iter() bool
 }
 
-//Whether the Object is throwable (STRING for now)
-type Throwable interface {
-	throw()
-}
-
 //Whether the Object is the target of IO writer
 type Writable interface {
 	IOWriter() io.Writer
@@ -271,17 +266,6 @@ func (rv *ReturnValue) CallMethod(line string, scope *Scope, method string, args
 	return NewError(line, NOMETHODERROR, method, rv.Type())
 }
 
-type Throw struct {
-	stmt  *ast.ThrowStmt
-	value Object
-}
-
-func (t *Throw) Inspect() string  { return t.value.Inspect() }
-func (t *Throw) Type() ObjectType { return THROW_OBJ }
-func (t *Throw) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
-	return NewError(line, NOMETHODERROR, method, t.Type())
-}
-
 //return a Nil object with error message 's'
 func NewNil(s string) *Nil {
 	return &Nil{OptionalMsg: s}
@@ -1231,6 +1215,10 @@ func init() {
 	NewDecimalObj()
 	NewUnicodeObj()
 	NewOptionalObj()
+	NewTestingObj()
+	NewHttpTestObj()
+	NewRpcObj()
+	NewAsyncObj()
 }
 
 func marshalJsonObject(obj interface{}) (bytes.Buffer, error) {
@@ -1289,6 +1277,13 @@ func marshalJsonObject(obj interface{}) (bytes.Buffer, error) {
 			return bytes.Buffer{}, err
 		}
 		out.WriteString(string(res))
+	case *Nil:
+		value := obj.(*Nil)
+		res, err := value.MarshalJSON()
+		if err != nil {
+			return bytes.Buffer{}, err
+		}
+		out.WriteString(string(res))
 	default:
 		return bytes.Buffer{}, errors.New("json error: maybe unsupported type or invalid data")
 	}
@@ -1401,7 +1396,8 @@ func hashObj2RawValue(h *Hash) interface{} {
 //`fmt` package's `Formatter` interface.
 //When we implement this interface, our `Object` could be directed passed to fmt.Printf(xxx)
 type Formatter struct {
-	Obj Object
+	Obj   Object
+	Color bool //REPL with color support
 }
 
 func (ft *Formatter) Format(s fmt.State, verb rune) {
@@ -1465,52 +1461,52 @@ func (ft *Formatter) Format(s fmt.State, verb rune) {
 
 	switch obj := ft.Obj.(type) {
 	case *Boolean:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["BOOL"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Bool)
 	case *Nil:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["BOOL"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Inspect())
 	case *Integer:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["NUMBER"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Int64)
 	case *UInteger:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["NUMBER"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.UInt64)
 	case *Float:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["NUMBER"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Float64)
 	case *String:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["STRING"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.String)
 	case *Array:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["ARRAY"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Inspect())
 	case *Hash:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["HASH"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Inspect())
 	case *Tuple:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["TUPLE"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Inspect())
 	case *DecimalObj:
-		if REPLColor {
+		if ft.Color {
 			formatStr = "\033[1;" + colorMap["NUMBER"] + "m" + formatStr + reset
 		}
 		fmt.Fprintf(s, formatStr, obj.Inspect())
@@ -1526,23 +1522,19 @@ the golang will return "a= 10", not "a=10", this is not what we expected.
 the solution is take from:
 	https://stackoverflow.com/questions/25928991/go-print-without-space-between-items
 */
-func correctPrintResult(needNewLine bool, args ...Object) (string, []interface{}) {
-	l := len(args)
-	if s, isOk := formatMap[l]; !isOk {
-		for i := 0; i < l; i++ {
-			s += "%v"
-		}
-		formatMap[l] = s
+func correctPrintResult(color bool, needNewLine bool, args ...Object) (string, []interface{}) {
+	//Note: 'formatMap' is not updated here, because it's shared by all the interpreters(goroutines).
+	s, isOk := formatMap[len(args)]
+	if !isOk {
+		s = strings.Repeat("%v", len(args))
 	}
-
-	s := formatMap[l]
 	if needNewLine {
 		s = s + "\n"
 	}
 
 	wrapped := make([]interface{}, len(args))
 	for i, v := range args {
-		wrapped[i] = &Formatter{Obj: v}
+		wrapped[i] = &Formatter{Obj: v, Color: color}
 	}
 
 	return s, wrapped
@@ -197,7 +197,7 @@ func (o *Optional) OrElseThrow(line string, args ...Object) Object {
 	}
 
 	//just like 'evalThrowStatement's return.
-	return &Error{Kind: THROWNOTHANDLED, Message: exceptStr.String}
+	return &Error{Kind: THROWNOTHANDLED, Message: exceptStr.String, Thrown: exceptStr}
 }
 
 // If a value is present, performs the given action with the value,
@@ -0,0 +1,324 @@
+package eval
+
+import (
+	"fmt"
+	"magpie/ast"
+	"strings"
+)
+
+// The patterns of the 'case' arms:
+//
+//	case value in {
+//	    [first, *rest]               { ... }  // arrays, '*rest' binds the remaining members
+//	    (x, y)                       { ... }  // tuples
+//	    {Name: n, "calories": c}     { ... }  // hashes, structs and instances having the keys
+//	    Point(x, y)                  { ... }  // instances of a class, by the order of its members
+//	    n: int if n > 10             { ... }  // types, and guards
+//	    "a" | "b", 1..5              { ... }  // alternatives and ranges
+//	    _                            { ... }  // anything
+//	}
+//
+// At the top level of an arm, a name is a value to compare with. In the
+// structural patterns, the names are bound to the matched values, and they
+// are visible in the guard and the block of the arm.
+
+// the type names of the type patterns, besides the class names
+var patternTypes = map[string]func(Object) bool{
+	"any":    func(Object) bool { return true },
+	"int":    func(o Object) bool { return o.Type() == INTEGER_OBJ },
+	"uint":   func(o Object) bool { return o.Type() == UINTEGER_OBJ },
+	"float":  func(o Object) bool { return o.Type() == FLOAT_OBJ },
+	"number": func(o Object) bool { _, ok := toFloat64(o); return ok },
+	"string": func(o Object) bool { return o.Type() == STRING_OBJ },
+	"bool":   func(o Object) bool { return o.Type() == BOOLEAN_OBJ },
+	"array":  func(o Object) bool { return o.Type() == ARRAY_OBJ },
+	"hash":   func(o Object) bool { return o.Type() == HASH_OBJ },
+	"tuple":  func(o Object) bool { return o.Type() == TUPLE_OBJ },
+	"struct": func(o Object) bool { return o.Type() == STRUCT_OBJ },
+	"nil":    func(o Object) bool { return o.Type() == NIL_OBJ },
+	"fn": func(o Object) bool {
+		_, isFn := o.(*Function)
+		_, isBuiltin := o.(*Builtin)
+		return isFn || isBuiltin
+	},
+}
+
+// matchPattern reports whether 'val' matches the pattern of a 'case' arm, the
+// names bound by the pattern are put in 'binds'. An expression which isn't a
+// pattern is a value, compared by 'equal'(so the strings of 'case in' are
+// regular expressions). The values in the structural patterns are compared
+// exactly.
+func matchPattern(pattern ast.Expression, val Object, scope *Scope, isWholeMatch bool, binds map[string]Object) (bool, Object) {
+	line := pattern.Pos().Sline()
+
+	switch pt := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true, nil
+
+	case *ast.BindPattern:
+		return bindPattern(pt.Name.Value, val, binds), nil
+
+	case *ast.TypePattern:
+		ok, errObj := matchType(line, pt.TypeName.Value, val, scope, PATTERNERROR)
+		if !ok || errObj != nil {
+			return false, errObj
+		}
+		if pt.Name != nil {
+			return bindPattern(pt.Name.Value, val, binds), nil
+		}
+		return true, nil
+
+	case *ast.AltPattern:
+		for _, alt := range pt.Alternatives {
+			altBinds := make(map[string]Object)
+			ok, errObj := matchPattern(alt, val, scope, isWholeMatch, altBinds)
+			if errObj != nil {
+				return false, errObj
+			}
+			if ok {
+				for name, v := range altBinds {
+					if !bindPattern(name, v, binds) {
+						return false, nil
+					}
+				}
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case *ast.RangePattern:
+		low := Eval(pt.Low, scope)
+		if low.Type() == ERROR_OBJ {
+			return false, low
+		}
+		high := Eval(pt.High, scope)
+		if high.Type() == ERROR_OBJ {
+			return false, high
+		}
+		return inPatternRange(val, low, high), nil
+
+	case *ast.SequencePattern:
+		return matchSequence(pt, val, scope, binds)
+
+	case *ast.HashPattern:
+		for i, k := range pt.Keys {
+			key := Eval(k, scope)
+			if key.Type() == ERROR_OBJ {
+				return false, key
+			}
+			v, ok := patternField(val, key)
+			if !ok {
+				return false, nil
+			}
+			if ok, errObj := matchPattern(pt.Values[i], v, scope, true, binds); !ok || errObj != nil {
+				return false, errObj
+			}
+		}
+		return true, nil
+
+	case *ast.ClassPattern:
+		return matchClass(pt, val, scope, isWholeMatch, binds)
+	}
+
+	//a value
+	matchRv := Eval(pattern, NewScope(scope, nil))
+	if matchRv.Type() == ERROR_OBJ {
+		return false, matchRv
+	}
+	return equal(isWholeMatch, val, matchRv), nil
+}
+
+// bindPattern binds 'name' to 'val', a name bound twice must have equal values,
+// e.g. '[x, x]' matches the arrays of two equal members.
+func bindPattern(name string, val Object, binds map[string]Object) bool {
+	if old, ok := binds[name]; ok {
+		return equal(true, old, val)
+	}
+	binds[name] = val
+	return true
+}
+
+// matchType reports whether 'val' is of the type 'name', which is one of
+// 'patternTypes' or a class. An unknown type is an error of the 'kind'.
+func matchType(line string, name string, val Object, scope *Scope, kind int) (bool, Object) {
+	if is, ok := patternTypes[name]; ok {
+		return is(val), nil
+	}
+
+	obj, ok := scope.Get(name)
+	if !ok {
+		return false, NewError(line, kind, fmt.Sprintf("unknown type '%s'", name))
+	}
+	cls, ok := obj.(*Class)
+	if !ok {
+		return false, NewError(line, kind, fmt.Sprintf("'%s' is not a type", name))
+	}
+	return isInstanceOf(val, cls), nil
+}
+
+func isInstanceOf(val Object, cls *Class) bool {
+	instance, ok := val.(*ObjectInstance)
+	if !ok {
+		return false
+	}
+	for c := instance.Class; c != nil; c = c.Parent {
+		if c == cls {
+			return true
+		}
+	}
+	return false
+}
+
+// inPatternRange reports whether low <= val <= high, for the numbers and the
+// strings.
+func inPatternRange(val, low, high Object) bool {
+	if v, ok := toFloat64(val); ok {
+		l, lok := toFloat64(low)
+		h, hok := toFloat64(high)
+		return lok && hok && l <= v && v <= h
+	}
+	if v, ok := val.(*String); ok {
+		l, lok := low.(*String)
+		h, hok := high.(*String)
+		return lok && hok && l.String <= v.String && v.String <= h.String
+	}
+	return false
+}
+
+// matchSequence matches the arrays('[...]') or the tuples('(...)'), a
+// '*rest' matches the remaining members.
+func matchSequence(pt *ast.SequencePattern, val Object, scope *Scope, binds map[string]Object) (bool, Object) {
+	var members []Object
+	switch v := val.(type) {
+	case *Array:
+		if pt.IsTuple {
+			return false, nil
+		}
+		members = v.Members
+	case *Tuple:
+		if !pt.IsTuple {
+			return false, nil
+		}
+		members = v.Members
+	default:
+		return false, nil
+	}
+
+	n := len(pt.Elements)
+	if (pt.Rest < 0 && len(members) != n) || len(members) < n {
+		return false, nil
+	}
+	for i, e := range pt.Elements {
+		m := members[i]
+		if pt.Rest >= 0 && i >= pt.Rest { //the elements after '*rest' match the last members
+			m = members[len(members)-(n-i)]
+		}
+		if ok, errObj := matchPattern(e, m, scope, true, binds); !ok || errObj != nil {
+			return false, errObj
+		}
+	}
+
+	if pt.Rest >= 0 && pt.RestName != nil {
+		rest := make([]Object, len(members)-n)
+		copy(rest, members[pt.Rest:pt.Rest+len(rest)])
+		var restObj Object = &Array{Members: rest}
+		if pt.IsTuple {
+			restObj = &Tuple{Members: rest}
+		}
+		return bindPattern(pt.RestName.Value, restObj, binds), nil
+	}
+	return true, nil
+}
+
+// patternField returns the value of 'key' in a hash, or the field named
+// 'key' of a struct or an instance.
+func patternField(val Object, key Object) (Object, bool) {
+	switch v := val.(type) {
+	case *Hash:
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return nil, false
+		}
+		pair, ok := v.Pairs[hashable.HashKey()]
+		if !ok {
+			return nil, false
+		}
+		return pair.Value, true
+	case *Struct:
+		name, ok := key.(*String)
+		if !ok {
+			return nil, false
+		}
+		return v.Scope.Get(name.String)
+	case *ObjectInstance:
+		name, ok := key.(*String)
+		if !ok {
+			return nil, false
+		}
+		for _, field := range classFields(v.Class) {
+			if field == name.String {
+				return v.Scope.Get(field)
+			}
+		}
+	}
+	return nil, false
+}
+
+// classFields returns the names of the non-static members of the class and
+// its parents, the parents' members first.
+func classFields(cls *Class) []string {
+	var fields []string
+	if cls.Parent != nil {
+		fields = classFields(cls.Parent)
+	}
+	for _, member := range cls.Members {
+		if member.StaticFlag {
+			continue
+		}
+		for _, name := range member.Names {
+			fields = append(fields, name.Value)
+		}
+	}
+	return fields
+}
+
+// matchClass matches the instances of the class by the order of its members.
+// If the name isn't a class, the pattern is a call, whose result is compared
+// with the value as before the patterns.
+func matchClass(pt *ast.ClassPattern, val Object, scope *Scope, isWholeMatch bool, binds map[string]Object) (bool, Object) {
+	line := pt.Pos().Sline()
+
+	obj, _ := scope.Get(pt.Class.Value)
+	cls, ok := obj.(*Class)
+	if !ok {
+		call := &ast.CallExpression{Token: pt.Token, Function: pt.Class}
+		for _, arg := range pt.Args {
+			switch a := arg.(type) {
+			case *ast.BindPattern:
+				call.Arguments = append(call.Arguments, a.Name)
+			case *ast.WildcardPattern, *ast.TypePattern, *ast.SequencePattern, *ast.HashPattern, *ast.ClassPattern, *ast.AltPattern, *ast.RangePattern:
+				return false, NewError(line, PATTERNERROR, fmt.Sprintf("'%s' is not a class", pt.Class.Value))
+			default:
+				call.Arguments = append(call.Arguments, a)
+			}
+		}
+		return matchPattern(call, val, scope, isWholeMatch, binds)
+	}
+
+	if !isInstanceOf(val, cls) {
+		return false, nil
+	}
+	fields := classFields(cls)
+	if len(pt.Args) > len(fields) {
+		return false, NewError(line, PATTERNERROR, fmt.Sprintf("class '%s' has %d member(s) [%s], got %d pattern(s)",
+			cls.Name, len(fields), strings.Join(fields, ", "), len(pt.Args)))
+	}
+	instance := val.(*ObjectInstance)
+	for i, arg := range pt.Args {
+		v, _ := instance.Scope.Get(fields[i])
+		if ok, errObj := matchPattern(arg, v, scope, true, binds); !ok || errObj != nil {
+			return false, errObj
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const patternInput = `
+class Point {
+    let x = 0
+    let y = 0
+    fn init(x, y) { this.x = x; this.y = y }
+}
+
+class Point3 : Point {
+    let z = 0
+    fn init(x, y, z) { parent.init(x, y); this.z = z }
+}
+
+fn describe(v) {
+    return case v in {
+        []                              { "empty" }
+        [first, *rest] if len(rest) > 1 { "long " + first + " " + len(rest) }
+        [*_, [x, y]]                    { "ends with pair " + x + y }
+        [a, a]                          { "twins " + a }
+        [a, b]                          { "pair " + a + " " + b }
+        (a, *rest, 0)                   { "tuple ends with 0 " + type(rest) + len(rest) }
+        (a, b)                          { "tuple " + a + b }
+        {Name: n, Calories: c: int}     { "food " + n + " " + c }
+        {"kind": "circle", r}           { "circle " + r }
+        Point3(x, y, z)                 { "point3 " + x + y + z }
+        Point(0, y)                     { "y axis " + y }
+        Point(x, y)                     { "point " + x + y }
+        n: int if n > 100               { "big " + n }
+        1..10                           { "small" }
+        "red" | "green" | "blue"        { "color" }
+        s: string                       { "string " + s }
+        _: float | _: nil               { "float or nil" }
+        _                               { "other" }
+    }
+}
+`
+
+func TestCasePatterns(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`describe([])`, "empty"},
+		{`describe([1, 2, 3])`, "long 1 2"},
+		{`describe([7, 7])`, "twins 7"},
+		{`describe([1, 2])`, "pair 1 2"},
+		{`describe([1, [3, 4]])`, "ends with pair 34"},
+		{`describe((1, 2, 3, 0))`, "tuple ends with 0 TUPLE2"},
+		{`describe((1, 0))`, "tuple ends with 0 TUPLE0"},
+		{`describe((1, 2))`, "tuple 12"},
+		{`describe({"Name": "apple", "Calories": 52, "Color": "red"})`, "food apple 52"},
+		{`describe({"Name": "apple", "Calories": "52"})`, "other"},
+		{`describe({"kind": "circle", "r": 2})`, "circle 2"},
+		{`describe(new Point(0, 5))`, "y axis 5"},
+		{`describe(new Point(3, 4))`, "point 34"},
+		{`describe(new Point3(1, 2, 3))`, "point3 123"},
+		{`describe(500)`, "big 500"},
+		{`describe(5)`, "small"},
+		{`describe(50)`, "other"},
+		{`describe("green")`, "color"},
+		{`describe("teal")`, "string teal"},
+		{`describe(1.5)`, "small"},
+		{`describe(11.5)`, "float or nil"},
+		{`describe(nil)`, "float or nil"},
+		{`describe(true)`, "other"},
+
+		//the instances could be matched as hashes too
+		{`case new Point(1, 2) in { {y: 2, x} { x } }`, "1"},
+		//the bindings are only visible in the arm
+		{`let n = "outer"; case [1] in { [n] { n } }; n`, "outer"},
+		//a name at the top level is a value
+		{`let x = [1, 2]; case [1, 2] in { x { "x" } else { "else" } }`, "x"},
+		{`let x = 3; case 2 in { x { "x" } else { "else" } }`, "else"},
+		//'case in' compares the strings partially, the patterns exactly
+		{`case "abc" in { "b" { "partial" } }`, "partial"},
+		{`case ["abc"] in { ["b"] { "partial" } else { "exact" } }`, "exact"},
+		{`case "abc" is { "b" { "partial" } else { "exact" } }`, "exact"},
+		//the guard is checked after the match
+		{`case [5] in { [n] if n > 10 { "big" } [n] if n > 1 { "medium " + n } }`, "medium 5"},
+		//a call which isn't a class is a value
+		{`fn two() { 2 }; case 2 in { two() { "two" } }`, "two"},
+		{`let k = "key"; case {"key": 1} in { {k: v} { v } else { "none" } }`, "none"},
+		{`case [1, 2, 3] in { [_, *mid, _] { mid } }`, "[2]"},
+		{`case [1] in { [_, *mid, _] { mid } else { "short" } }`, "short"},
+		{`case (1,) in { (x,) { x } }`, "1"},
+		{`case "m" in { "a".."z" { "letter" } }`, "letter"},
+		{`case describe in { f: fn { "fn" } }`, "fn"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run(patternInput + tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestCasePatternErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`case 1 in { x: Missing { 1 } }`, "unknown type 'Missing'"},
+		{`let notType = 1; case 1 in { x: notType { 1 } }`, "'notType' is not a type"},
+		{`case new Point(1, 2) in { Point(a, b, c) { 1 } }`, "class 'Point' has 2 member(s) [x, y], got 3 pattern(s)"},
+		{`case 1 in { describe([a, b]) { 1 } }`, "'describe' is not a class"},
+		{`case [1] in { [n] if n.nothing() { 1 } }`, "nothing"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(patternInput + tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	//the pattern errors are TypeErrors
+	interp := NewInterpreter(&bytes.Buffer{})
+	result, err := interp.Run("let r = \"\"\ntry { case 1 in { x: Missing { 1 } } } catch (e: TypeError) { r = \"caught\" }\nr")
+	if err != nil || result.Inspect() != "caught" {
+		t.Errorf("expected the TypeError to be caught, got=%v %v", result, err)
+	}
+}
@@ -0,0 +1,806 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"magpie/ast"
+	"magpie/parser"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	RPC_OBJ       = "RPC_OBJ"
+	RPCSERVER_OBJ = "RPCSERVER_OBJ"
+	RPCCLIENT_OBJ = "RPCCLIENT_OBJ"
+	rpc_name      = "rpc"
+)
+
+// the maximum size of a received message
+const maxRpcMessage = 32 << 20
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000 //an object thrown by the method
+)
+
+var rpcErrorMessages = map[int]string{
+	rpcParseError:     "Parse error",
+	rpcInvalidRequest: "Invalid Request",
+	rpcMethodNotFound: "Method not found",
+	rpcInvalidParams:  "Invalid params",
+	rpcInternalError:  "Internal error",
+}
+
+// rpcErrorCodes maps the kinds of the runtime errors of a method to the
+// JSON-RPC error codes, the other kinds are '-32603 Internal error'.
+var rpcErrorCodes = map[int]int{
+	ARGUMENTERROR:     rpcInvalidParams,
+	PARAMTYPEERROR:    rpcInvalidParams,
+	INPUTERROR:        rpcInvalidParams,
+	INVALIDARG:        rpcInvalidParams,
+	INLENERR:          rpcInvalidParams,
+	NULLABLEERROR:     rpcInvalidParams,
+	FUNCCALLBACKERROR: rpcInvalidParams,
+	JSONERROR:         rpcInternalError,
+}
+
+// RPCERROR_CLASS is the exception of the errors returned by the remote
+// methods, its 'code' is the JSON-RPC error code and 'data' is the error's
+// data, e.g. {"type": "DivideByZeroError"}.
+var RPCERROR_CLASS = &Class{
+	Name:   "RpcError",
+	Parent: EXCEPTION_CLASS,
+	Members: []*ast.LetStatement{
+		exceptionMember("code", &ast.IntegerLiteral{}),
+		exceptionMember("data", &ast.NilLiteral{}),
+	},
+}
+
+func initRpcErrorClass() bool {
+	RPCERROR_CLASS.Methods = map[string]ClassMethod{
+		"getCode": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				return exceptionField(line, self, "code", args...)
+			},
+		},
+		"getData": &BuiltinMethod{
+			Fn: func(line string, self *ObjectInstance, scope *Scope, args ...Object) Object {
+				return exceptionField(line, self, "data", args...)
+			},
+		},
+	}
+	BuiltinClasses[RPCERROR_CLASS.Name] = RPCERROR_CLASS
+	parser.BuiltinClasses[RPCERROR_CLASS.Name] = true
+	return true
+}
+
+var _ = initRpcErrorClass()
+
+// Rpc is the 'rpc' module, JSON-RPC 2.0 over HTTP or TCP. The server side
+// publishes the public methods of an object:
+//
+//	class Calc {
+//	    public fn add(a, b) { return a + b }
+//	}
+//
+//	let server = rpc.newServer(new Calc())
+//	let srv = server.newHttpServer("127.0.0.1:8080")   // POST http://127.0.0.1:8080/
+//	srv.start()
+//	server.serveTCP(listenTCP("tcp", "127.0.0.1:9000"))  // blocks until the listener is closed
+//
+// The client side is a proxy, its method calls are the remote calls:
+//
+//	let calc = rpc.dial("http://127.0.0.1:8080/")      // or "tcp://127.0.0.1:9000"
+//	println(calc.add(1, 2))
+//	calc.notify("add", 1, 2)                            // no response
+//
+// The errors of the remote methods are thrown as 'RpcError' exceptions.
+type Rpc struct{}
+
+func NewRpcObj() Object {
+	ret := &Rpc{}
+	SetGlobalObj(rpc_name, ret)
+	return ret
+}
+
+func (r *Rpc) Inspect() string  { return "<" + rpc_name + ">" }
+func (r *Rpc) Type() ObjectType { return RPC_OBJ }
+
+func (r *Rpc) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "newServer":
+		return r.NewServer(line, args...)
+	case "dial":
+		return r.Dial(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, r.Type())
+}
+
+// newServer(obj[, name]): publishes the public methods of 'obj', named
+// 'name.method' if 'name' is given. The methods without a modifier are private,
+// so it's an error if 'obj' has no 'public' method.
+func (r *Rpc) NewServer(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	instance, ok := args[0].(*ObjectInstance)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "newServer", "*ObjectInstance", args[0].Type())
+	}
+	prefix := ""
+	if len(args) == 2 {
+		name, ok := args[1].(*String)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "second", "newServer", "*String", args[1].Type())
+		}
+		prefix = name.String + "."
+	}
+
+	s := &RpcServer{instance: instance, methods: make(map[string]*Function)}
+	for cls := instance.Class; cls != nil; cls = cls.Parent {
+		for name, m := range cls.Methods {
+			fn, ok := m.(*Function)
+			if !ok || name == "init" || fn.Literal.StaticFlag || fn.Literal.ModifierLevel != ast.ModifierPublic {
+				continue
+			}
+			if _, ok := s.methods[prefix+name]; !ok { //the overriding method
+				s.methods[prefix+name] = fn
+			}
+		}
+	}
+	if len(s.methods) == 0 {
+		return NewError(line, GENERICERROR, fmt.Sprintf("rpc: class '%s' has no public method to publish", instance.Class.Name))
+	}
+	return s
+}
+
+// dial(url[, options]): the url is 'http://...', 'https://...' or
+// 'tcp://host:port', the options of the http(s) clients are the options of
+// 'http.newClient'.
+func (r *Rpc) Dial(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	url, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "dial", "*String", args[0].Type())
+	}
+	var opts *Hash
+	if len(args) == 2 {
+		if opts, ok = args[1].(*Hash); !ok {
+			return NewError(line, PARAMTYPEERROR, "second", "dial", "*Hash", args[1].Type())
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(url.String, "http://"), strings.HasPrefix(url.String, "https://"):
+		client, err := newClient(opts)
+		if err != nil {
+			return NewError(line, GENERICERROR, err.Error())
+		}
+		return &RpcClient{url: url.String, client: client}
+	case strings.HasPrefix(url.String, "tcp://"):
+		if opts != nil {
+			return NewError(line, GENERICERROR, "rpc: the options are only for the http(s) urls")
+		}
+		conn, err := net.Dial("tcp", strings.TrimPrefix(url.String, "tcp://"))
+		if err != nil {
+			return NewNil(err.Error())
+		}
+		return &RpcClient{conn: conn, dec: json.NewDecoder(conn)}
+	}
+	return NewError(line, GENERICERROR, fmt.Sprintf("rpc: unsupported url %q, expect http://, https:// or tcp://", url.String))
+}
+
+// rpcError is the error object of a response.
+type rpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func newRpcError(code int, data interface{}) *rpcError {
+	e := &rpcError{Code: code, Message: rpcErrorMessages[code]}
+	if data != nil {
+		e.Data, _ = json.Marshal(data)
+	}
+	return e
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// RpcServer is a JSON-RPC server of an object's public methods.
+type RpcServer struct {
+	instance *ObjectInstance
+	methods  map[string]*Function
+}
+
+func (s *RpcServer) Inspect() string  { return "<rpcserver>" }
+func (s *RpcServer) Type() ObjectType { return RPCSERVER_OBJ }
+
+func (s *RpcServer) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "methods":
+		return s.Methods(line, args...)
+	case "serveHTTP":
+		return s.ServeRequest(line, args...)
+	case "newHttpServer":
+		return s.NewHttpServer(line, args...)
+	case "serveTCP":
+		return s.ServeTCP(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, s.Type())
+}
+
+// methods(): the sorted names of the published methods.
+func (s *RpcServer) Methods(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	arr := &Array{}
+	for _, name := range names {
+		arr.Members = append(arr.Members, NewString(name))
+	}
+	return arr
+}
+
+// serveHTTP(writer, request): answers a JSON-RPC request in a route function,
+//
+//	@route(url="/rpc", methods=["POST"])
+//	fn calc(writer, request) { server.serveHTTP(writer, request) }
+func (s *RpcServer) ServeRequest(line string, args ...Object) Object {
+	if len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "2", len(args))
+	}
+
+	w, ok := args[0].(*HttpResponseWriter)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "serveHTTP", "*HttpResponseWriter", args[0].Type())
+	}
+	r, ok := args[1].(*HttpRequest)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "second", "serveHTTP", "*HttpRequest", args[1].Type())
+	}
+	s.ServeHTTP(w.Writer, r.Request)
+	return NIL
+}
+
+// newHttpServer(addr[, options]): a http server(like 'http.newServer') which
+// answers the JSON-RPC requests posted to any path.
+func (s *RpcServer) NewHttpServer(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+
+	addr, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "newHttpServer", "*String", args[0].Type())
+	}
+	srv := &http.Server{Addr: addr.String, Handler: s}
+
+	var o *serverOptions
+	if len(args) == 2 {
+		opts, ok := args[1].(*Hash)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "second", "newHttpServer", "*Hash", args[1].Type())
+		}
+		var err error
+		if o, err = newServerOptions(opts, false); err != nil {
+			return NewError(line, GENERICERROR, err.Error())
+		}
+		o.apply(srv)
+	}
+	return &HttpServer{Server: srv, keepAlives: true, options: o}
+}
+
+// serveTCP(listener): serves the connections of a listener of 'listenTCP',
+// the messages are separated by newlines. It blocks until the listener is
+// closed.
+func (s *RpcServer) ServeTCP(line string, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	l, ok := args[0].(*TCPListenerObject)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "serveTCP", "*TCPListenerObject", args[0].Type())
+	}
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return NIL
+			}
+			return NewFalseObj(err.Error())
+		}
+		go s.serveConn(line, conn)
+	}
+}
+
+func (s *RpcServer) serveConn(line string, conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(io.LimitReader(conn, maxRpcMessage))
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) { //the stream can't be read any further
+				resp, _ := json.Marshal(&rpcResponse{Version: "2.0", Error: newRpcError(rpcParseError, nil), ID: rpcNullID})
+				conn.Write(append(resp, '\n'))
+			}
+			return
+		}
+		dec = json.NewDecoder(io.MultiReader(dec.Buffered(), io.LimitReader(conn, maxRpcMessage)))
+
+		if resp := s.handleMessage(line, msg); resp != nil {
+			if _, err := conn.Write(append(resp, '\n')); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeHTTP answers the JSON-RPC requests posted to the server.
+func (s *RpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRpcMessage))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	resp := s.handleMessage("", msg)
+	if resp == nil { //notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// handleMessage answers a request or a batch of requests, it returns nil if
+// there's nothing to answer, i.e. the notifications.
+func (s *RpcServer) handleMessage(line string, msg []byte) []byte {
+	msg = bytes.TrimSpace(msg)
+	if !json.Valid(msg) {
+		resp, _ := json.Marshal(&rpcResponse{Version: "2.0", Error: newRpcError(rpcParseError, nil), ID: rpcNullID})
+		return resp
+	}
+
+	if msg[0] != '[' {
+		resp := s.handleRequest(line, msg)
+		if resp == nil {
+			return nil
+		}
+		ret, _ := json.Marshal(resp)
+		return ret
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(msg, &batch)
+	if len(batch) == 0 {
+		resp, _ := json.Marshal(&rpcResponse{Version: "2.0", Error: newRpcError(rpcInvalidRequest, nil), ID: rpcNullID})
+		return resp
+	}
+	responses := []*rpcResponse{}
+	for _, req := range batch {
+		if resp := s.handleRequest(line, req); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	ret, _ := json.Marshal(responses)
+	return ret
+}
+
+// handleRequest calls the method of a request, it returns nil for a valid
+// notification(a request without 'id').
+func (s *RpcServer) handleRequest(line string, req json.RawMessage) *rpcResponse {
+	invalid := &rpcResponse{Version: "2.0", Error: newRpcError(rpcInvalidRequest, nil), ID: rpcNullID}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(req, &fields); err != nil {
+		return invalid
+	}
+	id, hasID := fields["id"]
+	if hasID {
+		switch id[0] {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': //string, number or null
+			invalid.ID = id
+		default:
+			return invalid
+		}
+	}
+	var version, method string
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" {
+		return invalid
+	}
+	if json.Unmarshal(fields["method"], &method) != nil {
+		return invalid
+	}
+	params, hasParams := fields["params"]
+	if hasParams && params[0] != '[' && params[0] != '{' {
+		return invalid
+	}
+
+	result, rerr := s.call(line, method, params)
+	if !hasID {
+		return nil
+	}
+	resp := &rpcResponse{Version: "2.0", ID: id}
+	if rerr != nil {
+		resp.Error = rerr
+	} else if res, err := marshalJsonObject(result); err != nil {
+		resp.Error = newRpcError(rpcInternalError, map[string]string{"type": "JsonError", "message": err.Error()})
+	} else {
+		resp.Result = res.Bytes()
+	}
+	return resp
+}
+
+// call calls the method with the positional(array) or named(object) params.
+func (s *RpcServer) call(line string, method string, params json.RawMessage) (Object, *rpcError) {
+	fn, ok := s.methods[method]
+	if !ok {
+		return nil, newRpcError(rpcMethodNotFound, nil)
+	}
+
+	var args []Object
+	if len(params) > 0 {
+		val, err := decodeRpcValue(params)
+		if err != nil {
+			return nil, newRpcError(rpcInvalidParams, map[string]string{"message": err.Error()})
+		}
+		if args, err = rpcArguments(fn, val); err != nil {
+			return nil, newRpcError(rpcInvalidParams, map[string]string{"message": err.Error()})
+		}
+	}
+
+	scope := NewScope(s.instance.Scope, nil)
+	result := evalFunctionDirect(fn, args, s.instance, scope, nil)
+	if err, ok := result.(*Error); ok {
+		return nil, rpcErrorOf(err)
+	}
+	return result, nil
+}
+
+// rpcArguments returns the arguments of 'fn' of the params, the missing
+// params must have default values.
+func rpcArguments(fn *Function, params Object) ([]Object, error) {
+	names := make([]string, len(fn.Literal.Parameters))
+	for i, p := range fn.Literal.Parameters {
+		names[i] = p.String()
+	}
+	hasDefault := func(name string) bool {
+		_, ok := fn.Literal.Values[name]
+		return ok
+	}
+
+	var args []Object
+	switch p := params.(type) {
+	case *Array:
+		args = p.Members
+		if !fn.Variadic && len(args) > len(names) {
+			return nil, fmt.Errorf("expect at most %d params, got %d", len(names), len(args))
+		}
+	case *Hash:
+		for i, name := range names {
+			pair, ok := p.Pairs[NewString(name).HashKey()]
+			if ok {
+				args = append(args, pair.Value)
+				continue
+			}
+			if !hasDefault(name) {
+				break
+			}
+			val, _ := fn.Scope.Get(name) //the default value is in the function's scope
+			args = append(args, val)
+			if i == len(names)-1 {
+				args = args[:len(args)-1] //the trailing default values are not passed
+			}
+		}
+		for _, hk := range p.Order {
+			name := p.Pairs[hk].Key.(*String).String
+			found := false
+			for _, n := range names {
+				found = found || n == name
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown param '%s'", name)
+			}
+		}
+	}
+
+	for i := len(args); i < len(names); i++ {
+		if fn.Variadic && i == len(names)-1 {
+			break
+		}
+		if !hasDefault(names[i]) {
+			return nil, fmt.Errorf("missing param '%s'", names[i])
+		}
+	}
+	return args, nil
+}
+
+// rpcErrorOf returns the response error of a method's error.
+func rpcErrorOf(err *Error) *rpcError {
+	if err.Thrown != nil {
+		e := &rpcError{Code: rpcServerError, Message: exceptionString(err.Thrown)}
+		if s, ok := err.Thrown.(*String); ok {
+			e.Message = s.String
+		}
+		if exception, ok := asException(err.Thrown); ok {
+			e.Data, _ = json.Marshal(map[string]string{"type": exception.Class.Name})
+		}
+		return e
+	}
+
+	code, ok := rpcErrorCodes[err.Kind]
+	if !ok {
+		code = rpcInternalError
+	}
+	typ := RUNTIMEERROR_CLASS.Name
+	if cls, ok := errorClasses[err.Kind]; ok {
+		typ = cls.Name
+	}
+	e := newRpcError(code, map[string]string{"type": typ})
+	e.Message = err.message()
+	return e
+}
+
+// decodeRpcValue decodes the json, the integers are decoded as integers.
+func decodeRpcValue(b []byte) (Object, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return rpcValue(v), nil
+}
+
+func rpcValue(v interface{}) Object {
+	switch v := v.(type) {
+	case []interface{}:
+		arr := &Array{}
+		for _, item := range v {
+			arr.Members = append(arr.Members, rpcValue(item))
+		}
+		return arr
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		hash := NewHash()
+		for _, k := range keys {
+			hash.Push("", NewString(k), rpcValue(v[k]))
+		}
+		return hash
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return NewInteger(i)
+		}
+		f, _ := v.Float64()
+		return NewFloat(f)
+	case string:
+		return NewString(v)
+	case bool:
+		return nativeBoolToBooleanObject(v)
+	}
+	return NIL
+}
+
+// RpcClient is the proxy of a JSON-RPC server, its methods other than
+// 'call', 'notify' and 'close' are remote calls.
+type RpcClient struct {
+	url    string      //the http(s) endpoint
+	client *HttpClient //the http client
+
+	conn net.Conn //the tcp connection
+	dec  *json.Decoder
+
+	mux    sync.Mutex
+	nextID int64
+}
+
+func (c *RpcClient) Inspect() string  { return "<rpcclient>" }
+func (c *RpcClient) Type() ObjectType { return RPCCLIENT_OBJ }
+
+func (c *RpcClient) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "call":
+		return c.Call(line, scope, args...)
+	case "notify":
+		return c.Notify(line, args...)
+	case "close":
+		return c.Close(line, args...)
+	default:
+		return c.invoke(line, scope, method, args)
+	}
+}
+
+// call(method, args...): calls a method whose name isn't an identifier,
+// e.g. 'calc.call("math.add", 1, 2)', or is one of the client's methods.
+func (c *RpcClient) Call(line string, scope *Scope, args ...Object) Object {
+	if len(args) < 1 {
+		return NewError(line, ARGUMENTERROR, ">=1", len(args))
+	}
+
+	method, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "call", "*String", args[0].Type())
+	}
+	return c.invoke(line, scope, method.String, args[1:])
+}
+
+// notify(method, args...): calls the method without waiting for a result.
+func (c *RpcClient) Notify(line string, args ...Object) Object {
+	if len(args) < 1 {
+		return NewError(line, ARGUMENTERROR, ">=1", len(args))
+	}
+
+	method, ok := args[0].(*String)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "notify", "*String", args[0].Type())
+	}
+	msg, errObj := rpcRequestMessage(line, method.String, args[1:], nil)
+	if errObj != nil {
+		return errObj
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, err := c.send(msg, false); err != nil {
+		return NewFalseObj(err.Error())
+	}
+	return TRUE
+}
+
+func (c *RpcClient) Close(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	if c.conn != nil {
+		if err := c.conn.Close(); err != nil {
+			return NewFalseObj(err.Error())
+		}
+	}
+	return TRUE
+}
+
+// invoke calls the remote method, the errors of the method are thrown as
+// 'RpcError' exceptions, and the failures of the connection return nil.
+func (c *RpcClient) invoke(line string, scope *Scope, method string, args []Object) Object {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+	msg, errObj := rpcRequestMessage(line, method, args, id)
+	if errObj != nil {
+		return errObj
+	}
+	reply, err := c.send(msg, true)
+	if err != nil {
+		return NewNil(err.Error())
+	}
+
+	var resp rpcResponse
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return NewNil("rpc: invalid response: " + err.Error())
+	}
+	if resp.Error != nil {
+		return rpcException(line, scope, resp.Error)
+	}
+	if string(resp.ID) != string(id) {
+		return NewNil(fmt.Sprintf("rpc: unexpected response id %s, expect %s", resp.ID, id))
+	}
+	result, err := decodeRpcValue(resp.Result)
+	if err != nil {
+		return NewNil("rpc: invalid result: " + err.Error())
+	}
+	return result
+}
+
+// send sends the message, and returns the response if 'reply'.
+func (c *RpcClient) send(msg []byte, reply bool) ([]byte, error) {
+	if c.conn != nil {
+		if _, err := c.conn.Write(append(msg, '\n')); err != nil || !reply {
+			return nil, err
+		}
+		var resp json.RawMessage
+		err := c.dec.Decode(&resp)
+		return resp, err
+	}
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, _, err := c.client.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRpcMessage))
+	if err != nil {
+		return nil, err
+	}
+	if reply && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc: %s", resp.Status)
+	}
+	return body, nil
+}
+
+// rpcRequestMessage returns the json of a request, it's a notification if
+// 'id' is nil.
+func rpcRequestMessage(line string, method string, args []Object, id json.RawMessage) ([]byte, Object) {
+	params, err := marshalJsonObject(&Array{Members: args})
+	if err != nil {
+		return nil, NewError(line, GENERICERROR, "rpc: "+err.Error())
+	}
+	req := struct {
+		Version string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id,omitempty"`
+	}{"2.0", method, params.Bytes(), id}
+	msg, err := json.Marshal(req)
+	if err != nil {
+		return nil, NewError(line, GENERICERROR, "rpc: "+err.Error())
+	}
+	return msg, nil
+}
+
+// rpcException returns the error which throws the 'RpcError' of 'e'.
+func rpcException(line string, scope *Scope, e *rpcError) Object {
+	exception := newException(RPCERROR_CLASS, NewString(e.Message), nil, scope)
+	exception.Scope.Reset("code", NewInteger(int64(e.Code)))
+	if len(e.Data) > 0 {
+		if data, err := decodeRpcValue(e.Data); err == nil {
+			exception.Scope.Reset("data", data)
+		}
+	}
+
+	err := NewError(line, THROWNOTHANDLED, exceptionString(exception)).(*Error)
+	err.Thrown = exception
+	return err
+}
@@ -0,0 +1,264 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rpcCalcInput = `
+class Base {
+    public fn echo(x) { return x }
+}
+
+class Calc : Base {
+    let count = 0
+
+    public fn add(a, b) { return a + b }
+    public fn div(a, b) { return a / b }
+    public fn greet(name, greeting = "hello") { return greeting + " " + name }
+    public fn sum(nums...) { return len(nums) }
+    public fn incr() { this.count = this.count + 1 }
+    public fn fail() { throw new IOError("bad value") }
+    public fn half(x) { return x / 2.0 }
+    fn hidden() { return 1 }
+    private fn secret() { return 2 }
+}
+
+let calc = new Calc()
+let server = rpc.newServer(calc)
+`
+
+// rpcInterp runs 'input' after the definitions of 'rpcCalcInput', and returns
+// the interpreter and the server.
+func rpcInterp(t *testing.T, input string) (*Interpreter, *RpcServer) {
+	t.Helper()
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(rpcCalcInput + input); err != nil {
+		t.Fatal(err)
+	}
+	server, _ := interp.Get("server")
+	return interp, server.(*RpcServer)
+}
+
+func TestRpcServerRequests(t *testing.T) {
+	_, server := rpcInterp(t, "")
+
+	tests := []struct{ request, expected string }{
+		{`{"jsonrpc": "2.0", "method": "add", "params": [1, 2], "id": 1}`, `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "params": {"b": 2, "a": "1"}, "id": "a"}`, `{"jsonrpc":"2.0","result":"12","id":"a"}`},
+		{`{"jsonrpc": "2.0", "method": "echo", "params": [{"b": [1.5, null, true]}], "id": null}`, `{"jsonrpc":"2.0","result":{"b":[1.5,null,true]},"id":null}`},
+		{`{"jsonrpc": "2.0", "method": "greet", "params": ["bob"], "id": 2}`, `{"jsonrpc":"2.0","result":"hello bob","id":2}`},
+		{`{"jsonrpc": "2.0", "method": "greet", "params": {"name": "bob", "greeting": "hi"}, "id": 3}`, `{"jsonrpc":"2.0","result":"hi bob","id":3}`},
+		{`{"jsonrpc": "2.0", "method": "sum", "params": [1, 2, 3], "id": 4}`, `{"jsonrpc":"2.0","result":3,"id":4}`},
+		{`{"jsonrpc": "2.0", "method": "half", "params": [3], "id": 5}`, `{"jsonrpc":"2.0","result":1.5,"id":5}`},
+		{`{"jsonrpc": "2.0", "method": "incr", "id": 6}`, `{"jsonrpc":"2.0","result":1,"id":6}`},
+
+		//notifications
+		{`{"jsonrpc": "2.0", "method": "incr"}`, ``},
+		{`{"jsonrpc": "2.0", "method": "none"}`, ``},
+
+		//errors
+		{`{"jsonrpc": "2.0", "method": "add", "params": [1, 2]`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc": "1.0", "method": "add", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": 1, "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "params": 1, "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "id": {}}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`1`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`{"jsonrpc": "2.0", "method": "none", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "hidden", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "secret", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "init", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "params": [1, 2, 3], "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":{"message":"expect at most 2 params, got 3"}},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "params": [1], "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":{"message":"missing param 'b'"}},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "c": 2}, "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":{"message":"unknown param 'c'"}},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "div", "params": [1, 0], "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"divide by zero","data":{"type":"DivideByZeroError"}},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "fail", "id": 1}`, `{"jsonrpc":"2.0","error":{"code":-32000,"message":"IOError: bad value","data":{"type":"IOError"}},"id":1}`},
+
+		//batches
+		{`[{"jsonrpc": "2.0", "method": "add", "params": [1, 2], "id": 1}, {"jsonrpc": "2.0", "method": "incr"}, 1, {"jsonrpc": "2.0", "method": "none", "id": 2}]`,
+			`[{"jsonrpc":"2.0","result":3,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":2}]`},
+		{`[{"jsonrpc": "2.0", "method": "incr"}, {"jsonrpc": "2.0", "method": "incr"}]`, ``},
+	}
+	for _, tt := range tests {
+		got := string(server.handleMessage("1", []byte(tt.request)))
+		if got != tt.expected {
+			t.Errorf("%s:\nexpected %s\n     got=%s", tt.request, tt.expected, got)
+		}
+	}
+}
+
+func TestRpcServerHTTP(t *testing.T) {
+	interp, _ := rpcInterp(t, `
+service Api on "127.0.0.1:0" {
+    @route(url="/rpc", methods=["POST"])
+    fn calc(writer, request) {
+        server.serveHTTP(writer, request)
+    }
+}
+`)
+	defer interp.Run("Api.stop()")
+	svc, _ := interp.Get("Api")
+	handler := svc.(*ServiceObj).Handler()
+
+	post := func(h http.Handler, method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/rpc", strings.NewReader(body)))
+		return w
+	}
+
+	w := post(handler, "POST", `{"jsonrpc": "2.0", "method": "add", "params": [1, 2], "id": 1}`)
+	if w.Code != 200 || w.Body.String() != `{"jsonrpc":"2.0","result":3,"id":1}` {
+		t.Errorf("expected the result, got=%d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+	if w := post(handler, "POST", `{"jsonrpc": "2.0", "method": "incr"}`); w.Code != 204 || w.Body.Len() != 0 {
+		t.Errorf("expected 204 for a notification, got=%d %q", w.Code, w.Body.String())
+	}
+
+	server, _ := interp.Get("server")
+	if w := post(server.(*RpcServer), "GET", ""); w.Code != 405 || w.Header().Get("Allow") != "POST" {
+		t.Errorf("expected 405, got=%d %v", w.Code, w.Header())
+	}
+}
+
+func TestRpcClient(t *testing.T) {
+	interp, server := rpcInterp(t, `
+let srv = server.newHttpServer("127.0.0.1:0")
+srv.start()
+`)
+	defer interp.Run("srv.stop()")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan Object)
+	go func() {
+		done <- server.ServeTCP("1", &TCPListenerObject{Listener: listener.(*net.TCPListener), Address: listener.Addr().String()})
+	}()
+	defer func() {
+		listener.Close()
+		if result := <-done; result != NIL {
+			t.Errorf("expected serveTCP to return nil, got=%s", result.Inspect())
+		}
+	}()
+
+	input := fmt.Sprintf(`
+let clients = [rpc.dial("http://" + srv.addr() + "/"), rpc.dial("tcp://%s")]
+let results = []
+for client in clients {
+    results.push(client.add(1, 2))
+    results.push(client.greet("bob"))
+    results.push(client.echo({"a": [1, 2.5, nil]}))
+    results.push(client.call("sum", 1, 2, 3))
+    results.push(client.notify("incr"))
+    try {
+        client.div(1, 0)
+    } catch e {
+        results.push(e.code)
+        results.push(e.data)
+        results.push(e.message)
+    }
+    try {
+        client.fail()
+    } catch (e: RpcError) {
+        results.push(e.getCode())
+        results.push(e.getMessage())
+    }
+    try {
+        client.none()
+    } catch e {
+        results.push(e.code)
+    }
+}
+clients[1].close()
+results
+`, listener.Addr().String())
+	result, err := interp.Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := `3, "hello bob", {"a" : [1, 2.5, nil]}, 3, true, -32603, {"type" : "DivideByZeroError"}, "divide by zero", -32000, "IOError: bad value", -32601`
+	expected := "[" + one + ", " + one + "]"
+	if result.Inspect() != expected {
+		t.Errorf("expected %s,\ngot=%s", expected, result.Inspect())
+	}
+
+	//the transport errors are nil
+	result, err = interp.Run(`let c = rpc.dial("http://127.0.0.1:1/"); c.add(1, 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type() != NIL_OBJ {
+		t.Errorf("expected nil, got=%s", result.Inspect())
+	}
+}
+
+func TestRpcServerTCPMessages(t *testing.T) {
+	_, server := rpcInterp(t, "")
+	client, conn := net.Pipe()
+	go server.serveConn("1", conn)
+	defer client.Close()
+
+	go client.Write([]byte(`{"jsonrpc": "2.0", "method": "add", "params": [1, 2], "id": 1}
+{"jsonrpc": "2.0", "method": "incr"} [{"jsonrpc": "2.0", "method": "echo", "params": ["x"], "id": 2}]
+{"jsonrpc": "2.0", "method"`))
+	dec := json.NewDecoder(client)
+	for _, expected := range []string{
+		`{"jsonrpc":"2.0","result":3,"id":1}`,
+		`[{"jsonrpc":"2.0","result":"x","id":2}]`,
+	} {
+		var resp json.RawMessage
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != expected {
+			t.Errorf("expected %s, got=%s", expected, resp)
+		}
+	}
+
+	//the connection is closed after a parse error
+	client.Write([]byte("}\n"))
+	rest, _ := ioutil.ReadAll(dec.Buffered())
+	more, _ := ioutil.ReadAll(client)
+	if got := strings.TrimSpace(string(rest) + string(more)); !strings.Contains(got, `"code":-32700`) {
+		t.Errorf("expected a parse error, got=%q", got)
+	}
+}
+
+func TestRpcErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`rpc.newServer(1)`, "*ObjectInstance"},
+		{`class A {}; rpc.newServer(new A(), 1)`, "*String"},
+		{`class A { fn f() { 1 } }; rpc.newServer(new A())`, "class 'A' has no public method to publish"},
+		{`rpc.dial("ftp://localhost")`, `unsupported url "ftp://localhost"`},
+		{`rpc.dial("tcp://localhost:1", {"timeout": 1})`, "only for the http(s) urls"},
+		{`rpc.dial("http://localhost", {"proxy": "x"})`, "unknown option 'proxy'"},
+		{`rpc.dial("http://localhost").call(1)`, "*String"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	//the published names with a prefix
+	interp, _ := rpcInterp(t, `rpc.newServer(calc, "calc").methods()`)
+	result, _ := interp.Run(`rpc.newServer(calc, "calc").methods()`)
+	expected := `["calc.add", "calc.div", "calc.echo", "calc.fail", "calc.greet", "calc.half", "calc.incr", "calc.sum"]`
+	if result.Inspect() != expected {
+		t.Errorf("expected %s, got=%s", expected, result.Inspect())
+	}
+}
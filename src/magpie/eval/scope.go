@@ -25,20 +25,38 @@ func NewScope(p *Scope, w io.Writer) *Scope {
 	} else {
 		ret.Writer = p.Writer
 		ret.CallStack = p.CallStack
+		ret.interp = p.interp
 	}
 
 	return ret
 }
 
+//newTopScope creates a scope without parent which belongs to the same
+//interpreter as 's', e.g. the scope of an imported module.
+func newTopScope(s *Scope) *Scope {
+	ret := NewScope(nil, s.Writer)
+	ret.interp = s.interp
+	return ret
+}
+
 //CallStack is a stack for CallFrame
 type CallStack struct {
 	Frames []CallFrame
+	limits *execLimits //nil if the program has no limits
+	gen    *genState   //the generator running on this call stack, or nil
+	task   *Task       //the task running on this call stack, or nil
 }
 
 type CallFrame struct {
 	FuncScope   *Scope
 	CurrentCall *ast.CallExpression // currently calling function
 	defers      []func()            // function's defers
+	name        string              // function's name, used in stack traces
+}
+
+// Name returns the name of the frame's function, as shown in stack traces.
+func (frame *CallFrame) Name() string {
+	return frame.name
 }
 
 func (frame *CallFrame) runDefers(s *Scope) {
@@ -55,6 +73,7 @@ type Scope struct {
 	parentScope *Scope
 	Writer      io.Writer
 	CallStack   *CallStack
+	interp      *Interpreter //nil means the default(package level) interpreter
 
 	//We need to use `Mutex`, because we added 'spawn'(multithread).
 	//if not，when running `spawn`, there will be lot of errors, even core dump.
@@ -87,6 +106,24 @@ func (s *Scope) GetKeys() []string {
 	return keys
 }
 
+// Vars returns a copy of the variables defined in the scope itself,
+// without the variables of its parent scopes.
+func (s *Scope) Vars() map[string]Object {
+	s.RLock()
+	defer s.RUnlock()
+
+	vars := make(map[string]Object, len(s.store))
+	for k, v := range s.store {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Parent returns the parent scope, or nil for a top level scope.
+func (s *Scope) Parent() *Scope {
+	return s.parentScope
+}
+
 func (s *Scope) DebugPrint(indent string) {
 	s.Lock()
 	defer s.Unlock()
@@ -0,0 +1,124 @@
+package eval
+
+import (
+	"fmt"
+	"magpie/ast"
+	"reflect"
+	"time"
+)
+
+// evalSelectStatement waits for the first of the cases which could proceed,
+// like Go's 'select'. The channels, the sent values and the seconds of
+// 'after' are evaluated once, in the order of the cases. A receiving case
+// assigns the received value(and 'ok', which is false for a closed channel)
+// like the assignment 'v, ok = ...', then its statements run in the scope of
+// the 'select'. 'break' and 'continue' in the statements apply to the
+// enclosing loop.
+//
+// The wait is interrupted by the cancellation of the current task, or when
+// the program goes over its limits.
+func evalSelectStatement(s *ast.SelectStmt, scope *Scope) Object {
+	line := s.Pos().Sline()
+
+	cases := make([]reflect.SelectCase, 0, len(s.Cases)+2)
+	for _, sc := range s.Cases {
+		c, errObj := selectCase(sc, scope)
+		if errObj != nil {
+			return errObj
+		}
+		cases = append(cases, c)
+	}
+
+	cancelIdx := -1
+	cancelled, stopped := waitInterrupts(scope)
+	if cancelled != nil {
+		cancelIdx = len(cases)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(cancelled)})
+	}
+	if stopped != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stopped)})
+	}
+
+	chosen, recv, recvOK, errObj := doSelect(line, cases)
+	if errObj != nil {
+		return errObj
+	}
+
+	if chosen >= len(s.Cases) { //interrupted
+		if chosen == cancelIdx {
+			return NewError(line, TASKCANCELLED, scope.CallStack.task.name)
+		}
+		return scope.CallStack.limits.stopError(line)
+	}
+
+	sc := s.Cases[chosen]
+	if sc.Kind == ast.SelectRecv {
+		var value Object = NIL
+		if recvOK {
+			value = recv.Interface().(Object)
+		}
+		values := []Object{value, NewBooleanObj(recvOK)}
+		for i, target := range sc.Targets {
+			assign := &ast.AssignExpression{Token: sc.Token, Name: target}
+			assign.Token.Literal = "="
+			if r := evalAssignValue(assign, values[i], scope); r.Type() == ERROR_OBJ {
+				return r
+			}
+		}
+	}
+
+	return evalBlockStatements(sc.Body.Statements, scope)
+}
+
+// selectCase evaluates the channel and the value of a 'select' case.
+func selectCase(sc *ast.SelectCase, scope *Scope) (reflect.SelectCase, Object) {
+	line := sc.Pos().Sline()
+
+	switch sc.Kind {
+	case ast.SelectDefault:
+		return reflect.SelectCase{Dir: reflect.SelectDefault}, nil
+
+	case ast.SelectTimeout:
+		val := Eval(sc.Value, scope)
+		if val.Type() == ERROR_OBJ {
+			return reflect.SelectCase{}, val
+		}
+		secs, ok := toFloat64(val)
+		if !ok {
+			return reflect.SelectCase{}, NewError(line, PARAMTYPEERROR, "first", "after", "*Integer|*Float", val.Type())
+		}
+		after := time.After(time.Duration(secs * float64(time.Second)))
+		return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(after)}, nil
+	}
+
+	val := Eval(sc.Chan, scope)
+	if val.Type() == ERROR_OBJ {
+		return reflect.SelectCase{}, val
+	}
+	c, ok := val.(*ChanObject)
+	if !ok {
+		return reflect.SelectCase{}, NewError(line, CHANNELERROR, fmt.Sprintf("'%s' is not a channel, got %s", sc.Chan.String(), val.Type()))
+	}
+
+	if sc.Kind == ast.SelectRecv {
+		return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)}, nil
+	}
+
+	value := Eval(sc.Value, scope)
+	if value.Type() == ERROR_OBJ {
+		return reflect.SelectCase{}, value
+	}
+	return reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.ch), Send: reflect.ValueOf(&value).Elem()}, nil
+}
+
+// doSelect is 'reflect.Select', but sending on a closed channel is an error.
+func doSelect(line string, cases []reflect.SelectCase) (chosen int, recv reflect.Value, recvOK bool, errObj Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			errObj = NewError(line, CHANNELERROR, fmt.Sprint(r))
+		}
+	}()
+
+	chosen, recv, recvOK = reflect.Select(cases)
+	return
+}
@@ -0,0 +1,130 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`let c = chan(1); c.send(1); let r = ""
+select {
+    case v = <-c: r = "recv " + v
+    default: r = "default"
+}
+r`, "recv 1"},
+		{`let c = chan(); let r = ""
+select {
+    case v = <-c: r = "recv " + v
+    default: r = "default"
+}
+r`, "default"},
+		{`let c1 = chan(); let c2 = chan(1); let r = ""
+select {
+    case <-c1: r = "c1"
+    case c2 <- "x": r = "sent " + c2.len()
+}
+r + " " + c2.recv()`, "sent 1 x"},
+		{`let c = chan(); let r = ""
+select {
+    case <-c: r = "recv"
+    case after(0.02): r = "timeout"
+}
+r`, "timeout"},
+		{`let c = chan(1); c.close(); let r = []
+select { case v, ok = <-c: r = [v, ok] }
+r`, "[nil, false]"},
+		{`let c = chan(1); c.send(3); let h = {"v": 0, "ok": nil}
+select { case h["v"], h["ok"] = <-c: h["v"] = h["v"] * 2 }
+h`, `{"v" : 6, "ok" : true}`},
+		//'break' applies to the enclosing loop
+		{`let c = chan(3); c.send(1); c.send(2); let n = 0
+for {
+    select {
+        case v = <-c: n = n + v
+        default: break
+    }
+}
+n`, "3"},
+		//'select' in a function returns its value
+		{`let c = chan(1); c.send(4)
+fn f() {
+    select {
+    case v = <-c: return v * 10
+    }
+}
+f()`, "40"},
+		//'<-' is only an operator in 'select'
+		{`let a = 1; a<-1`, "false"},
+
+		{`let c = chan(2); [c.trySend(1), c.trySend(2), c.trySend(3), c.len(), c.cap()]`, "[true, true, false, 2, 2]"},
+		{`let c = chan(1); c.send("a"); let v, ok = c.tryRecv(); let w, ok2 = c.tryRecv(); [v, ok, w, ok2]`, `["a", true, nil, false]`},
+		{`let c = chan(); [c.len(), c.cap()]`, "[0, 0]"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		result, err := interp.Run(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`let c = chan(); c.close(); select { case c <- 1: 1 }`, "Channel error: send on closed channel"},
+		{`let c = chan(); c.close(); c.trySend(1)`, "Channel error: send on closed channel"},
+		{`let c = chan(); c.close(); c.send(1)`, "Channel error: send on closed channel"},
+		{`let c = 1; select { case <-c: 1 }`, "'c' is not a channel, got INTEGER"},
+		{`select { case after("1"): 1 }`, "first argument for 'after' should be type *Integer|*Float"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		_, err := interp.Run(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestSelectInterrupts(t *testing.T) {
+	//a waiting 'select' in a cancelled task
+	input := `
+let c = chan()
+async fn wait() {
+    select { case <-c: return "recv" }
+}
+let t = wait()
+async.sleep(0.02)
+t.cancel()
+let r = ""
+try { await t } catch (e: CancelledError) { r = e.message }
+r
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	result, err := interp.Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inspect() != "task 'wait' was cancelled" {
+		t.Errorf("expected the task to be cancelled, got=%s", result.Inspect())
+	}
+
+	//a 'select' which never proceeds is stopped by the limits
+	interp = NewInterpreter(&bytes.Buffer{})
+	interp.Limits = Limits{Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err = interp.Run(`select {}`)
+	if e, ok := err.(*Error); !ok || e.Kind != EXECLIMITERROR {
+		t.Errorf("expected an EXECLIMITERROR, got=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the select to be stopped, took %v", elapsed)
+	}
+}
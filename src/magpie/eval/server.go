@@ -0,0 +1,216 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// the default timeout of 'stop()', for the requests in progress to finish
+const defaultStopTimeout = 15 * time.Second
+
+// serverRunner runs an http server in the background, it's the lifecycle of
+// the 'service' handles and of the servers created by 'http.newServer':
+//
+//	let svc = http.newServer("127.0.0.1:0", handler)
+//	svc.start()         //listens and serves in the background
+//	println(svc.addr()) //e.g. "127.0.0.1:41235"
+//	svc.stop(5)         //graceful shutdown, waits at most 5 seconds
+//	svc.wait()          //blocks until the server is stopped
+//
+// A stopped server could be started again.
+type serverRunner struct {
+	mux      sync.Mutex
+	srv      *http.Server //the running server, nil if it isn't running
+	listener net.Listener
+	done     chan struct{} //closed when the running server is stopped
+}
+
+// the running servers of the process, waited by 'WaitServers'
+var runningServers = struct {
+	sync.Mutex
+	m map[*serverRunner]chan struct{}
+}{m: make(map[*serverRunner]chan struct{})}
+
+// start listens on 'srv.Addr'(the port could be 0) and serves in a goroutine.
+func (r *serverRunner) start(srv *http.Server) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.srv != nil {
+		return errors.New("server already started")
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	r.srv, r.listener, r.done = srv, l, done
+	runningServers.Lock()
+	runningServers.m[r] = done
+	runningServers.Unlock()
+
+	go func() {
+		if srv.TLSConfig != nil { //the certificates are in the config
+			srv.ServeTLS(l, "", "")
+		} else {
+			srv.Serve(l)
+		}
+		r.mux.Lock()
+		if r.srv == srv {
+			r.srv = nil
+		}
+		r.mux.Unlock()
+
+		runningServers.Lock()
+		if runningServers.m[r] == done {
+			delete(runningServers.m, r)
+		}
+		runningServers.Unlock()
+		close(done)
+	}()
+	return nil
+}
+
+// stop shuts the server down gracefully, the requests in progress have
+// 'timeout' to finish before their connections are closed.
+func (r *serverRunner) stop(timeout time.Duration) error {
+	r.mux.Lock()
+	srv, done := r.srv, r.done
+	r.mux.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		srv.Close()
+	}
+	<-done
+	return err
+}
+
+// wait blocks until the server is stopped.
+func (r *serverRunner) wait() {
+	r.mux.Lock()
+	done := r.done
+	r.mux.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// addr returns the address the server listens on, or "" if it isn't running.
+func (r *serverRunner) addr() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.srv == nil {
+		return ""
+	}
+	return r.listener.Addr().String()
+}
+
+// callMethod calls the methods 'start', 'stop', 'wait' and 'addr' of the
+// server handles, 'newServer' returns the server to start.
+func (r *serverRunner) callMethod(line string, method string, newServer func() *http.Server, args ...Object) Object {
+	switch method {
+	case "start":
+		if len(args) != 0 {
+			return NewError(line, ARGUMENTERROR, "0", len(args))
+		}
+		if err := r.start(newServer()); err != nil {
+			return NewFalseObj(err.Error())
+		}
+		return TRUE
+	case "stop":
+		if len(args) > 1 {
+			return NewError(line, ARGUMENTERROR, "0|1", len(args))
+		}
+		timeout := defaultStopTimeout
+		if len(args) == 1 {
+			seconds, ok := toFloat64(args[0])
+			if !ok {
+				return NewError(line, PARAMTYPEERROR, "first", "stop", "*Integer|*Float", args[0].Type())
+			}
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		if err := r.stop(timeout); err != nil {
+			return NewFalseObj(err.Error())
+		}
+		return TRUE
+	case "wait":
+		if len(args) != 0 {
+			return NewError(line, ARGUMENTERROR, "0", len(args))
+		}
+		r.wait()
+		return NIL
+	case "addr":
+		if len(args) != 0 {
+			return NewError(line, ARGUMENTERROR, "0", len(args))
+		}
+		return NewString(r.addr())
+	}
+	return NewError(line, GENERICERROR, "unknown server method: "+method)
+}
+
+// WaitServers blocks until all the servers started by the scripts(the
+// services and the servers of 'http.newServer') are stopped. On SIGINT, the
+// servers are shut down gracefully.
+func WaitServers() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
+
+	for {
+		runningServers.Lock()
+		var done chan struct{}
+		for _, d := range runningServers.m {
+			done = d
+			break
+		}
+		runningServers.Unlock()
+		if done == nil {
+			return
+		}
+
+		select {
+		case <-done:
+		case <-c:
+			StopServers(defaultStopTimeout)
+			return
+		}
+	}
+}
+
+// StopServers shuts down all the running servers gracefully, the requests
+// in progress have 'timeout' to finish.
+func StopServers(timeout time.Duration) {
+	runningServers.Lock()
+	var runners []*serverRunner
+	for r := range runningServers.m {
+		runners = append(runners, r)
+	}
+	runningServers.Unlock()
+
+	var wg sync.WaitGroup
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r *serverRunner) {
+			defer wg.Done()
+			r.stop(timeout)
+		}(r)
+	}
+	wg.Wait()
+}
@@ -8,13 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"magpie/ast"
+	"magpie/docs"
+	"magpie/lexer"
+	"magpie/parser"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -29,6 +32,15 @@ type ServiceObj struct {
 	Addr   string
 	Router *Router
 	Route  *Route
+
+	//Middlewares wrap the router, i.e. they run for every request of the
+	//service, in order. They are the functions annotated with '@middleware',
+	//'@before' or '@after' in the service block.
+	Middlewares []MiddlewareFunc
+
+	runner  serverRunner
+	options *serverOptions //the TLS and HTTP/2 options, nil for plain HTTP/1
+	views   *serviceViews  //the templates of 'writer.render', or nil
 }
 
 func NewService(addr string) Object {
@@ -46,6 +58,8 @@ func (s *ServiceObj) Type() ObjectType { return SERVICE_OBJ }
 
 func (s *ServiceObj) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
 	switch method {
+	case "start", "stop", "wait", "addr":
+		return s.runner.callMethod(line, method, s.newServer, args...)
 	case "run":
 		return s.Run(line, args...)
 	case "handleFunc":
@@ -64,6 +78,8 @@ func (s *ServiceObj) CallMethod(line string, scope *Scope, method string, args .
 	return NewError(line, NOMETHODERROR, method, s.Type())
 }
 
+// Run starts the service, and blocks until it's stopped, or until SIGINT
+// (Ctrl+C) is received, then the service is shut down gracefully.
 func (s *ServiceObj) Run(line string, args ...Object) Object {
 	if len(args) != 1 {
 		return NewError(line, ARGUMENTERROR, "1", len(args))
@@ -73,48 +89,44 @@ func (s *ServiceObj) Run(line string, args ...Object) Object {
 		s.Router.Use(LoggingMiddleware)
 	}
 
-	srv := &http.Server{
-		Addr: s.Addr,
-		// Good practice to set timeouts to avoid Slowloris attacks.
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      s.Router,
+	if err := s.runner.start(s.newServer()); err != nil {
+		return NewFalseObj(err.Error())
 	}
 
-	// Run our server in a goroutine so that it doesn't block.
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
-		}
-	}()
-
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
 	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
 
-	// Block until we receive our signal.
-	<-c
-
-	// Create a deadline to wait for.
-	wait := time.Second * 15
-	ctx, cancel := context.WithTimeout(context.Background(), wait)
-	defer cancel()
-	// Doesn't block if no connections, but will otherwise wait
-	// until the timeout deadline.
-	srv.Shutdown(ctx)
-	// Optionally, you could run srv.Shutdown in a goroutine and block on
-	// <-ctx.Done() if your application should wait for other services
-	// to finalize based on context cancellation.
-	//log.Println("shutting down...")
-
-	close(c)
-	os.Exit(0)
+	stopped := make(chan struct{})
+	go func() {
+		s.runner.wait()
+		close(stopped)
+	}()
 
+	// Block until we receive our signal, or the service is stopped.
+	select {
+	case <-c:
+		s.runner.stop(defaultStopTimeout)
+	case <-stopped:
+	}
 	return NIL
 }
 
+func (s *ServiceObj) newServer() *http.Server {
+	srv := &http.Server{
+		Addr: s.Addr,
+		// Good practice to set timeouts to avoid Slowloris attacks.
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+		Handler:      s.Handler(),
+	}
+	s.options.apply(srv)
+	return srv
+}
+
 func (s *ServiceObj) HandleFunc(line string, scope *Scope, args ...Object) Object {
 	if len(args) != 2 {
 		return NewError(line, ARGUMENTERROR, "2", len(args))
@@ -135,18 +147,105 @@ func (s *ServiceObj) HandleFunc(line string, scope *Scope, args ...Object) Objec
 		return NewError(line, FUNCCALLBACKERROR, 2, paramCount)
 	}
 
-	s.Route = s.Router.HandleFunc(pattern.String, func(w http.ResponseWriter, r *http.Request) {
-		ServeService(line, scope, block, w, r)
+	s.handle(line, scope, pattern.String, block, nil)
+	return NIL
+}
+
+// handle registers the route 'pattern', whose handler 'f' is wrapped by the
+// route's middlewares 'mws'.
+func (s *ServiceObj) handle(line string, scope *Scope, pattern string, f *Function, mws []MiddlewareFunc) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeService(line, scope, f, w, r)
 	})
+	s.Route = s.Router.Handle(pattern, chainMiddlewares(handler, mws))
+}
 
-	return NIL
+// handleWebSocket registers the '@websocket' function 'f', the websocket
+// handshakes are GET requests.
+func (s *ServiceObj) handleWebSocket(line string, scope *Scope, pattern string, f *Function, origins []string, mws []MiddlewareFunc) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWebSocket(line, scope, f, origins, w, r)
+	})
+	s.Route = s.Router.Handle(pattern, chainMiddlewares(handler, mws)).Methods("GET")
+}
+
+// Handler returns the router wrapped by the service's middlewares.
+func (s *ServiceObj) Handler() http.Handler {
+	handler := chainMiddlewares(s.Router, s.Middlewares)
+	if s.views == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), viewsKey, s.views)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// chainMiddlewares wraps 'handler' with 'mws', the first one is the outermost.
+func chainMiddlewares(handler http.Handler, mws []MiddlewareFunc) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+// handleOpenAPI serves the OpenAPI document of the service at '/openapi.json',
+// the routes of the service take precedence.
+func (s *ServiceObj) handleOpenAPI(stmt *ast.ServiceStatement) error {
+	api, err := doc.ServiceOpenAPI(serviceWithDocs(stmt)).JSON()
+	if err != nil {
+		return err
+	}
+	s.Router.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(api)
+	}).Methods("GET")
+	return nil
+}
+
+// serviceWithDocs returns the service statement with the doc comments, which
+// are only kept by the doc parser, so the service's file is parsed again, the
+// program of the file is also returned. It returns 'stmt' and a nil program if
+// the file couldn't be parsed.
+func serviceWithDocs(stmt *ast.ServiceStatement) (*ast.ServiceStatement, *ast.Program) {
+	filename := stmt.Pos().Filename
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return stmt, nil
+	}
+
+	parseMux.Lock()
+	defer parseMux.Unlock()
+	saved := parser.FileLines
+	parser.FileLines = strings.Split(string(contents), "\n")
+	defer func() { parser.FileLines = saved }()
+	p := parser.NewWithDoc(lexer.New(filename, string(contents)), filepath.Dir(filename))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return stmt, nil
+	}
+	for _, statement := range program.Statements {
+		if s, ok := statement.(*ast.ServiceStatement); ok && s.Name.Value == stmt.Name.Value && s.Pos().Offset == stmt.Pos().Offset {
+			return s, program
+		}
+	}
+	return stmt, nil
 }
 
 func ServeService(line string, scope *Scope, f *Function, w http.ResponseWriter, r *http.Request) {
+	s := newServiceScope(line, scope, f, w, r)
+	fr := Eval(f.Literal.Body, s) //fr: function result
+	writeServiceResult(fr, w)
+}
+
+// newServiceScope returns the scope of a service function, with its
+// 'writer' and 'request' parameters, the url parameters('vars'), and the
+// bound request body('body') if the route has a '@body' annotation.
+func newServiceScope(line string, scope *Scope, f *Function, w http.ResponseWriter, r *http.Request) *Scope {
 	s := NewScope(scope, nil)
 
 	//Save the two variables to `Scope`, so `Eval` can use them
-	s.Set(f.Literal.Parameters[0].(*ast.Identifier).Value, &HttpResponseWriter{Writer: w})
+	s.Set(f.Literal.Parameters[0].(*ast.Identifier).Value, &HttpResponseWriter{Writer: w, views: requestViews(r)})
 	s.Set(f.Literal.Parameters[1].(*ast.Identifier).Value, &HttpRequest{Request: r})
 
 	hash := NewHash()
@@ -157,40 +256,124 @@ func ServeService(line string, scope *Scope, f *Function, w http.ResponseWriter,
 	}
 	s.Set("vars", hash)
 
-	fr := Eval(f.Literal.Body, s) //fr: function result
-	if fr.Type() == NIL_OBJ {
-		return
-	}
-
-	if r, ok := fr.(*ReturnValue); ok {
-		rvs := r.Values
-		if len(rvs) == 1 { // one return value
-			rv := rvs[0]
-			switch rv.(type) {
-			case *Integer:
-				iObj := rv.(*Integer)
-				w.WriteHeader(int(iObj.Int64))
-			case *Hash:
-				hObj := rv.(*Hash)
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusOK)
-				res, err := hObj.MarshalJSON()
-				if err == nil { // no error
-					w.Write(res)
-				}
-			}
-		} else if len(rvs) == 2 {
-			hObj := rvs[0].(*Hash)
-			iObj := rvs[0].(*Integer)
+	if body := requestBody(r); body != nil {
+		s.Set("body", body)
+	}
+	return s
+}
+
+// writeServiceResult writes the returned value of a service function as the
+// response: 'return 201' writes the status code, 'return {...}' writes the
+// hash as json, and 'return {...}, 400' writes both. It reports whether a
+// value is written.
+func writeServiceResult(fr Object, w http.ResponseWriter) bool {
+	r, ok := fr.(*ReturnValue)
+	if !ok {
+		return false
+	}
 
+	rvs := r.Values
+	if len(rvs) == 1 { // one return value
+		switch rv := rvs[0].(type) {
+		case *Integer:
+			w.WriteHeader(int(rv.Int64))
+			return true
+		case *Hash:
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(int(iObj.Int64))
-			res, err := hObj.MarshalJSON()
+			w.WriteHeader(http.StatusOK)
+			res, err := rv.MarshalJSON()
 			if err == nil { // no error
 				w.Write(res)
 			}
+			return true
+		}
+	} else if len(rvs) == 2 {
+		hObj, ok1 := rvs[0].(*Hash)
+		iObj, ok2 := rvs[1].(*Integer)
+		if !ok1 || !ok2 {
+			return false
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(int(iObj.Int64))
+		res, err := hObj.MarshalJSON()
+		if err == nil { // no error
+			w.Write(res)
 		}
+		return true
+	}
+	return false
+}
+
+// writeServiceError writes '500 Internal Server Error' if 'fr' is an error,
+// and reports whether it is. The error isn't sent to the client.
+func writeServiceError(fr Object, w http.ResponseWriter) bool {
+	if fr == nil || fr.Type() != ERROR_OBJ {
+		return false
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	return true
+}
+
+// ServiceMiddleware returns the middleware of a function annotated with
+// '@middleware', '@before' or '@after'(the 'kind'):
+//
+//	@middleware fn auth(writer, request, next) {...}
+//	@before fn check(writer, request) {...}
+//	@after fn log(writer, request) {...}
+//
+// A '@middleware' function calls 'next()' to run the rest of the chain, if it
+// doesn't, the response is what it writes or returns(like a route function).
+// A '@before' function runs before the rest of the chain, which is skipped
+// if it returns a response. An '@after' function runs after the rest of the chain.
+//
+// If a '@middleware' function fails(e.g. throws) before calling 'next()', or a
+// '@before' function fails, the rest of the chain is skipped and the response
+// is '500 Internal Server Error'. The response is already written when an
+// '@after' function runs, its errors are ignored.
+func ServiceMiddleware(line string, scope *Scope, f *Function, kind string) (MiddlewareFunc, Object) {
+	params := 2
+	if kind == "middleware" {
+		params = 3
 	}
+	if paramCount := len(f.Literal.Parameters); paramCount != params {
+		return nil, NewError(line, FUNCCALLBACKERROR, params, paramCount)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := newServiceScope(line, scope, f, w, r)
+			switch kind {
+			case "middleware":
+				called := false
+				s.Set(f.Literal.Parameters[2].(*ast.Identifier).Value, &Builtin{
+					Fn: func(line string, scope *Scope, args ...Object) Object {
+						if len(args) != 0 {
+							return NewError(line, ARGUMENTERROR, "0", len(args))
+						}
+						if called {
+							return NewError(line, GENERICERROR, "next() called twice")
+						}
+						called = true
+						next.ServeHTTP(w, r)
+						return NIL
+					},
+				})
+				fr := Eval(f.Literal.Body, s)
+				if !called && !writeServiceError(fr, w) {
+					writeServiceResult(fr, w)
+				}
+			case "before":
+				fr := Eval(f.Literal.Body, s)
+				if !writeServiceError(fr, w) && !writeServiceResult(fr, w) {
+					next.ServeHTTP(w, r)
+				}
+			case "after":
+				next.ServeHTTP(w, r)
+				Eval(f.Literal.Body, s)
+			}
+		})
+	}, nil
 }
 
 func (s *ServiceObj) Methods(line string, args ...Object) Object {
@@ -692,6 +875,8 @@ type contextKey int
 const (
 	varsKey contextKey = iota
 	routeKey
+	bodyKey  //the instance of '@body' binding
+	viewsKey //the templates of the service
 )
 
 // Vars returns the route variables for the current request, if any.
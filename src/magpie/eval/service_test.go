@@ -0,0 +1,482 @@
+package eval
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const middlewareInput = `
+let log = []
+
+fn cors(writer, request, next) {
+    writer.header().setHeader("Access-Control-Allow-Origin", "*")
+    log.push("cors")
+    if request.method() == "OPTIONS" {
+        return 204
+    }
+    next()
+    log.push("cors done")
+}
+
+fn requestId(writer, request) {
+    writer.header().setHeader("X-Request-Id", "42")
+    log.push("before")
+}
+
+fn logRequest(writer, request) {
+    log.push("after " + request.url())
+}
+
+fn auth(writer, request, next) {
+    log.push("auth")
+    if request.header().get("Authorization") != "secret" {
+        return { "error": "unauthorized" }, 401
+    }
+    next()
+}
+
+fn deny(writer, request) {
+    log.push("deny")
+    return 403
+}
+
+fn failBefore(writer, request) {
+    log.push("failBefore")
+    throw new Exception("denied")
+}
+
+fn failMiddleware(writer, request, next) {
+    log.push("failMiddleware")
+    let x = undefinedVariable
+    next()
+}
+
+fn hello(writer, request) {
+    log.push("hello " + vars["name"])
+    return { "hello": vars["name"] }
+}
+`
+
+func TestServiceMiddlewares(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(middlewareInput); err != nil {
+		t.Fatal(err)
+	}
+	fn := func(name string) *Function {
+		f, _ := interp.Get(name)
+		return f.(*Function)
+	}
+	mw := func(name, kind string) MiddlewareFunc {
+		m, err := ServiceMiddleware("1", interp.Scope(), fn(name), kind)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Inspect())
+		}
+		return m
+	}
+
+	svc := NewService(":0").(*ServiceObj)
+	svc.Middlewares = []MiddlewareFunc{mw("cors", "middleware"), mw("requestId", "before"), mw("logRequest", "after")}
+	svc.handle("1", interp.Scope(), "/hello/{name}", fn("hello"), nil)
+	svc.handle("1", interp.Scope(), "/admin/{name}", fn("hello"), []MiddlewareFunc{mw("auth", "middleware")})
+	svc.handle("1", interp.Scope(), "/denied/{name}", fn("hello"), []MiddlewareFunc{mw("deny", "before")})
+	svc.handle("1", interp.Scope(), "/failbefore/{name}", fn("hello"), []MiddlewareFunc{mw("failBefore", "before")})
+	svc.handle("1", interp.Scope(), "/failmw/{name}", fn("hello"), []MiddlewareFunc{mw("failMiddleware", "middleware")})
+
+	tests := []struct {
+		method, url, auth string
+		status            int
+		body              string
+		log               string
+	}{
+		{"GET", "/hello/magpie", "", 200, `{"hello":"magpie"}`,
+			`["cors", "before", "hello magpie", "after /hello/magpie", "cors done"]`},
+		{"OPTIONS", "/hello/magpie", "", 204, "", `["cors"]`},
+		{"GET", "/admin/magpie", "", 401, `{"error":"unauthorized"}`,
+			`["cors", "before", "auth", "after /admin/magpie", "cors done"]`},
+		{"GET", "/admin/magpie", "secret", 200, `{"hello":"magpie"}`,
+			`["cors", "before", "auth", "hello magpie", "after /admin/magpie", "cors done"]`},
+		{"GET", "/denied/magpie", "", 403, "",
+			`["cors", "before", "deny", "after /denied/magpie", "cors done"]`},
+		{"GET", "/failbefore/magpie", "", 500, "Internal Server Error\n",
+			`["cors", "before", "failBefore", "after /failbefore/magpie", "cors done"]`},
+		{"GET", "/failmw/magpie", "", 500, "Internal Server Error\n",
+			`["cors", "before", "failMiddleware", "after /failmw/magpie", "cors done"]`},
+	}
+
+	for _, tt := range tests {
+		interp.Run("log = []")
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(w, req)
+
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s %s: expected %d %q, got=%d %q", tt.method, tt.url, tt.status, tt.body, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s %s: missing the header set by 'cors'", tt.method, tt.url)
+		}
+		if log, _ := interp.Get("log"); log.Inspect() != tt.log {
+			t.Errorf("%s %s: expected the log %s, got=%s", tt.method, tt.url, tt.log, log.Inspect())
+		}
+	}
+}
+
+func TestServiceMiddlewareParameters(t *testing.T) {
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(middlewareInput); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := interp.Get("requestId")
+	if _, err := ServiceMiddleware("1", interp.Scope(), f.(*Function), "middleware"); err == nil {
+		t.Errorf("expected an error for a middleware without 'next'")
+	}
+
+	svc := NewService(":0").(*ServiceObj)
+	h, _ := interp.Get("hello")
+	svc.handle("1", interp.Scope(), "/", h.(*Function), nil)
+	w := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 without middlewares, got=%d", w.Code)
+	}
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	input := `
+service Api on "127.0.0.1:0" {
+    @route(url="/ping", methods=["GET"])
+    fn ping(writer, request) {
+        return { "pong": true }
+    }
+}
+let server = http.newServer("127.0.0.1:0", fn(writer, request) { writer.write("hi") })
+server.start()
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(addr, path string) string {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for _, tt := range []struct{ handle, path, body string }{{"Api", "/ping", `{"pong":true}`}, {"server", "/", "hi"}} {
+		addr, err := interp.Run(tt.handle + ".addr()")
+		if err != nil || addr.Inspect() == "" {
+			t.Fatalf("%s.addr(): %v %v", tt.handle, addr, err)
+		}
+		if got := get(addr.Inspect(), tt.path); got != tt.body {
+			t.Errorf("%s: expected %q, got=%q", tt.handle, tt.body, got)
+		}
+		if result, _ := interp.Run(tt.handle + ".start()"); IsTrue(result) {
+			t.Errorf("%s: expected start() to fail when running, got=%s", tt.handle, result.Inspect())
+		}
+
+		//'wait()' returns once the service is stopped
+		waited := make(chan struct{})
+		h, _ := interp.Get(tt.handle)
+		go func() {
+			h.CallMethod("1", interp.Scope(), "wait")
+			close(waited)
+		}()
+		if result, err := interp.Run(tt.handle + ".stop(1)"); err != nil || result != TRUE {
+			t.Fatalf("%s.stop(): %v %v", tt.handle, result, err)
+		}
+		select {
+		case <-waited:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s.wait() didn't return after stop()", tt.handle)
+		}
+		if addr, _ := interp.Run(tt.handle + ".addr()"); addr.Inspect() != "" {
+			t.Errorf("%s: expected no address when stopped, got=%q", tt.handle, addr.Inspect())
+		}
+		if !strings.Contains(get(addr.Inspect(), tt.path), "refused") {
+			t.Errorf("%s: expected the connection to be refused", tt.handle)
+		}
+
+		//restart
+		if result, _ := interp.Run(tt.handle + ".start()"); result != TRUE {
+			t.Fatalf("%s: restart failed: %s", tt.handle, result.Inspect())
+		}
+		addr, _ = interp.Run(tt.handle + ".addr()")
+		if got := get(addr.Inspect(), tt.path); got != tt.body {
+			t.Errorf("%s after restart: expected %q, got=%q", tt.handle, tt.body, got)
+		}
+		interp.Run(tt.handle + ".stop()")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		WaitServers()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitServers() didn't return without running servers")
+	}
+}
+
+func TestServiceOpenAPI(t *testing.T) {
+	input := `
+service Api on "127.0.0.1:0:openapi" {
+    @route(url="/users/{id:[0-9]+}", methods=["GET"])
+    fn getUser(writer, request) {
+        return { "id": vars["id"] }
+    }
+}
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	defer interp.Run("Api.stop()")
+
+	addr, _ := interp.Run("Api.addr()")
+	resp, err := http.Get("http://" + addr.Inspect() + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	for _, expected := range []string{`"title": "Api"`, `"/users/{id}": {`, `"operationId": "getUser"`, `"pattern": "^(?:[0-9]+)$"`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %s in:\n%s", expected, body)
+		}
+	}
+}
+
+func TestServiceBodyBinding(t *testing.T) {
+	input := `
+class Person {
+    @NotNull
+    property Age { get; set; }
+}
+
+class User : Person {
+    @NotEmpty
+    property Name { get; set; }
+    property Tags { get; set; }
+}
+
+service Users on "127.0.0.1:0" {
+    @route(url="/users", methods=["POST"])
+    @body(schema=User)
+    fn create(writer, request) {
+        return { "name": body.Name, "age": body.Age, "tags": body.Tags, "raw": request.json()["Name"] }, 201
+    }
+
+    @route(url="/small", methods=["POST"])
+    @body(schema=User, maxSize=32)
+    fn createSmall(writer, request) {
+        return { "name": body.Name }, 201
+    }
+}
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	defer interp.Run("Users.stop()")
+	addr, _ := interp.Run("Users.addr()")
+
+	tests := []struct {
+		url    string
+		body   string
+		status int
+		resp   string
+	}{
+		{"/users", `{"Name": "magpie", "Age": 3, "Other": true}`, 201, `{"name":"magpie","age":3,"tags":null,"raw":"magpie"}`},
+		{"/users", `{"Name": "", "Tags": []}`, 400, `{"error":"validation failed","fields":{"Age":"must not be null","Name":"must not be empty"}}`},
+		{"/users", `{"Age": null}`, 400, `{"error":"validation failed","fields":{"Age":"must not be null","Name":"must not be null"}}`},
+		{"/users", `["magpie"]`, 400, `{"error":"invalid JSON body: expect a JSON object"}`},
+		{"/users", `{"Name":`, 400, `{"error":"invalid JSON body: EOF"}`},
+		{"/small", `{"Name": "magpie", "Age": 3}`, 201, `{"name":"magpie"}`},
+		{"/small", `{"Name": "magpie", "Age": 3, "Tags": ["a", "b"]}`, 413, `{"error":"invalid body: http: request body too large"}`},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post("http://"+addr.Inspect()+tt.url, "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || string(body) != tt.resp {
+			t.Errorf("%s: expected %d %s, got=%d %s", tt.body, tt.status, tt.resp, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestServiceBodyNotClass(t *testing.T) {
+	input := `
+service Bad on "127.0.0.1:0" {
+    @route(url="/")
+    @body(schema="User")
+    fn create(writer, request) {}
+}
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	_, err := interp.Run(input)
+	if err == nil || !strings.Contains(err.Error(), "schema argument for 'body' should be type *Class") {
+		t.Errorf("expected a schema type error, got=%v", err)
+	}
+}
+
+func TestHttpRequestBody(t *testing.T) {
+	input := `
+fn echo(writer, request) {
+    return { "q": request.query("q"), "none": request.query("none"), "all": request.query(),
+             "body": request.body(), "again": request.body() }
+}
+
+fn small(writer, request) {
+    return { "body": request.body(3), "json": request.json(3) }
+}
+
+fn upload(writer, request) {
+    let f = request.file("doc")
+    if f == nil {
+        return 400
+    }
+    return { "filename": f.filename, "size": f.size, "content": f.content }
+}
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(":0").(*ServiceObj)
+	for _, name := range []string{"echo", "upload", "small"} {
+		f, _ := interp.Get(name)
+		svc.handle("1", interp.Scope(), "/"+name, f.(*Function), nil)
+	}
+
+	w := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/echo?q=1&q=2&a=b", strings.NewReader("hello")))
+	expected := `{"q":"1","none":null,"all":{"a":["b"],"q":["1","2"]},"body":"hello","again":"hello"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected %s, got=%s", expected, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/small", strings.NewReader("hello")))
+	expected = `{"body":null,"json":null}`
+	if w.Body.String() != expected {
+		t.Errorf("expected %s, got=%s", expected, w.Body.String())
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("doc", "notes.txt")
+	fw.Write([]byte("magpie"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, req)
+	expected = `{"filename":"notes.txt","size":6,"content":"magpie"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected %s, got=%s", expected, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	svc.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("hello")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without the file, got=%d", w.Code)
+	}
+}
+
+func TestHttpTestClient(t *testing.T) {
+	input := `
+service Users on "127.0.0.1:0" {
+    @before
+    fn auth(writer, request) {
+        if request.header().get("Authorization") != "secret" {
+            return { "error": "unauthorized" }, 401
+        }
+    }
+
+    @route(url="/users/{id}", methods=["GET", "DELETE"])
+    fn user(writer, request) {
+        if request.method() == "DELETE" {
+            return 204
+        }
+        return { "id": vars["id"] }
+    }
+
+    @route(url="/users", methods=["POST", "PUT"])
+    fn create(writer, request) {
+        writer.header().setHeader("Location", "/users/1")
+        return { "method": request.method(), "got": request.json() }, 201
+    }
+
+    @route(url="/text", methods=["POST"])
+    fn text(writer, request) {
+        writer.write(request.body())
+    }
+}
+
+let client = httptest.newClient(Users)
+let auth = {"Authorization": "secret"}
+let results = [
+    client.get("/users/42"),
+    client.get("/users/42", auth),
+    client.delete("/users/42", auth),
+    client.post("/users", {"name": "magpie"}, auth),
+    client.request("put", "/users", [1, 2], auth),
+    client.post("/text", "plain", auth),
+]
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	interp.NoListen = true
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := interp.Run("Users.addr()"); addr.Inspect() != "" {
+		t.Errorf("expected the service not listening, got the address %q", addr.Inspect())
+	}
+
+	tests := []struct {
+		expr, expected string
+	}{
+		{"results[0].status", "401"},
+		{"results[0].body", `{"error" : "unauthorized"}`},
+		{"results[1].body", `{"id" : "42"}`},
+		{"results[1].text", `{"id":"42"}`},
+		{`results[1]["headers"]["Content-Type"]`, "application/json; charset=utf-8"},
+		{"results[2].status", "204"},
+		{"results[3].status", "201"},
+		{`results[3]["headers"]["Location"]`, "/users/1"},
+		{"results[3].body", `{"method" : "POST", "got" : {"name" : "magpie"}}`},
+		{"results[4].body", `{"method" : "PUT", "got" : [1, 2]}`},
+		{"results[5].body", "plain"},
+	}
+	for _, tt := range tests {
+		result, err := interp.Run(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.expr, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %s, got=%s", tt.expr, tt.expected, result.Inspect())
+		}
+	}
+}
@@ -96,7 +96,6 @@ type String struct {
 
 func (s *String) iter() bool { return true }
 
-func (s *String) throw() {}
 func (s *String) Inspect() string {
 	if s.Valid {
 		return s.String
@@ -0,0 +1,315 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	TESTING_OBJ  = "TESTING_OBJ"
+	testing_name = "testing"
+)
+
+// Testing is the 'testing' module, the assertions of the tests run by
+// 'magpie test'. A failed assertion returns an 'AssertionError', which
+// stops the test. Every assertion takes an optional message as its last
+// argument, which is prepended to the reason of the failure.
+//
+//	@Test
+//	fn testAdd() {
+//	    testing.equal(3, add(1, 2))
+//	    testing.deepEqual([1, {"a": 2}], pair(1, 2), "pair")
+//	    testing.approx(0.3, 0.1 + 0.2)
+//	    testing.throws(fn() { 1 / 0 }, DivideByZeroError)
+//	}
+type Testing struct{}
+
+func NewTestingObj() Object {
+	ret := &Testing{}
+	SetGlobalObj(testing_name, ret)
+	return ret
+}
+
+func (t *Testing) Inspect() string  { return "<" + testing_name + ">" }
+func (t *Testing) Type() ObjectType { return TESTING_OBJ }
+
+func (t *Testing) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "ok":
+		return t.Ok(line, args...)
+	case "equal":
+		return t.Equal(line, args...)
+	case "notEqual":
+		return t.NotEqual(line, args...)
+	case "deepEqual":
+		return t.DeepEqual(line, args...)
+	case "approx":
+		return t.Approx(line, args...)
+	case "throws":
+		return t.Throws(line, scope, args...)
+	case "fail":
+		return t.Fail(line, args...)
+	}
+	return NewError(line, NOMETHODERROR, method, t.Type())
+}
+
+// testing.ok(value[, msg]): 'value' is true.
+func (t *Testing) Ok(line string, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return NewError(line, ARGUMENTERROR, "1|2", len(args))
+	}
+	if !IsTrue(args[0]) {
+		return assertionError(line, args[1:], "expected a true value, got %s", testingInspect(args[0]))
+	}
+	return NIL
+}
+
+// testing.equal(expected, actual[, msg]): the values are equal, arrays,
+// hashes and objects are equal only if they are the same object.
+func (t *Testing) Equal(line string, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return NewError(line, ARGUMENTERROR, "2|3", len(args))
+	}
+	if !strictEqual(args[0], args[1]) {
+		return assertionError(line, args[2:], "expected %s, got %s", testingInspect(args[0]), testingInspect(args[1]))
+	}
+	return NIL
+}
+
+// testing.notEqual(unexpected, actual[, msg])
+func (t *Testing) NotEqual(line string, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return NewError(line, ARGUMENTERROR, "2|3", len(args))
+	}
+	if strictEqual(args[0], args[1]) {
+		return assertionError(line, args[2:], "expected a value other than %s", testingInspect(args[0]))
+	}
+	return NIL
+}
+
+// testing.deepEqual(expected, actual[, msg]): the values are equal, the
+// members of arrays, tuples and hashes, and the variables of objects
+// are compared recursively.
+func (t *Testing) DeepEqual(line string, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return NewError(line, ARGUMENTERROR, "2|3", len(args))
+	}
+	if path, ok := deepEqual(args[0], args[1], ""); !ok {
+		where := ""
+		if path != "" {
+			where = " at " + path
+		}
+		return assertionError(line, args[2:], "values differ%s: expected %s, got %s", where, testingInspect(args[0]), testingInspect(args[1]))
+	}
+	return NIL
+}
+
+// testing.approx(expected, actual[, epsilon][, msg]): the numbers differ by
+// at most 'epsilon'(default 1e-9).
+func (t *Testing) Approx(line string, args ...Object) Object {
+	if len(args) < 2 || len(args) > 4 {
+		return NewError(line, ARGUMENTERROR, "2..4", len(args))
+	}
+	expected, ok := toFloat64(args[0])
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "approx", "*Integer|*Float", args[0].Type())
+	}
+	actual, ok := toFloat64(args[1])
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "second", "approx", "*Integer|*Float", args[1].Type())
+	}
+
+	epsilon := 1e-9
+	msg := args[2:]
+	if len(msg) > 0 {
+		if e, ok := toFloat64(msg[0]); ok {
+			epsilon = e
+			msg = msg[1:]
+		}
+	}
+	if math.Abs(expected-actual) > epsilon {
+		return assertionError(line, msg, "expected %s ± %s, got %s", testingInspect(args[0]),
+			strconv.FormatFloat(epsilon, 'g', -1, 64), testingInspect(args[1]))
+	}
+	return NIL
+}
+
+// testing.throws(fn[, exceptionClass][, msg]): calling 'fn' throws an
+// exception(or results in a runtime error), which is an instance of
+// 'exceptionClass' if given. It returns the exception.
+func (t *Testing) Throws(line string, scope *Scope, args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return NewError(line, ARGUMENTERROR, "1..3", len(args))
+	}
+	fn, ok := args[0].(*Function)
+	if !ok {
+		return NewError(line, PARAMTYPEERROR, "first", "throws", "*Function", args[0].Type())
+	}
+	if fn.Async {
+		return NewError(line, GENERICERROR, "throws: async functions are not supported")
+	}
+	var cls *Class
+	msg := args[1:]
+	if len(msg) > 0 {
+		if c, ok := msg[0].(*Class); ok {
+			cls, msg = c, msg[1:]
+		}
+	}
+
+	err, ok := evalFunctionDirect(fn, []Object{}, nil, fn.Scope, nil).(*Error)
+	if !ok {
+		return assertionError(line, msg, "expected an exception, got none")
+	}
+	if !err.catchable() {
+		return err
+	}
+	exception := err.Thrown
+	if exception == nil {
+		exception = errorToException(err, scope)
+	}
+	if cls != nil && !InstanceOf(cls.Name, asInstance(exception)) {
+		return assertionError(line, msg, "expected an exception of class %s, got %s", cls.Name, exceptionString(exception))
+	}
+	return exception
+}
+
+// testing.fail([msg])
+func (t *Testing) Fail(line string, args ...Object) Object {
+	if len(args) > 1 {
+		return NewError(line, ARGUMENTERROR, "0|1", len(args))
+	}
+	if len(args) == 0 {
+		return assertionError(line, nil, "test failed")
+	}
+	return assertionError(line, nil, "%s", args[0].Inspect())
+}
+
+// assertionError returns an 'AssertionError' with the reason of the failure,
+// 'msg' is the optional message of the assertion.
+func assertionError(line string, msg []Object, format string, args ...interface{}) Object {
+	reason := fmt.Sprintf(format, args...)
+	if len(msg) > 0 {
+		reason = msg[0].Inspect() + ": " + reason
+	}
+	err := NewError(line, GENERICERROR, "assertion failed: "+reason).(*Error)
+	err.Kind = ASSERTIONERROR
+	return err
+}
+
+// the same value, or the same array/hash/object
+func strictEqual(a, b Object) bool {
+	switch a.(type) {
+	case *Array, *Hash, *ObjectInstance, *Struct:
+		return a == b
+	}
+	return equal(true, a, b)
+}
+
+// deepEqual compares the values recursively, it returns where they differ
+// if they are not equal, e.g. '[1]["name"]'.
+func deepEqual(a, b Object, path string) (string, bool) {
+	switch a := a.(type) {
+	case *Array:
+		other, ok := b.(*Array)
+		if !ok || len(a.Members) != len(other.Members) {
+			return path, false
+		}
+		return deepEqualMembers(a.Members, other.Members, path)
+	case *Tuple:
+		other, ok := b.(*Tuple)
+		if !ok || len(a.Members) != len(other.Members) {
+			return path, false
+		}
+		return deepEqualMembers(a.Members, other.Members, path)
+	case *Hash:
+		other, ok := b.(*Hash)
+		if !ok || len(a.Pairs) != len(other.Pairs) {
+			return path, false
+		}
+		for _, k := range a.Order {
+			pair := a.Pairs[k]
+			otherPair, ok := other.Pairs[k]
+			p := path + "[" + testingInspect(pair.Key) + "]"
+			if !ok {
+				return p, false
+			}
+			if p, ok := deepEqual(pair.Value, otherPair.Value, p); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	case *ObjectInstance:
+		other, ok := b.(*ObjectInstance)
+		if !ok || a.Class != other.Class {
+			return path, false
+		}
+		return deepEqualVars(a.Scope, other.Scope, path)
+	case *Struct:
+		other, ok := b.(*Struct)
+		if !ok {
+			return path, false
+		}
+		return deepEqualVars(a.Scope, other.Scope, path)
+	}
+	if !strictEqual(a, b) {
+		return path, false
+	}
+	return "", true
+}
+
+func deepEqualMembers(a, b []Object, path string) (string, bool) {
+	for i := range a {
+		if p, ok := deepEqual(a[i], b[i], path+"["+strconv.Itoa(i)+"]"); !ok {
+			return p, false
+		}
+	}
+	return "", true
+}
+
+// compares the variables of two objects, except 'this' and 'parent'
+func deepEqualVars(a, b *Scope, path string) (string, bool) {
+	aVars, bVars := a.Vars(), b.Vars()
+	for _, vars := range []map[string]Object{aVars, bVars} {
+		delete(vars, "this")
+		delete(vars, "parent")
+	}
+	if len(aVars) != len(bVars) {
+		return path, false
+	}
+	for name, v := range aVars {
+		other, ok := bVars[name]
+		if !ok {
+			return path + "." + name, false
+		}
+		if p, ok := deepEqual(v, other, path+"."+name); !ok {
+			return p, false
+		}
+	}
+	return "", true
+}
+
+func toFloat64(obj Object) (float64, bool) {
+	switch o := obj.(type) {
+	case *Integer:
+		return float64(o.Int64), true
+	case *UInteger:
+		return float64(o.UInt64), true
+	case *Float:
+		return o.Float64, true
+	}
+	return 0, false
+}
+
+func asInstance(obj Object) *ObjectInstance {
+	instance, _ := obj.(*ObjectInstance)
+	return instance
+}
+
+// strings are quoted, so '1' and '"1"' could be told apart
+func testingInspect(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return strconv.Quote(s.String)
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,270 @@
+package eval
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// The options of the servers(the options hash of a 'service', of
+// 'http.newServer' and of 'http.listenAndServe'):
+//
+//	service Hello on "0.0.0.0:8443", {"cert": "server.crt", "key": "server.key"} { ... }
+//
+//	cert, key:  the certificate and private key files, the server serves https
+//	clientCA:   the CA file to verify the client certificates(mutual TLS)
+//	clientAuth: "require"(default with 'clientCA') or "verify"(verify the
+//	            certificate if the client sends one)
+//	minVersion: the minimum TLS version, "1.0", "1.1", "1.2"(default) or "1.3"
+//	http2:      serve HTTP/2, the default is true with TLS. Without TLS, true
+//	            serves unencrypted HTTP/2(h2c) besides HTTP/1.
+//	templates:  only for 'service', the directory of the html templates of
+//	            'writer.render'
+//
+// The options of the clients('http.newClient'):
+//
+//	caFile:             the CA bundle to verify the server certificates
+//	insecureSkipVerify: don't verify the server certificates
+//	cert, key:          the client certificate for mutual TLS
+//	minVersion:         the minimum TLS version
+//	http2:              attempt HTTP/2, the default is true
+//	timeout:            the timeout of a request in seconds, 0 means no timeout.
+//	                    With 'retries', it's the timeout of each attempt.
+//
+// The options of the retries, the rate limit and the circuit breaker are
+// described in httpclient.go.
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serverOptions are the parsed options of a server.
+type serverOptions struct {
+	tlsConfig *tls.Config //nil for plain http
+	http2     bool
+	templates string //the template directory of a service
+}
+
+// newServerOptions parses the options hash of a server, or of a service if
+// 'service' is true.
+func newServerOptions(opts *Hash, service bool) (*serverOptions, error) {
+	kind, names := "server", []string{"cert", "key", "clientCA", "clientAuth", "minVersion", "http2"}
+	if service {
+		kind, names = "service", append(names, "templates")
+	}
+	o, err := readOptions(opts, kind, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &serverOptions{templates: o.str("templates")}
+	cert, key := o.str("cert"), o.str("key")
+	if (cert == "") != (key == "") {
+		return nil, fmt.Errorf("%s options: 'cert' and 'key' must be given together", kind)
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		ret.tlsConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+		if ret.tlsConfig.MinVersion, err = o.tlsVersion(); err != nil {
+			return nil, err
+		}
+
+		if ca := o.str("clientCA"); ca != "" {
+			pool, err := loadCertPool(ca)
+			if err != nil {
+				return nil, err
+			}
+			ret.tlsConfig.ClientCAs = pool
+			ret.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		switch o.str("clientAuth") {
+		case "", "require":
+		case "verify":
+			ret.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("%s options: unknown clientAuth %q", kind, o.str("clientAuth"))
+		}
+		if ret.tlsConfig.ClientCAs == nil && o.str("clientAuth") != "" {
+			return nil, fmt.Errorf("%s options: 'clientAuth' needs 'clientCA'", kind)
+		}
+	} else if o.str("clientCA") != "" || o.str("clientAuth") != "" || o.str("minVersion") != "" {
+		return nil, fmt.Errorf("%s options: the TLS options need 'cert' and 'key'", kind)
+	}
+	ret.http2 = o.boolean("http2", ret.tlsConfig != nil)
+	return ret, nil
+}
+
+// apply sets the options to the server, a nil options is plain HTTP/1.
+func (o *serverOptions) apply(srv *http.Server) {
+	if o == nil {
+		return
+	}
+	srv.TLSConfig = o.tlsConfig
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if o.http2 {
+		if o.tlsConfig != nil {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+	srv.Protocols = protocols
+}
+
+// newClient returns the http client of the options hash of 'http.newClient'.
+func newClient(opts *Hash) (*HttpClient, error) {
+	o, err := readOptions(opts, "client", "caFile", "insecureSkipVerify", "cert", "key", "minVersion", "http2", "timeout",
+		"totalTimeout", "retries", "retryOn", "retryMethods", "backoff", "maxBackoff", "jitter", "rateLimit", "burst",
+		"breakerFailures", "breakerCooldown")
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.boolean("insecureSkipVerify", false)}
+	if tlsConfig.MinVersion, err = o.tlsVersion(); err != nil {
+		return nil, err
+	}
+	if ca := o.str("caFile"); ca != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(ca); err != nil {
+			return nil, err
+		}
+	}
+	cert, key := o.str("cert"), o.str("key")
+	if (cert == "") != (key == "") {
+		return nil, errors.New("client options: 'cert' and 'key' must be given together")
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.ForceAttemptHTTP2 = o.boolean("http2", true)
+	if !transport.ForceAttemptHTTP2 {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		transport.Protocols = protocols
+	}
+
+	client := &http.Client{Transport: transport}
+	if timeout, ok := o.values["timeout"]; ok {
+		seconds, ok := toFloat64(timeout)
+		if !ok {
+			return nil, fmt.Errorf("client options: 'timeout' should be a number, got=%s", timeout.Type())
+		}
+		client.Timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	ret := &HttpClient{Client: client}
+	if err := ret.readPolicy(o); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// options are the values of an options hash.
+type options struct {
+	kind   string //'server', 'service' or 'client', used in the error messages
+	values map[string]Object
+}
+
+// readOptions reads the options hash, the keys must be one of 'names'.
+func readOptions(opts *Hash, kind string, names ...string) (*options, error) {
+	o := &options{kind: kind, values: make(map[string]Object)}
+	if opts == nil {
+		return o, nil
+	}
+	for _, hk := range opts.Order {
+		pair := opts.Pairs[hk]
+		key, ok := pair.Key.(*String)
+		if !ok {
+			return nil, fmt.Errorf("%s options: the keys should be strings, got=%s", kind, pair.Key.Type())
+		}
+		known := false
+		for _, name := range names {
+			if name == key.String {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%s options: unknown option '%s'", kind, key.String)
+		}
+		if pair.Value.Type() != NIL_OBJ {
+			o.values[key.String] = pair.Value
+		}
+	}
+
+	//check the types of the options
+	for name, val := range o.values {
+		switch name {
+		case "http2", "insecureSkipVerify", "jitter":
+			if _, ok := val.(*Boolean); !ok {
+				return nil, fmt.Errorf("%s options: '%s' should be a boolean, got=%s", kind, name, val.Type())
+			}
+		case "retryOn", "retryMethods":
+			if _, ok := val.(*Array); !ok {
+				return nil, fmt.Errorf("%s options: '%s' should be an array, got=%s", kind, name, val.Type())
+			}
+		case "timeout", "totalTimeout", "retries", "backoff", "maxBackoff", "rateLimit", "burst", "breakerFailures", "breakerCooldown":
+		default:
+			if _, ok := val.(*String); !ok {
+				return nil, fmt.Errorf("%s options: '%s' should be a string, got=%s", kind, name, val.Type())
+			}
+		}
+	}
+	return o, nil
+}
+
+func (o *options) str(name string) string {
+	if s, ok := o.values[name].(*String); ok {
+		return s.String
+	}
+	return ""
+}
+
+func (o *options) boolean(name string, defaultValue bool) bool {
+	if b, ok := o.values[name].(*Boolean); ok {
+		return b.Bool
+	}
+	return defaultValue
+}
+
+func (o *options) tlsVersion() (uint16, error) {
+	v := o.str("minVersion")
+	if v == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("%s options: unknown minVersion %q", o.kind, v)
+	}
+	return version, nil
+}
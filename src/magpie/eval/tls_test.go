@@ -0,0 +1,224 @@
+package eval
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCerts writes a self-signed CA, a server certificate of 127.0.0.1 and a
+// client certificate signed by the CA to 'dir', it returns the file names.
+func testCerts(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	write := func(name string, typ string, der []byte) {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		files[name] = file
+	}
+	newKey := func(name string) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name, "EC PRIVATE KEY", der)
+		return key
+	}
+
+	caKey := newKey("ca.key")
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "magpie test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("ca.crt", "CERTIFICATE", der)
+
+	for i, name := range []string{"server", "client"} {
+		key := newKey(name + ".key")
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		if name == "server" {
+			cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			cert.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		} else {
+			cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name+".crt", "CERTIFICATE", der)
+	}
+	return files
+}
+
+const fetchInput = `
+fn fetch(client, url) {
+    let resp = client.get(url)
+    if resp == nil {
+        return "error"
+    }
+    defer resp.closeBody()
+    return resp.proto() + " " + resp.status() + " " + resp.readAll()
+}
+`
+
+func TestServiceTLS(t *testing.T) {
+	certs := testCerts(t, t.TempDir())
+	input := fmt.Sprintf(`
+service Secure on "127.0.0.1:0", {"cert": %q, "key": %q, "minVersion": "1.3"} {
+    @route(url="/ping", methods=["GET"])
+    fn ping(writer, request) {
+        writer.write("pong")
+    }
+}
+let url = "https://" + Secure.addr() + "/ping"
+let trusted = http.newClient({"caFile": %q, "timeout": 5})
+let http1 = http.newClient({"caFile": %q, "http2": false})
+let insecure = http.newClient({"insecureSkipVerify": true})
+let untrusted = http.newClient()
+`, certs["server.crt"], certs["server.key"], certs["ca.crt"], certs["ca.crt"])
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(fetchInput + input); err != nil {
+		t.Fatal(err)
+	}
+	defer interp.Run("Secure.stop()")
+
+	tests := []struct{ client, expected string }{
+		{"trusted", "HTTP/2.0 200 pong"},
+		{"http1", "HTTP/1.1 200 pong"},
+		{"insecure", "HTTP/2.0 200 pong"},
+		{"untrusted", "error"},
+	}
+	for _, tt := range tests {
+		result, err := interp.Run("fetch(" + tt.client + ", url)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.client, tt.expected, result.Inspect())
+		}
+	}
+
+	//the minimum version is TLS 1.3
+	addr, _ := interp.Run("Secure.addr()")
+	conn, err := tls.Dial("tcp", addr.Inspect(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		conn.Close()
+		t.Errorf("expected the TLS 1.2 handshake to fail")
+	}
+}
+
+func TestServiceMutualTLS(t *testing.T) {
+	certs := testCerts(t, t.TempDir())
+	input := fmt.Sprintf(`
+service Secure on "127.0.0.1:0", {"cert": %q, "key": %q, "clientCA": %q} {
+    @route(url="/ping", methods=["GET"])
+    fn ping(writer, request) {
+        writer.write("pong")
+    }
+}
+let url = "https://" + Secure.addr() + "/ping"
+let anonymous = http.newClient({"caFile": %q})
+let client = http.newClient({"caFile": %q, "cert": %q, "key": %q})
+`, certs["server.crt"], certs["server.key"], certs["ca.crt"], certs["ca.crt"], certs["ca.crt"], certs["client.crt"], certs["client.key"])
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(fetchInput + input); err != nil {
+		t.Fatal(err)
+	}
+	defer interp.Run("Secure.stop()")
+
+	for _, tt := range []struct{ client, expected string }{{"anonymous", "error"}, {"client", "HTTP/2.0 200 pong"}} {
+		result, err := interp.Run("fetch(" + tt.client + ", url)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.client, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestHttpServerH2C(t *testing.T) {
+	input := `
+let server = http.newServer("127.0.0.1:0", fn(writer, request) { writer.write("hi") }, {"http2": true})
+server.start()
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	defer interp.Run("server.stop()")
+
+	addr, _ := interp.Run("server.addr()")
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Get("http://" + addr.Inspect() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2.0, got=%s", resp.Proto)
+	}
+}
+
+func TestTLSOptionErrors(t *testing.T) {
+	certs := testCerts(t, t.TempDir())
+	tests := []struct{ input, expected string }{
+		{`http.newClient({"timeout": "1s"})`, "'timeout' should be a number"},
+		{`http.newClient({"http2": 1})`, "'http2' should be a boolean"},
+		{`http.newClient({"proxy": "localhost"})`, "unknown option 'proxy'"},
+		{`http.newClient({"minVersion": "2.0"})`, `unknown minVersion "2.0"`},
+		{`http.newClient({"caFile": "no-such-file.crt"})`, "no-such-file.crt"},
+		{fmt.Sprintf(`http.newServer(":0", {"cert": %q})`, certs["server.crt"]), "'cert' and 'key' must be given together"},
+		{`http.newServer(":0", {"minVersion": "1.3"})`, "the TLS options need 'cert' and 'key'"},
+		{fmt.Sprintf(`http.newServer(":0", {"cert": %q, "key": %q, "clientAuth": "verify"})`, certs["server.crt"], certs["server.key"]), "'clientAuth' needs 'clientCA'"},
+		{fmt.Sprintf(`http.newServer(":0", {"cert": %q, "key": %q, "clientCA": %q, "clientAuth": "always"})`, certs["server.crt"], certs["server.key"], certs["ca.crt"]), `unknown clientAuth "always"`},
+		{`http.newServer(":0", 1)`, "*Function|*Hash"},
+		{`service Bad on ":0", [1] {}`, "*Hash"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.NoListen = true
+		_, err := interp.Run(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package eval
+
+import (
+	"fmt"
+	"magpie/ast"
+	"strings"
+)
+
+// The type annotations(e.g. 'fn add(a: int, b: int): int') are ignored when a
+// program runs, unless they're checked at runtime(Interpreter.CheckTypes or
+// 'magpie --check-types'). Then the arguments of the function calls, the
+// return values and the values of the lets are checked against them. The
+// types are the ones of the type patterns of 'case', or classes.
+
+// checkArgTypes checks the arguments of a call to the function 'fl' against
+// the parameters' type annotations. The elements of a variadic parameter are
+// checked one by one.
+func checkArgTypes(line string, fl *ast.FunctionLiteral, name string, args []Object, scope *Scope) Object {
+	if len(fl.ParamTypes) == 0 || !scope.interp.checkTypes() {
+		return nil
+	}
+
+	for i, arg := range args {
+		idx := i
+		if idx >= len(fl.Parameters) {
+			if !fl.Variadic {
+				break
+			}
+			idx = len(fl.Parameters) - 1
+		}
+		param := fl.Parameters[idx].String()
+		if t, ok := fl.ParamTypes[param]; ok {
+			what := fmt.Sprintf("argument '%s' of '%s'", param, name)
+			if errObj := checkType(line, t, arg, what, scope); errObj != nil {
+				return errObj
+			}
+		}
+	}
+	return nil
+}
+
+// checkReturnType returns 'val' if it's of the return type of 'fl', otherwise
+// an error.
+func checkReturnType(line string, fl *ast.FunctionLiteral, name string, val Object, scope *Scope) Object {
+	if fl.ReturnType == nil || val.Type() == ERROR_OBJ || !scope.interp.checkTypes() {
+		return val
+	}
+
+	what := fmt.Sprintf("return value of '%s'", name)
+	if errObj := checkType(line, fl.ReturnType, val, what, scope); errObj != nil {
+		return errObj
+	}
+	return val
+}
+
+// checkLetType checks the value of the idx-th name of the let statement.
+func checkLetType(l *ast.LetStatement, idx int, val Object, scope *Scope) Object {
+	if idx >= len(l.Types) || l.Types[idx] == nil || !scope.interp.checkTypes() {
+		return nil
+	}
+
+	what := fmt.Sprintf("'%s'", l.Names[idx].Value)
+	return checkType(l.Names[idx].Pos().Sline(), l.Types[idx], val, what, scope)
+}
+
+// checkType returns an error if 'val' isn't of one of the types of 't'.
+func checkType(line string, t *ast.TypeAnnotation, val Object, what string, scope *Scope) Object {
+	for _, name := range t.Types {
+		ok, errObj := matchType(line, name.Value, val, scope, TYPEERROR)
+		if errObj != nil {
+			return errObj
+		}
+		if ok {
+			return nil
+		}
+	}
+	return NewError(line, TYPEERROR, fmt.Sprintf("%s should be %s, got %s", what, t, annotationType(val)))
+}
+
+// annotationType returns the type of 'val' as it's written in the type
+// annotations, e.g. 'int' for an INTEGER, or the class of an instance.
+func annotationType(val Object) string {
+	switch val.Type() {
+	case INTEGER_OBJ:
+		return "int"
+	case UINTEGER_OBJ:
+		return "uint"
+	case BOOLEAN_OBJ:
+		return "bool"
+	case NIL_OBJ:
+		return "nil"
+	}
+
+	switch v := val.(type) {
+	case *ObjectInstance:
+		return v.Class.Name
+	case *Function, *Builtin:
+		return "fn"
+	}
+	return strings.ToLower(string(val.Type()))
+}
@@ -0,0 +1,76 @@
+package eval
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const typecheckInput = `
+class Point { let x = 0 }
+class Point3 : Point {}
+
+fn add(a: int, b: int): int { a + b }
+fn name(p: Point | nil): string { p == nil ? "none" : "point" }
+fn bad(): int { return "s" }
+fn sum(first: number, rest: int...) { first }
+`
+
+func TestCheckTypes(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`add(1, 2)`, "3"},
+		{`name(nil)`, "none"},
+		{`name(new Point3())`, "point"},
+		{`sum(1.5, 2, 3)`, "1.5"},
+		{`let x: int | nil = nil; x`, "nil"},
+		{`let f: fn = add; f(1, 1)`, "2"},
+		//a let without a value isn't checked
+		{`let y: int; y`, "nil"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.CheckTypes = true
+		result, err := interp.Run(typecheckInput + tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestCheckTypesErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`add(1, "2")`, "argument 'b' of 'add' should be int, got string"},
+		{`name(1)`, "argument 'p' of 'name' should be Point | nil, got int"},
+		{`bad()`, "return value of 'bad' should be int, got string"},
+		{`sum(1, 2, "3")`, "argument 'rest' of 'sum' should be int, got string"},
+		{`let z: int | nil = "s"`, "'z' should be int | nil, got string"},
+		{`let w: Foo = 1`, "unknown type 'Foo'"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.CheckTypes = true
+		_, err := interp.Run(typecheckInput + tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	//the annotations are ignored unless they're checked
+	interp := NewInterpreter(&bytes.Buffer{})
+	result, err := interp.Run(typecheckInput + `add(1, "2")`)
+	if err != nil || result.Inspect() != "12" {
+		t.Errorf("expected the annotations to be ignored, got=%v %v", result, err)
+	}
+
+	//the type errors are TypeErrors
+	interp = NewInterpreter(&bytes.Buffer{})
+	interp.CheckTypes = true
+	result, err = interp.Run(typecheckInput + "let r = \"\"\ntry { bad() } catch (e: TypeError) { r = \"caught\" }\nr")
+	if err != nil || result.Inspect() != "caught" {
+		t.Errorf("expected the TypeError to be caught, got=%v %v", result, err)
+	}
+}
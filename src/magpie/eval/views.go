@@ -0,0 +1,192 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	html "html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Static files and html views of the services:
+//
+//	service Web on "127.0.0.1:8080", {"templates": "./views"} {
+//	    @static(url="/assets", dir="./public", maxAge=3600)
+//
+//	    @route(url="/users")
+//	    fn users(writer, request) {
+//	        writer.render("users/list.html", {"users": ["alice", "bob"]})
+//	    }
+//	}
+//
+// '@static' serves the files of 'dir' under 'url'. 'writer.render' executes a
+// html template of the 'templates' directory, the templates are named by their
+// paths relative to the directory. With the ':debug' address option, the
+// templates are parsed again on each render, so the changes are seen without
+// restarting the service.
+
+// staticHandler serves the files of 'dir' under the url 'prefix'. The
+// directories are not listed, their 'index.html' is served instead if there's
+// one. 'maxAge' is the seconds of the 'Cache-Control' header, 0 means the
+// clients revalidate each time. The conditional('If-None-Match',
+// 'If-Modified-Since') and range requests are answered by http.ServeContent.
+func staticHandler(prefix string, dir string, maxAge int64) http.Handler {
+	root := http.Dir(dir)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+		f, err := root.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err == nil && fi.IsDir() {
+			index, ierr := root.Open(path.Join(name, "index.html"))
+			if ierr != nil {
+				http.NotFound(w, r)
+				return
+			}
+			defer index.Close()
+			f = index
+			fi, err = f.Stat()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		header := w.Header()
+		if maxAge > 0 {
+			header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		} else {
+			header.Set("Cache-Control", "no-cache")
+		}
+		header.Set("ETag", fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	})
+}
+
+// handleStatic registers the '@static' route of 'url', the middlewares 'mws'
+// wrap the file server.
+func (s *ServiceObj) handleStatic(url string, dir string, maxAge int64, mws []MiddlewareFunc) {
+	prefix := strings.TrimSuffix(url, "/")
+	handler := staticHandler(prefix, dir, maxAge)
+	s.Route = s.Router.PathPrefix(prefix+"/").Handler(chainMiddlewares(handler, mws)).Methods("GET", "HEAD")
+}
+
+// serviceViews are the html templates of a service's 'templates' directory.
+type serviceViews struct {
+	dir    string
+	reload bool //parses the templates on each render, in debug mode
+
+	mux  sync.Mutex
+	tmpl *TemplateObj
+}
+
+// newServiceViews parses the templates of 'dir', so the errors are reported
+// when the service is declared.
+func newServiceViews(dir string, reload bool) (*serviceViews, error) {
+	v := &serviceViews{dir: dir, reload: reload}
+	if _, err := v.templates(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// templates returns the parsed templates, they are parsed again if 'reload'.
+func (v *serviceViews) templates() (*TemplateObj, error) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if v.tmpl != nil && !v.reload {
+		return v.tmpl, nil
+	}
+	tmpl, err := parseTemplateDir(v.dir)
+	if err != nil {
+		return nil, err
+	}
+	v.tmpl = &TemplateObj{TmplType: T_HTML, HTMLTemplate: tmpl}
+	return v.tmpl, nil
+}
+
+// parseTemplateDir parses the files under 'dir' as html templates, which are
+// named by their slash separated paths relative to 'dir', e.g. 'users/list.html'.
+// The hidden files are skipped.
+func parseTemplateDir(dir string) (*html.Template, error) {
+	root := html.New("")
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && file != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		_, err = root.New(filepath.ToSlash(rel)).Parse(string(contents))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// render executes the template 'name' with 'data', the result is buffered so
+// nothing is written if it fails.
+func (v *serviceViews) render(w http.ResponseWriter, name string, data Object) error {
+	tmpl, err := v.templates()
+	if err != nil {
+		return err
+	}
+
+	var raw interface{}
+	if data != nil && data.Type() != NIL_OBJ {
+		res, err := marshalJsonObject(data)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(res.Bytes(), &raw); err != nil {
+			return err
+		}
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.HTMLTemplate.ExecuteTemplate(&out, name, raw); err != nil {
+		return err
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// requestViews returns the views of the service serving the request, or nil.
+func requestViews(r *http.Request) *serviceViews {
+	if rv := r.Context().Value(viewsKey); rv != nil {
+		return rv.(*serviceViews)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files of 'files'(the slash separated paths relative
+// to 'dir', and their contents).
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serviceHandler runs 'input' without listening, and returns the handler of
+// the service 'name'.
+func serviceHandler(t *testing.T, input string, name string) http.Handler {
+	t.Helper()
+	interp := NewInterpreter(&bytes.Buffer{})
+	interp.NoListen = true
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	svc, ok := interp.Get(name)
+	if !ok {
+		t.Fatalf("no service %s", name)
+	}
+	return svc.(*ServiceObj).Handler()
+}
+
+func TestServiceStatic(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"public/app.css":         "body { color: red }",
+		"public/index.html":      "<h1>home</h1>",
+		"public/docs/readme.txt": "readme",
+		"secret.txt":             "secret",
+	})
+	handler := serviceHandler(t, fmt.Sprintf(`
+service Web on "127.0.0.1:0" {
+    @static(url="/assets", dir=%q, maxAge=3600)
+    @static(url="/nocache/", dir=%q)
+
+    @route(url="/assets/api")
+    fn api(writer, request) {
+        return { "api": true }
+    }
+}
+`, filepath.Join(dir, "public"), filepath.Join(dir, "public")), "Web")
+
+	serve := func(method, url string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		method, url string
+		status      int
+		body        string
+	}{
+		{"GET", "/assets/app.css", 200, "body { color: red }"},
+		{"HEAD", "/assets/app.css", 200, ""},
+		{"GET", "/assets/", 200, "<h1>home</h1>"},
+		{"GET", "/assets/docs/readme.txt", 200, "readme"},
+		{"GET", "/assets/docs/", 404, "404 page not found\n"},
+		{"GET", "/assets/none.css", 404, "404 page not found\n"},
+		{"GET", "/assets/../secret.txt", 301, ""},   //the router redirects to the clean path
+		{"GET", "/assets/api", 200, `{"api":true}`}, //the routes take precedence
+		{"POST", "/assets/app.css", 405, ""},
+		{"GET", "/nocache/app.css", 200, "body { color: red }"},
+	}
+	for _, tt := range tests {
+		w := serve(tt.method, tt.url, nil)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s %s: expected %d %q, got=%d %q", tt.method, tt.url, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	//the files outside of the directory are not served
+	req := httptest.NewRequest("GET", "/assets/app.css", nil)
+	req.URL.Path = "/assets/../secret.txt"
+	w := httptest.NewRecorder()
+	staticHandler("/assets", filepath.Join(dir, "public"), 0).ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected 404 outside of the directory, got=%d %q", w.Code, w.Body.String())
+	}
+
+	w = serve("GET", "/assets/app.css", nil)
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("unexpected Cache-Control %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+	if got := serve("GET", "/nocache/app.css", nil).Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("unexpected Cache-Control %q without maxAge", got)
+	}
+
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("expected ETag and Last-Modified, got=%v", w.Header())
+	}
+	if w := serve("GET", "/assets/app.css", map[string]string{"If-None-Match": etag}); w.Code != 304 {
+		t.Errorf("If-None-Match: expected 304, got=%d", w.Code)
+	}
+	if w := serve("GET", "/assets/app.css", map[string]string{"If-Modified-Since": modified}); w.Code != 304 {
+		t.Errorf("If-Modified-Since: expected 304, got=%d", w.Code)
+	}
+	w = serve("GET", "/assets/app.css", map[string]string{"Range": "bytes=0-3"})
+	if w.Code != 206 || w.Body.String() != "body" || w.Header().Get("Content-Range") != "bytes 0-3/19" {
+		t.Errorf("Range: expected 206 \"body\", got=%d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestServiceRender(t *testing.T) {
+	dir := t.TempDir()
+	views := filepath.Join(dir, "views")
+	writeFiles(t, views, map[string]string{
+		"layout.html":     `{{define "header"}}<title>{{.title}}</title>{{end}}`,
+		"users/list.html": `{{template "header" .}}<ul>{{range .users}}<li>{{.}}</li>{{end}}</ul>`,
+		".swp/broken":     `{{`,
+	})
+	input := `
+service Web on "127.0.0.1:0%s", {"templates": %q} {
+    @route(url="/users")
+    fn users(writer, request) {
+        writer.render("users/list.html", {"title": "Users", "users": ["alice", "<bob>"]})
+    }
+
+    @route(url="/missing")
+    fn missing(writer, request) {
+        if !writer.render("missing.html") {
+            return { "error": "no template" }, 500
+        }
+    }
+}
+`
+	handler := serviceHandler(t, fmt.Sprintf(input, "", views), "Web")
+	serve := func(h http.Handler, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := serve(handler, "/users")
+	expected := "<title>Users</title><ul><li>alice</li><li>&lt;bob&gt;</li></ul>"
+	if w.Body.String() != expected {
+		t.Errorf("expected %q, got=%q", expected, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+	if w := serve(handler, "/missing"); w.Code != 500 || w.Body.String() != `{"error":"no template"}` {
+		t.Errorf("expected the missing template to fail, got=%d %q", w.Code, w.Body.String())
+	}
+
+	//the templates are parsed once, unless in debug mode
+	debugHandler := serviceHandler(t, fmt.Sprintf(input, ":debug", views), "Web")
+	writeFiles(t, views, map[string]string{"users/list.html": `{{len .users}} users`})
+	if got := serve(handler, "/users").Body.String(); got != expected {
+		t.Errorf("expected the parsed template, got=%q", got)
+	}
+	if got := serve(debugHandler, "/users").Body.String(); got != "2 users" {
+		t.Errorf("expected the template reloaded in debug mode, got=%q", got)
+	}
+}
+
+func TestServiceViewErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"broken.html": "{{if}}"})
+	tests := []struct{ input, expected string }{
+		{`service Web on ":0", {"templates": "no-such-dir"} {}`, "no-such-dir"},
+		{fmt.Sprintf(`service Web on ":0", {"templates": %q} {}`, dir), "broken.html"},
+		{`http.newServer(":0", {"templates": "views"})`, "unknown option 'templates'"},
+		{`service Web on ":0" { @static(dir="public") }`, "'@static' must have a 'url' string"},
+		{`service Web on ":0" { @static(url="/assets") }`, "'@static' must have a 'dir' string"},
+		{`service Web on ":0" { @static(url="/assets", dir="public", maxAge="1h") }`, "*Integer"},
+		{`service Web on ":0" { @static(url="/assets", dir="public", before=1) }`, "*Function"},
+	}
+	for _, tt := range tests {
+		interp := NewInterpreter(&bytes.Buffer{})
+		interp.NoListen = true
+		_, err := interp.Run(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	//'render' without the 'templates' option
+	w := &HttpResponseWriter{Writer: httptest.NewRecorder()}
+	if result := w.Render("1", NewString("index.html")); result.Type() != ERROR_OBJ || !strings.Contains(result.Inspect(), "no templates") {
+		t.Errorf("expected the error of no templates, got=%s", result.Inspect())
+	}
+}
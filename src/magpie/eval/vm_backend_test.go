@@ -0,0 +1,15 @@
+package eval_test
+
+import (
+	"magpie/ast"
+	"magpie/eval"
+	"magpie/vm"
+)
+
+// The inputs of the evaluator's tests are run with the vm too(see 'testEval'),
+// so the two backends can't drift apart.
+func init() {
+	eval.OtherBackend = func(program *ast.Program, scope *eval.Scope) eval.Object {
+		return vm.New().Run(program, scope)
+	}
+}
@@ -0,0 +1,453 @@
+package eval
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"magpie/ast"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	WEBSOCKET_OBJ = "WEBSOCKET_OBJ"
+)
+
+// the GUID of the opening handshake(RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the maximum size of a received message
+const maxWebSocketMessage = 32 << 20
+
+// frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseNoStatus      = 1005 //never sent, it means the close frame has no code
+	wsCloseInvalidData   = 1007
+	wsCloseTooBig        = 1009
+	wsCloseInternalError = 1011
+)
+
+// wsError is a protocol error, the connection is closed with its code.
+type wsError struct {
+	code int
+	msg  string
+}
+
+func (e *wsError) Error() string { return "websocket: " + e.msg }
+
+// WebSocket is the server side of a websocket connection, it's the first
+// parameter of the '@websocket' functions of a service, or the result of
+// 'http.upgrade(writer, request)':
+//
+//	@websocket(url="/chat/{room}")
+//	fn chat(conn, request) {
+//	    for msg in conn {           //until the client closes the connection
+//	        conn.send(vars["room"] + ": " + msg)
+//	    }
+//	}
+type WebSocket struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	writeMux  sync.Mutex //the frames could be sent from several goroutines
+	closeSent bool
+	closeOnce sync.Once
+}
+
+// Make the websocket could be used in `for msg in conn`
+func (ws *WebSocket) iter() bool { return true }
+
+// Implement the 'Closeable' interface
+func (ws *WebSocket) close(line string, args ...Object) Object {
+	return ws.Close(line, args...)
+}
+
+func (ws *WebSocket) Inspect() string  { return fmt.Sprintf("<websocket %s>", ws.conn.RemoteAddr()) }
+func (ws *WebSocket) Type() ObjectType { return WEBSOCKET_OBJ }
+func (ws *WebSocket) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
+	switch method {
+	case "send":
+		return ws.Send(line, args...)
+	case "recv":
+		return ws.Recv(line, args...)
+	case "close":
+		return ws.Close(line, args...)
+	case "remoteAddr":
+		if len(args) != 0 {
+			return NewError(line, ARGUMENTERROR, "0", len(args))
+		}
+		return NewString(ws.conn.RemoteAddr().String())
+	}
+	return NewError(line, NOMETHODERROR, method, ws.Type())
+}
+
+// Send sends a text message, the hashes and arrays are sent as json. It
+// returns false with the reason if the message couldn't be sent.
+func (ws *WebSocket) Send(line string, args ...Object) Object {
+	if len(args) != 1 {
+		return NewError(line, ARGUMENTERROR, "1", len(args))
+	}
+
+	var payload []byte
+	switch o := args[0].(type) {
+	case *String:
+		payload = []byte(o.String)
+	case json.Marshaler: //Hash, Array...
+		b, err := o.MarshalJSON()
+		if err != nil {
+			return NewFalseObj(err.Error())
+		}
+		payload = b
+	default:
+		return NewError(line, PARAMTYPEERROR, "first", "send", "*String|*Hash|*Array", args[0].Type())
+	}
+
+	if err := ws.writeFrame(wsText, payload); err != nil {
+		return NewFalseObj(err.Error())
+	}
+	return TRUE
+}
+
+// Recv blocks until a message is received. It returns nil when the connection
+// is closed, with the reason if it isn't closed normally.
+func (ws *WebSocket) Recv(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return NewError(line, ARGUMENTERROR, "0", len(args))
+	}
+
+	msg, err := ws.readMessage()
+	if err == io.EOF {
+		return NIL
+	}
+	if err != nil {
+		return NewNil(err.Error())
+	}
+	return NewString(string(msg))
+}
+
+// Close sends a close frame with the optional code(default 1000) and
+// reason, and closes the connection.
+func (ws *WebSocket) Close(line string, args ...Object) Object {
+	if len(args) > 2 {
+		return NewError(line, ARGUMENTERROR, "0..2", len(args))
+	}
+
+	code, reason := wsCloseNormal, ""
+	if len(args) > 0 {
+		c, ok := args[0].(*Integer)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "first", "close", "*Integer", args[0].Type())
+		}
+		code = int(c.Int64)
+	}
+	if len(args) > 1 {
+		r, ok := args[1].(*String)
+		if !ok {
+			return NewError(line, PARAMTYPEERROR, "second", "close", "*String", args[1].Type())
+		}
+		reason = r.String
+	}
+
+	ws.closeWith(code, reason)
+	return NIL
+}
+
+// closeWith sends a close frame(if it isn't sent yet) and closes the connection.
+func (ws *WebSocket) closeWith(code int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(reason) > 123 { //control frames have at most 125 bytes
+		reason = reason[:123]
+	}
+	ws.writeFrame(wsClose, append(payload, reason...))
+	ws.closeOnce.Do(func() { ws.conn.Close() })
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMux.Lock()
+	defer ws.writeMux.Unlock()
+	if ws.closeSent {
+		return errors.New("websocket: connection closed")
+	}
+	if opcode == wsClose {
+		ws.closeSent = true
+	}
+
+	header := []byte{0x80 | opcode, 0} //FIN, the server's frames aren't masked
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		header = append(header, b[:]...)
+	}
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+func (ws *WebSocket) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(ws.rw, h[:]); err != nil {
+		return
+	}
+	fin, opcode = h[0]&0x80 != 0, h[0]&0x0F
+	if h[0]&0x70 != 0 {
+		err = &wsError{wsCloseProtocolError, "reserved bits set"}
+		return
+	}
+	if h[1]&0x80 == 0 {
+		err = &wsError{wsCloseProtocolError, "unmasked client frame"}
+		return
+	}
+
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(ws.rw, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(ws.rw, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if opcode >= wsClose && (n > 125 || !fin) {
+		err = &wsError{wsCloseProtocolError, "invalid control frame"}
+		return
+	}
+	if n > maxWebSocketMessage {
+		err = &wsError{wsCloseTooBig, "message too big"}
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.rw, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.rw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// readMessage returns the next text or binary message, the control frames
+// are handled on the way. It returns io.EOF if the client closes the
+// connection, and closes the connection on errors.
+func (ws *WebSocket) readMessage() ([]byte, error) {
+	var msg []byte
+	var msgOpcode byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			if e, ok := err.(*wsError); ok {
+				ws.closeWith(e.code, e.msg)
+			} else {
+				ws.closeOnce.Do(func() { ws.conn.Close() })
+				if err == io.ErrUnexpectedEOF || errors.Is(err, net.ErrClosed) {
+					err = io.EOF
+				}
+			}
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 && binary.BigEndian.Uint16(payload) != wsCloseNoStatus {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			ws.closeWith(code, "") //echo the close frame
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if msgOpcode != 0 {
+				ws.closeWith(wsCloseProtocolError, "expected a continuation frame")
+				return nil, errors.New("websocket: expected a continuation frame")
+			}
+			msgOpcode, msg = opcode, payload
+		case wsContinuation:
+			if msgOpcode == 0 {
+				ws.closeWith(wsCloseProtocolError, "unexpected continuation frame")
+				return nil, errors.New("websocket: unexpected continuation frame")
+			}
+			if len(msg)+len(payload) > maxWebSocketMessage {
+				ws.closeWith(wsCloseTooBig, "message too big")
+				return nil, errors.New("websocket: message too big")
+			}
+			msg = append(msg, payload...)
+		default:
+			ws.closeWith(wsCloseProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("websocket: unknown opcode %d", opcode)
+		}
+
+		if fin {
+			if msgOpcode == wsText && !utf8.Valid(msg) {
+				ws.closeWith(wsCloseInvalidData, "invalid utf-8")
+				return nil, errors.New("websocket: invalid utf-8 text message")
+			}
+			return msg, nil
+		}
+	}
+}
+
+// UpgradeWebSocket upgrades an http request to a websocket connection. If
+// it fails, an error response is written. The 'Origin' of the request must
+// be one of 'origins', or the request's host without them, see checkOrigin.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, origins []string) (*WebSocket, error) {
+	fail := func(status int, msg string) (*WebSocket, error) {
+		if status == http.StatusUpgradeRequired {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(w, msg, status)
+		return nil, errors.New("websocket: " + msg)
+	}
+	if r.Method != "GET" {
+		return fail(http.StatusMethodNotAllowed, "the method must be GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, "missing Sec-WebSocket-Key")
+	}
+	if !checkOrigin(r, origins) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	conn.SetDeadline(time.Time{}) //clear the timeouts of the server
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	w.Header().Write(rw) //e.g. the headers set by the middlewares
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocket{conn: conn, rw: rw}, nil
+}
+
+// checkOrigin reports whether the 'Origin' of the handshake is allowed, so
+// the pages of other sites couldn't connect with the cookies of the user.
+// It must be one of 'origins'("*" allows any), or without them, its host
+// must be the request's host. The requests without an 'Origin' aren't from
+// the browsers, they're allowed.
+func checkOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(origins) > 0 {
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// websocketOrigins returns the allowed origins of an array of strings, e.g.
+// '@websocket(url="/chat", origins=["https://example.com"])'.
+func websocketOrigins(line string, nth string, method string, val Object) ([]string, Object) {
+	arr, ok := val.(*Array)
+	if !ok {
+		return nil, NewError(line, PARAMTYPEERROR, nth, method, "*Array", val.Type())
+	}
+	var origins []string
+	for _, v := range arr.Members {
+		str, ok := v.(*String)
+		if !ok {
+			return nil, NewError(line, GENERICERROR, "'origins' must be an array of strings")
+		}
+		origins = append(origins, str.String)
+	}
+	return origins, nil
+}
+
+// headerContains reports whether the comma separated values of the header
+// contain 'token', case insensitively.
+func headerContains(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServeWebSocket upgrades the request and calls the '@websocket' function
+// 'f' with the connection, which is closed when the function returns.
+func ServeWebSocket(line string, scope *Scope, f *Function, origins []string, w http.ResponseWriter, r *http.Request) {
+	ws, err := UpgradeWebSocket(w, r, origins)
+	if err != nil {
+		return
+	}
+	s := newServiceScope(line, scope, f, w, r)
+	s.Set(f.Literal.Parameters[0].(*ast.Identifier).Value, ws)
+	if result := Eval(f.Literal.Body, s); result != nil && result.Type() == ERROR_OBJ {
+		ws.closeWith(wsCloseInternalError, "")
+		return
+	}
+	ws.closeWith(wsCloseNormal, "")
+}
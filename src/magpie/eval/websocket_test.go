@@ -0,0 +1,255 @@
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal websocket client for the tests.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWebSocket sends the handshake, with the 'Origin' header if origin isn't empty.
+func dialWebSocket(t *testing.T, addr, path, origin string) (*wsClient, string) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if origin != "" {
+		origin = "Origin: " + origin + "\r\n"
+	}
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+addr+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+origin+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	c := &wsClient{conn: conn, r: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(resp.Body)
+		conn.Close()
+		return nil, resp.Status + " " + string(body)
+	}
+	//the example of RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept: %q", accept)
+	}
+	return c, ""
+}
+
+func (c *wsClient) write(fin bool, opcode byte, payload string) {
+	b := []byte{opcode, 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i := 0; i < len(payload); i++ {
+		b = append(b, payload[i]^mask[i%4])
+	}
+	c.conn.Write(b)
+}
+
+func (c *wsClient) read() (byte, string) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return 0, err.Error()
+	}
+	n := int(h[1] & 0x7F)
+	if n == 126 {
+		var b [2]byte
+		io.ReadFull(c.r, b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	}
+	payload := make([]byte, n)
+	io.ReadFull(c.r, payload)
+	return h[0] & 0x0F, string(payload)
+}
+
+func TestServiceWebSocket(t *testing.T) {
+	input := `
+service Chat on "127.0.0.1:0" {
+    @before
+    fn tag(writer, request) {
+        writer.header().setHeader("X-Service", "chat")
+    }
+
+    @websocket(url="/rooms/{room}")
+    fn room(conn, request) {
+        conn.send("welcome to " + vars["room"])
+        for msg in conn {
+            if msg == "bye" {
+                conn.close(4000, "bye")
+                break
+            }
+            conn.send({"room": vars["room"], "msg": msg})
+        }
+        reports.send("left " + vars["room"])
+    }
+
+    @websocket(url="/recv")
+    fn recv(conn, request) {
+        reports.send(conn.recv())
+        reports.send(conn.recv() == nil)
+    }
+
+    @websocket(url="/app", origins=["https://app.example"])
+    fn app(conn, request) {
+        conn.send("hello app")
+    }
+
+    @route(url="/events")
+    fn events(writer, request) {
+        writer.sse("first line\nsecond line")
+        writer.sse("count", {"n": 1})
+    }
+}
+`
+	//the handlers report to the test through a channel
+	events := &ChanObject{ch: make(chan Object, 10)}
+	interp := NewInterpreter(&bytes.Buffer{})
+	interp.Set("reports", events)
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	svc, _ := interp.Get("Chat")
+	runner := &svc.(*ServiceObj).runner
+	defer runner.stop(time.Second)
+	addr := runner.addr()
+
+	c, failure := dialWebSocket(t, addr, "/rooms/go", "http://"+addr)
+	if c == nil {
+		t.Fatal(failure)
+	}
+	expect := func(opcode byte, payload string) {
+		t.Helper()
+		if op, p := c.read(); op != opcode || p != payload {
+			t.Errorf("expected frame %d %q, got=%d %q", opcode, payload, op, p)
+		}
+	}
+	expect(wsText, "welcome to go")
+	c.write(true, wsText, "hello")
+	expect(wsText, `{"room":"go","msg":"hello"}`)
+	//fragmented message with a ping in between
+	c.write(false, wsText, "hel")
+	c.write(true, wsPing, "p")
+	expect(wsPong, "p")
+	c.write(true, wsContinuation, "lo again")
+	expect(wsText, `{"room":"go","msg":"hello again"}`)
+	c.write(true, wsText, "bye")
+	expect(wsClose, "\x0f\xa0bye")
+	c.conn.Close()
+
+	//the client closes the connection
+	c, _ = dialWebSocket(t, addr, "/recv", "")
+	c.write(true, wsText, "only one")
+	c.write(true, wsClose, "\x03\xe8")
+	expect(wsClose, "\x03\xe8")
+	c.conn.Close()
+
+	for _, expected := range []string{"left go", "only one", "true"} {
+		select {
+		case e := <-events.ch:
+			if e.Inspect() != expected {
+				t.Errorf("expected the event %q, got=%q", expected, e.Inspect())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the event %q", expected)
+		}
+	}
+
+	//the origins
+	tests := []struct {
+		path   string
+		origin string
+		ok     bool
+	}{
+		{"/rooms/go", "http://evil.example", false},
+		{"/rooms/go", "http://" + strings.ToUpper(addr), true},
+		{"/app", "https://app.example", true},
+		{"/app", "http://" + addr, false},
+		{"/app", "", true},
+	}
+	for _, tt := range tests {
+		c, failure := dialWebSocket(t, addr, tt.path, tt.origin)
+		if c != nil {
+			c.conn.Close()
+		}
+		if tt.ok && c == nil {
+			t.Errorf("%s from %q: expected the upgrade, got=%s", tt.path, tt.origin, failure)
+		} else if !tt.ok && !strings.HasPrefix(failure, "403 Forbidden origin not allowed") {
+			t.Errorf("%s from %q: expected 403, got=%q", tt.path, tt.origin, failure)
+		}
+	}
+
+	//not a websocket handshake
+	resp, err := http.Get("http://" + addr + "/rooms/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("X-Service") != "chat" {
+		t.Errorf("expected 400 from the middlewares chain, got=%d %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, err = http.Get("http://" + addr + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	expected := "data: first line\ndata: second line\n\nevent: count\ndata: {\"n\":1}\n\n"
+	if string(body) != expected {
+		t.Errorf("expected events %q, got=%q", expected, body)
+	}
+}
+
+func TestHttpUpgrade(t *testing.T) {
+	input := `
+let server = http.newServer("127.0.0.1:0", fn(writer, request) {
+    let conn = http.upgrade(writer, request, ["https://app.example"])
+    if conn == nil { return }
+    for msg in conn { conn.send(strings.upper(msg)) }
+})
+server.start()
+`
+	interp := NewInterpreter(&bytes.Buffer{})
+	if _, err := interp.Run(input); err != nil {
+		t.Fatal(err)
+	}
+	server, _ := interp.Get("server")
+	runner := &server.(*HttpServer).runner
+	defer runner.stop(time.Second)
+
+	if _, failure := dialWebSocket(t, runner.addr(), "/", "https://other.example"); !strings.HasPrefix(failure, "403 ") {
+		t.Errorf("expected 403 from another origin, got=%q", failure)
+	}
+	c, failure := dialWebSocket(t, runner.addr(), "/", "https://app.example")
+	if c == nil {
+		t.Fatal(failure)
+	}
+	defer c.conn.Close()
+	c.write(true, wsText, "echo")
+	if op, p := c.read(); op != wsText || p != "ECHO" {
+		t.Errorf("expected ECHO, got=%d %q", op, p)
+	}
+	//unmasked frames are protocol errors
+	c.conn.Write([]byte{0x81, 1, 'x'})
+	if op, p := c.read(); op != wsClose || !strings.HasPrefix(p, "\x03\xea") {
+		t.Errorf("expected a close frame with 1002, got=%d %q", op, p)
+	}
+}
@@ -61,6 +61,7 @@ var keywords = map[string]int{
 	"qw":         1,
 	"unless":     1,
 	"spawn":      1,
+	"yield":      1,
 	"enum":       1,
 	"defer":      1,
 	"nil":        1,
@@ -91,6 +92,9 @@ var keywords = map[string]int{
 	"async":      1,
 	"await":      1,
 	"service":    1,
+	"macro":      1,
+	"quote":      1,
+	"unquote":    1,
 }
 
 const (
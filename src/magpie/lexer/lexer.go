@@ -263,9 +263,13 @@ func (l *Lexer) NextToken() token.Token {
 						tok = newToken(token.SLASH, l.ch)
 					}
 				} else { //regexp
-					tok.Literal = l.readRegExLiteral()
-					tok.Type = token.REGEX
-					tok.Pos = pos
+					if s, err := l.readRegExLiteral(); err == nil {
+						tok.Literal = s
+						tok.Type = token.REGEX
+						tok.Pos = pos
+						return tok
+					}
+					tok = token.Token{Pos: pos, Type: token.ILLEGAL, Literal: "/"}
 					return tok
 				}
 			}
@@ -677,7 +681,7 @@ func (l *Lexer) readIdentifier() token.Token {
 
 	cnt := strings.Count(ret, "?")
 	if cnt > 1 { //multiple '?'
-		errStr := fmt.Sprintf("Line[%d]: Identifier() could only contain one '?' character", l.line, ret)
+		errStr := fmt.Sprintf("Line[%d]: Identifier(%s) could only contain one '?' character", l.line, ret)
 		panic(errStr)
 	} else if cnt == 1 { //only one '?'
 		if ret[len(ret)-1:] != "?" {
@@ -692,20 +696,23 @@ func (l *Lexer) readIdentifier() token.Token {
 	return tok
 }
 
-func (l *Lexer) readRegExLiteral() (literal string) {
+func (l *Lexer) readRegExLiteral() (string, error) {
 	position := l.position
 	/* read until closing slash */
 	for {
 		l.readNext()
+		if l.ch == 0 {
+			return "", errors.New("unexpected EOF")
+		}
 		if l.ch == '\\' {
 			// Skip escape sequence
 			l.readNext()
 		} else if l.ch == '/' {
 			// This is the closing
-			literal = string(l.input[position+1 : l.position])
+			literal := string(l.input[position+1 : l.position])
 			l.readNext() //skip the '/'
 
-			return
+			return literal, nil
 		}
 	}
 }
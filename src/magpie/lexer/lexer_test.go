@@ -38,15 +38,15 @@ func TestNextToken(t *testing.T) {
 	x or y
 	struct
 	do
-	if (~\d+(\w)+.*$~.exec("abc def") == 0) {  # this is just a comment
+	if (/\d+(\w)+.*$/.exec("abc def") == 0) {  # this is just a comment
 	    return "found"
 	}
 	# this is another command
-	let a234 = ~[ab|cd].*\~efg$~
+	let a234 = /[ab|cd].*\/efg$/
 	let ww = 1.523 + 2    # test for floating point number
 	for item in arr
 	grep { $_ > 5 }
-	if (abc =~ ~\d+~)
+	if (abc =~ /\d+/)
 	y ? a : b
 	52.9..80.7
 	52..80
@@ -142,7 +142,7 @@ func TestNextToken(t *testing.T) {
 		{token.IDENT, "call"},
 		{token.LBRACE, "{"},
 		{token.STRING, "foo"},
-		{token.FATARROW, "=>"},
+		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.LBRACKET, "["},
@@ -164,7 +164,7 @@ func TestNextToken(t *testing.T) {
 		{token.STRUCT, "struct"},
 		{token.DO, "do"},
 
-		//if (~\d+(\w)+.*$~.exec("abc def") == 0) {
+		//if (/\d+(\w)+.*$/.exec("abc def") == 0) {
 		//    return "found"
 		//}
 		{token.IF, "if"},
@@ -183,11 +183,11 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "found"},
 		{token.RBRACE, "}"},
 
-		//let a234 = ~[ab|cd].*\~efg$~
+		//let a234 = /[ab|cd].*\/efg$/
 		{token.LET, "let"},
 		{token.IDENT, "a234"},
 		{token.ASSIGN, "="},
-		{token.REGEX, `[ab|cd].*\~efg$`},
+		{token.REGEX, `[ab|cd].*\/efg$`},
 
 		//let ww = 1.523 + 2
 		{token.LET, "let"},
@@ -211,7 +211,7 @@ func TestNextToken(t *testing.T) {
 		{token.INT, "5"},
 		{token.RBRACE, "}"},
 
-		//input := `if (abc =~ ~\d+~)`
+		//input := `if (abc =~ /\d+/)`
 		{token.IF, "if"},
 		{token.LPAREN, "("},
 		{token.IDENT, "abc"},
@@ -235,10 +235,10 @@ func TestNextToken(t *testing.T) {
 		{token.INT, "52"},
 		{token.DOTDOT, ".."},
 		{token.INT, "80"},
-		{token.EOF, ""},
+		{token.EOF, "<EOF>"},
 	}
 
-	l := New(input)
+	l := New("", input)
 
 	for i, tt := range tests {
 		tok := l.NextToken()
@@ -253,3 +253,13 @@ func TestNextToken(t *testing.T) {
 	}
 
 }
+
+func TestUnterminatedRegex(t *testing.T) {
+	l := New("", `"abc" / "abc"`)
+	expected := []token.TokenType{token.STRING, token.ILLEGAL, token.EOF}
+	for i, typ := range expected {
+		if tok := l.NextToken(); tok.Type != typ {
+			t.Fatalf("tokens[%d] - expected=%q, got=%q(%q)", i, typ, tok.Type, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package lsp
+
+import (
+	"fmt"
+	"magpie/ast"
+	"magpie/eval"
+	"magpie/lexer"
+	"magpie/parser"
+	"magpie/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// a position in a parser error message: ' <file:line:col> ' or ' <line:col> '
+var regErrorPos = regexp.MustCompile(`<(?:([^<>]*):)?(\d+):(\d+)>`)
+
+// document is an opened source file, which is parsed each time it changes.
+type document struct {
+	uri   string
+	path  string
+	lines []string
+
+	program     *ast.Program //nil if the parser panicked
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri, path, text string) *document {
+	d := &document{uri: uri, path: path, lines: strings.Split(text, "\n")}
+	d.parse(text)
+	return d
+}
+
+func (d *document) parse(text string) {
+	defer parser.ResetDebugInfos()
+	defer func() {
+		if r := recover(); r != nil {
+			d.program = nil
+			d.diagnostics = append(d.diagnostics, d.diagnostic(fmt.Sprint(r), ""))
+		}
+	}()
+
+	l := lexer.New(d.path, text)
+	p := parser.New(l, filepath.Dir(d.path))
+	d.program = p.ParseProgram()
+
+	d.diagnostics = []Diagnostic{}
+	errLines := p.ErrorLines()
+	for i, msg := range p.Errors() {
+		line := ""
+		if i < len(errLines) {
+			line = errLines[i]
+		}
+		d.diagnostics = append(d.diagnostics, d.diagnostic(msg, line))
+	}
+}
+
+// diagnostic converts a parser error to a diagnostic. 'errLine' is the error's
+// line as returned by 'Parser.ErrorLines()', i.e. ' <file:line> ' or 'line'.
+// Errors in other(imported) files are reported at the first line.
+func (d *document) diagnostic(msg, errLine string) Diagnostic {
+	line, col := 0, 0
+	file := d.path
+	if s := strings.Trim(strings.TrimSpace(errLine), "<>"); s != "" {
+		if idx := strings.LastIndex(s, ":"); idx >= 0 {
+			file, s = s[:idx], s[idx+1:]
+		}
+		if n, err := strconv.Atoi(s); err == nil {
+			line = n
+		}
+	}
+	if m := regErrorPos.FindStringSubmatch(msg); m != nil && (m[1] == "" || m[1] == file) {
+		if n, _ := strconv.Atoi(m[2]); line == 0 || n == line {
+			line = n
+			col, _ = strconv.Atoi(m[3])
+		}
+	}
+	if file != d.path {
+		line, col = 0, 0
+	}
+
+	//the message is after the position, e.g. 'Syntax Error: <3:5> - expected ...'
+	if idx := strings.Index(msg, "- "); idx >= 0 && regErrorPos.MatchString(msg[:idx]) {
+		prefix := strings.TrimSpace(regErrorPos.ReplaceAllString(msg[:idx], ""))
+		msg = strings.TrimSuffix(prefix, ":") + ": " + strings.TrimSpace(msg[idx+2:])
+		if file != d.path {
+			msg += " (in " + file + ")"
+		}
+	}
+
+	start := d.position(token.Position{Line: line, Col: col})
+	end := start
+	end.Character = len([]rune(d.line(start.Line)))
+	if end.Character <= start.Character {
+		end.Character = start.Character + 1
+	}
+	return Diagnostic{
+		Range:    Range{Start: start, End: end},
+		Severity: SeverityError,
+		Source:   "magpie",
+		Message:  msg,
+	}
+}
+
+func (d *document) line(n int) string {
+	if n < 0 || n >= len(d.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(d.lines[n], "\r")
+}
+
+// position converts a one-based token position to a zero-based LSP position.
+func (d *document) position(pos token.Position) Position {
+	p := Position{Line: pos.Line - 1, Character: pos.Col - 1}
+	if p.Line < 0 {
+		p.Line = 0
+	}
+	if p.Character < 0 {
+		p.Character = 0
+	}
+	return p
+}
+
+func (d *document) rangeOf(node ast.Node) Range {
+	start := d.position(node.Pos())
+	end := d.position(safeEnd(node))
+	if end.Line < start.Line || end.Line == start.Line && end.Character < start.Character {
+		end = start
+	}
+	return Range{Start: start, End: end}
+}
+
+// safeEnd returns the end of a node, or its start if the end is unknown.
+// The 'End()' methods may panic for the nodes of an erroneous program.
+func safeEnd(node ast.Node) (end token.Position) {
+	defer func() {
+		if r := recover(); r != nil || !end.IsValid() {
+			end = node.Pos()
+		}
+	}()
+	return node.End()
+}
+
+// ----------------------------------------------------------------------------
+// Document symbols
+
+// symbols returns the symbols of the top level declarations: the same
+// declarations mdoc documents(classes, functions, lets, consts and enums),
+// and interfaces.
+func (d *document) symbols() []DocumentSymbol {
+	ret := []DocumentSymbol{}
+	if d.program == nil {
+		return ret
+	}
+
+	for _, stmt := range d.program.Statements {
+		switch s := stmt.(type) {
+		case *ast.ClassStatement:
+			sym := d.symbol(s.Name.Value, SymbolClass, s, s.Name)
+			if s.ClassLiteral.Parent != "" {
+				sym.Detail = ": " + s.ClassLiteral.Parent
+			}
+			sym.Children = d.classSymbols(s.ClassLiteral)
+			ret = append(ret, sym)
+		case *ast.InterfaceStatement:
+			sym := d.symbol(s.Name.Value, SymbolInterface, s, s.Name)
+			for _, m := range sortedInterfaceMethods(s.Methods) {
+				sym.Children = append(sym.Children, d.symbol(m.Name.Value, SymbolMethod, m, m.Name))
+			}
+			ret = append(ret, sym)
+		case *ast.FunctionStatement:
+			sym := d.symbol(s.Name.Value, SymbolFunction, s, s.Name)
+			sym.Detail = params(s.FunctionLiteral)
+			ret = append(ret, sym)
+		case *ast.MacroStatement:
+			var names []string
+			for _, p := range s.Parameters {
+				names = append(names, p.Value)
+			}
+			sym := d.symbol(s.Name.Value, SymbolFunction, s, s.Name)
+			sym.Detail = "macro(" + strings.Join(names, ", ") + ")"
+			ret = append(ret, sym)
+		case *ast.LetStatement:
+			for _, name := range s.Names {
+				ret = append(ret, d.symbol(name.Value, SymbolVariable, s, name))
+			}
+		case *ast.ConstStatement:
+			for _, name := range s.Name {
+				ret = append(ret, d.symbol(name.Value, SymbolConstant, s, name))
+			}
+		case *ast.EnumStatement:
+			ret = append(ret, d.symbol(s.Name.Value, SymbolEnum, s, s.Name))
+		}
+	}
+	return ret
+}
+
+func (d *document) classSymbols(c *ast.ClassLiteral) []DocumentSymbol {
+	var ret []DocumentSymbol
+	for _, m := range c.Members {
+		for _, name := range m.Names {
+			ret = append(ret, d.symbol(name.Value, SymbolField, m, name))
+		}
+	}
+	for _, p := range c.Properties {
+		ret = append(ret, d.symbol(p.Name.Value, SymbolProperty, p, p.Name))
+	}
+	for _, m := range c.Methods {
+		sym := d.symbol(m.Name.Value, SymbolMethod, m, m.Name)
+		sym.Detail = params(m.FunctionLiteral)
+		ret = append(ret, sym)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		pi, pj := ret[i].SelectionRange.Start, ret[j].SelectionRange.Start
+		return pi.Line < pj.Line || pi.Line == pj.Line && pi.Character < pj.Character
+	})
+	return ret
+}
+
+func (d *document) symbol(name string, kind SymbolKind, node ast.Node, ident *ast.Identifier) DocumentSymbol {
+	sym := DocumentSymbol{Name: name, Kind: kind, Range: d.rangeOf(node), SelectionRange: d.rangeOf(ident)}
+	//the selection range must be contained in the range
+	if r := sym.Range; before(sym.SelectionRange.Start, r.Start) || before(r.End, sym.SelectionRange.End) {
+		sym.Range = sym.SelectionRange
+	}
+	return sym
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
+
+func params(fn *ast.FunctionLiteral) string {
+	var names []string
+	for _, p := range fn.Parameters {
+		name := p.String()
+		if t, ok := fn.ParamTypes[name]; ok {
+			name += ": " + t.String()
+		}
+		names = append(names, name)
+	}
+	if fn.Variadic && len(names) > 0 {
+		names[len(names)-1] += "..."
+	}
+	ret := ""
+	if fn.ReturnType != nil {
+		ret = ": " + fn.ReturnType.String()
+	}
+	return "(" + strings.Join(names, ", ") + ")" + ret
+}
+
+func sortedInterfaceMethods(methods map[string]*ast.InterfaceMethod) []*ast.InterfaceMethod {
+	var ret []*ast.InterfaceMethod
+	for _, m := range methods {
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		pi, pj := ret[i].Pos(), ret[j].Pos()
+		return pi.Line < pj.Line || pi.Line == pj.Line && pi.Col < pj.Col
+	})
+	return ret
+}
+
+// ----------------------------------------------------------------------------
+// Go to definition
+
+// definition is a declared name and the part of the source where it's visible.
+type definition struct {
+	ident *ast.Identifier
+	node  ast.Node //the declaration
+
+	//the enclosing function, or nil if the name is declared at the top level
+	scope *ast.FunctionLiteral
+	start token.Position
+	end   token.Position
+}
+
+type defCollector struct {
+	defs  *[]*definition
+	scope *ast.FunctionLiteral
+}
+
+func (c defCollector) add(ident *ast.Identifier, node ast.Node) {
+	if ident == nil || !ident.Token.Pos.IsValid() {
+		return
+	}
+	def := &definition{ident: ident, node: node, scope: c.scope}
+	if c.scope != nil {
+		def.start, def.end = functionSpan(c.scope)
+	}
+	*c.defs = append(*c.defs, def)
+}
+
+func (c defCollector) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.FunctionLiteral:
+		inner := defCollector{defs: c.defs, scope: n}
+		for _, p := range n.Parameters {
+			if ident, ok := p.(*ast.Identifier); ok {
+				inner.add(ident, ident)
+			}
+		}
+		return inner
+	case *ast.FunctionStatement:
+		c.add(n.Name, n)
+	case *ast.MacroStatement:
+		c.add(n.Name, n)
+	case *ast.LetStatement:
+		for _, name := range n.Names {
+			c.add(name, n)
+		}
+	case *ast.ConstStatement:
+		for _, name := range n.Name {
+			c.add(name, n)
+		}
+	case *ast.ClassStatement:
+		c.add(n.Name, n)
+	case *ast.InterfaceStatement:
+		c.add(n.Name, n)
+	case *ast.EnumStatement:
+		c.add(n.Name, n)
+	case *ast.PropertyDeclStmt:
+		c.add(n.Name, n)
+	}
+	return c
+}
+
+// the span of a function: from its 'fn' keyword(or first parameter) to the end of its body
+func functionSpan(fn *ast.FunctionLiteral) (start, end token.Position) {
+	start = fn.Token.Pos
+	if !start.IsValid() && len(fn.Parameters) > 0 {
+		start = fn.Parameters[0].Pos()
+	}
+	if fn.Body == nil {
+		return start, start
+	}
+	if !start.IsValid() {
+		start = fn.Body.Pos()
+	}
+	return start, safeEnd(fn.Body)
+}
+
+func (d *document) definitions() []*definition {
+	var defs []*definition
+	if d.program != nil {
+		ast.Walk(defCollector{defs: &defs}, d.program)
+	}
+	return defs
+}
+
+// definition finds the declaration of the name at 'pos'. If the name is
+// declared several times, the innermost visible declaration wins; of the
+// declarations in the same scope, the last one before 'pos' wins.
+func (d *document) definition(pos Position) *Location {
+	name := d.wordAt(pos)
+	if name == "" {
+		return nil
+	}
+	best := d.visibleDefinition(name, pos)
+	if best == nil {
+		return nil
+	}
+
+	uri := d.uri
+	if file := best.ident.Token.Pos.Filename; file != d.path {
+		uri = pathToURI(file)
+	}
+	return &Location{URI: uri, Range: d.rangeOf(best.ident)}
+}
+
+// better reports whether 'a' is a better match than 'b' for a name at 'cur'.
+func better(a, b *definition, cur token.Position, path string) bool {
+	//the innermost scope
+	if a.scope != b.scope {
+		if b.scope == nil {
+			return true
+		}
+		if a.scope == nil {
+			return false
+		}
+		return inSpan(a.start, b.start, b.end)
+	}
+	//the current file before the imported files
+	aLocal, bLocal := a.ident.Token.Pos.Filename == path, b.ident.Token.Pos.Filename == path
+	if aLocal != bLocal {
+		return aLocal
+	}
+	//the last one before 'cur'
+	aBefore, bBefore := !posBefore(cur, a.ident.Pos()), !posBefore(cur, b.ident.Pos())
+	if aBefore != bBefore {
+		return aBefore
+	}
+	if aBefore {
+		return posBefore(b.ident.Pos(), a.ident.Pos())
+	}
+	return posBefore(a.ident.Pos(), b.ident.Pos())
+}
+
+func posBefore(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+func inSpan(pos, start, end token.Position) bool {
+	return !posBefore(pos, start) && !posBefore(end, pos)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordAt returns the identifier under(or just before) the cursor.
+func (d *document) wordAt(pos Position) string {
+	line := []rune(d.line(pos.Line))
+	if pos.Character > len(line) {
+		return ""
+	}
+	start, end := pos.Character, pos.Character
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isIdentRune(line[end]) {
+		end++
+	}
+	word := string(line[start:end])
+	if word == "" || unicode.IsDigit([]rune(word)[0]) {
+		return ""
+	}
+	return word
+}
+
+// ----------------------------------------------------------------------------
+// Completion
+
+// completion returns the methods which could follow the '.' before the cursor.
+// The type of the receiver is guessed from the text before the '.': a literal,
+// a builtin module(e.g. 'os'), or a variable initialized with a literal or
+// with 'new SomeClass'. If the type is unknown, the methods of all the builtin
+// types are returned.
+func (d *document) completion(pos Position) []CompletionItem {
+	line := []rune(d.line(pos.Line))
+	if pos.Character > len(line) {
+		pos.Character = len(line)
+	}
+	i := pos.Character
+	for i > 0 && isIdentRune(line[i-1]) { //the prefix of the method being typed
+		i--
+	}
+	if i == 0 || line[i-1] != '.' {
+		return nil
+	}
+	receiver := strings.TrimRightFunc(string(line[:i-1]), unicode.IsSpace)
+
+	if class := d.receiverClass(receiver, pos); class != nil {
+		return classCompletions(class)
+	}
+	if t, ok := d.receiverType(receiver, pos); ok {
+		return methodCompletions(t)
+	}
+
+	var items []CompletionItem
+	seen := make(map[string]bool)
+	for _, t := range eval.BuiltinMethodTypes() {
+		for _, item := range methodCompletions(t) {
+			if !seen[item.Label] {
+				seen[item.Label] = true
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func methodCompletions(t eval.ObjectType) []CompletionItem {
+	var items []CompletionItem
+	for _, name := range eval.BuiltinMethods(t) {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionMethod, Detail: string(t)})
+	}
+	return items
+}
+
+func classCompletions(c *ast.ClassStatement) []CompletionItem {
+	var items []CompletionItem
+	for _, m := range c.ClassLiteral.Members {
+		for _, name := range m.Names {
+			items = append(items, CompletionItem{Label: name.Value, Kind: CompletionField, Detail: c.Name.Value})
+		}
+	}
+	for name := range c.ClassLiteral.Properties {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionProperty, Detail: c.Name.Value})
+	}
+	for name, m := range c.ClassLiteral.Methods {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionMethod, Detail: c.Name.Value + params(m.FunctionLiteral)})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// the trailing identifier of 'text', e.g. 'b' for 'a + b'
+func lastIdent(text string) string {
+	r := []rune(text)
+	i := len(r)
+	for i > 0 && isIdentRune(r[i-1]) {
+		i--
+	}
+	return string(r[i:])
+}
+
+// receiverType guesses the builtin type of the expression at the end of 'text'.
+func (d *document) receiverType(text string, pos Position) (eval.ObjectType, bool) {
+	if text == "" {
+		return "", false
+	}
+	switch text[len(text)-1] {
+	case '"', '`':
+		return eval.STRING_OBJ, true
+	case ']':
+		return eval.ARRAY_OBJ, true
+	case '}':
+		return eval.HASH_OBJ, true
+	}
+
+	name := lastIdent(text)
+	if name == "" {
+		return "", false
+	}
+	if unicode.IsDigit([]rune(name)[0]) {
+		//'3.14.' is a float, '3.' is an integer
+		rest := strings.TrimSuffix(text, name)
+		if strings.HasSuffix(rest, ".") && unicode.IsDigit([]rune(lastIdent(rest[:len(rest)-1]) + "x")[0]) {
+			return eval.FLOAT_OBJ, true
+		}
+		return eval.INTEGER_OBJ, true
+	}
+	if strings.HasSuffix(strings.TrimSuffix(text, name), ".") { //a field, e.g. 'a.b.'
+		return "", false
+	}
+
+	if def := d.visibleDefinition(name, pos); def != nil {
+		return literalType(def)
+	}
+	if obj, ok := eval.GetGlobalObj(name); ok {
+		return obj.Type(), true
+	}
+	return "", false
+}
+
+// receiverClass returns the class of the receiver at the end of 'text', if it's
+// a class name, 'this', or a variable initialized with 'new SomeClass'.
+func (d *document) receiverClass(text string, pos Position) *ast.ClassStatement {
+	name := lastIdent(text)
+	if name == "" || strings.HasSuffix(strings.TrimSuffix(text, name), ".") {
+		return nil
+	}
+
+	defs := d.definitions()
+	findClass := func(className string) *ast.ClassStatement {
+		for _, def := range defs {
+			if c, ok := def.node.(*ast.ClassStatement); ok && c.Name.Value == className {
+				return c
+			}
+		}
+		return nil
+	}
+
+	if name == "this" || name == "self" {
+		cur := token.Position{Filename: d.path, Line: pos.Line + 1, Col: pos.Character + 1}
+		var inner *ast.ClassStatement
+		for _, def := range defs {
+			c, ok := def.node.(*ast.ClassStatement)
+			if ok && def.ident.Token.Pos.Filename == d.path && inSpan(cur, c.Pos(), safeEnd(c.ClassLiteral)) {
+				inner = c
+			}
+		}
+		return inner
+	}
+
+	def := d.visibleDefinition(name, pos)
+	if def == nil {
+		return nil
+	}
+	if c, ok := def.node.(*ast.ClassStatement); ok {
+		return c
+	}
+	if value := initialValue(def); value != nil {
+		if n, ok := value.(*ast.NewExpression); ok {
+			if ident, ok := n.Class.(*ast.Identifier); ok {
+				return findClass(ident.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// visibleDefinition returns the declaration of 'name' visible at 'pos'.
+func (d *document) visibleDefinition(name string, pos Position) *definition {
+	cur := token.Position{Filename: d.path, Line: pos.Line + 1, Col: pos.Character + 1}
+	var best *definition
+	for _, def := range d.definitions() {
+		if def.ident.Value != name {
+			continue
+		}
+		if def.scope != nil && (def.ident.Token.Pos.Filename != d.path || !inSpan(cur, def.start, def.end)) {
+			continue
+		}
+		if best == nil || better(def, best, cur, d.path) {
+			best = def
+		}
+	}
+	return best
+}
+
+// the expression a let/const name is initialized with
+func initialValue(def *definition) ast.Expression {
+	switch n := def.node.(type) {
+	case *ast.LetStatement:
+		for i, name := range n.Names {
+			if name == def.ident && i < len(n.Values) && !n.DestructingFlag {
+				return n.Values[i]
+			}
+		}
+	case *ast.ConstStatement:
+		for i, name := range n.Name {
+			if name == def.ident && i < len(n.Value) {
+				return n.Value[i]
+			}
+		}
+	}
+	return nil
+}
+
+func literalType(def *definition) (eval.ObjectType, bool) {
+	if _, ok := def.node.(*ast.EnumStatement); ok {
+		return eval.ENUM_OBJ, true
+	}
+
+	switch initialValue(def).(type) {
+	case *ast.StringLiteral, *ast.InterpolatedString:
+		return eval.STRING_OBJ, true
+	case *ast.IntegerLiteral:
+		return eval.INTEGER_OBJ, true
+	case *ast.UIntegerLiteral:
+		return eval.UINTEGER_OBJ, true
+	case *ast.FloatLiteral:
+		return eval.FLOAT_OBJ, true
+	case *ast.Boolean:
+		return eval.BOOLEAN_OBJ, true
+	case *ast.ArrayLiteral:
+		return eval.ARRAY_OBJ, true
+	case *ast.HashLiteral:
+		return eval.HASH_OBJ, true
+	case *ast.TupleLiteral:
+		return eval.TUPLE_OBJ, true
+	case *ast.RegExLiteral:
+		return eval.REGEX_OBJ, true
+	case *ast.EnumLiteral:
+		return eval.ENUM_OBJ, true
+	}
+	return "", false
+}
@@ -0,0 +1,273 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///tmp/lsptest/main.mp"
+
+// session runs the server with the given requests, and returns the messages
+// it sent, keyed by the request id(or the method for notifications).
+func session(t *testing.T, requests ...string) map[string][]json.RawMessage {
+	var in bytes.Buffer
+	for _, req := range requests {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(req), req)
+	}
+	shutdown := `{"jsonrpc":"2.0","id":"shutdown","method":"shutdown"}`
+	exit := `{"jsonrpc":"2.0","method":"exit"}`
+	fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(shutdown), shutdown)
+	fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(exit), exit)
+
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Run(); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	msgs := make(map[string][]json.RawMessage)
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading header: %s", err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			t.Fatalf("reading body: %s", err)
+		}
+
+		var msg struct {
+			ID     interface{}     `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("invalid message %s: %s", data, err)
+		}
+		switch {
+		case msg.Method != "":
+			msgs[msg.Method] = append(msgs[msg.Method], msg.Params)
+		case msg.Error != nil:
+			msgs[fmt.Sprint(msg.ID)] = append(msgs[fmt.Sprint(msg.ID)], msg.Error)
+		default:
+			msgs[fmt.Sprint(msg.ID)] = append(msgs[fmt.Sprint(msg.ID)], msg.Result)
+		}
+	}
+	return msgs
+}
+
+func didOpen(text string) string {
+	data, _ := json.Marshal(text)
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` +
+		testURI + `","languageId":"magpie","version":1,"text":` + string(data) + `}}}`
+}
+
+func positionRequest(id int, method string, line, char int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":{"textDocument":{"uri":"%s"},"position":{"line":%d,"character":%d}}}`,
+		id, method, testURI, line, char)
+}
+
+func TestInitialize(t *testing.T) {
+	msgs := session(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"workspace/symbol","params":{}}`,
+	)
+
+	var result struct {
+		Capabilities struct {
+			DocumentSymbolProvider bool `json:"documentSymbolProvider"`
+			DefinitionProvider     bool `json:"definitionProvider"`
+			CompletionProvider     struct {
+				TriggerCharacters []string `json:"triggerCharacters"`
+			} `json:"completionProvider"`
+		} `json:"capabilities"`
+	}
+	if len(msgs["1"]) != 1 {
+		t.Fatalf("expected a response to initialize, got=%v", msgs)
+	}
+	json.Unmarshal(msgs["1"][0], &result)
+	c := result.Capabilities
+	if !c.DocumentSymbolProvider || !c.DefinitionProvider || len(c.CompletionProvider.TriggerCharacters) != 1 {
+		t.Errorf("unexpected capabilities: %s", msgs["1"][0])
+	}
+
+	var respErr responseError
+	if len(msgs["2"]) != 1 || json.Unmarshal(msgs["2"][0], &respErr) != nil || respErr.Code != codeMethodNotFound {
+		t.Errorf("expected a 'method not found' error, got=%s", msgs["2"])
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	exit := `{"jsonrpc":"2.0","method":"exit"}`
+	in := strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(exit), exit))
+	if err := NewServer(in, &bytes.Buffer{}).Run(); err != ErrNoShutdown {
+		t.Errorf("expected ErrNoShutdown, got=%v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	msgs := session(t,
+		didOpen("let a = 1\nlet = 2\nlet c = 3\n"),
+		didOpen("let a = 1\n"),
+	)
+
+	published := msgs["textDocument/publishDiagnostics"]
+	if len(published) != 2 {
+		t.Fatalf("expected 2 publishDiagnostics, got=%d", len(published))
+	}
+
+	var params PublishDiagnosticsParams
+	json.Unmarshal(published[0], &params)
+	if params.URI != testURI || len(params.Diagnostics) == 0 {
+		t.Fatalf("expected diagnostics for %s, got=%s", testURI, published[0])
+	}
+	d := params.Diagnostics[0]
+	if d.Range.Start.Line != 1 || d.Severity != SeverityError || !strings.HasPrefix(d.Message, "Syntax Error: ") {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+
+	params = PublishDiagnosticsParams{}
+	json.Unmarshal(published[1], &params)
+	if params.Diagnostics == nil || len(params.Diagnostics) != 0 {
+		t.Errorf("expected an empty list of diagnostics, got=%s", published[1])
+	}
+}
+
+const testProgram = `let greeting = "hello"
+const Pi = 3.14
+enum Color { RED, GREEN }
+fn add(x, y) {
+    let sum = x + y
+    return sum
+}
+class Dog {
+    let name = "dog"
+    fn bark() { return this.name }
+}
+let x = 10
+let d = new Dog()
+add(x, 1)
+greeting.
+d.
+[1,2].
+`
+
+func TestDocumentSymbols(t *testing.T) {
+	msgs := session(t,
+		didOpen(testProgram),
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"`+testURI+`"}}}`,
+	)
+
+	var symbols []DocumentSymbol
+	if err := json.Unmarshal(msgs["1"][0], &symbols); err != nil {
+		t.Fatalf("invalid symbols: %s", err)
+	}
+
+	var got []string
+	for _, s := range symbols {
+		got = append(got, fmt.Sprintf("%s:%d:%d", s.Name, s.Kind, s.SelectionRange.Start.Line))
+		for _, c := range s.Children {
+			got = append(got, fmt.Sprintf("  %s:%d:%d", c.Name, c.Kind, c.SelectionRange.Start.Line))
+		}
+	}
+	expected := []string{"greeting:13:0", "Pi:14:1", "Color:10:2", "add:12:3", "Dog:5:7",
+		"  name:8:8", "  bark:6:9", "x:13:11", "d:13:12"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected symbols %v, got=%v", expected, got)
+	}
+	if symbols[0].SelectionRange.Start.Character != 4 || symbols[0].SelectionRange.End.Character != 12 {
+		t.Errorf("unexpected range for 'greeting': %+v", symbols[0].SelectionRange)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		line, char int
+		expected   string //line:character of the definition, or "" for none
+	}{
+		{13, 0, "3:3"},  //add(x, 1)
+		{13, 4, "11:4"}, //x in add(x, 1)
+		{4, 14, "3:7"},  //x in 'x + y', the parameter of 'add'
+		{5, 12, "4:8"},  //sum
+		{12, 13, "7:6"}, //Dog in 'new Dog()'
+		{9, 8, "9:7"},   //bark itself
+		{14, 3, "0:4"},  //greeting
+		{14, 9, ""},     //after 'greeting.'
+	}
+
+	var requests = []string{didOpen(testProgram)}
+	for i, tt := range tests {
+		requests = append(requests, positionRequest(i, "textDocument/definition", tt.line, tt.char))
+	}
+	msgs := session(t, requests...)
+
+	for i, tt := range tests {
+		var loc *Location
+		if err := json.Unmarshal(msgs[strconv.Itoa(i)][0], &loc); err != nil {
+			t.Fatalf("invalid location: %s", err)
+		}
+		got := ""
+		if loc != nil {
+			if loc.URI != testURI {
+				t.Errorf("expected uri %s, got=%s", testURI, loc.URI)
+			}
+			got = fmt.Sprintf("%d:%d", loc.Range.Start.Line, loc.Range.Start.Character)
+		}
+		if got != tt.expected {
+			t.Errorf("definition at %d:%d: expected %q, got=%q", tt.line, tt.char, tt.expected, got)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		line, char int
+		contains   string
+		excludes   string
+	}{
+		{14, 9, "upper", "push"}, //greeting.
+		{15, 2, "bark", "upper"}, //d.
+		{16, 6, "push", "upper"}, //[1,2].
+		{13, 3, "", ""},          //not after a '.'
+	}
+
+	var requests = []string{didOpen(testProgram)}
+	for i, tt := range tests {
+		requests = append(requests, positionRequest(i, "textDocument/completion", tt.line, tt.char))
+	}
+	msgs := session(t, requests...)
+
+	for i, tt := range tests {
+		var list CompletionList
+		if err := json.Unmarshal(msgs[strconv.Itoa(i)][0], &list); err != nil {
+			t.Fatalf("invalid completion list: %s", err)
+		}
+		labels := make(map[string]bool)
+		for _, item := range list.Items {
+			labels[item.Label] = true
+		}
+		if tt.contains == "" {
+			if len(labels) != 0 {
+				t.Errorf("completion at %d:%d: expected no items, got=%d", tt.line, tt.char, len(labels))
+			}
+			continue
+		}
+		if !labels[tt.contains] || labels[tt.excludes] {
+			t.Errorf("completion at %d:%d: expected %q and not %q, got=%v", tt.line, tt.char, tt.contains, tt.excludes, labels)
+		}
+	}
+}
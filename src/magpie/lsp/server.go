@@ -0,0 +1,243 @@
+// Package lsp implements a language server for magpie, which speaks the
+// Language Server Protocol over a stream(normally stdin/stdout).
+//
+// It supports diagnostics for the parser's errors, document symbols,
+// go-to-definition and completion of the builtin objects' methods.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNoShutdown is returned by Run if the client sends 'exit' without
+// sending 'shutdown' first.
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Server is a language server. Requests are handled one at a time, in the
+// order they are received.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs     map[string]*document //uri -> document
+	shutdown bool
+}
+
+// NewServer returns a server which reads requests from 'in' and writes
+// responses and notifications to 'out'.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Run serves requests until the client sends 'exit' or closes the stream.
+func (s *Server) Run() error {
+	for {
+		data, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			if err := s.replyError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads a message with a header like 'Content-Length: 123'.
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("lsp: invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Server) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(data)
+	return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}) error {
+	if id == nil { //notifications have no response
+		return nil
+	}
+	return s.write(&response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, msg string) error {
+	return s.write(&errorResponse{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: msg}})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req *request) (err error) {
+	defer func() {
+		if r := recover(); r != nil && req.ID != nil {
+			err = s.replyError(req.ID, codeInternalError, fmt.Sprint(r))
+		}
+	}()
+
+	if s.shutdown && req.ID != nil {
+		return s.replyError(req.ID, codeInvalidRequest, "server is shut down")
+	}
+
+	switch req.Method {
+	case "initialize":
+		return s.reply(req.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, //full
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]string{"name": "magpie"},
+		})
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+		return s.reply(req.ID, nil)
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil //a notification, there is no one to tell
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+			URI: params.TextDocument.URI, Diagnostics: []Diagnostic{},
+		})
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.replyError(req.ID, codeInvalidParams, err.Error())
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			return s.reply(req.ID, []DocumentSymbol{})
+		}
+		return s.reply(req.ID, doc.symbols())
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.replyError(req.ID, codeInvalidParams, err.Error())
+		}
+		doc := s.docs[params.TextDocument.URI]
+		if doc == nil {
+			return s.reply(req.ID, nil)
+		}
+		if loc := doc.definition(params.Position); loc != nil {
+			return s.reply(req.ID, loc)
+		}
+		return s.reply(req.ID, nil)
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.replyError(req.ID, codeInvalidParams, err.Error())
+		}
+		list := &CompletionList{Items: []CompletionItem{}}
+		if doc := s.docs[params.TextDocument.URI]; doc != nil {
+			list.Items = append(list.Items, doc.completion(params.Position)...)
+		}
+		return s.reply(req.ID, list)
+	}
+
+	if req.ID == nil { //unknown notifications are ignored
+		return nil
+	}
+	return s.replyError(req.ID, codeMethodNotFound, "method not supported: "+req.Method)
+}
+
+// update parses the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	doc := newDocument(uri, uriToPath(uri), text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI: uri, Diagnostics: doc.diagnostics,
+	})
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	//windows: file:///c:/dir/file.mp
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,130 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Lock is an entry of the magpie.lock, which records the exact contents of
+// every module in the build, including the requires of the requires.
+type Lock struct {
+	Path    string
+	Version string
+	Rev     string //the git commit, or '-' for local modules
+	Hash    string //hash of the module's files, see 'HashDir'
+}
+
+// ReadLock reads the magpie.lock in the directory 'dir'. It returns nil if
+// there is no magpie.lock.
+func ReadLock(dir string) ([]*Lock, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, LockFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []*Lock
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: malformed line", filepath.Join(dir, LockFile), lineno)
+		}
+		locks = append(locks, &Lock{Path: fields[0], Version: fields[1], Rev: fields[2], Hash: fields[3]})
+	}
+	return locks, scanner.Err()
+}
+
+// WriteLock writes the magpie.lock in the directory 'dir'.
+func WriteLock(dir string, locks []*Lock) error {
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].Path != locks[j].Path {
+			return locks[i].Path < locks[j].Path
+		}
+		return locks[i].Version < locks[j].Version
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by 'magpie mod tidy'. DO NOT EDIT.\n")
+	for _, l := range locks {
+		fmt.Fprintf(&buf, "%s %s %s %s\n", l.Path, l.Version, l.Rev, l.Hash)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, LockFile), buf.Bytes(), 0644)
+}
+
+func findLock(locks []*Lock, path, version string) *Lock {
+	for _, l := range locks {
+		if l.Path == path && l.Version == version {
+			return l
+		}
+	}
+	return nil
+}
+
+// HashDir returns the hash of the files of a module: the sha256 of the list
+// of the files' names and their sha256, in the form 'h1:<base64>'. The '.git'
+// and 'vendor' directories are ignored.
+func HashDir(dir string) (string, error) {
+	files, err := moduleFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, name := range files {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		fh := sha256.New()
+		_, err = io.Copy(fh, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%x  %s\n", fh.Sum(nil), filepath.ToSlash(name))
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// moduleFiles returns the regular files of a module, relative to 'dir' and sorted.
+func moduleFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != dir && (fi.Name() == ".git" || fi.Name() == VendorDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
@@ -0,0 +1,366 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"magpie/lexer"
+	"magpie/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Init creates a magpie.mod for the module 'path' in the directory 'dir'.
+// If 'path' is empty, the name of the directory is used.
+func Init(dir, path string, w io.Writer) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if isFile(filepath.Join(abs, ModFile)) {
+		return fmt.Errorf("%s already exists", filepath.Join(abs, ModFile))
+	}
+	if path == "" {
+		path = filepath.Base(abs)
+	}
+	f := &File{Dir: abs, Module: path}
+	if err := f.Write(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "magpie: creating new %s: module %s\n", ModFile, path)
+	return nil
+}
+
+// Tidy makes the magpie.mod in the directory 'dir' match the imports of the
+// module's files: the requires which aren't imported are removed. Then it
+// downloads the git modules(and the modules they require) to the module
+// cache, and writes the hashes of all the modules to the magpie.lock.
+func Tidy(dir string, w io.Writer) error {
+	f, err := readMain(dir)
+	if err != nil {
+		return err
+	}
+	imports, err := ScanImports(f.Dir)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[*Require]bool)
+	var missing []string
+	for _, imp := range imports {
+		r, local := f.provider(imp.Path)
+		switch {
+		case local:
+		case r != nil:
+			used[r] = true
+		case Lookup(f.Dir, imp.Path) != "" || Lookup(filepath.Dir(imp.File), imp.Path) != "":
+			//a file relative to the module, not a module
+		case os.Getenv("MAGPIE_ROOT") != "" && Lookup(os.Getenv("MAGPIE_ROOT"), imp.Path) != "":
+		default:
+			missing = append(missing, fmt.Sprintf("%s: no required module provides package %s", imp.File, imp.Path))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s\nadd a 'require <path> <version> <source>' line to %s",
+			strings.Join(missing, "\n"), filepath.Join(f.Dir, ModFile))
+	}
+
+	var requires []*Require
+	for _, r := range f.Requires {
+		if used[r] {
+			requires = append(requires, r)
+		} else {
+			fmt.Fprintf(w, "magpie: removing unused module %s %s\n", r.Path, r.Version)
+		}
+	}
+	f.Requires = requires
+
+	locks, err := ReadLock(f.Dir)
+	if err != nil {
+		return err
+	}
+	mods, err := loadAll(f, locks, w)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Write(); err != nil {
+		return err
+	}
+	var newLocks []*Lock
+	for _, m := range mods {
+		newLocks = append(newLocks, m.lock)
+	}
+	return WriteLock(f.Dir, newLocks)
+}
+
+// Vendor copies the modules of the build into the 'vendor' directory of the
+// module in the directory 'dir'. Imports are resolved from the vendor
+// directory before the module cache and the local sources.
+func Vendor(dir string, w io.Writer) error {
+	f, err := readMain(dir)
+	if err != nil {
+		return err
+	}
+	locks, err := ReadLock(f.Dir)
+	if err != nil {
+		return err
+	}
+
+	vendor := filepath.Join(f.Dir, VendorDir)
+	if err := os.RemoveAll(vendor); err != nil {
+		return err
+	}
+	mods, err := loadAll(f, locks, w)
+	if err != nil {
+		return err
+	}
+
+	var list bytes.Buffer
+	vendored := make(map[string]string) //path -> version
+	for _, m := range mods {
+		if v, ok := vendored[m.Path]; ok {
+			return fmt.Errorf("cannot vendor both %s@%s and %s@%s", m.Path, v, m.Path, m.Version)
+		}
+		vendored[m.Path] = m.Version
+		if err := copyModule(m.dir, filepath.Join(vendor, filepath.FromSlash(m.Path))); err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "# %s %s\n", m.Path, m.Version)
+	}
+	if len(mods) == 0 {
+		fmt.Fprintf(w, "magpie: no dependencies to vendor\n")
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(vendor, "modules.txt"), list.Bytes(), 0644)
+}
+
+func readMain(dir string) (*File, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !isFile(filepath.Join(abs, ModFile)) {
+		return nil, fmt.Errorf("%s not found in %s, run 'magpie mod init' first", ModFile, abs)
+	}
+	return ReadFile(abs)
+}
+
+// a module of the build
+type loaded struct {
+	*Require
+	dir  string
+	lock *Lock
+}
+
+// loadAll loads the requires of the main module 'f', and the requires of
+// its requires. The hashes of the git modules are checked against 'locks'.
+func loadAll(f *File, locks []*Lock, w io.Writer) ([]*loaded, error) {
+	var mods []*loaded
+	seen := make(map[string]bool) //path@version
+
+	type item struct {
+		from *File
+		req  *Require
+	}
+	var queue []item
+	for _, r := range f.Requires {
+		queue = append(queue, item{f, r})
+	}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		key := it.req.Path + "@" + it.req.Version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		m, err := load(it.from, it.req, locks, w)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, m)
+
+		dep, err := Find(m.dir)
+		if err != nil {
+			return nil, err
+		}
+		if dep != nil && dep.Dir == m.dir {
+			for _, r := range dep.Requires {
+				queue = append(queue, item{dep, r})
+			}
+		}
+	}
+	return mods, nil
+}
+
+// load makes the module 'r' required by 'from' available, and computes its hash.
+func load(from *File, r *Require, locks []*Lock, w io.Writer) (*loaded, error) {
+	m := &loaded{Require: r, lock: &Lock{Path: r.Path, Version: r.Version, Rev: "-"}}
+	if r.IsGit() {
+		dir, rev, err := download(from, r, w)
+		if err != nil {
+			return nil, err
+		}
+		m.dir, m.lock.Rev = dir, rev
+	} else {
+		m.dir = from.SourceDir(r)
+		if !isDir(m.dir) {
+			return nil, fmt.Errorf("module %s: source directory %s not found", r.Path, m.dir)
+		}
+	}
+
+	hash, err := HashDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Hash = hash
+
+	//local modules are edited in place, their hashes are only recorded
+	if old := findLock(locks, r.Path, r.Version); old != nil && r.IsGit() {
+		if old.Rev != m.lock.Rev || old.Hash != m.lock.Hash {
+			return nil, fmt.Errorf("module %s@%s: checksum mismatch\n\tdownloaded: %s %s\n\t%s: %s %s",
+				r.Path, r.Version, m.lock.Rev, m.lock.Hash, LockFile, old.Rev, old.Hash)
+		}
+	}
+	return m, nil
+}
+
+// download clones the git module 'r' into the module cache, and returns the
+// module's directory and the commit of its version.
+func download(from *File, r *Require, w io.Writer) (dir, rev string, err error) {
+	dir = CachePath(r.Path, r.Version)
+	if !isDir(dir) {
+		repo := strings.TrimPrefix(r.Source, gitPrefix)
+		//a local repository, relative to the magpie.mod which requires it
+		if local := filepath.Join(from.Dir, filepath.FromSlash(repo)); !filepath.IsAbs(repo) && !strings.Contains(repo, "://") && isDir(local) {
+			repo = local
+		}
+
+		fmt.Fprintf(w, "magpie: downloading %s %s\n", r.Path, r.Version)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", "", err
+		}
+		tmp, err := ioutil.TempDir(filepath.Dir(dir), ".tmp-")
+		if err != nil {
+			return "", "", err
+		}
+		defer os.RemoveAll(tmp)
+
+		if err := git("", "clone", "--quiet", "--", repo, tmp); err != nil {
+			return "", "", fmt.Errorf("module %s: %s", r.Path, err)
+		}
+		if err := git(tmp, "-c", "advice.detachedHead=false", "checkout", "--quiet", r.Version, "--"); err != nil {
+			return "", "", fmt.Errorf("module %s@%s: %s", r.Path, r.Version, err)
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return "", "", err
+		}
+	}
+
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("module %s@%s: %s is not a git repository", r.Path, r.Version, dir)
+	}
+	return dir, strings.TrimSpace(string(out)), nil
+}
+
+func git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func copyModule(src, dst string) error {
+	files, err := moduleFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import is an import statement of a file.
+type Import struct {
+	File string //the importing file
+	Path string //the import path, e.g. 'acme/util/strings' for 'import acme.util.strings'
+}
+
+// ScanImports returns the imports of the '.mp' files in the directory 'dir'
+// and its subdirectories. The 'vendor' directory, hidden directories and
+// other modules(directories with their own magpie.mod) are skipped.
+func ScanImports(dir string) ([]Import, error) {
+	var imports []Import
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			name := fi.Name()
+			if path != dir && (name == VendorDir || strings.HasPrefix(name, ".") || isFile(filepath.Join(path, ModFile))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".mp") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, imp := range scanFile(string(data)) {
+			imports = append(imports, Import{File: path, Path: imp})
+		}
+		return nil
+	})
+	sort.SliceStable(imports, func(i, j int) bool { return imports[i].Path < imports[j].Path })
+	return imports, err
+}
+
+// scanFile returns the import paths of a file's 'import' statements.
+func scanFile(src string) (paths []string) {
+	defer func() {
+		recover() //the lexer panics on some malformed sources
+	}()
+
+	l := lexer.New("", src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type != token.IMPORT {
+			continue
+		}
+		tok = l.NextToken()
+		switch tok.Type {
+		case token.STRING:
+			paths = append(paths, tok.Literal)
+		case token.IDENT:
+			parts := []string{tok.Literal}
+			for tok = l.NextToken(); tok.Type == token.DOT; tok = l.NextToken() {
+				tok = l.NextToken()
+				parts = append(parts, tok.Literal)
+			}
+			paths = append(paths, strings.Join(parts, "/"))
+		}
+	}
+	return paths
+}
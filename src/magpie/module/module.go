@@ -0,0 +1,373 @@
+// Package module implements magpie's module system: the 'magpie.mod'
+// manifest, the 'magpie.lock' lock file, the resolution of import paths and
+// the 'magpie mod' command.
+//
+// A magpie.mod looks like:
+//
+//	module acme/app
+//
+//	require acme/util v1.2.0 ../util
+//	require (
+//	    acme/json v0.3.0 git:https://example.com/acme/json.git
+//	)
+//
+// Each required module has a path, a version and a source. The source is a
+// local directory(relative to the magpie.mod), or 'git:' followed by the url
+// or the directory of a git repository, in which case the version is a tag,
+// a branch or a commit of the repository.
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	ModFile   = "magpie.mod"
+	LockFile  = "magpie.lock"
+	VendorDir = "vendor"
+
+	gitPrefix = "git:"
+)
+
+var regComment = regexp.MustCompile(`(^|\s)//.*$`)
+
+// Require is a required module.
+type Require struct {
+	Path    string //module path, e.g. 'acme/util'
+	Version string //e.g. 'v1.2.0'
+	Source  string //local directory, or 'git:' + repository
+}
+
+// IsGit reports whether the module's source is a git repository.
+func (r *Require) IsGit() bool {
+	return strings.HasPrefix(r.Source, gitPrefix)
+}
+
+// File is a parsed magpie.mod.
+type File struct {
+	Dir      string //the directory of the magpie.mod
+	Module   string //module path
+	Requires []*Require
+}
+
+// Parse parses the contents of the magpie.mod in the directory 'dir'.
+func Parse(dir string, data []byte) (*File, error) {
+	f := &File{Dir: dir}
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		//a comment starts with '//' after a space, so urls like 'git:https://...' aren't comments
+		line := regComment.ReplaceAllString(scanner.Text(), "")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", filepath.Join(dir, ModFile), lineno, fmt.Sprintf(format, args...))
+		}
+
+		if inBlock {
+			if len(fields) == 1 && fields[0] == ")" {
+				inBlock = false
+				continue
+			}
+			if err := f.addRequire(fields); err != nil {
+				return nil, errorf("%s", err)
+			}
+			continue
+		}
+
+		switch fields[0] {
+		case "module":
+			if len(fields) != 2 {
+				return nil, errorf("usage: module <path>")
+			}
+			if f.Module != "" {
+				return nil, errorf("repeated module statement")
+			}
+			f.Module = fields[1]
+		case "require":
+			if len(fields) == 2 && fields[1] == "(" {
+				inBlock = true
+				continue
+			}
+			if err := f.addRequire(fields[1:]); err != nil {
+				return nil, errorf("%s", err)
+			}
+		default:
+			return nil, errorf("unknown directive: %s", fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%s: missing ')' of require block", filepath.Join(dir, ModFile))
+	}
+	if f.Module == "" {
+		return nil, fmt.Errorf("%s: missing module statement", filepath.Join(dir, ModFile))
+	}
+	return f, nil
+}
+
+func (f *File) addRequire(fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("usage: require <path> <version> <source>")
+	}
+	if err := checkPath(fields[0]); err != nil {
+		return err
+	}
+	if err := checkVersion(fields[1]); err != nil {
+		return err
+	}
+	if strings.HasPrefix(strings.TrimPrefix(fields[2], gitPrefix), "-") {
+		return fmt.Errorf("invalid source %s: must not start with '-'", fields[2])
+	}
+	for _, r := range f.Requires {
+		if r.Path == fields[0] {
+			return fmt.Errorf("repeated require of %s", fields[0])
+		}
+	}
+	f.Requires = append(f.Requires, &Require{Path: fields[0], Version: fields[1], Source: fields[2]})
+	return nil
+}
+
+// checkPath checks the module path 'path' of a require. The path is used as
+// a directory under the vendor directory and the module cache, so it must
+// be relative and must not leave them.
+func checkPath(path string) error {
+	if path == "" || strings.HasPrefix(path, "/") || strings.HasPrefix(path, "-") ||
+		strings.Contains(path, "\\") || filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return fmt.Errorf("invalid module path %s", path)
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return fmt.Errorf("invalid module path %s", path)
+		}
+	}
+	return nil
+}
+
+// checkVersion checks the version 'version' of a require. It's passed to
+// 'git checkout', and is a part of the module's directory in the cache.
+func checkVersion(version string) error {
+	if strings.HasPrefix(version, "-") || strings.Contains(version, "\\") {
+		return fmt.Errorf("invalid version %s", version)
+	}
+	for _, elem := range strings.Split(version, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return fmt.Errorf("invalid version %s", version)
+		}
+	}
+	return nil
+}
+
+// ReadFile reads the magpie.mod in the directory 'dir'.
+func ReadFile(dir string) (*File, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ModFile))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(dir, data)
+}
+
+// Find reads the magpie.mod of the module the directory 'dir' belongs to,
+// i.e. the magpie.mod in 'dir' or in its nearest parent. It returns nil
+// if there is no such file.
+func Find(dir string) (*File, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if isFile(filepath.Join(dir, ModFile)) {
+			return ReadFile(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Format returns the contents of the magpie.mod, with the requires sorted.
+func (f *File) Format() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "module %s\n", f.Module)
+
+	reqs := append([]*Require(nil), f.Requires...)
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Path < reqs[j].Path })
+	switch len(reqs) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&buf, "\nrequire %s %s %s\n", reqs[0].Path, reqs[0].Version, reqs[0].Source)
+	default:
+		buf.WriteString("\nrequire (\n")
+		for _, r := range reqs {
+			fmt.Fprintf(&buf, "\t%s %s %s\n", r.Path, r.Version, r.Source)
+		}
+		buf.WriteString(")\n")
+	}
+	return buf.Bytes()
+}
+
+// Write writes the magpie.mod to its directory.
+func (f *File) Write() error {
+	return ioutil.WriteFile(filepath.Join(f.Dir, ModFile), f.Format(), 0644)
+}
+
+// Lookup returns the required module which provides the import path 'path',
+// i.e. the module with the longest path which is a prefix of 'path'.
+func (f *File) Lookup(path string) *Require {
+	var found *Require
+	for _, r := range f.Requires {
+		if hasPathPrefix(path, r.Path) && (found == nil || len(r.Path) > len(found.Path)) {
+			found = r
+		}
+	}
+	return found
+}
+
+// provider returns the required module which provides the import path
+// 'path', or 'local' is true if it's a package of the module itself.
+func (f *File) provider(path string) (r *Require, local bool) {
+	r = f.Lookup(path)
+	if hasPathPrefix(path, f.Module) && (r == nil || len(r.Path) <= len(f.Module)) {
+		return nil, true
+	}
+	return r, false
+}
+
+// ModuleDir returns the directory of the required module 'r': the vendor
+// directory of the module or of one of its parents, the source directory
+// of a local module, or the module cache for a git module.
+func (f *File) ModuleDir(r *Require) string {
+	for dir := f.Dir; ; {
+		if d := filepath.Join(dir, VendorDir, filepath.FromSlash(r.Path)); isDir(d) {
+			return d
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return f.SourceDir(r)
+}
+
+// SourceDir returns the directory of the required module 'r' without looking
+// at the vendor directories.
+func (f *File) SourceDir(r *Require) string {
+	if r.IsGit() {
+		return CachePath(r.Path, r.Version)
+	}
+	if filepath.IsAbs(r.Source) {
+		return r.Source
+	}
+	return filepath.Join(f.Dir, filepath.FromSlash(r.Source))
+}
+
+// CacheDir returns the directory where the git modules are downloaded to:
+// $MAGPIE_CACHE, or '.magpie/pkg' in the user's home directory.
+func CacheDir() string {
+	if dir := os.Getenv("MAGPIE_CACHE"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".magpie", "pkg")
+}
+
+// CachePath returns the directory of the version 'version' of the module 'path' in the module cache.
+func CachePath(path, version string) string {
+	return filepath.Join(CacheDir(), filepath.FromSlash(path)+"@"+version)
+}
+
+// Resolve resolves the import path 'path' of a file in the directory 'dir'
+// using the magpie.mod of the module the directory belongs to. The result is
+// a '.mp' file or a package directory. It returns "" if there's no magpie.mod,
+// or if the import path isn't provided by the module or by its requires.
+func Resolve(dir, path string) (string, error) {
+	f, err := Find(dir)
+	if err != nil || f == nil {
+		return "", err
+	}
+
+	r, local := f.provider(path)
+	if local {
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, f.Module), "/")
+		if fn := Lookup(f.Dir, rel); fn != "" {
+			return fn, nil
+		}
+		return "", fmt.Errorf("module %s does not contain package %s", f.Module, path)
+	}
+	if r == nil {
+		return "", nil
+	}
+	root := f.ModuleDir(r)
+	if !isDir(root) {
+		return "", fmt.Errorf("module %s@%s is not downloaded, run 'magpie mod tidy'", r.Path, r.Version)
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, r.Path), "/")
+	if fn := Lookup(root, rel); fn != "" {
+		return fn, nil
+	}
+	return "", fmt.Errorf("module %s@%s does not contain package %s", r.Path, r.Version, path)
+}
+
+// Lookup returns the file 'dir/path.mp', or the package directory 'dir/path'
+// if it contains '.mp' files. It returns "" if there is neither.
+func Lookup(dir, path string) string {
+	fn := filepath.Join(dir, filepath.FromSlash(path))
+	if path != "" && isFile(fn+".mp") {
+		return fn + ".mp"
+	}
+	if files, _ := PackageFiles(fn); len(files) > 0 {
+		return fn
+	}
+	return ""
+}
+
+// PackageFiles returns the '.mp' files of the package directory 'dir',
+// sorted by name. The test files('_test.mp') are not included.
+func PackageFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".mp") || strings.HasSuffix(name, "_test.mp") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
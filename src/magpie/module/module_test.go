@@ -0,0 +1,272 @@
+package module
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files(path -> contents) under 'dir'.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `// the app
+module acme/app
+
+require acme/util v1.0.0 ../util
+require (
+	acme/json v0.3.0 git:https://example.com/json.git // json
+)
+`
+	f, err := Parse("/src/app", []byte(input))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if f.Module != "acme/app" || len(f.Requires) != 2 {
+		t.Fatalf("unexpected file: %+v", f)
+	}
+	if r := f.Requires[1]; r.Path != "acme/json" || r.Version != "v0.3.0" || !r.IsGit() {
+		t.Errorf("unexpected require: %+v", r)
+	}
+
+	expected := "module acme/app\n\nrequire (\n\tacme/json v0.3.0 git:https://example.com/json.git\n\tacme/util v1.0.0 ../util\n)\n"
+	if got := string(f.Format()); got != expected {
+		t.Errorf("Format: expected %q, got=%q", expected, got)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"require a v1 ../a", "missing module statement"},
+		{"module a\nrequire b v1", "usage: require"},
+		{"module a\nrequire b v1 ../b\nrequire b v2 ../b", "repeated require of b"},
+		{"module a\nreplace b => ../b", "unknown directive: replace"},
+		{"module a\nrequire (\nb v1 ../b", "missing ')'"},
+		{"module a\nrequire ../b v1 ../b", "invalid module path ../b"},
+		{"module a\nrequire b/../../c v1 ../b", "invalid module path b/../../c"},
+		{"module a\nrequire /b v1 ../b", "invalid module path /b"},
+		{"module a\nrequire b -v1 ../b", "invalid version -v1"},
+		{"module a\nrequire b v1/../.. ../b", "invalid version v1/../.."},
+		{"module a\nrequire b v1 git:--upload-pack=touch", "invalid source git:--upload-pack=touch"},
+	}
+	for _, tt := range errors {
+		_, err := Parse("dir", []byte(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root, err := ioutil.TempDir("", "magpie-mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"app/magpie.mod":  "module acme/app\nrequire acme/util v1.0.0 ../util\nrequire acme/util/text v1.0.0 ../text\n",
+		"app/lib/math.mp": "",
+		"app/pkg/a.mp":    "",
+		"util/str.mp":     "",
+		"text/wrap.mp":    "",
+	})
+	app := filepath.Join(root, "app")
+
+	tests := []struct {
+		path     string
+		expected string //relative to 'root', "" if not provided by the modules
+		err      string
+	}{
+		{"acme/app/lib/math", "app/lib/math.mp", ""},
+		{"acme/app/pkg", "app/pkg", ""},
+		{"acme/util/str", "util/str.mp", ""},
+		{"acme/util/text/wrap", "text/wrap.mp", ""}, //the longest module path wins
+		{"acme/app/none", "", "module acme/app does not contain package acme/app/none"},
+		{"acme/other/x", "", ""},
+	}
+	for _, tt := range tests {
+		fn, err := Resolve(filepath.Join(app, "lib"), tt.path)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Resolve(%q): expected error %q, got=%v", tt.path, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): unexpected error: %s", tt.path, err)
+			continue
+		}
+		expected := ""
+		if tt.expected != "" {
+			expected = filepath.Join(root, filepath.FromSlash(tt.expected))
+		}
+		if fn != expected {
+			t.Errorf("Resolve(%q): expected %q, got=%q", tt.path, expected, fn)
+		}
+	}
+
+	//a vendored module takes precedence over its source
+	if err := os.MkdirAll(filepath.Join(app, "vendor/acme/util"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, app, map[string]string{"vendor/acme/util/str.mp": ""})
+	fn, err := Resolve(app, "acme/util/str")
+	if expected := filepath.Join(app, "vendor/acme/util/str.mp"); err != nil || fn != expected {
+		t.Errorf("Resolve: expected the vendored %q, got=%q, %v", expected, fn, err)
+	}
+}
+
+func TestTidyAndVendor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root, err := ioutil.TempDir("", "magpie-mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Setenv("MAGPIE_CACHE", filepath.Join(root, "cache"))
+	defer os.Unsetenv("MAGPIE_CACHE")
+
+	writeFiles(t, root, map[string]string{
+		"util/magpie.mod":   "module acme/util\nrequire acme/base v1.0.0 ../base\n",
+		"util/text/text.mp": "import acme.base.core\nfn Up(s) { return s.upper() }",
+		"base/core.mp":      "fn Core() { return 1 }",
+		"repo/json.mp":      "fn Name() { return \"json\" }",
+		"app/main.mp":       "import \"acme/util/text\"\nimport acme.json.json\nimport lib.local\n",
+		"app/lib/local.mp":  "",
+	})
+
+	//a git repository with the tag v1.0.0
+	repo := filepath.Join(root, "repo")
+	for _, args := range [][]string{
+		{"init", "-q"}, {"add", "-A"},
+		{"-c", "user.name=magpie", "-c", "user.email=magpie@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+
+	app := filepath.Join(root, "app")
+	var out bytes.Buffer
+	if err := Init(app, "acme/app", &out); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+	if err := Init(app, "acme/app", &out); err == nil {
+		t.Errorf("expected Init to fail if the magpie.mod exists")
+	}
+
+	//a missing require
+	if err := Tidy(app, &out); err == nil || !strings.Contains(err.Error(), "no required module provides package acme/util/text") {
+		t.Fatalf("expected a missing require error, got=%v", err)
+	}
+
+	writeFiles(t, app, map[string]string{"magpie.mod": `module acme/app
+require acme/util v0.1.0 ../util
+require acme/json v1.0.0 git:../repo
+require acme/unused v1.0.0 ../unused
+`})
+	if err := Tidy(app, &out); err != nil {
+		t.Fatalf("Tidy: %s", err)
+	}
+	f, _ := ReadFile(app)
+	if len(f.Requires) != 2 || f.Lookup("acme/unused") != nil {
+		t.Errorf("expected the unused require to be removed, got=%s", f.Format())
+	}
+
+	locks, err := ReadLock(app)
+	if err != nil {
+		t.Fatalf("ReadLock: %s", err)
+	}
+	var got []string
+	for _, l := range locks {
+		got = append(got, l.Path+"@"+l.Version)
+		if l.Path == "acme/json" && len(l.Rev) != 40 {
+			t.Errorf("expected the commit of acme/json, got=%q", l.Rev)
+		}
+	}
+	if expected := "acme/base@v1.0.0 acme/json@v1.0.0 acme/util@v0.1.0"; strings.Join(got, " ") != expected {
+		t.Errorf("expected locks %q, got=%q", expected, strings.Join(got, " "))
+	}
+	if fn, err := Resolve(app, "acme/json/json"); err != nil || fn != filepath.Join(CachePath("acme/json", "v1.0.0"), "json.mp") {
+		t.Errorf("expected acme/json in the module cache, got=%q, %v", fn, err)
+	}
+
+	//the downloaded module doesn't match the lock file
+	for _, l := range locks {
+		if l.Path == "acme/json" {
+			l.Hash = "h1:bad"
+		}
+	}
+	WriteLock(app, locks)
+	if err := Tidy(app, &out); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got=%v", err)
+	}
+	os.Remove(filepath.Join(app, LockFile))
+
+	if err := Vendor(app, &out); err != nil {
+		t.Fatalf("Vendor: %s", err)
+	}
+	for _, fn := range []string{"vendor/acme/util/text/text.mp", "vendor/acme/base/core.mp", "vendor/acme/json/json.mp", "vendor/modules.txt"} {
+		if !isFile(filepath.Join(app, fn)) {
+			t.Errorf("expected %s to be vendored", fn)
+		}
+	}
+	if isDir(filepath.Join(app, "vendor/acme/json/.git")) {
+		t.Errorf("the .git directory should not be vendored")
+	}
+
+	//the requires of a vendored module are found in the vendor directory of the main module
+	os.RemoveAll(filepath.Join(root, "base"))
+	if fn, err := Resolve(filepath.Join(app, "vendor/acme/util/text"), "acme/base/core"); err != nil || fn != filepath.Join(app, "vendor/acme/base/core.mp") {
+		t.Errorf("expected the vendored acme/base, got=%q, %v", fn, err)
+	}
+}
+
+func TestScanImports(t *testing.T) {
+	root, err := ioutil.TempDir("", "magpie-mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"a.mp":             "import x.y.z\nlet s = \"import not.this\"\nimport \"p/q\" as r",
+		"sub/b.mp":         "import c",
+		"vendor/v/v.mp":    "import skipped",
+		"other/magpie.mod": "module other",
+		"other/o.mp":       "import skipped",
+		".hidden/h.mp":     "import skipped",
+	})
+	imports, err := ScanImports(root)
+	if err != nil {
+		t.Fatalf("ScanImports: %s", err)
+	}
+	var got []string
+	for _, imp := range imports {
+		got = append(got, imp.Path)
+	}
+	if expected := "c p/q x/y/z"; strings.Join(got, " ") != expected {
+		t.Errorf("expected imports %q, got=%q", expected, strings.Join(got, " "))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"magpie/ast"
 	"magpie/lexer"
+	"magpie/module"
 	"magpie/token"
 	"os"
 	"path/filepath"
@@ -133,6 +134,13 @@ var (
 	DebugInfos    SortByLine
 )
 
+//ResetDebugInfos clears the debug informations collected while parsing. Long running
+//programs which parse many times(e.g. the language server) should call it after parsing.
+func ResetDebugInfos() {
+	tmpDebugInfos = nil
+	DebugInfos = nil
+}
+
 //group by ast.Node's line number.
 func SplitSlice(list []ast.Node) [][]ast.Node {
 	sort.Sort(SortByLine(list))
@@ -168,6 +176,7 @@ type Parser struct {
 	errors     []string //error messages
 	errorLines []string
 	path       string
+	importing  []string //the files and package directories being imported, to detect an import cycle
 
 	curToken  token.Token
 	peekToken token.Token
@@ -181,7 +190,40 @@ type Parser struct {
 	Functions map[string]*ast.FunctionLiteral
 
 	//macro defines
-	defines map[string]bool
+	defines   map[string]bool
+	constants map[string]ast.Expression //the values of '#define NAME value'
+	member    bool                      //the next identifier is a member(e.g. 'obj.NAME')
+
+	quotes int //the depth of the quotes being parsed
+
+	//the function literals being parsed, the innermost is the last one
+	fnStack []*ast.FunctionLiteral
+}
+
+//The classes provided by the interpreter(e.g. 'Exception'), which
+//could be used with 'new' without being declared.
+var BuiltinClasses = make(map[string]bool)
+
+//The macros defined on the command line('magpie -D NAME=value'), they're
+//defined in every file. A name without a value is only defined(for '#ifdef').
+var Defines = make(map[string]string)
+
+//AddDefine adds a command line macro of 'NAME' or 'NAME=value' to 'Defines'.
+//The value after '=' must not be empty, or the name would be defined without
+//being bound.
+func AddDefine(def string) error {
+	name, value := def, ""
+	if idx := strings.Index(def, "="); idx >= 0 {
+		name, value = def[:idx], def[idx+1:]
+		if value == "" {
+			return fmt.Errorf("-D %s: empty macro value, use '-D %s' to define it without a value", def, name)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("-D %s: empty macro name", def)
+	}
+	Defines[name] = value
+	return nil
 }
 
 type (
@@ -209,7 +251,7 @@ func NewWithDoc(l *lexer.Lexer, wd string) *Parser {
 
 	p.classMap = make(map[string]bool)
 	p.Functions = make(map[string]*ast.FunctionLiteral)
-	p.defines = make(map[string]bool)
+	p.initDefines()
 
 	p.registerAction()
 	p.nextToken()
@@ -227,7 +269,7 @@ func New(l *lexer.Lexer, wd string) *Parser {
 
 	p.classMap = make(map[string]bool)
 	p.Functions = make(map[string]*ast.FunctionLiteral)
-	p.defines = make(map[string]bool)
+	p.initDefines()
 
 	p.registerAction()
 	p.nextToken()
@@ -237,7 +279,7 @@ func New(l *lexer.Lexer, wd string) *Parser {
 
 func (p *Parser) registerAction() {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
-	p.registerPrefix(token.IDENT, p.parseIdentifier)
+	p.registerPrefix(token.IDENT, p.parseIdentifierExpression)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.UINT, p.parseUIntegerLiteral)
 	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
@@ -288,6 +330,11 @@ func (p *Parser) registerAction() {
 	//async & await
 	p.registerPrefix(token.ASYNC, p.parseAsyncLiteral)
 	p.registerPrefix(token.AWAIT, p.parseAwaitExpression)
+	p.registerPrefix(token.QUOTE, p.parseQuoteExpression)
+	p.registerPrefix(token.UNQUOTE, p.parseUnquoteExpression)
+
+	//generator
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
 
 	//datetime literal
 	p.registerPrefix(token.DATETIME, p.parseDateTime)
@@ -417,8 +464,13 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 			if importStmt, ok := stmt.(*ast.ImportStatement); ok {
 				importPath := strings.TrimSpace(importStmt.ImportPath)
-				_, ok := program.Imports[importPath]
-				if !ok {
+				old, ok := program.Imports[importPath]
+				if ok && old.File != importStmt.File {
+					msg := fmt.Sprintf("Syntax Error:%v- import '%s' conflicts with 'import %s', use 'import \"%s\" as name'",
+						importStmt.Pos(), importStmt.Path, old.Path, importStmt.Path)
+					p.errors = append(p.errors, msg)
+					p.errorLines = append(p.errorLines, importStmt.Pos().Sline())
+				} else if !ok {
 					for k, funcLiteral := range importStmt.Functions {
 						p.Functions[k] = funcLiteral
 					} //for debugger
@@ -480,6 +532,8 @@ func (p *Parser) ParseProgram() *ast.Program {
 			DebugInfos = append(DebugInfos, n)
 		case *ast.SpawnStmt:
 			DebugInfos = append(DebugInfos, n)
+		case *ast.SelectStmt:
+			DebugInfos = append(DebugInfos, n)
 		case *ast.UsingStmt:
 			DebugInfos = append(DebugInfos, n)
 		case *ast.QueryExpr:
@@ -503,6 +557,11 @@ func (p *Parser) parseStatement() ast.Statement {
 		ret = p.parseDeferStatement()
 	case token.SPAWN:
 		ret = p.parseSpawnStatement()
+	case token.SELECT:
+		if !p.peekTokenIs(token.LBRACE) {
+			return p.parseExpressionStatement()
+		}
+		ret = p.parseSelectStatement()
 	case token.IMPORT:
 		return p.parseImportStatement()
 	case token.TRY:
@@ -522,8 +581,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		//     fn +(v) { block }
 		//so we should not use above code
 		return p.parseFunctionStatement()
+	case token.AT:
+		return p.parseAnnotatedFunctionStatement()
 	case token.CLASS:
 		return p.parseClassStatement()
+	case token.INTERFACE:
+		return p.parseInterfaceStatement()
 	case token.SERVICE:
 		return p.parseServiceStatement()
 	case token.ENUM:
@@ -534,6 +597,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseAsyncStatement()
 	case token.DEFINE:
 		return p.parseDefineStatement()
+	case token.MACRO:
+		return p.parseMacroStatement()
 	case token.IFDEF_MACRO:
 		ret = p.parseIfMacroStatement()
 	case token.LBRACE:
@@ -784,19 +849,14 @@ func (p *Parser) parseTryStatement() ast.Statement {
 	p.nextToken()
 	tryStmt.Try = p.parseBlockStatement()
 
-	if p.peekTokenIs(token.CATCH) {
+	for p.peekTokenIs(token.CATCH) {
 		p.nextToken() //skip '}'
 
-		if p.peekTokenIs(token.IDENT) {
-			p.nextToken()
-			tryStmt.Var = p.curToken.Literal
-		}
-
-		if !p.expectPeek(token.LBRACE) {
+		clause := p.parseCatchClause()
+		if clause == nil {
 			return nil
 		}
-
-		tryStmt.Catch = p.parseBlockStatement()
+		tryStmt.Catches = append(tryStmt.Catches, clause)
 	}
 
 	if p.peekTokenIs(token.FINALLY) {
@@ -811,6 +871,50 @@ func (p *Parser) parseTryStatement() ast.Statement {
 	return tryStmt
 }
 
+//catch { }
+//catch e { }
+//catch (e) { }
+//catch (e: IOError | KeyError) { }
+func (p *Parser) parseCatchClause() *ast.CatchClause {
+	clause := &ast.CatchClause{Token: p.curToken}
+
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		clause.Var = p.curToken.Literal
+	} else if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		clause.Var = p.curToken.Literal
+
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			for {
+				if !p.expectPeek(token.IDENT) {
+					return nil
+				}
+				clause.Types = append(clause.Types, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+				if !p.peekTokenIs(token.BITOR) {
+					break
+				}
+				p.nextToken()
+			}
+		}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	clause.Block = p.parseBlockStatement()
+	return clause
+}
+
 func (p *Parser) parseThrowStatement() *ast.ThrowStmt {
 	stmt := &ast.ThrowStmt{Token: p.curToken}
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -857,6 +961,10 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	if p.peekTokenIs(token.RBRACE) { //e.g. { return }
 		return stmt
 	}
+	//the end of a 'select' case, e.g. 'case <-done: return'
+	if p.peekTokenIs(token.DEFAULT) || (p.peekTokenIs(token.CASE) && p.peekToken.Pos.Line > p.curToken.Pos.Line) {
+		return stmt
+	}
 
 	p.nextToken()
 	for {
@@ -943,6 +1051,18 @@ func (p *Parser) parseLetStatement(inClass bool, nextFlag bool) *ast.LetStatemen
 		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		stmt.Names = append(stmt.Names, name)
 
+		if p.peekTokenIs(token.COLON) { //e.g. 'let x: int = 1'
+			p.nextToken()
+			t := p.parseTypeAnnotation()
+			if t == nil {
+				return stmt
+			}
+			for len(stmt.Types) < len(stmt.Names)-1 {
+				stmt.Types = append(stmt.Types, nil)
+			}
+			stmt.Types = append(stmt.Types, t)
+		}
+
 		if !p.peekTokenIs(token.ASSIGN) && !p.curTokenIs(token.SEMICOLON) && !p.peekTokenIs(token.COMMA) {
 			if p.peekTokenIs(token.SEMICOLON) {
 				p.nextToken()
@@ -1218,71 +1338,172 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	return e
 }
 
+//import a.b.c
+//import "a/b/c"
+//import "a/b/c" as d
 func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	stmt := &ast.ImportStatement{Token: p.curToken}
 
 	p.nextToken()
 
-	paths := []string{}
-	paths = append(paths, p.curToken.Literal)
-
-	for p.peekTokenIs(token.DOT) {
-		p.nextToken()
-		p.nextToken()
+	var path string
+	if p.curTokenIs(token.STRING) {
+		path = strings.TrimSpace(p.curToken.Literal)
+	} else {
+		paths := []string{}
 		paths = append(paths, p.curToken.Literal)
-	}
 
-	path := strings.TrimSpace(strings.Join(paths, "/"))
+		for p.peekTokenIs(token.DOT) {
+			p.nextToken()
+			p.nextToken()
+			paths = append(paths, p.curToken.Literal)
+		}
+		path = strings.TrimSpace(strings.Join(paths, "/"))
+	}
+	stmt.Path = path
 	stmt.ImportPath = filepath.Base(path)
 
-	program, funcs, err := p.getImportedStatements(path)
+	//'as' is not a keyword, so it must be on the same line
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "as" && p.peekToken.Pos.Line == p.curToken.Pos.Line {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return stmt
+		}
+		stmt.ImportPath = p.curToken.Literal
+	}
+
+	program, funcs, file, err := p.getImportedStatements(path)
 	if err != nil {
 		p.errors = append(p.errors, err.Error())
 		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
 		return stmt
 	}
+	stmt.File = file
 	stmt.Functions = funcs
 	stmt.Program = program
 	return stmt
 }
 
-func (p *Parser) getImportedStatements(importpath string) (*ast.Program, map[string]*ast.FunctionLiteral, error) {
+//getImportedStatements parses the imported file, or all the files of the imported package directory.
+//It returns the parsed program, its functions and the resolved file or directory.
+func (p *Parser) getImportedStatements(importpath string) (*ast.Program, map[string]*ast.FunctionLiteral, string, error) {
 	path := p.path
 
 	if path == "" {
 		path = "."
 	}
 
-	fn := filepath.Join(path, importpath+".mp")
-	f, err := ioutil.ReadFile(fn)
-	if err != nil { //error occurred, maybe the file do not exists.
-		// Check for 'MAGPIE_ROOT' environment variable
-		importRoot := os.Getenv("MAGPIE_ROOT")
-		if len(importRoot) == 0 { //'MAGPIE_ROOT' environment variable is not set
-			return nil, nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, path)
+	fn, err := p.resolveImport(path, importpath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+
+	importing := p.importing
+	if len(importing) == 0 && p.curToken.Pos.Filename != "" { //the main file
+		if abs, err := filepath.Abs(p.curToken.Pos.Filename); err == nil {
+			importing = []string{abs}
+		}
+	}
+	for i, f := range importing {
+		if f == fn {
+			cycle := strings.Join(append(importing[i:len(importing):len(importing)], fn), " -> ")
+			return nil, nil, "", fmt.Errorf("Syntax Error:%v- import cycle not allowed: %s", p.curToken.Pos, cycle)
+		}
+	}
+	importing = append(importing[:len(importing):len(importing)], fn)
+
+	files := []string{fn}
+	if !strings.HasSuffix(fn, ".mp") { //package directory
+		if files, err = module.PackageFiles(fn); err != nil {
+			return nil, nil, "", fmt.Errorf("Syntax Error:%v- %s", p.curToken.Pos, err)
+		}
+	}
+
+	program := &ast.Program{Statements: []ast.Statement{}, Imports: make(map[string]*ast.ImportStatement)}
+	funcs := make(map[string]*ast.FunctionLiteral)
+	for _, file := range files {
+		f, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("Syntax Error:%v- %s", p.curToken.Pos, err)
+		}
+
+		l := lexer.New(file, string(f))
+		var ps *Parser
+		if p.mode&ParseComments == 0 {
+			ps = New(l, path)
 		} else {
-			fn = filepath.Join(importRoot, importpath+".mp")
-			e, err := ioutil.ReadFile(fn)
-			if err != nil {
-				return nil, nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, importRoot)
-			}
-			f = e
+			ps = NewWithDoc(l, path)
+		}
+		ps.importing = importing
+		parsed := ps.ParseProgram()
+		if len(ps.errors) != 0 {
+			p.errors = append(p.errors, ps.errors...)
+			p.errorLines = append(p.errorLines, ps.errorLines...)
+		}
+		if parsed == nil {
+			continue
+		}
+		program.Statements = append(program.Statements, parsed.Statements...)
+		for k, v := range parsed.Imports {
+			program.Imports[k] = v
+		}
+		for k, v := range ps.Functions {
+			funcs[k] = v
 		}
 	}
+	return program, funcs, fn, nil
+}
 
-	l := lexer.New(fn, string(f))
-	var ps *Parser
-	if p.mode&ParseComments == 0 {
-		ps = New(l, path)
-	} else {
-		ps = NewWithDoc(l, path)
+//resolveImport resolves an import path to a '.mp' file or a package directory. The modules(magpie.mod)
+//take precedence, then the paths relative to the parser's directory, then 'MAGPIE_ROOT'.
+func (p *Parser) resolveImport(path string, importpath string) (string, error) {
+	fn, err := module.Resolve(p.fileDir(), importpath)
+	if err != nil {
+		return "", fmt.Errorf("Syntax Error:%v- %s", p.curToken.Pos, err)
+	}
+	if fn != "" {
+		return fn, nil
 	}
-	parsed := ps.ParseProgram()
-	if len(ps.errors) != 0 {
-		p.errors = append(p.errors, ps.errors...)
-		p.errorLines = append(p.errorLines, ps.errorLines...)
+
+	if fn = module.Lookup(path, importpath); fn != "" {
+		return fn, nil
+	}
+
+	// Check for 'MAGPIE_ROOT' environment variable
+	importRoot := os.Getenv("MAGPIE_ROOT")
+	if len(importRoot) == 0 { //'MAGPIE_ROOT' environment variable is not set
+		return "", fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, path)
+	}
+	if fn = module.Lookup(importRoot, importpath); fn == "" {
+		return "", fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, importRoot)
+	}
+	return fn, nil
+}
+
+//fileDir returns the directory of the file being parsed.
+func (p *Parser) fileDir() string {
+	filename := p.curToken.Pos.Filename
+	if filename == "" {
+		return p.path
+	}
+	if filepath.IsAbs(filename) {
+		return filepath.Dir(filename)
 	}
-	return parsed, ps.Functions, nil
+	if fn := filepath.Join(p.path, filename); p.path != "" && fileExists(fn) {
+		return filepath.Dir(fn)
+	}
+	if fileExists(filename) {
+		return filepath.Dir(filename)
+	}
+	return p.path
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
 }
 
 func (p *Parser) parseDoLoopExpression() ast.Expression {
@@ -1577,6 +1798,16 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+//an identifier in an expression, a '#define' constant is replaced by its value
+func (p *Parser) parseIdentifierExpression() ast.Expression {
+	member := p.member
+	p.member = false
+	if value, ok := p.constants[p.curToken.Literal]; ok && !member {
+		return ast.Copy(value).(ast.Expression)
+	}
+	return p.parseIdentifier()
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
@@ -2286,6 +2517,7 @@ func (p *Parser) parseExpressionArrayEx(a []ast.Expression, closure token.TokenT
 // case expr in {
 //    expr,expr { expr }
 //    expr { expr }
+//    pattern if guard { expr }
 //    else { expr }
 // }
 func (p *Parser) parseCaseExpression() ast.Expression {
@@ -2321,9 +2553,12 @@ func (p *Parser) parseCaseExpression() ast.Expression {
 			p.nextToken() //skip the '}'
 		} else {
 			var aMatches []*ast.CaseMatchExpr
-			for !p.curTokenIs(token.LBRACE) {
+			for !p.curTokenIs(token.LBRACE) || len(aMatches) == 0 {
 				aMatch := &ast.CaseMatchExpr{Token: p.curToken}
-				aMatch.Expr = p.parseExpression(LOWEST)
+				aMatch.Expr = p.parseCasePattern(true)
+				if aMatch.Expr == nil || p.curTokenIs(token.EOF) {
+					return nil
+				}
 				aMatches = append(aMatches, aMatch)
 
 				if !p.peekTokenIs(token.COMMA) {
@@ -2335,6 +2570,16 @@ func (p *Parser) parseCaseExpression() ast.Expression {
 
 			} //end for
 
+			//the guard of the arm
+			if p.curTokenIs(token.IF) {
+				p.nextToken()
+				guard := p.parseExpression(LOWEST)
+				for i := 0; i < len(aMatches); i++ {
+					aMatches[i].Guard = guard
+				}
+				p.nextToken()
+			}
+
 			if !p.curTokenIs(token.LBRACE) {
 				msg := fmt.Sprintf("Syntax Error:%v- expected token to be '{', got %s instead", p.curToken.Pos, p.curToken.Type)
 				p.errors = append(p.errors, msg)
@@ -2361,6 +2606,192 @@ func (p *Parser) parseCaseExpression() ast.Expression {
 	return ce
 }
 
+// parseCasePattern parses the pattern of a 'case' arm, the alternatives are
+// separated by '|'. At the top level of an arm, a name is a value to compare
+// with(as before the patterns), in the structural patterns it's a binding.
+func (p *Parser) parseCasePattern(top bool) ast.Expression {
+	first := p.parseSinglePattern(top)
+	if !p.peekTokenIs(token.BITOR) {
+		return first
+	}
+
+	alt := &ast.AltPattern{Token: p.peekToken, Alternatives: []ast.Expression{first}}
+	for p.peekTokenIs(token.BITOR) {
+		p.nextToken()
+		p.nextToken()
+		alt.Alternatives = append(alt.Alternatives, p.parseSinglePattern(top))
+	}
+	return alt
+}
+
+func (p *Parser) parseSinglePattern(top bool) ast.Expression {
+	switch {
+	case p.curTokenIs(token.UNDERSCORE):
+		if p.peekTokenIs(token.COLON) {
+			return p.parseTypePattern(nil)
+		}
+		return &ast.WildcardPattern{Token: p.curToken}
+	case p.curTokenIs(token.IDENT):
+		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		switch {
+		case p.peekTokenIs(token.COLON):
+			return p.parseTypePattern(name)
+		case p.peekTokenIs(token.LPAREN):
+			return p.parseClassPattern(name)
+		case !top && !p.peekTokenIs(token.DOT) && !p.peekTokenIs(token.LBRACKET) && !p.peekTokenIs(token.DOTDOT):
+			return &ast.BindPattern{Token: p.curToken, Name: name}
+		}
+	case p.curTokenIs(token.LBRACKET):
+		sp, _ := p.parseSequencePattern(false, token.RBRACKET)
+		if sp == nil {
+			return nil
+		}
+		return sp
+	case p.curTokenIs(token.LPAREN):
+		sp, comma := p.parseSequencePattern(true, token.RPAREN)
+		if sp == nil {
+			return nil
+		}
+		if len(sp.Elements) == 1 && sp.Rest < 0 && !comma { //(pattern)
+			return sp.Elements[0]
+		}
+		return sp
+	case p.curTokenIs(token.LBRACE):
+		return p.parseHashPattern()
+	}
+
+	//a value, or a range of values
+	value := p.parseExpression(DOTDOT)
+	if !p.peekTokenIs(token.DOTDOT) {
+		return value
+	}
+	rp := &ast.RangePattern{Token: p.peekToken, Low: value}
+	p.nextToken()
+	p.nextToken()
+	rp.High = p.parseExpression(DOTDOT)
+	return rp
+}
+
+// name: type
+func (p *Parser) parseTypePattern(name *ast.Identifier) ast.Expression {
+	tp := &ast.TypePattern{Token: p.curToken, Name: name}
+	p.nextToken() //skip the name
+	p.nextToken() //skip ':'
+	switch p.curToken.Type {
+	case token.IDENT, token.NIL, token.FUNCTION, token.STRUCT:
+		tp.TypeName = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	default:
+		msg := fmt.Sprintf("Syntax Error:%v- expected a type name after ':', got %s instead", p.curToken.Pos, p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		return nil
+	}
+	return tp
+}
+
+// [a, *rest, b] or (a, b), it also reports whether there's a comma, so '(x)'
+// could be told from '(x,)'.
+func (p *Parser) parseSequencePattern(isTuple bool, end token.TokenType) (*ast.SequencePattern, bool) {
+	sp := &ast.SequencePattern{Token: p.curToken, IsTuple: isTuple, Rest: -1}
+	comma := false
+	for !p.peekTokenIs(end) {
+		p.nextToken()
+		if p.curTokenIs(token.ASTERISK) {
+			if sp.Rest >= 0 {
+				msg := fmt.Sprintf("Syntax Error:%v- only one '*' is allowed in a pattern", p.curToken.Pos)
+				p.errors = append(p.errors, msg)
+				p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+				return nil, false
+			}
+			sp.Rest = len(sp.Elements)
+			p.nextToken()
+			if p.curTokenIs(token.IDENT) {
+				sp.RestName = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			} else if !p.curTokenIs(token.UNDERSCORE) {
+				msg := fmt.Sprintf("Syntax Error:%v- expected a name or '_' after '*', got %s instead", p.curToken.Pos, p.curToken.Type)
+				p.errors = append(p.errors, msg)
+				p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+				return nil, false
+			}
+		} else {
+			sp.Elements = append(sp.Elements, p.parseCasePattern(false))
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+		comma = true
+	}
+	if !p.expectPeek(end) {
+		return nil, false
+	}
+	sp.EndToken = p.curToken
+	return sp, comma
+}
+
+// {Name: pattern, "key": pattern, Name}, the last one is short for 'Name: Name'.
+func (p *Parser) parseHashPattern() ast.Expression {
+	hp := &ast.HashPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key, value ast.Expression
+		switch p.curToken.Type {
+		case token.IDENT:
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+			if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE) {
+				value = &ast.BindPattern{Token: p.curToken, Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+			}
+		case token.STRING, token.INT, token.UINT:
+			key = p.parseExpression(SLICE)
+		default:
+			msg := fmt.Sprintf("Syntax Error:%v- expected a name, string or integer key in the hash pattern, got %s instead", p.curToken.Pos, p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			return nil
+		}
+		if value == nil {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			value = p.parseCasePattern(false)
+		}
+		hp.Keys = append(hp.Keys, key)
+		hp.Values = append(hp.Values, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hp.EndToken = p.curToken
+	return hp
+}
+
+// Class(pattern, ...)
+func (p *Parser) parseClassPattern(name *ast.Identifier) ast.Expression {
+	cp := &ast.ClassPattern{Token: p.curToken, Class: name}
+	p.nextToken() //'('
+	for !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		cp.Args = append(cp.Args, p.parseCasePattern(false))
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	cp.EndToken = p.curToken
+	return cp
+}
+
 //fn name(paramenters)
 func (p *Parser) parseFunctionStatement() ast.Statement {
 	FnStmt := &ast.FunctionStatement{Token: p.curToken}
@@ -2389,6 +2820,66 @@ func (p *Parser) parseFunctionStatement() ast.Statement {
 	return FnStmt
 }
 
+//@Annotation fn name(x, y) { block }
+//@Annotation(key=value, ...) fn name(x, y) { block }
+//Only the functions(not the function literals) could have annotations, e.g. '@Test' for 'magpie test'.
+func (p *Parser) parseAnnotatedFunctionStatement() ast.Statement {
+	var annos []*ast.AnnotationStmt
+	for p.curTokenIs(token.AT) {
+		anno := &ast.AnnotationStmt{Token: p.curToken, Attributes: map[string]ast.Expression{}}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		anno.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if p.peekTokenIs(token.LPAREN) || p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			end, endLiteral := token.RPAREN, ")"
+			if p.curTokenIs(token.LBRACE) {
+				end, endLiteral = token.RBRACE, "}"
+			}
+
+			p.nextToken()
+			for !p.curTokenIs(end) {
+				if !p.curTokenIs(token.IDENT) {
+					msg := fmt.Sprintf("Syntax Error:%v- expected token to be IDENT, got '%s' instead", p.curToken.Pos, p.curToken.Type)
+					p.errors = append(p.errors, msg)
+					p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+					return nil
+				}
+				key := p.curToken.Literal
+				if !p.expectPeek(token.ASSIGN) {
+					return nil
+				}
+				p.nextToken()
+				anno.Attributes[key] = p.parseExpression(LOWEST)
+				p.nextToken()
+				if p.curTokenIs(token.COMMA) {
+					p.nextToken()
+				} else if !p.curTokenIs(end) {
+					msg := fmt.Sprintf("Syntax Error:%v- expected token to be '%s', got '%s' instead", p.curToken.Pos, endLiteral, p.curToken.Type)
+					p.errors = append(p.errors, msg)
+					p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+					return nil
+				}
+			}
+		}
+		p.nextToken()
+		annos = append(annos, anno)
+	}
+
+	if !p.curTokenIs(token.FUNCTION) {
+		msg := fmt.Sprintf("Syntax Error:%v- expected token to be 'fn' after the annotation, got '%s' instead.", p.curToken.Pos, p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		return nil
+	}
+
+	r := p.parseFunctionStatement().(*ast.FunctionStatement)
+	r.Annotations = annos
+	return r
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	fn := &ast.FunctionLiteral{Token: p.curToken, Variadic: false}
 	if !p.expectPeek(token.LPAREN) {
@@ -2396,9 +2887,17 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	p.parseFuncExpressionArray(fn, token.RPAREN)
+	if p.peekTokenIs(token.COLON) { //return type, e.g. 'fn add(a, b): int'
+		p.nextToken()
+		if fn.ReturnType = p.parseTypeAnnotation(); fn.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if p.expectPeek(token.LBRACE) {
+		p.fnStack = append(p.fnStack, fn)
 		fn.Body = p.parseBlockStatement()
+		p.fnStack = p.fnStack[:len(p.fnStack)-1]
 	}
 	return fn
 }
@@ -2422,6 +2921,18 @@ func (p *Parser) parseFuncExpressionArray(fn *ast.FunctionLiteral, closure token
 		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		fn.Parameters = append(fn.Parameters, name)
 
+		if p.peekTokenIs(token.COLON) { //e.g. 'a: int'
+			p.nextToken()
+			t := p.parseTypeAnnotation()
+			if t == nil {
+				return
+			}
+			if fn.ParamTypes == nil {
+				fn.ParamTypes = make(map[string]*ast.TypeAnnotation)
+			}
+			fn.ParamTypes[key] = t
+		}
+
 		if p.peekTokenIs(token.ASSIGN) {
 			hasDefParamValue = true
 			p.nextToken()
@@ -2478,9 +2989,31 @@ func (p *Parser) parseFuncExpressionArray(fn *ast.FunctionLiteral, closure token
 	return
 }
 
-func (p *Parser) parseCallExpressions(f ast.Expression) ast.Expression {
-	call := &ast.CallExpression{Token: p.curToken, Function: f}
-	call.Arguments = p.parseExpressionArray(call.Arguments, token.RPAREN)
+// : type, e.g. ': int' or ': int | nil'
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	t := &ast.TypeAnnotation{Token: p.curToken}
+	for {
+		p.nextToken()
+		switch p.curToken.Type {
+		case token.IDENT, token.NIL, token.FUNCTION, token.STRUCT:
+			t.Types = append(t.Types, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		default:
+			msg := fmt.Sprintf("Syntax Error:%v- expected a type name, got %s instead", p.curToken.Pos, p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			return nil
+		}
+
+		if !p.peekTokenIs(token.BITOR) {
+			return t
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseCallExpressions(f ast.Expression) ast.Expression {
+	call := &ast.CallExpression{Token: p.curToken, Function: f}
+	call.Arguments = p.parseExpressionArray(call.Arguments, token.RPAREN)
 	return call
 }
 
@@ -2511,7 +3044,9 @@ func (p *Parser) parseMethodCallExpression(obj ast.Expression) ast.Expression {
 		//methodCall.Call = p.parseExpression(LOWEST)
 		//Note: here the precedence should not be `LOWEST`, or else when parsing below line:
 		//     logger.LDATE + 1 ==> logger.(LDATE + 1)
+		p.member = true
 		methodCall.Call = p.parseExpression(CALL)
+		p.member = false
 	} else {
 		p.nextToken()
 		methodCall.Call = p.parseCallExpressions(name)
@@ -2561,6 +3096,156 @@ func (p *Parser) parseSpawnStatement() *ast.SpawnStmt {
 	return stmt
 }
 
+//select {
+//    case v, ok = <-ch1: ...
+//    case ch2 <- x:      ...
+//    case after(1.5):    ...
+//    default:            ...
+//}
+//The channel and the value expressions are parsed with the precedence 'SLICE',
+//so they stop before the ':'(e.g. 'case ch <- (a > b):' needs the parentheses).
+func (p *Parser) parseSelectStatement() ast.Statement {
+	stmt := &ast.SelectStmt{Token: p.curToken}
+
+	p.nextToken() //skip 'select'
+	p.nextToken() //skip '{'
+	for !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			continue
+		}
+		sc := p.parseSelectCase()
+		if sc == nil {
+			return nil
+		}
+		stmt.Cases = append(stmt.Cases, sc)
+	}
+	stmt.RBraceToken = p.curToken
+
+	return stmt
+}
+
+//parse a 'case' or 'default' clause of 'select', the current token is the
+//token after the clause when it returns.
+func (p *Parser) parseSelectCase() *ast.SelectCase {
+	sc := &ast.SelectCase{Token: p.curToken}
+
+	switch {
+	case p.curTokenIs(token.DEFAULT):
+		sc.Kind = ast.SelectDefault
+	case !p.curTokenIs(token.CASE):
+		msg := fmt.Sprintf("Syntax Error:%v- expected 'case' or 'default' in select, got %s instead", p.curToken.Pos, p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		return nil
+	default:
+		p.nextToken() //skip 'case'
+		if !p.parseSelectComm(sc) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	sc.Body = &ast.BlockStatement{Token: p.curToken, RBraceToken: p.curToken}
+	p.nextToken() //skip ':'
+	for !p.curTokenIs(token.CASE) && !p.curTokenIs(token.DEFAULT) && !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.EOF) {
+			msg := fmt.Sprintf("Syntax Error:%v- no end symbol '}' found for select statement.", sc.Token.Pos)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, sc.Token.Pos.Sline())
+			return nil
+		}
+		if !p.curTokenIs(token.SEMICOLON) {
+			stmt := p.parseStatement()
+			if stmt != nil {
+				sc.Body.Statements = append(sc.Body.Statements, stmt)
+			}
+			sc.Body.RBraceToken = p.curToken
+		}
+		p.nextToken()
+	}
+
+	return sc
+}
+
+//parse the communication of a 'case': '[targets =] <-ch', 'ch <- value' or 'after(seconds)'
+func (p *Parser) parseSelectComm(sc *ast.SelectCase) bool {
+	if p.curTokenIs(token.IDENT) && p.curToken.Literal == "after" && p.peekTokenIs(token.LPAREN) {
+		sc.Kind = ast.SelectTimeout
+		p.nextToken()
+		p.nextToken()
+		sc.Value = p.parseExpression(LOWEST)
+		return sc.Value != nil && p.expectPeek(token.RPAREN)
+	}
+
+	if p.isRecvArrow() {
+		sc.Kind = ast.SelectRecv
+		return p.parseSelectRecv(sc)
+	}
+
+	first := p.parseExpression(LESSGREATER)
+	if first == nil {
+		return false
+	}
+
+	if p.peekTokenIs(token.LT) { //ch <- value
+		p.nextToken()
+		if !p.isRecvArrow() {
+			msg := fmt.Sprintf("Syntax Error:%v- expected '<-' in select case, got %s instead", p.curToken.Pos, p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			return false
+		}
+		sc.Kind = ast.SelectSend
+		sc.Chan = first
+		p.nextToken()
+		p.nextToken()
+		sc.Value = p.parseExpression(SLICE)
+		return sc.Value != nil
+	}
+
+	//v, ok = <-ch
+	sc.Kind = ast.SelectRecv
+	sc.Targets = append(sc.Targets, first)
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		second := p.parseExpression(LESSGREATER)
+		if second == nil {
+			return false
+		}
+		sc.Targets = append(sc.Targets, second)
+	}
+	if !p.expectPeek(token.ASSIGN) {
+		return false
+	}
+	p.nextToken()
+	if !p.isRecvArrow() {
+		msg := fmt.Sprintf("Syntax Error:%v- expected '<-' in select case, got %s instead", p.curToken.Pos, p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		return false
+	}
+	return p.parseSelectRecv(sc)
+}
+
+//parse '<-ch', the current token is the '<'
+func (p *Parser) parseSelectRecv(sc *ast.SelectCase) bool {
+	p.nextToken()
+	p.nextToken()
+	sc.Chan = p.parseExpression(SLICE)
+	return sc.Chan != nil
+}
+
+//'<-' is lexed as '<' and '-', so 'a<-1' still means 'a < -1' outside of 'select'.
+func (p *Parser) isRecvArrow() bool {
+	return p.curTokenIs(token.LT) && p.peekTokenIs(token.MINUS) &&
+		p.peekToken.Pos.Line == p.curToken.Pos.Line && p.peekToken.Pos.Col == p.curToken.Pos.Col+1
+}
+
 func (p *Parser) parseNilExpression() ast.Expression {
 	return &ast.NilLiteral{Token: p.curToken}
 }
@@ -2736,6 +3421,7 @@ func (p *Parser) parseClassLiteralForAnno() ast.Expression {
 }
 
 // class : parentClass { block }.
+// class : parentClass, interface1, interface2 { block }.
 //e.g. let classname = class : parentClass { block }
 func (p *Parser) parseClassLiteral() ast.Expression {
 	cls := &ast.ClassLiteral{
@@ -2750,8 +3436,18 @@ func (p *Parser) parseClassLiteral() ast.Expression {
 		if !p.expectPeek(token.IDENT) {
 			return nil
 		}
+		//Note: the first name could also be an interface, this is
+		//decided when the class is evaluated.
 		cls.Parent = p.curToken.Literal
 		p.nextToken()
+
+		for p.curTokenIs(token.COMMA) { //implemented interfaces
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			cls.Interfaces = append(cls.Interfaces, p.curToken.Literal)
+			p.nextToken()
+		}
 	}
 	if !p.curTokenIs(token.LBRACE) {
 		msg := fmt.Sprintf("Syntax Error:%v- expected token to be '{', got %s instead", p.curToken.Pos, p.curToken.Type)
@@ -2800,6 +3496,118 @@ func (p *Parser) parseClassLiteral() ast.Expression {
 	return cls
 }
 
+//interface name { fn method(x, y); property name { get; set; } }
+//interface name : parent1, parent2 { block }
+func (p *Parser) parseInterfaceStatement() *ast.InterfaceStatement {
+	stmt := &ast.InterfaceStatement{
+		Token:      p.curToken,
+		Methods:    make(map[string]*ast.InterfaceMethod),
+		Properties: make(map[string]*ast.PropertyDeclStmt),
+	}
+	stmt.Doc = p.lineComment
+
+	if !p.expectPeek(token.IDENT) { //interface name
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COLON) { //parent interfaces
+		p.nextToken()
+		for {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.Parents = append(stmt.Parents, p.curToken.Literal)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	p.nextToken() //skip '{'
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.FUNCTION:
+			m := p.parseInterfaceMethod()
+			if m == nil {
+				return nil
+			}
+			stmt.Methods[m.Name.Value] = m
+		case token.PROPERTY:
+			prop := p.parsePropertyDeclStmt(false)
+			if prop == nil {
+				return nil
+			}
+			stmt.Properties[prop.Name.Value] = prop
+		case token.SEMICOLON:
+		default:
+			msg := fmt.Sprintf("Syntax Error:%v- expected token to be 'fn'|'property', got %s instead. Only method and property declarations are allowed in interface.", p.curToken.Pos, p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			return nil
+		}
+		p.nextToken()
+	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		pos := p.curToken.Pos
+		msg := fmt.Sprintf("Syntax Error:%v- expected token to be '}', got EOF instead. Interface should end with '}'.", pos)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, pos.Sline())
+		return nil
+	}
+	stmt.SrcEndToken = p.curToken
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+//fn method(x, y)
+func (p *Parser) parseInterfaceMethod() *ast.InterfaceMethod {
+	m := &ast.InterfaceMethod{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) { //method name
+		return nil
+	}
+	m.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	//reuse the function parameter parsing
+	fn := &ast.FunctionLiteral{Token: m.Token}
+	p.parseFuncExpressionArray(fn, token.RPAREN)
+	m.Parameters = fn.Parameters
+	m.Variadic = fn.Variadic
+	m.ParamTypes = fn.ParamTypes
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if m.ReturnType = p.parseTypeAnnotation(); m.ReturnType == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.LBRACE) {
+		msg := fmt.Sprintf("Syntax Error:%v- Interface method '%s' should not have a body.", p.peekToken.Pos, m.Name.Value)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.peekToken.Pos.Sline())
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return m
+}
+
 func (p *Parser) parseClassBody(processAnnoClass bool) *ast.BlockStatement {
 	stmts := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
 
@@ -2990,6 +3798,12 @@ func (p *Parser) parsePropertyDeclStmt(processAnnoClass bool) *ast.PropertyDeclS
 		return nil
 	}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) { //e.g. 'property Year: int'
+		p.nextToken()
+		if stmt.Type = p.parseTypeAnnotation(); stmt.Type == nil {
+			return nil
+		}
+	}
 
 	if processAnnoClass || p.peekTokenIs(token.SEMICOLON) { //annotation class' property defaults to have both getter and setter.
 		getterToken := token.Token{Pos: p.curToken.Pos, Type: token.GET, Literal: "get"}
@@ -3117,7 +3931,7 @@ func (p *Parser) parseNewExpression() ast.Expression {
 	}
 
 	clsName := call.Function.(*ast.Identifier).Value
-	if _, ok := p.classMap[clsName]; !ok {
+	if !p.classMap[clsName] && !BuiltinClasses[clsName] {
 		pos := p.fixPosCol()
 		msg := fmt.Sprintf("Syntax Error:%v- 'new' should follow a 'class' name.", pos)
 		p.errors = append(p.errors, msg)
@@ -3218,6 +4032,8 @@ func (p *Parser) parseFatArrowFunction(left ast.Expression) ast.Expression {
 	}
 
 	p.nextToken()
+	p.fnStack = append(p.fnStack, fn)
+	defer func() { p.fnStack = p.fnStack[:len(p.fnStack)-1] }()
 	if p.curTokenIs(token.LBRACE) { //if it's block, we use parseBlockStatement
 		fn.Body = p.parseBlockStatement()
 	} else { //not block, we use parseStatement
@@ -3570,7 +4386,12 @@ func (p *Parser) parseOrderingExpr() ast.Expression {
 
 //let add = async fn(a, b)  { a + b }
 //let add = async (a, b) => { a + b }
+//async.all(tasks)           ('async' module)
 func (p *Parser) parseAsyncLiteral() ast.Expression {
+	if p.peekTokenIs(token.DOT) {
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
 	p.nextToken() //skip 'async'
 	if !p.curTokenIs(token.FUNCTION) && !p.curTokenIs(token.LPAREN) {
 		msg := fmt.Sprintf("Syntax Error:%v- async should be followed by a function or lambda, got %s instead.", p.curToken.Pos, p.curToken.Type)
@@ -3587,6 +4408,10 @@ func (p *Parser) parseAsyncLiteral() ast.Expression {
 
 //async fn add(a, b) { a + b }
 func (p *Parser) parseAsyncStatement() ast.Statement {
+	if p.peekTokenIs(token.DOT) { //async.xxx(...)
+		return p.parseExpressionStatement()
+	}
+
 	p.nextToken() //skip 'async'
 	if !p.curTokenIs(token.FUNCTION) {
 		msg := fmt.Sprintf("Syntax Error:%v- async should be followed by a function, got %s instead.", p.curToken.Pos, p.curToken.Type)
@@ -3603,25 +4428,41 @@ func (p *Parser) parseAsyncStatement() ast.Statement {
 
 //await add(1, 2)
 //await obj.xxx(params)
+//await tasks[0]
 func (p *Parser) parseAwaitExpression() ast.Expression {
 	expr := &ast.AwaitExpr{Token: p.curToken}
 
 	p.nextToken()
-	expr.Call = p.parseExpressionStatement().Expression
+	expr.Value = p.parseExpression(PREFIX)
+	if expr.Value == nil {
+		return nil
+	}
+	return expr
+}
 
-	//check call type
-	switch expr.Call.(type) {
-	case *ast.CallExpression:
-		return expr
-	case *ast.MethodCallExpression:
-		return expr
-	default:
-		msg := fmt.Sprintf("Syntax Error:%v- await keyword can only be used on function/method calls!", p.curToken.Pos)
+//yield value
+//yield             (yields nil)
+//x = yield value   (x is the value passed to the generator's 'send' method)
+//A function containing 'yield' is a generator function.
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expr := &ast.YieldExpression{Token: p.curToken}
+
+	if len(p.fnStack) == 0 {
+		msg := fmt.Sprintf("Syntax Error:%v- 'yield' outside of function", p.curToken.Pos)
 		p.errors = append(p.errors, msg)
 		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
-		return nil
+	} else {
+		p.fnStack[len(p.fnStack)-1].Generator = true
 	}
 
+	//no value, e.g. '{ yield }', 'yield;', 'x = (yield)', or the next token is on the next line
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.RPAREN) ||
+		p.peekTokenIs(token.EOF) || p.peekToken.Pos.Line != p.curToken.Pos.Line {
+		return expr
+	}
+
+	p.nextToken()
+	expr.Value = p.parseExpression(LOWEST)
 	return expr
 }
 
@@ -3659,6 +4500,8 @@ func (p *Parser) parseDiamond() ast.Expression {
 }
 
 //define macro
+//#define NAME
+//#define NAME value   (NAME is replaced by the value in the expressions)
 func (p *Parser) parseDefineStatement() ast.Statement {
 	if !p.expectPeek(token.IDENT) { //macro name
 		pos := p.fixPosCol()
@@ -3668,11 +4511,149 @@ func (p *Parser) parseDefineStatement() ast.Statement {
 		return nil
 	}
 
-	p.defines[p.curToken.Literal] = true
+	name := p.curToken.Literal
+	p.defines[name] = true
+	delete(p.constants, name)
+
+	//the value must be on the same line
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.EOF) || p.peekToken.Pos.Line != p.curToken.Pos.Line {
+		return nil
+	}
+
+	p.nextToken()
+	if value := p.parseExpression(LOWEST); value != nil {
+		p.constants[name] = value
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
 
 	return nil
 }
 
+//initDefines defines the macros of the command line('Defines').
+func (p *Parser) initDefines() {
+	p.defines = make(map[string]bool)
+	p.constants = make(map[string]ast.Expression)
+	for name, value := range Defines {
+		p.defines[name] = true
+		if value != "" {
+			p.constants[name] = defineValue(value)
+		}
+	}
+}
+
+//defineValue returns the expression of a command line define's value: a
+//number, a boolean, nil, or a string(which could be quoted).
+func defineValue(value string) ast.Expression {
+	tok := token.Token{Pos: token.Position{Filename: "<command line>"}, Literal: value}
+	if i, err := strconv.ParseInt(value, 0, 64); err == nil {
+		tok.Type = token.INT
+		return &ast.IntegerLiteral{Token: tok, Value: i}
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		tok.Type = token.FLOAT
+		return &ast.FloatLiteral{Token: tok, Value: f}
+	}
+	switch value {
+	case "true", "false":
+		tok.Type = token.LookupIdent(value)
+		return &ast.Boolean{Token: tok, Value: value == "true"}
+	case "nil":
+		tok.Type = token.NIL
+		return &ast.NilLiteral{Token: tok}
+	}
+
+	if s, err := strconv.Unquote(value); err == nil {
+		value = s
+	}
+	tok.Type, tok.Literal = token.STRING, value
+	return &ast.StringLiteral{Token: tok, Value: value}
+}
+
+//macro name(params) { block }
+//The body is run when the program is expanded, with the AST nodes of the
+//call's arguments, and it returns the AST node which replaces the call.
+func (p *Parser) parseMacroStatement() ast.Statement {
+	stmt := &ast.MacroStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) { //macro name
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	for !p.peekTokenIs(token.RPAREN) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Parameters = append(stmt.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+//quote(expression)
+//quote { block-statements }
+func (p *Parser) parseQuoteExpression() ast.Expression {
+	expr := &ast.QuoteExpression{Token: p.curToken}
+
+	p.quotes++
+	defer func() { p.quotes-- }()
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		expr.Node = p.parseBlockStatement()
+		return expr
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	value := p.parseExpression(LOWEST)
+	if value == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	expr.Node = value
+	return expr
+}
+
+//unquote(expression), the expression is evaluated when the quote is
+func (p *Parser) parseUnquoteExpression() ast.Expression {
+	expr := &ast.UnquoteExpression{Token: p.curToken}
+
+	if p.quotes == 0 {
+		msg := fmt.Sprintf("Syntax Error:%v- 'unquote' outside of quote", p.curToken.Pos)
+		p.errors = append(p.errors, msg)
+		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Expression = p.parseExpression(LOWEST)
+	if expr.Expression == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return expr
+}
+
 //service name on "addrs" { block }
 func (p *Parser) parseServiceStatement() *ast.ServiceStatement {
 	stmt := &ast.ServiceStatement{
@@ -3695,11 +4676,25 @@ func (p *Parser) parseServiceStatement() *ast.ServiceStatement {
 		return nil
 	}
 
-	stmt.Debug = strings.HasSuffix(p.curToken.Literal, ":debug")
-	if stmt.Debug {
-		stmt.Addr = strings.TrimSuffix(p.curToken.Literal, ":debug")
-	} else {
-		stmt.Addr = p.curToken.Literal
+	//options after the address: "localhost:8080:debug:openapi"
+	stmt.Addr = p.curToken.Literal
+	for {
+		if strings.HasSuffix(stmt.Addr, ":debug") {
+			stmt.Debug = true
+			stmt.Addr = strings.TrimSuffix(stmt.Addr, ":debug")
+		} else if strings.HasSuffix(stmt.Addr, ":openapi") {
+			stmt.OpenAPI = true
+			stmt.Addr = strings.TrimSuffix(stmt.Addr, ":openapi")
+		} else {
+			break
+		}
+	}
+
+	//the server options hash: service Hello on "0.0.0.0:8443", {"cert": "server.crt", "key": "server.key"} { ... }
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Options = p.parseExpression(LOWEST)
 	}
 
 	p.nextToken()
@@ -3713,14 +4708,29 @@ func (p *Parser) parseServiceStatement() *ast.ServiceStatement {
 	stmt.SrcEndToken = p.curToken
 	//fmt.Printf("service statement=%s\n", stmt.String())
 
+	//each function has one annotation of its kind('@route', '@middleware', etc),
+	//a '@route' function could also have a '@body' annotation.
 	for k, v := range stmt.Methods {
-		annoLen := len(v.Annotations)
-		if annoLen != 1 {
+		kindCount, bodyCount := 0, 0
+		for _, anno := range v.Annotations {
+			if anno.Name.Value == "body" {
+				bodyCount++
+			} else {
+				kindCount++
+			}
+		}
+		if kindCount != 1 {
 			msg := fmt.Sprintf("Syntax Error:%v- function(%s)'s annotation count not one", p.curToken.Pos, k)
 			p.errors = append(p.errors, msg)
 			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
 			return nil
 		}
+		if bodyCount > 0 && (bodyCount > 1 || ast.ServiceAnnotation(v).Name.Value != "route") {
+			msg := fmt.Sprintf("Syntax Error:%v- function(%s) could only have one '@body' annotation with '@route'", p.curToken.Pos, k)
+			p.errors = append(p.errors, msg)
+			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			return nil
+		}
 	}
 
 	return stmt
@@ -3752,16 +4762,34 @@ func (p *Parser) parseServiceBody(s *ast.ServiceStatement) *ast.BlockStatement {
 
 func (p *Parser) parseServiceStmt(s *ast.ServiceStatement) ast.Statement {
 	var annos []*ast.AnnotationStmt
+	doc := p.lineComment //the doc comments above the annotations
 
 	//parse Annotation
 	for p.curTokenIs(token.AT) {
 		anno := &ast.AnnotationStmt{Token: p.curToken, Attributes: map[string]ast.Expression{}}
 
-		if !p.expectPeek(token.IDENT) {
+		if p.peekTokenIs(token.STATIC) { //'static' is a keyword
+			p.nextToken()
+		} else if !p.expectPeek(token.IDENT) {
 			return nil
 		}
-		if p.curToken.Literal != "route" {
-			msg := fmt.Sprintf("Syntax Error:%v- expected token to be 'route', got '%s' instead", p.curToken.Pos, p.curToken.Literal)
+		switch p.curToken.Literal {
+		case "route", "websocket", "body", "static":
+		case "middleware", "before", "after":
+			//@middleware fn auth(writer, request, next) { block }
+			//@before fn check(writer, request) { block }
+			anno.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if p.peekTokenIs(token.LPAREN) { //allow '@middleware()'
+				p.nextToken()
+				if !p.expectPeek(token.RPAREN) {
+					return nil
+				}
+			}
+			p.nextToken()
+			annos = append(annos, anno)
+			continue
+		default:
+			msg := fmt.Sprintf("Syntax Error:%v- expected token to be 'route', 'websocket', 'static', 'body', 'middleware', 'before' or 'after', got '%s' instead", p.curToken.Pos, p.curToken.Literal)
 			p.errors = append(p.errors, msg)
 			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
 			return nil
@@ -3797,6 +4825,18 @@ func (p *Parser) parseServiceStmt(s *ast.ServiceStatement) ast.Statement {
 			return nil
 		}
 
+		//@static(url="/assets", dir="./public") has no function
+		if anno.Name.Value == "static" {
+			if len(annos) != 0 {
+				msg := fmt.Sprintf("Syntax Error:%v- '@static' can't be used with other annotations", anno.Token.Pos)
+				p.errors = append(p.errors, msg)
+				p.errorLines = append(p.errorLines, anno.Token.Pos.Sline())
+				return nil
+			}
+			s.Statics = append(s.Statics, anno)
+			return anno
+		}
+
 		p.nextToken()
 		annos = append(annos, anno)
 	} //end for
@@ -3811,6 +4851,9 @@ func (p *Parser) parseServiceStmt(s *ast.ServiceStatement) ast.Statement {
 	r := p.parseFunctionStatement().(*ast.FunctionStatement)
 	r.Annotations = annos
 	r.IsServiceAnno = true
+	if r.Doc == nil {
+		r.Doc = doc
+	}
 	s.Methods[r.Name.Value] = r
 
 	return r
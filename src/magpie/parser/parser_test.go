@@ -13,7 +13,7 @@ var path, _ = os.Getwd()
 
 func TestParsingDoLoopExpression(t *testing.T) {
 	input := `do {}`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -27,7 +27,7 @@ func TestParsingDoLoopExpression(t *testing.T) {
 
 func TestParsingWhileLoopExpression(t *testing.T) {
 	input := `while (5 < 10 ){}`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -42,7 +42,7 @@ func TestParsingWhileLoopExpression(t *testing.T) {
 func TestParsingForLoopExpression(t *testing.T) {
 	//input := `for (i = 0; i< 10; i = i+1) {}`
 	input := `for (i; i<10; i=i+1) {}`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -56,7 +56,7 @@ func TestParsingForLoopExpression(t *testing.T) {
 
 func TestParsingForEachArrayLoopExpression(t *testing.T) {
 	input := `for x in array where x > 5 {}`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -73,7 +73,7 @@ func TestParsingForEachArrayLoopExpression(t *testing.T) {
 
 func TestParsingForEachMapLoopExpression(t *testing.T) {
 	input := `for key, value in hash {}`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -93,7 +93,7 @@ func TestParsingForEachMapLoopExpression(t *testing.T) {
 
 func TestParsingGrepExpression(t *testing.T) {
 	input := `grep { $_ > 5 } [2,4,6,8,10]`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -106,12 +106,12 @@ func TestParsingGrepExpression(t *testing.T) {
 	if a.Var != "$_" {
 		t.Fatalf("a.Var is not '$_'. got=%T", a.Var)
 	}
-	t.Logf(a.Block.String())
-	t.Logf(a.Value.String())
+	t.Log(a.Block.String())
+	t.Log(a.Value.String())
 }
 func TestParsingAssignmentExpressions(t *testing.T) {
 	input := `x = 5`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -127,7 +127,7 @@ func TestParsingAssignmentExpressions(t *testing.T) {
 
 func TestParsingFloatAssignmentExpressions(t *testing.T) {
 	input := `x = 5.234`
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -142,8 +142,8 @@ func TestParsingFloatAssignmentExpressions(t *testing.T) {
 }
 
 func TestParsingEmptyHashLiteralExpressions(t *testing.T) {
-	input := `{}`
-	l := lexer.New(input)
+	input := `({})`
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -159,8 +159,8 @@ func TestParsingEmptyHashLiteralExpressions(t *testing.T) {
 }
 
 func TestParsingHashLiteralExpressions(t *testing.T) {
-	input := `{"one" : 1, "two" : 2, "three": 3}`
-	l := lexer.New(input)
+	input := `({"one" : 1, "two" : 2, "three": 3})`
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -192,7 +192,7 @@ func TestParsingHashLiteralExpressions(t *testing.T) {
 
 func TestParsingMethodExpressions(t *testing.T) {
 	input := "array.len(1, 2)"
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -221,17 +221,17 @@ func TestParsingSliceExpressions(t *testing.T) {
 		{"myArray[1:3];", 1, 3},
 		{"myArray[:3];", 0, 3},
 		{"myArray[1:];", 1, nil},
-		{"myArray[fn(){5}():5]", "fn () { 5 }", 5},
+		{"myArray[fn(){5}():5]", "fn () { 5; }", 5},
 		{"myArray[a:3];", "a", 3},
 		{"myArray[:-1]", 0, -1},
-		{"myArray[5:fn(){5}()]", 5, "fn () { 5 }"},
+		{"myArray[5:fn(){5}()]", 5, "fn () { 5; }"},
 		{"myArray[3:a];", 3, "a"},
 		{"myArray[1 + 1:0];", nil, 0},
 		{"myArray[0:1 + 1];", 0, nil},
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
@@ -293,7 +293,7 @@ func TestParsingSliceExpressions(t *testing.T) {
 func TestParsingIndexExpressions(t *testing.T) {
 	input := "myArray[1 + 1]"
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -310,7 +310,7 @@ func TestParsingIndexExpressions(t *testing.T) {
 
 func TestArrayExpression(t *testing.T) {
 	input := "[1, 2 * 3, 2 + 2]"
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 
@@ -328,9 +328,9 @@ func TestArrayExpression(t *testing.T) {
 }
 
 func TestRegExLiteralExpression(t *testing.T) {
-	input := `~\d+(\w)+.*$~;`
+	input := `/\d+(\w)+.*$/;`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -348,7 +348,7 @@ func TestRegExLiteralExpression(t *testing.T) {
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello, world";`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -379,7 +379,7 @@ func TestInterpolatedString(t *testing.T) {
 		{"'aa{x+1}abc'", "aa{0}abc", 1},
 	}
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
@@ -410,7 +410,7 @@ func TestLetStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 
 		program := p.ParseProgram()
@@ -436,13 +436,12 @@ func TestImportStatements(t *testing.T) {
 	tests := []struct {
 		input         string
 		expectedValue string
-		ismodule      bool
 	}{
-		{"import test_files", "test_files", true},
+		{"import test_files.test", "test"},
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 
 		program := p.ParseProgram()
@@ -452,14 +451,11 @@ func TestImportStatements(t *testing.T) {
 			t.Fatalf("program.Imports does not contain 1 statements. got=%d", len(program.Imports))
 		}
 		for _, v := range program.Imports {
-			if !testLiteralExpression(t, v.ImportPath, tt.expectedValue) {
-				return
-			}
-			if v.IsModule != tt.ismodule {
-				t.Fatalf("Imported value not %v. got=%v", v.IsModule, tt.ismodule)
+			if v.ImportPath != tt.expectedValue {
+				t.Fatalf("ImportPath not %q. got=%q", tt.expectedValue, v.ImportPath)
 			}
-			if len(v.Program.Imports) != 3 {
-				t.Fatalf("Imported Program had wrong number of modules. expected=3, got=%d", len(v.Program.Imports))
+			if len(v.Program.Statements) != 1 {
+				t.Fatalf("Imported Program had wrong number of statements. expected=1, got=%d", len(v.Program.Statements))
 			}
 		}
 	}
@@ -495,7 +491,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 
 		program := p.ParseProgram()
@@ -534,7 +530,7 @@ func checkParserErrors(t *testing.T, p *Parser) {
 func TestIdentifierExpression(t *testing.T) {
 	input := "foobar;"
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -581,7 +577,7 @@ func testBooleanExpression(t *testing.T, exp ast.Expression, value bool) bool {
 func TestIntegerLiteralExpression(t *testing.T) {
 	input := "5;"
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -620,7 +616,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 	}
 
 	for _, tt := range prefixTests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 
 		program := p.ParseProgram()
@@ -713,7 +709,7 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"true and false", true, "and", false},
 	}
 	for _, tt := range infixTests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
@@ -738,152 +734,152 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}{
 		{
 			"-a * b",
-			"((-a) * b)",
+			"((-a) * b);",
 		},
 		{
 			"!-a",
-			"(!(-a))",
+			"(!(-a));",
 		},
 		{
 			"a + b + c",
-			"((a + b) + c)",
+			"((a + b) + c);",
 		},
 		{
 			"a + b - c",
-			"((a + b) - c)",
+			"((a + b) - c);",
 		},
 		{
 			"a * b * c",
-			"((a * b) * c)",
+			"((a * b) * c);",
 		},
 		{
 			"a * b / c",
-			"((a * b) / c)",
+			"((a * b) / c);",
 		},
 		{
 			"a + b / c",
-			"(a + (b / c))",
+			"(a + (b / c));",
 		},
 		{
 			"a * b % c",
-			"((a * b) % c)",
+			"((a * b) % c);",
 		},
 		{
 			"a % b / c",
-			"((a % b) / c)",
+			"((a % b) / c);",
 		},
 		{
 			"a + b % c",
-			"(a + (b % c))",
+			"(a + (b % c));",
 		},
 		{
 			"a + b * c + d / e - f",
-			"(((a + (b * c)) + (d / e)) - f)",
+			"(((a + (b * c)) + (d / e)) - f);",
 		},
 		{
 			"3 + 4; -5 * 5",
-			"(3 + 4)((-5) * 5)",
+			"(3 + 4);((-5) * 5);",
 		},
 		{
 			"5 > 4 == 3 < 4",
-			"((5 > 4) == (3 < 4))",
+			"((5 > 4) == (3 < 4));",
 		},
 		{
 			"5 < 4 != 3 > 4",
-			"((5 < 4) != (3 > 4))",
+			"((5 < 4) != (3 > 4));",
 		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
-			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
+			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)));",
 		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
-			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
+			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)));",
 		},
 		{
 			"true",
-			"true",
+			"true;",
 		},
 		{
 			"false",
-			"false",
+			"false;",
 		},
 		{
 			"3 > 5 == false;",
-			"((3 > 5) == false)",
+			"((3 > 5) == false);",
 		},
 		{
 			"3 > 5 == true;",
-			"((3 > 5) == true)",
+			"((3 > 5) == true);",
 		},
 		{
 			"1 - (2 + 3) + 4",
-			"((1 - (2 + 3)) + 4)",
+			"((1 - (2 + 3)) + 4);",
 		},
 		{
 			"(5 + 5) * 2",
-			"((5 + 5) * 2)",
+			"((5 + 5) * 2);",
 		},
 		{
 			"-(5 + 5)",
-			"(-(5 + 5))",
+			"(-(5 + 5));",
 		},
 		{
 			"!(true == true)",
-			"(!(true == true))",
+			"(!(true == true));",
 		},
 		{
 			"a + add(b * c) + d",
-			"((a + add((b * c))) + d)",
+			"((a + add((b * c))) + d);",
 		},
 		{
 			"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))",
-			"add(a, b, 1, (2 * 3), (4 + 5), add(6, (7 * 8)))",
+			"add(a, b, 1, (2 * 3), (4 + 5), add(6, (7 * 8)));",
 		},
 		{
 			"add(a + b + c * d / f + g)",
-			"add((((a + b) + ((c * d) / f)) + g))",
+			"add((((a + b) + ((c * d) / f)) + g));",
 		},
 		{
 			"add(a) or b",
-			"(add(a) or b)",
+			"(add(a) or b);",
 		},
 		{
 			"x == y or x == z",
-			"((x == y) or (x == z))",
+			"((x == y) or (x == z));",
 		},
 		{
 			"x == y and x == z",
-			"((x == y) and (x == z))",
+			"((x == y) and (x == z));",
 		},
 		{
 			"x or y and (x and z)",
-			"(x or (y and (x and z)))",
+			"(x or (y and (x and z)));",
 		},
 		{
 			"(x or y) and (x or z)",
-			"((x or y) and (x or z))",
+			"((x or y) and (x or z));",
 		},
 		{
 			"(x and y) or (x and z)",
-			"((x and y) or (x and z))",
+			"((x and y) or (x and z));",
 		},
 		{
 			"(x or y) ==  (x and z)",
-			"((x or y) == (x and z))",
+			"((x or y) == (x and z));",
 		},
 		{
 			"a[0] and x",
-			"((a[0]) and x)",
+			"((a[0]) and x);",
 		},
 		{
 			`str(x) or i.find("abc")`,
-			"(str(x) or i.find(abc))",
+			"(str(x) or i.find(abc));",
 		},
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
@@ -942,7 +938,7 @@ func testInfixExpression(t *testing.T, exp ast.Expression, left interface{}, ope
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -958,13 +954,20 @@ func TestIfExpression(t *testing.T) {
 	if !ok {
 		t.Fatalf("exp not *ast.IfExpression. got=%T", stmt.Expression)
 	}
-	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+	if len(exp.Conditions) != 1 {
+		t.Fatalf("exp does not include %d conditions. got=%d", 1, len(exp.Conditions))
+	}
+	if !testInfixExpression(t, exp.Conditions[0].Cond, "x", "<", "y") {
 		return
 	}
-	if len(exp.Consequence.Statements) != 1 {
-		t.Fatalf("consequence does not include %d statements. got=%d", 1, len(exp.Consequence.Statements))
+	consequenceBlock, ok := exp.Conditions[0].Body.(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("consequence is not *ast.BlockStatement. got=%T", exp.Conditions[0].Body)
+	}
+	if len(consequenceBlock.Statements) != 1 {
+		t.Fatalf("consequence does not include %d statements. got=%d", 1, len(consequenceBlock.Statements))
 	}
-	consequence, ok := exp.Consequence.Statements[0].(*ast.ExpressionStatement)
+	consequence, ok := consequenceBlock.Statements[0].(*ast.ExpressionStatement)
 	if !ok {
 		testIdentifier(t, consequence.Expression, "x")
 	}
@@ -976,7 +979,7 @@ func TestIfExpression(t *testing.T) {
 func TestIfElseExpression(t *testing.T) {
 	input := `if (x < y) { x } else { y }`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -992,29 +995,40 @@ func TestIfElseExpression(t *testing.T) {
 	if !ok {
 		t.Fatalf("exp not *ast.IfExpression. got=%T", stmt.Expression)
 	}
-	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+	if len(exp.Conditions) != 1 {
+		t.Fatalf("exp does not include %d conditions. got=%d", 1, len(exp.Conditions))
+	}
+	if !testInfixExpression(t, exp.Conditions[0].Cond, "x", "<", "y") {
 		return
 	}
-	if len(exp.Consequence.Statements) != 1 {
-		t.Fatalf("consequence does not include %d statements. got=%d", 1, len(exp.Consequence.Statements))
+	consequenceBlock, ok := exp.Conditions[0].Body.(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("consequence is not *ast.BlockStatement. got=%T", exp.Conditions[0].Body)
 	}
-	consequence, ok := exp.Consequence.Statements[0].(*ast.ExpressionStatement)
+	if len(consequenceBlock.Statements) != 1 {
+		t.Fatalf("consequence does not include %d statements. got=%d", 1, len(consequenceBlock.Statements))
+	}
+	consequence, ok := consequenceBlock.Statements[0].(*ast.ExpressionStatement)
 	if !ok {
 		testIdentifier(t, consequence.Expression, "x")
 	}
-	if len(exp.Alternative.Statements) != 1 {
-		t.Fatalf("alternative does not include %d statements. got=%d", 1, len(exp.Alternative.Statements))
+	alternativeBlock, ok := exp.Alternative.(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("alternative is not *ast.BlockStatement. got=%T", exp.Alternative)
+	}
+	if len(alternativeBlock.Statements) != 1 {
+		t.Fatalf("alternative does not include %d statements. got=%d", 1, len(alternativeBlock.Statements))
 	}
-	alternative, ok := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	alternative, ok := alternativeBlock.Statements[0].(*ast.ExpressionStatement)
 	if !ok {
 		testIdentifier(t, alternative.Expression, "x")
 	}
 }
 
 func TestFunctionLiteralParsing(t *testing.T) {
-	input := `fn(x, y) { x + y; }`
+	input := `(fn(x, y) { x + y; })`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 
 	program := p.ParseProgram()
@@ -1056,13 +1070,13 @@ func TestFunctionParameterParsing(t *testing.T) {
 		input          string
 		expectedParams []string
 	}{
-		{input: "fn() {};", expectedParams: []string{}},
-		{input: "fn(x) {};", expectedParams: []string{"x"}},
-		{input: "fn(x, y, z) {};", expectedParams: []string{"x", "y", "z"}},
+		{input: "(fn() {});", expectedParams: []string{}},
+		{input: "(fn(x) {});", expectedParams: []string{"x"}},
+		{input: "(fn(x, y, z) {});", expectedParams: []string{"x", "y", "z"}},
 	}
 
 	for _, tt := range tests {
-		l := lexer.New(tt.input)
+		l := lexer.New("", tt.input)
 		p := New(l, path)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
@@ -1082,7 +1096,7 @@ func TestFunctionParameterParsing(t *testing.T) {
 func TestCallExpressionParsing(t *testing.T) {
 	input := `add(1, 2 * 3, 4 + 5)`
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -1118,13 +1132,13 @@ func TestTryExpressionParsing(t *testing.T) {
                       let th = 1 + 2
                       if (th == 3) { throw "SUMERROR" }
                   }
-                  catch "OTHERERROR" {
+                  catch (e: OtherError) {
                       putln("Catched OTHERERROR")
                   }
-                  catch "SUMERROR" {
+                  catch (e: SumError) {
                       putln("Catched SUMERROR")
                   }
-                  catch {
+                  catch e {
                       putln("Catched ALL")
                   }
                   finally {
@@ -1133,7 +1147,7 @@ func TestTryExpressionParsing(t *testing.T) {
 
 `
 
-	l := lexer.New(input)
+	l := lexer.New("", input)
 	p := New(l, path)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
@@ -1142,13 +1156,680 @@ func TestTryExpressionParsing(t *testing.T) {
 		t.Fatalf("program.Statements does not contain %d statements. got=%d", 1, len(program.Statements))
 	}
 
-	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	_, ok := program.Statements[0].(*ast.TryStmt)
 	if !ok {
-		t.Fatalf("stmt is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		t.Fatalf("stmt is not ast.TryStmt. got=%T", program.Statements[0])
+	}
+}
+
+func TestInterfaceStatement(t *testing.T) {
+	input := `
+interface Shape : Named, Printable {
+    fn area();
+    fn scale(x, y)
+    property name { get; }
+    property size;
+}
+class Square : Base, Shape {}
+`
+
+	l := lexer.New("test", input)
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", 2, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.InterfaceStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.InterfaceStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Name.Value != "Shape" || strings.Join(stmt.Parents, ",") != "Named,Printable" {
+		t.Errorf("wrong interface declaration. got=%q", stmt.Docs())
+	}
+	if len(stmt.Methods) != 2 || len(stmt.Methods["scale"].Parameters) != 2 {
+		t.Errorf("wrong interface methods. got=%q", stmt.String())
+	}
+	if prop := stmt.Properties["name"]; prop == nil || prop.Getter == nil || prop.Setter != nil {
+		t.Errorf("property 'name' should only have a getter. got=%q", stmt.String())
+	}
+	if prop := stmt.Properties["size"]; prop == nil || prop.Getter == nil || prop.Setter == nil {
+		t.Errorf("property 'size' should have a getter and a setter. got=%q", stmt.String())
+	}
+
+	cls, ok := program.Statements[1].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ClassStatement. got=%T", program.Statements[1])
+	}
+	if cls.ClassLiteral.Parent != "Base" || strings.Join(cls.ClassLiteral.Interfaces, ",") != "Shape" {
+		t.Errorf("wrong class declaration. got=%q", cls.String())
+	}
+}
+
+func TestCatchClauseParsing(t *testing.T) {
+	input := `
+try {
+    throw "disk"
+} catch (e: IOError | KeyError) {
+    println(e)
+} catch (e) {
+} catch e {
+} catch {
+} finally {
+}
+`
+
+	l := lexer.New("test", input)
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.TryStmt)
+	if !ok {
+		t.Fatalf("stmt is not ast.TryStmt. got=%T", program.Statements[0])
+	}
+	if len(stmt.Catches) != 4 || stmt.Finally == nil {
+		t.Fatalf("wrong try statement. got=%q", stmt.String())
+	}
+
+	tests := []struct {
+		variable string
+		types    string
+	}{
+		{"e", "IOError,KeyError"},
+		{"e", ""},
+		{"e", ""},
+		{"", ""},
+	}
+	for i, tt := range tests {
+		clause := stmt.Catches[i]
+		types := []string{}
+		for _, typ := range clause.Types {
+			types = append(types, typ.Value)
+		}
+		if clause.Var != tt.variable || strings.Join(types, ",") != tt.types {
+			t.Errorf("catch clause %d wrong. got=%q", i, clause.String())
+		}
+	}
+}
+
+func TestYieldExpression(t *testing.T) {
+	input := `
+fn gen() {
+    yield 1 + 2
+    let x = yield
+    let f = fn() { yield x }
+}
+let plain = fn() { return 1 }
+`
+
+	l := lexer.New("test", input)
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", 2, len(program.Statements))
+	}
+
+	gen := program.Statements[0].(*ast.FunctionStatement).FunctionLiteral
+	if !gen.Generator {
+		t.Errorf("function 'gen' should be a generator")
+	}
+
+	body := gen.Body.Statements
+	yield, ok := body[0].(*ast.ExpressionStatement).Expression.(*ast.YieldExpression)
+	if !ok || yield.Value.String() != "(1 + 2)" {
+		t.Errorf("wrong yield expression. got=%q", body[0].String())
+	}
+	yield, ok = body[1].(*ast.LetStatement).Values[0].(*ast.YieldExpression)
+	if !ok || yield.Value != nil {
+		t.Errorf("wrong yield expression without value. got=%q", body[1].String())
+	}
+	inner := body[2].(*ast.LetStatement).Values[0].(*ast.FunctionLiteral)
+	if !inner.Generator {
+		t.Errorf("the inner function should be a generator")
+	}
+
+	plain := program.Statements[1].(*ast.LetStatement).Values[0].(*ast.FunctionLiteral)
+	if plain.Generator {
+		t.Errorf("function 'plain' should not be a generator")
+	}
+
+	p = New(lexer.New("test", "yield 1"), path)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for 'yield' outside of function")
+	}
+}
+
+func TestImportPath(t *testing.T) {
+	l := lexer.New("test", "import \"test_files/test\" as t\nimport test_files.test\n")
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Imports) != 2 {
+		t.Fatalf("program.Imports does not contain 2 imports. got=%d", len(program.Imports))
+	}
+	imp := program.Imports["t"]
+	if imp == nil || imp.Path != "test_files/test" || !strings.HasSuffix(imp.File, "test_files/test.mp") {
+		t.Fatalf("wrong import. got=%+v", imp)
+	}
+	if imp.String() != `import "test_files/test" as t` {
+		t.Errorf("wrong import string. got=%q", imp.String())
+	}
+	if program.Imports["test"] == nil || program.Imports["test"].File != imp.File {
+		t.Errorf("expected 'test' to be the same file as 't'")
+	}
+
+	//two different modules with the same name
+	l = lexer.New("test", "import test_files.test\nimport \"test_files/sub_package/pkg\" as test\n")
+	p = New(l, path)
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || !strings.Contains(p.Errors()[0], "conflicts with") {
+		t.Errorf("expected an import conflict error. got=%v", p.Errors())
+	}
+}
+
+func TestAnnotatedFunctionStatement(t *testing.T) {
+	input := `@Test
+fn first() {}
+
+@Test(skip="later", retries=2) @Setup
+fn second() {}
+`
+	l := lexer.New("test", input)
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	first := program.Statements[0].(*ast.FunctionStatement)
+	if first.Name.Value != "first" || len(first.Annotations) != 1 || first.Annotations[0].Name.Value != "Test" {
+		t.Errorf("wrong annotations of 'first'. got=%+v", first.Annotations)
+	}
+	second := program.Statements[1].(*ast.FunctionStatement)
+	if len(second.Annotations) != 2 || second.Annotations[1].Name.Value != "Setup" {
+		t.Fatalf("wrong annotations of 'second'. got=%+v", second.Annotations)
+	}
+	attrs := second.Annotations[0].Attributes
+	if len(attrs) != 2 || attrs["skip"].String() != "later" || attrs["retries"].String() != "2" {
+		t.Errorf("wrong attributes of '@Test'. got=%v", attrs)
+	}
+
+	p = New(lexer.New("test", "@Test\nlet x = 1"), path)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], "after the annotation") {
+		t.Errorf("expected an error for an annotation without function. got=%v", p.Errors())
+	}
+}
+
+func TestServiceMiddlewareAnnotations(t *testing.T) {
+	input := `service Hello on "127.0.0.1:8090" {
+    @middleware
+    fn cors(writer, request, next) { next() }
+
+    @before()
+    fn check(writer, request) {}
+
+    @route(url="/admin", middleware=[cors])
+    fn admin(writer, request) {}
+}
+`
+	l := lexer.New("test", input)
+	p := New(l, path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	svc := program.Statements[0].(*ast.ServiceStatement)
+	for name, anno := range map[string]string{"cors": "middleware", "check": "before", "admin": "route"} {
+		fn := svc.Methods[name]
+		if fn == nil || len(fn.Annotations) != 1 || fn.Annotations[0].Name.Value != anno {
+			t.Errorf("function %s: expected the annotation '@%s', got=%+v", name, anno, fn)
+		}
+	}
+	if _, ok := svc.Methods["admin"].Annotations[0].Attributes["middleware"]; !ok {
+		t.Errorf("expected the attribute 'middleware' of '@route'")
+	}
+
+	p = New(lexer.New("test", `service Hello on "127.0.0.1:8090" { @unknown fn f(w, r) {} }`), path)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], "'middleware'") {
+		t.Errorf("expected an error for an unknown annotation. got=%v", p.Errors())
+	}
+}
+
+func TestServiceBodyAnnotation(t *testing.T) {
+	input := `service Hello on "127.0.0.1:8090" {
+    @body(schema=User)
+    @route(url="/users", methods=["POST"])
+    fn create(writer, request) {}
+}
+`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fn := program.Statements[0].(*ast.ServiceStatement).Methods["create"]
+	if anno := ast.ServiceAnnotation(fn); anno == nil || anno.Name.Value != "route" {
+		t.Errorf("expected the annotation '@route', got=%+v", anno)
+	}
+	if anno := ast.BodyAnnotation(fn); anno == nil || anno.Attributes["schema"].String() != "User" {
+		t.Errorf("expected the annotation '@body(schema=User)', got=%+v", anno)
+	}
+
+	tests := []string{
+		`@body(schema=User) fn f(w, r) {}`,
+		`@body(schema=User) @websocket(url="/") fn f(w, r) {}`,
+		`@body(schema=User) @body(schema=User) @route(url="/") fn f(w, r) {}`,
+	}
+	for _, tt := range tests {
+		p := New(lexer.New("test", `service Hello on "127.0.0.1:8090" { `+tt+` }`), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected an error", tt)
+		}
+	}
+}
+
+func TestServiceServerOptions(t *testing.T) {
+	input := `service Hello on "0.0.0.0:8443", {"cert": "server.crt", "key": "server.key"} {
+    @route(url="/")
+    fn index(writer, request) {}
+}
+`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	svc := program.Statements[0].(*ast.ServiceStatement)
+	if svc.Addr != "0.0.0.0:8443" {
+		t.Errorf("expected the address 0.0.0.0:8443, got=%s", svc.Addr)
+	}
+	if _, ok := svc.Options.(*ast.HashLiteral); !ok {
+		t.Fatalf("expected the options to be a hash literal, got=%T", svc.Options)
+	}
+	if _, ok := svc.Methods["index"]; !ok {
+		t.Errorf("expected the method 'index'")
+	}
+}
+
+func TestServiceStaticAnnotation(t *testing.T) {
+	input := `service Web on "127.0.0.1:8090" {
+    @static(url="/assets", dir="./public", maxAge=3600)
+    @route(url="/")
+    fn index(writer, request) {}
+    @static(url="/docs", dir="./docs")
+}
+`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	svc := program.Statements[0].(*ast.ServiceStatement)
+	if len(svc.Statics) != 2 || len(svc.Methods) != 1 {
+		t.Fatalf("expected 2 static routes and 1 function, got=%d %d", len(svc.Statics), len(svc.Methods))
+	}
+	for i, expected := range []string{"/assets", "/docs"} {
+		if got := svc.Statics[i].Attributes["url"].String(); got != expected {
+			t.Errorf("static route %d: expected url %s, got=%s", i, expected, got)
+		}
+	}
+
+	tests := []string{
+		`@route(url="/") @static(url="/assets", dir="public") fn f(w, r) {}`,
+		`@static(url="/assets", dir="public") fn f(w, r) {}`,
+	}
+	for _, tt := range tests {
+		p := New(lexer.New("test", `service Hello on "127.0.0.1:8090" { `+tt+` }`), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected an error", tt)
+		}
+	}
+}
+
+func TestServiceAddressOptions(t *testing.T) {
+	tests := []struct {
+		addr           string
+		expected       string
+		debug, openAPI bool
+	}{
+		{"127.0.0.1:8090", "127.0.0.1:8090", false, false},
+		{":8090:debug", ":8090", true, false},
+		{":8090:openapi", ":8090", false, true},
+		{"localhost:8090:debug:openapi", "localhost:8090", true, true},
+		{"localhost:8090:openapi:debug", "localhost:8090", true, true},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New("test", `service Hello on "`+tt.addr+`" { @route(url="/") fn f(w, r) {} }`), path)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		svc := program.Statements[0].(*ast.ServiceStatement)
+		if svc.Addr != tt.expected || svc.Debug != tt.debug || svc.OpenAPI != tt.openAPI {
+			t.Errorf("%s: expected %s debug=%t openapi=%t, got=%s debug=%t openapi=%t",
+				tt.addr, tt.expected, tt.debug, tt.openAPI, svc.Addr, svc.Debug, svc.OpenAPI)
+		}
+	}
+}
+
+func TestCasePatterns(t *testing.T) {
+	input := `case v in {
+    [first, *rest] if len(rest) > 1 { 1 }
+    (a, *_, b), (x,) { 2 }
+    {Name: n, "age": [_, 2], Calories} { 3 }
+    Point(0, y) | Point(x: int, 0) { 4 }
+    n: int, _: Point { 5 }
+    1..10, "a" | "b" { 6 }
+    x, Color.Red { 7 }
+    else { 8 }
+}`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	ce := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CaseExpr)
+	expected := []struct {
+		pattern, typ, guard string
+	}{
+		{"[first, *rest]", "*ast.SequencePattern", "(len(rest) > 1)"},
+		{"(a, *_, b)", "*ast.SequencePattern", ""},
+		{"(x,)", "*ast.SequencePattern", ""},
+		{`{Name: n, age: [_, 2], Calories: Calories}`, "*ast.HashPattern", ""},
+		{"Point(0, y) | Point(x: int, 0)", "*ast.AltPattern", ""},
+		{"n: int", "*ast.TypePattern", ""},
+		{"_: Point", "*ast.TypePattern", ""},
+		{"1..10", "*ast.RangePattern", ""},
+		{`a | b`, "*ast.AltPattern", ""},
+		{"x", "*ast.Identifier", ""}, //a value at the top level
+		{"Color.Red", "*ast.MethodCallExpression", ""},
+	}
+	if len(ce.Matches) != len(expected)+1 {
+		t.Fatalf("expected %d arms, got=%d", len(expected)+1, len(ce.Matches))
+	}
+	for i, tt := range expected {
+		m := ce.Matches[i].(*ast.CaseMatchExpr)
+		guard := ""
+		if m.Guard != nil {
+			guard = m.Guard.String()
+		}
+		if got := m.Expr.String(); got != tt.pattern || fmt.Sprintf("%T", m.Expr) != tt.typ || guard != tt.guard {
+			t.Errorf("arm %d: expected %s(%s) if %q, got=%s(%T) if %q", i, tt.pattern, tt.typ, tt.guard, got, m.Expr, guard)
+		}
+	}
+
+	//the names in the structural patterns are bindings
+	seq := ce.Matches[0].(*ast.CaseMatchExpr).Expr.(*ast.SequencePattern)
+	if _, ok := seq.Elements[0].(*ast.BindPattern); !ok || seq.Rest != 1 || seq.RestName.Value != "rest" {
+		t.Errorf("expected the binding 'first' and '*rest', got=%#v", seq)
+	}
+
+	for _, input := range []string{
+		`case v in { [*a, *b] { 1 } }`,
+		`case v in { [*1] { 1 } }`,
+		`case v in { x: 1 { 1 } }`,
+		`case v in { {[1]: x} { 1 } }`,
+	} {
+		p := New(lexer.New("test", input), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestAwaitExpression(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"await add(1, 2)", "await add(1, 2)"},
+		{"await tasks[0] + 1", "(await (tasks[0]) + 1)"},
+		{"let r = await async.all(tasks)", "let r = await async.all(tasks)"},
+		{"async.sleep(1)", "async.sleep(1)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New("test", tt.input), path)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := strings.TrimSuffix(program.String(), ";"); got != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSelectStatement(t *testing.T) {
+	input := `select {
+    case v, ok = <-chans[0]:
+        println(v)
+        x = 1
+    case out <- a + 1: ; case <-done: return
+    case after(1.5):
+    default: println("none")
+}`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.SelectStmt)
+	if !ok {
+		t.Fatalf("expected *ast.SelectStmt, got=%T", program.Statements[0])
+	}
+
+	expected := []struct {
+		kind ast.SelectCaseKind
+		str  string
+		n    int //number of statements
+	}{
+		{ast.SelectRecv, "case v, ok = <-(chans[0]): println(v);x=1;", 2},
+		{ast.SelectSend, "case out <- (a + 1): ", 0},
+		{ast.SelectRecv, "case <-done: return ;", 1},
+		{ast.SelectTimeout, "case after(1.5): ", 0},
+		{ast.SelectDefault, "default: println(none);", 1},
+	}
+	if len(stmt.Cases) != len(expected) {
+		t.Fatalf("expected %d cases, got=%d", len(expected), len(stmt.Cases))
+	}
+	for i, e := range expected {
+		c := stmt.Cases[i]
+		if c.Kind != e.kind || c.String() != e.str || len(c.Body.Statements) != e.n {
+			t.Errorf("case %d: expected (%d, %q, %d), got=(%d, %q, %d)", i, e.kind, e.str, e.n, c.Kind, c.String(), len(c.Body.Statements))
+		}
+	}
+
+	errors := []string{
+		"select { case <-c }",
+		"select { case c < 1: 1 }",
+		"select { foo: 1 }",
+		"select { case <-c: 1",
+	}
+	for _, input := range errors {
+		p := New(lexer.New("test", input), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected a syntax error", input)
+		}
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"fn add(a: int, b: int): int { a + b }", "fn add (a: int, b: int): int { (a + b); }"},
+		{"let f = fn(x: string | nil, y = 2): bool { true }", "let f = fn (x: string | nil, y): bool { true; }"},
+		{"fn sum(first: number, rest: int...) { first }", "fn sum (first: number, rest: int) { first; }"},
+		{"let x: int, y = 1, 2", "let x: int, y = 1, 2"},
+		{"let p: Point | nil = nil", "let p: Point | nil = nil"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New("test", tt.input), path)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := strings.TrimSpace(strings.TrimSuffix(program.String(), ";")); got != tt.expected {
+			t.Errorf("%s: expected %q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	fl := func(input string) *ast.FunctionLiteral {
+		p := New(lexer.New("test", input), path)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		return program.Statements[0].(*ast.FunctionStatement).FunctionLiteral
+	}
+	lit := fl("fn f(a: int | float, b): Point {}")
+	if got := lit.ParamTypes["a"]; got == nil || len(got.Types) != 2 || got.String() != "int | float" {
+		t.Errorf("expected the type 'int | float' for 'a', got=%v", got)
+	}
+	if _, ok := lit.ParamTypes["b"]; ok {
+		t.Errorf("expected no type for 'b'")
+	}
+	if lit.ReturnType == nil || lit.ReturnType.String() != "Point" {
+		t.Errorf("expected the return type 'Point', got=%v", lit.ReturnType)
 	}
 
-	_, ok = stmt.Expression.(*ast.TryStmt)
+	for _, input := range []string{
+		`fn f(a: 1) {}`,
+		`fn f(a): {}`,
+		`let x: int | = 1`,
+	} {
+		p := New(lexer.New("test", input), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestMacroStatement(t *testing.T) {
+	input := `macro unlessZero(x, body) { quote(if unquote(x) != 0 { unquote(body) }) }
+macro block() { quote { let a = 1; a } }`
+	p := New(lexer.New("test", input), path)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got=%d", len(program.Statements))
+	}
+	m, ok := program.Statements[0].(*ast.MacroStatement)
 	if !ok {
-		t.Fatalf("function is not ast.TryStmt. got=%T", stmt.Expression)
+		t.Fatalf("expected *ast.MacroStatement, got=%T", program.Statements[0])
+	}
+	if m.Name.Value != "unlessZero" || len(m.Parameters) != 2 || m.Parameters[1].Value != "body" {
+		t.Errorf("unexpected macro %s", m)
+	}
+	q := m.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.QuoteExpression)
+	if _, ok := q.Node.(*ast.IfExpression); !ok {
+		t.Errorf("expected a quoted *ast.IfExpression, got=%T", q.Node)
+	}
+
+	q = program.Statements[1].(*ast.MacroStatement).Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.QuoteExpression)
+	if block, ok := q.Node.(*ast.BlockStatement); !ok || len(block.Statements) != 2 {
+		t.Errorf("expected a quoted block of 2 statements, got=%s", q)
+	}
+
+	for _, input := range []string{
+		`unquote(x)`,
+		`macro m(1) { quote(1) }`,
+		`macro m(a) quote(a)`,
+		`quote x`,
+	} {
+		p := New(lexer.New("test", input), path)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestDefineValues(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"#define MAX 10\nMAX + 1", "(10 + 1)"},
+		{"#define TWICE 2 * 3\nTWICE * 2", "((2 * 3) * 2)"},
+		{"#define NAME \"magpie\"; NAME", "magpie"},
+		{"#define DEBUG\nDEBUG", "DEBUG"},
+		//the members aren't replaced
+		{"#define LEVEL 1\nlog.LEVEL", "log.LEVEL"},
+		{"#define LEVEL 1\n#define LEVEL\nLEVEL", "LEVEL"},
+		{"LEVEL", "3"},
+		{"#ifdef FLAG { 1 } #else { 2 }", "#ifdef FLAG 1"},
+	}
+
+	Defines["LEVEL"] = "3"
+	Defines["FLAG"] = ""
+	defer func() {
+		delete(Defines, "LEVEL")
+		delete(Defines, "FLAG")
+	}()
+
+	for _, tt := range tests {
+		p := New(lexer.New("test", tt.input), path)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		got := strings.TrimSpace(program.String())
+		if got = strings.TrimSuffix(got, ";"); !strings.HasPrefix(got, tt.expected) {
+			t.Errorf("%q: expected %q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	for value, expected := range map[string]string{
+		"42": "*ast.IntegerLiteral", "0x10": "*ast.IntegerLiteral", "2.5": "*ast.FloatLiteral",
+		"true": "*ast.Boolean", "nil": "*ast.NilLiteral", `"a b"`: "*ast.StringLiteral", "abc": "*ast.StringLiteral",
+	} {
+		if got := fmt.Sprintf("%T", defineValue(value)); got != expected {
+			t.Errorf("%s: expected %s, got=%s", value, expected, got)
+		}
+	}
+}
+
+func TestAddDefine(t *testing.T) {
+	tests := []struct {
+		def   string
+		name  string
+		value string
+		err   string
+	}{
+		{"LEVEL=3", "LEVEL", "3", ""},
+		{"FLAG", "FLAG", "", ""},
+		{"EXPR=a=b", "EXPR", "a=b", ""},
+		{"LEVEL=", "", "", "-D LEVEL=: empty macro value, use '-D LEVEL' to define it without a value"},
+		{"=3", "", "", "-D =3: empty macro name"},
+		{"", "", "", "-D : empty macro name"},
+	}
+
+	for _, tt := range tests {
+		err := AddDefine(tt.def)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: expected error %q, got=%v", tt.def, tt.err, err)
+			}
+			if _, ok := Defines["LEVEL"]; ok {
+				t.Errorf("%q: LEVEL is defined", tt.def)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.def, err)
+			continue
+		}
+		if value, ok := Defines[tt.name]; !ok || value != tt.value {
+			t.Errorf("%q: expected %s=%q, got=%q(%t)", tt.def, tt.name, tt.value, value, ok)
+		}
+		delete(Defines, tt.name)
 	}
 }
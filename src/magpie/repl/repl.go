@@ -19,11 +19,11 @@ var magpieKeywords = []string{
 	"fn", "let", "true", "false", "if", "else",
 	"elif", "return", "import", "and", "or", "struct", "do", "while",
 	"break", "continue", "for", "in", "where", "grep", "map", "case",
-	"is", "try", "catch", "finally", "throw", "qw", "unless", "spawn",
+	"is", "try", "catch", "finally", "throw", "qw", "unless", "spawn", "yield",
 	"enum", "defer", "nil", "class", "new", "this", "parent", "property",
 	"get", "set", "static", "public", "private", "protected", "interface", "default",
 	"from", "select", "group", "into", "orderby", "join", "on", "equals", "by", "ascending", "descending",
-	"async", "await", "service",
+	"async", "await", "service", "macro", "quote", "unquote",
 }
 
 //Note: we should put the longest operators first.
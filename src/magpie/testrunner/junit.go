@@ -0,0 +1,105 @@
+package testrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The JUnit XML format, as understood by most CI servers.
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test suite per test file.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suites := junitSuites{Time: seconds(r.Duration)}
+	index := make(map[string]int) //file -> index of its suite
+	var durations []time.Duration
+	for _, result := range r.Results {
+		i, ok := index[result.File]
+		if !ok {
+			i = len(suites.Suites)
+			index[result.File] = i
+			suites.Suites = append(suites.Suites, junitSuite{Name: result.File})
+			durations = append(durations, 0)
+		}
+		durations[i] += result.Duration
+		suite := &suites.Suites[i]
+
+		c := junitCase{Name: result.Name, Classname: result.File, Time: seconds(result.Duration), SystemOut: result.Output}
+		if c.Name == "" {
+			c.Name = "(load)"
+		}
+		switch result.Status {
+		case Failed:
+			c.Failure = &junitMessage{Message: firstLine(result.Message), Text: result.Message}
+			suite.Failures++
+			suites.Failures++
+		case Skipped:
+			c.Skipped = &junitMessage{Message: result.Message}
+			suite.Skipped++
+			suites.Skipped++
+		}
+		suite.Tests++
+		suites.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	for i, d := range durations {
+		suites.Suites[i].Time = seconds(d)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func firstLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
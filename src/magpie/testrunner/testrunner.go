@@ -0,0 +1,302 @@
+// Package testrunner implements the 'magpie test' command, which runs the
+// tests of the '*_test.mp' files in a directory tree.
+//
+// A test is a function without parameters, annotated with '@Test' or whose
+// name starts with 'test'. Each test runs in a fresh interpreter, i.e. the
+// test file is evaluated again before every test, so the tests don't share
+// any state. A function annotated with '@Setup'(or named 'setup') runs
+// before each test, and a function annotated with '@Teardown'(or named
+// 'teardown') runs after each test, even if the test failed.
+//
+//	@Test
+//	fn addition() {
+//	    testing.equal(3, 1 + 2)
+//	}
+//
+//	@Test(skip="not implemented")
+//	fn division() {}
+//
+// A test fails if it results in an error, e.g. a failed assertion of the
+// 'testing' module, or an uncaught exception.
+package testrunner
+
+import (
+	"bytes"
+	"fmt"
+	"magpie/eval"
+	"magpie/module"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const testSuffix = "_test.mp"
+
+type Status int
+
+const (
+	Passed Status = iota
+	Failed
+	Skipped
+)
+
+func (s Status) String() string {
+	switch s {
+	case Passed:
+		return "PASS"
+	case Failed:
+		return "FAIL"
+	}
+	return "SKIP"
+}
+
+// Options controls which tests are run.
+type Options struct {
+	//Run, if not nil, selects the tests whose names match it.
+	Run *regexp.Regexp
+}
+
+// Result is the result of a test. A test file which couldn't be evaluated
+// has a failed result without a name.
+type Result struct {
+	File     string
+	Name     string
+	Status   Status
+	Message  string //the reason of the failure, or why the test is skipped
+	Output   string //what the test printed
+	Duration time.Duration
+}
+
+// Report is the result of a test run.
+type Report struct {
+	Results  []*Result
+	Duration time.Duration
+}
+
+// Count returns the number of tests with the status 's'.
+func (r *Report) Count(s Status) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed reports whether any test failed.
+func (r *Report) Failed() bool {
+	return r.Count(Failed) > 0
+}
+
+// Find returns the test files under 'dir', sorted. The vendor directory,
+// hidden directories and nested modules(directories with a magpie.mod) are
+// skipped.
+func Find(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			name := info.Name()
+			if name == module.VendorDir || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, module.ModFile)); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, testSuffix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Run runs the tests of the test files under 'dir'.
+func Run(dir string, opts Options) (*Report, error) {
+	files, err := Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	report := &Report{}
+	for _, file := range files {
+		report.Results = append(report.Results, RunFile(file, opts)...)
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// a test file's functions, found by evaluating the file
+type suite struct {
+	tests    []*testFunc
+	setup    string
+	teardown string
+}
+
+type testFunc struct {
+	name string
+	line int
+	skip string //the reason if the test is skipped
+}
+
+// RunFile runs the tests of the test file 'path'.
+func RunFile(path string, opts Options) []*Result {
+	start := time.Now()
+	interp, out, err := load(path)
+	if err != nil {
+		return []*Result{{File: path, Status: Failed, Message: err.Error(), Output: out.String(), Duration: time.Since(start)}}
+	}
+
+	s := discover(path, interp)
+	var results []*Result
+	for _, t := range s.tests {
+		if opts.Run != nil && !opts.Run.MatchString(t.name) {
+			continue
+		}
+		if t.skip != "" {
+			results = append(results, &Result{File: path, Name: t.name, Status: Skipped, Message: t.skip})
+			continue
+		}
+		results = append(results, runTest(path, s, t.name))
+	}
+	return results
+}
+
+// load evaluates a test file in a new interpreter.
+func load(path string) (*eval.Interpreter, *bytes.Buffer, error) {
+	out := &bytes.Buffer{}
+	interp := eval.NewInterpreter(out)
+	interp.NoListen = true //the services are tested with 'httptest'
+	interp.Set(eval.TEST_ANNOCLASS.Name, eval.TEST_ANNOCLASS)
+	interp.Set(eval.SETUP_ANNOCLASS.Name, eval.SETUP_ANNOCLASS)
+	interp.Set(eval.TEARDOWN_ANNOCLASS.Name, eval.TEARDOWN_ANNOCLASS)
+
+	result, err := interp.RunFile(path)
+	if err != nil {
+		return nil, out, errorString(result, err)
+	}
+	return interp, out, nil
+}
+
+// discover finds the tests, the setup and the teardown functions defined
+// in the test file(not in its imports).
+func discover(path string, interp *eval.Interpreter) *suite {
+	s := &suite{}
+	for name, obj := range interp.Scope().Vars() {
+		fn, ok := obj.(*eval.Function)
+		if !ok || fn.Literal.Token.Pos.Filename != path || len(fn.Literal.Parameters) != 0 {
+			continue
+		}
+
+		var test *testFunc
+		for _, anno := range fn.Annotations {
+			switch anno.Class {
+			case eval.TEST_ANNOCLASS:
+				test = &testFunc{name: name, skip: skipReason(anno)}
+			case eval.SETUP_ANNOCLASS:
+				s.setup = name
+			case eval.TEARDOWN_ANNOCLASS:
+				s.teardown = name
+			}
+		}
+		switch {
+		case test != nil:
+		case name == "setup" && s.setup == "":
+			s.setup = name
+			continue
+		case name == "teardown" && s.teardown == "":
+			s.teardown = name
+			continue
+		case strings.HasPrefix(name, "test") && len(fn.Annotations) == 0:
+			test = &testFunc{name: name}
+		default:
+			continue
+		}
+		test.line = fn.Literal.Token.Pos.Line
+		s.tests = append(s.tests, test)
+	}
+
+	sort.Slice(s.tests, func(i, j int) bool { return s.tests[i].line < s.tests[j].line })
+	return s
+}
+
+// the reason of '@Test(skip="reason")', '@Test(skip=true)' has a default reason
+func skipReason(anno *eval.ObjectInstance) string {
+	skip, ok := anno.Scope.Get("skip")
+	if !ok || !eval.IsTrue(skip) {
+		return ""
+	}
+	if s, ok := skip.(*eval.String); ok {
+		return s.String
+	}
+	return "skipped"
+}
+
+// runTest runs a test in a fresh interpreter.
+func runTest(path string, s *suite, name string) *Result {
+	start := time.Now()
+	r := &Result{File: path, Name: name}
+	defer func() { r.Duration = time.Since(start) }()
+
+	interp, out, err := load(path)
+	if err != nil {
+		r.Status, r.Message, r.Output = Failed, err.Error(), out.String()
+		return r
+	}
+
+	call := func(name string) error {
+		fn, _ := interp.Get(name)
+		result, err := interp.Call(fn)
+		if err != nil {
+			return errorString(result, err)
+		}
+		return nil
+	}
+
+	if s.setup != "" {
+		err = call(s.setup)
+		if err != nil {
+			err = fmt.Errorf("%s: %s", s.setup, err)
+		}
+	}
+	if err == nil {
+		err = call(name)
+	}
+	if s.teardown != "" {
+		if tdErr := call(s.teardown); tdErr != nil && err == nil {
+			err = fmt.Errorf("%s: %s", s.teardown, tdErr)
+		}
+	}
+
+	r.Output = out.String()
+	if err != nil {
+		r.Status, r.Message = Failed, err.Error()
+	}
+	return r
+}
+
+// errorString returns the error with its stack trace, if any.
+func errorString(result eval.Object, err error) error {
+	e, ok := result.(*eval.Error)
+	if !ok {
+		return err
+	}
+	msg := strings.TrimSpace(e.Inspect())
+	if len(e.StackTrace) > 1 {
+		msg += "\nStack trace:\n" + strings.TrimRight(e.StackTraceString(), "\n")
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,224 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const mathTest = `let counter = 0
+
+@Setup
+fn reset() {
+    counter = 10
+}
+
+fn teardown() {
+    print("teardown:", counter)
+}
+
+@Test
+fn increment() {
+    counter += 1
+    testing.equal(11, counter)
+}
+
+fn testDeepEqual() {
+    testing.deepEqual([1, {"a": [2, 3]}, (4, 5)], [1, {"a": [2, 3]}, (4, 5)])
+    testing.approx(0.3, 0.1 + 0.2)
+    testing.approx(1, 1.05, 0.1, "close enough")
+    testing.notEqual([1], [1])
+    testing.ok(counter == 10)
+}
+
+fn testThrows() {
+    let e = testing.throws(fn() { throw new Exception("boom") })
+    testing.equal("boom", e.message)
+    testing.throws(fn() { 1 / 0 }, DivideByZeroError)
+}
+
+fn testDeepEqualFails() {
+    print("output of a failed test")
+    testing.deepEqual({"a": [2, 3]}, {"a": [2, 4]}, "nested")
+}
+
+fn testThrowsFails() {
+    testing.throws(fn() { 1 + 1 })
+}
+
+@Test(skip="not implemented")
+fn division() {
+    testing.fail("unreachable")
+}
+
+fn testWithParameter(x) {}
+fn helper() {}
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "magpie-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		fn := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := ioutil.WriteFile(fn, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFind(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a_test.mp":             "",
+		"b.mp":                  "",
+		"sub/c_test.mp":         "",
+		"vendor/d_test.mp":      "",
+		".hidden/e_test.mp":     "",
+		"nested/magpie.mod":     "module nested\n",
+		"nested/f_test.mp":      "",
+		"sub/deeper/g_test.mp":  "",
+		"sub/deeper/g_test.mpx": "",
+	})
+	defer os.RemoveAll(dir)
+
+	files, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	expected := "a_test.mp sub/c_test.mp sub/deeper/g_test.mp"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expected %q, got=%q", expected, strings.Join(got, " "))
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"math_test.mp":    mathTest,
+		"bad/bad_test.mp": "fn testX() {\n    let x = \n",
+	})
+	defer os.RemoveAll(dir)
+
+	report, err := Run(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		status  Status
+		message string
+		output  string
+	}{
+		{"", Failed, "Syntax Error", ""},
+		{"increment", Passed, "", "teardown:11"},
+		{"testDeepEqual", Passed, "", "teardown:10"},
+		{"testThrows", Passed, "", "teardown:10"},
+		{"testDeepEqualFails", Failed, `nested: values differ at ["a"][1]`, "output of a failed testteardown:10"},
+		{"testThrowsFails", Failed, "expected an exception, got none", "teardown:10"},
+		{"division", Skipped, "not implemented", ""},
+	}
+	if len(report.Results) != len(tests) {
+		for _, r := range report.Results {
+			t.Logf("%+v", r)
+		}
+		t.Fatalf("expected %d results, got=%d", len(tests), len(report.Results))
+	}
+	for i, tt := range tests {
+		r := report.Results[i]
+		if r.Name != tt.name || r.Status != tt.status {
+			t.Errorf("results[%d]: expected %s %s, got=%s %s", i, tt.status, tt.name, r.Status, r.Name)
+		}
+		if !strings.Contains(r.Message, tt.message) || (tt.message == "" && r.Message != "") {
+			t.Errorf("%s: expected the message %q, got=%q", tt.name, tt.message, r.Message)
+		}
+		if r.Output != tt.output {
+			t.Errorf("%s: expected the output %q, got=%q", tt.name, tt.output, r.Output)
+		}
+	}
+	if report.Count(Passed) != 3 || report.Count(Failed) != 3 || report.Count(Skipped) != 1 || !report.Failed() {
+		t.Errorf("unexpected counts: %d passed, %d failed, %d skipped", report.Count(Passed), report.Count(Failed), report.Count(Skipped))
+	}
+
+	report, err = Run(dir, Options{Run: regexp.MustCompile("^test.*Fails$")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range report.Results {
+		names = append(names, r.Name)
+	}
+	if strings.Join(names, " ") != " testDeepEqualFails testThrowsFails" {
+		t.Errorf("unexpected tests selected by -run: %q", names)
+	}
+}
+
+const serviceTest = `//the address can't be listened on, the service is tested in memory
+service Meters on "256.0.0.1:80" {
+    @route(url="/meters/{id:[0-9]+}", methods=["GET"])
+    fn getMeter(writer, request) {
+        return { "id": vars["id"] }
+    }
+}
+
+fn testGetMeter() {
+    let resp = httptest.newClient(Meters).get("/meters/42")
+    testing.equal(200, resp.status)
+    testing.equal("42", resp.body.id)
+    testing.equal(404, httptest.newClient(Meters).get("/meters/x").status)
+}
+`
+
+func TestRunService(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"service_test.mp": serviceTest})
+	defer os.RemoveAll(dir)
+
+	report, err := Run(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 1 || report.Results[0].Status != Passed {
+		t.Errorf("expected the service test to pass, got=%+v", *report.Results[0])
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"math_test.mp": mathTest})
+	defer os.RemoveAll(dir)
+
+	report, err := Run(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, buf.String())
+	}
+	if suites.Tests != 6 || suites.Failures != 2 || suites.Skipped != 1 || len(suites.Suites) != 1 {
+		t.Fatalf("unexpected suites: %+v", suites)
+	}
+	c := suites.Suites[0].Cases[3]
+	if c.Name != "testDeepEqualFails" || c.Failure == nil || !strings.Contains(c.Failure.Message, "assertion failed") {
+		t.Errorf("unexpected test case: %+v", c)
+	}
+	if c := suites.Suites[0].Cases[5]; c.Skipped == nil || c.Skipped.Message != "not implemented" {
+		t.Errorf("unexpected skipped test case: %+v", c)
+	}
+}
@@ -109,13 +109,14 @@ const (
 	THROW
 	DEFER
 	SPAWN
+	YIELD
 	NIL
 	ENUM
 	QW
 	UNLESS
 
 	//class related
-	INTERFACE //NOT IMPLEMENTED
+	INTERFACE
 	CLASS
 	NEW
 	PROPERTY
@@ -169,6 +170,9 @@ const (
 	DEFINE
 	IFDEF_MACRO
 	ELSE_MACRO
+	MACRO
+	QUOTE
+	UNQUOTE
 )
 
 var keywords = map[string]TokenType{
@@ -202,6 +206,7 @@ var keywords = map[string]TokenType{
 	"throw":     THROW,
 	"defer":     DEFER,
 	"spawn":     SPAWN,
+	"yield":     YIELD,
 	"nil":       NIL,
 	"enum":      ENUM,
 	"qw":        QW, //“quoted words”
@@ -241,6 +246,11 @@ var keywords = map[string]TokenType{
 
 	//service
 	"service": SERVICE,
+
+	//macros
+	"macro":   MACRO,
+	"quote":   QUOTE,
+	"unquote": UNQUOTE,
 }
 
 //for debug & testing
@@ -527,6 +537,12 @@ func (tt TokenType) String() string {
 		return "#ifdef"
 	case ELSE_MACRO:
 		return "#else"
+	case MACRO:
+		return "MACRO"
+	case QUOTE:
+		return "QUOTE"
+	case UNQUOTE:
+		return "UNQUOTE"
 
 	default:
 		return "UNKNOWN"
@@ -0,0 +1,491 @@
+// Package vm executes the bytecode produced by package 'compiler'.
+//
+// The vm works on the same values('eval.Object') and the same scopes
+// ('eval.Scope') as the tree-walking evaluator, so compiled code, evaluated
+// code and Go code registered with the interpreter can freely call each other.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"magpie/ast"
+	"magpie/compiler"
+	"magpie/eval"
+	"os"
+	"sync"
+)
+
+// compiled function bodies, shared by all the vm instances.
+var (
+	functions   = make(map[*ast.FunctionLiteral]*compiler.Bytecode)
+	functionsMu sync.RWMutex
+)
+
+type loop struct {
+	breakPos    int
+	continuePos int
+	scopeDepth  int //number of scopes when the loop was entered
+	sp          int //stack size when the loop was entered
+}
+
+type VM struct {
+}
+
+func New() *VM {
+	return &VM{}
+}
+
+// RunContext is like 'Run', but the program is stopped when 'ctx' is done, or
+// when any of the 'limits' is exceeded. It is the vm's counterpart of 'eval.EvalContext'.
+func (vm *VM) RunContext(ctx context.Context, program *ast.Program, scope *eval.Scope, limits eval.Limits) eval.Object {
+	return eval.WithLimits(ctx, scope, limits, func() eval.Object {
+		return vm.Run(program, scope)
+	})
+}
+
+// Run compiles and runs 'program' in 'scope'. It is the vm's counterpart of
+// 'eval.Eval(program, scope)'.
+func (vm *VM) Run(program *ast.Program, scope *eval.Scope) (val eval.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := eval.PanicToError(r, program)
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			val = eval.NIL
+		}
+	}()
+
+	results := eval.EvalImports(program, scope)
+	if results.Type() == eval.ERROR_OBJ {
+		return results
+	}
+	if errObj := eval.ExpandMacros(program, scope); errObj != nil {
+		return errObj
+	}
+
+	code, err := compiler.Compile(program)
+	if err != nil {
+		return eval.NewError(program.Pos().Sline(), eval.GENERICERROR, err.Error())
+	}
+
+	results = vm.execute(code, scope)
+	switch r := results.(type) {
+	case *eval.ReturnValue:
+		return r.Value
+	case *eval.Error:
+		eval.TraceError(r, scope)
+	}
+	return results
+}
+
+func (vm *VM) execute(code *compiler.Bytecode, scope *eval.Scope) eval.Object {
+	ins := code.Instructions
+	stack := make([]eval.Object, 0, 16)
+	scopes := []*eval.Scope{scope}
+	loops := []loop{}
+
+	for ip := 0; ip < len(ins); {
+		op := compiler.Opcode(ins[ip])
+		scope = scopes[len(scopes)-1]
+
+		switch op {
+		case compiler.OpConstant:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			ip += 3
+			stack = append(stack, copyConstant(code.Constants[idx]))
+
+		case compiler.OpNil:
+			ip++
+			stack = append(stack, eval.NIL)
+
+		case compiler.OpTrue:
+			ip++
+			stack = append(stack, eval.TRUE)
+
+		case compiler.OpFalse:
+			ip++
+			stack = append(stack, eval.FALSE)
+
+		case compiler.OpPop:
+			ip++
+			stack = stack[:len(stack)-1]
+
+		case compiler.OpGetName:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.Identifier)
+			ip += 3
+			val := eval.EvalIdentifier(node, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack = append(stack, val)
+
+		case compiler.OpLet:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.LetStatement)
+			ip += 3
+			val := stack[len(stack)-1]
+			if tup, ok := val.(*eval.Tuple); ok && tup.IsMulti {
+				val = eval.NIL
+				if len(tup.Members) > 0 {
+					val = tup.Members[0]
+				}
+				stack[len(stack)-1] = val
+			}
+			if isAbrupt(val) {
+				return val
+			}
+			scope.Set(node.Names[0].Value, val)
+
+		case compiler.OpAssign:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.AssignExpression)
+			ip += 3
+			val := stack[len(stack)-1]
+			if isAbrupt(val) {
+				return val
+			}
+			val = eval.EvalAssign(node, val, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack[len(stack)-1] = val
+
+		case compiler.OpFunc:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.FunctionLiteral)
+			ip += 3
+			val := eval.NewFunction(node, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack = append(stack, val)
+
+		case compiler.OpInfix:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.InfixExpression)
+			ip += 3
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			if isAbrupt(left) {
+				return left
+			}
+			if isAbrupt(right) {
+				return right
+			}
+
+			val := integerInfix(node.Operator, left, right)
+			if val == nil {
+				val = eval.EvalInfix(node, left, right, scope)
+				if isAbrupt(val) {
+					return val
+				}
+			}
+			stack = append(stack, val)
+
+		case compiler.OpPrefix:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.PrefixExpression)
+			ip += 3
+			right := stack[len(stack)-1]
+			if isAbrupt(right) {
+				return right
+			}
+			val := eval.EvalPrefix(node, right, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack[len(stack)-1] = val
+
+		case compiler.OpArray:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			ip += 3
+			members := make([]eval.Object, n)
+			copy(members, stack[len(stack)-n:])
+			stack = stack[:len(stack)-n]
+			stack = append(stack, &eval.Array{Members: members})
+
+		case compiler.OpJump:
+			pos := int(compiler.ReadUint16(ins[ip+1:]))
+			if pos < ip { //jumping back to the start of a loop
+				if err := eval.CheckLimits(nil, scope); err != nil {
+					return err
+				}
+			}
+			ip = pos
+
+		case compiler.OpJumpNotTruthy:
+			pos := int(compiler.ReadUint16(ins[ip+1:]))
+			ip += 3
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if isAbrupt(cond) {
+				return cond
+			}
+			if !eval.IsTrue(cond) {
+				ip = pos
+			}
+
+		case compiler.OpPushScope:
+			ip++
+			scopes = append(scopes, eval.NewScope(scope, nil))
+
+		case compiler.OpPopScope:
+			ip++
+			scopes = scopes[:len(scopes)-1]
+
+		case compiler.OpLoop:
+			breakPos := int(compiler.ReadUint16(ins[ip+1:]))
+			continuePos := int(compiler.ReadUint16(ins[ip+3:]))
+			ip += 5
+			loops = append(loops, loop{breakPos: breakPos, continuePos: continuePos, scopeDepth: len(scopes), sp: len(stack)})
+
+		case compiler.OpLoopEnd:
+			ip++
+			loops = loops[:len(loops)-1]
+
+		case compiler.OpBreak, compiler.OpContinue:
+			if len(loops) == 0 { //'break' or 'continue' outside a loop
+				if op == compiler.OpBreak {
+					return eval.BREAK
+				}
+				return eval.CONTINUE
+			}
+			l := loops[len(loops)-1]
+			scopes, stack = scopes[:l.scopeDepth], stack[:l.sp]
+			if op == compiler.OpBreak {
+				ip = l.breakPos
+			} else {
+				ip = l.continuePos
+			}
+
+		case compiler.OpGetFunc:
+			call := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.CallExpression)
+			skipPos := int(compiler.ReadUint16(ins[ip+3:]))
+			ip += 5
+
+			if fn := lookupFunction(call, scope); fn != nil {
+				stack = append(stack, fn)
+				continue
+			}
+
+			//Not a function the vm knows how to call, let the evaluator call it.
+			val := eval.Eval(call, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack = append(stack, val)
+			ip = skipPos
+
+		case compiler.OpCall:
+			call := code.Nodes[compiler.ReadUint16(ins[ip+1:])].(*ast.CallExpression)
+			argc := int(compiler.ReadUint16(ins[ip+3:]))
+			ip += 5
+
+			args := make([]eval.Object, argc)
+			copy(args, stack[len(stack)-argc:])
+			fn := stack[len(stack)-argc-1]
+			stack = stack[:len(stack)-argc-1]
+
+			val := vm.call(call, fn, args, scope)
+			if isAbrupt(val) {
+				return val
+			}
+			stack = append(stack, val)
+
+		case compiler.OpReturn:
+			val := stack[len(stack)-1]
+			return &eval.ReturnValue{Value: val, Values: []eval.Object{val}}
+
+		case compiler.OpReturnMulti:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			values := make([]eval.Object, n)
+			copy(values, stack[len(stack)-n:])
+			return &eval.ReturnValue{Value: values[0], Values: values}
+
+		case compiler.OpEval:
+			node := code.Nodes[compiler.ReadUint16(ins[ip+1:])]
+			ip += 3
+
+			val := eval.Eval(node, scope)
+			if val == nil {
+				val = eval.NIL
+			}
+			switch val.(type) {
+			case *eval.Error, *eval.ReturnValue:
+				return val
+			case *eval.Break, *eval.Continue:
+				if len(loops) == 0 {
+					return val
+				}
+				l := loops[len(loops)-1]
+				scopes, stack = scopes[:l.scopeDepth], stack[:l.sp]
+				if _, ok := val.(*eval.Break); ok {
+					ip = l.breakPos
+				} else {
+					ip = l.continuePos
+				}
+				continue
+			}
+			stack = append(stack, val)
+
+		default:
+			return eval.NewError("", eval.GENERICERROR, fmt.Sprintf("vm: unknown opcode %d", op))
+		}
+	}
+
+	if len(stack) == 0 {
+		return eval.NIL
+	}
+	return stack[len(stack)-1]
+}
+
+// returns the callee of 'call' if the vm can call it directly, otherwise nil.
+func lookupFunction(call *ast.CallExpression, scope *eval.Scope) eval.Object {
+	name := call.Function.(*ast.Identifier).Value
+	if fn, ok := scope.Get(name); ok {
+		if f, ok := fn.(*eval.Function); ok && !f.Async {
+			return f
+		}
+		return nil
+	}
+
+	if b, ok := eval.LookupBuiltin(name); ok {
+		return b
+	}
+	return nil
+}
+
+func (vm *VM) call(call *ast.CallExpression, fn eval.Object, args []eval.Object, scope *eval.Scope) eval.Object {
+	line := call.Function.Pos().Sline()
+
+	switch f := fn.(type) {
+	case *eval.Builtin:
+		for _, arg := range args {
+			if arg.Type() == eval.ERROR_OBJ {
+				return arg
+			}
+		}
+		return f.Fn(line, scope, args...)
+
+	case *eval.Function:
+		//check if it's static function
+		if thisObj, ok := scope.Get("this"); ok {
+			if thisObj.Type() == eval.CLASS_OBJ && !f.Literal.StaticFlag {
+				return eval.NewError(line, eval.CALLNONSTATICERROR)
+			}
+		}
+
+		if err := eval.CheckCallDepth(call, scope); err != nil {
+			return err
+		}
+
+		if f.Literal.Generator {
+			return eval.CallGenerator(f, call, args, scope)
+		}
+
+		funcScope := eval.EnterFunction(f, call, args, scope)
+		defer eval.LeaveFunction(funcScope)
+
+		var r eval.Object
+		if code := functionCode(f.Literal); code != nil {
+			r = vm.execute(code, funcScope)
+		} else {
+			r = eval.Eval(f.Literal.Body, funcScope)
+		}
+
+		if r == nil { //empty function body
+			return eval.NIL
+		}
+		if rv, ok := r.(*eval.ReturnValue); ok {
+			return eval.UnwrapReturnValue(rv)
+		}
+		eval.TraceError(r, funcScope)
+		return r
+	}
+
+	return eval.NewError(line, eval.GENERICERROR, fmt.Sprintf("%s is not a function", fn.Type()))
+}
+
+// returns the compiled body of the function literal, compiling it on first use.
+// nil is returned if the body could not be compiled.
+func functionCode(fl *ast.FunctionLiteral) *compiler.Bytecode {
+	functionsMu.RLock()
+	code, ok := functions[fl]
+	functionsMu.RUnlock()
+	if ok {
+		return code
+	}
+
+	code, err := compiler.CompileFunction(fl)
+	if err != nil {
+		code = nil
+	}
+
+	functionsMu.Lock()
+	functions[fl] = code
+	functionsMu.Unlock()
+	return code
+}
+
+// Whether the result stops the execution of the current function.
+func isAbrupt(obj eval.Object) bool {
+	switch obj.(type) {
+	case *eval.Error:
+		return true
+	}
+	return false
+}
+
+// Literal objects are mutable(e.g. 'setValid'), so every evaluation of a
+// literal must produce a new object, just like the evaluator does.
+func copyConstant(obj eval.Object) eval.Object {
+	switch o := obj.(type) {
+	case *eval.Integer:
+		return eval.NewInteger(o.Int64)
+	case *eval.UInteger:
+		return eval.NewUInteger(o.UInt64)
+	case *eval.Float:
+		return eval.NewFloat(o.Float64)
+	case *eval.String:
+		return eval.NewString(o.String)
+	}
+	return obj
+}
+
+// Fast path for the most common operators on two integers. The results are
+// computed exactly like 'evalNumberInfixExpression' does. Returns nil if the
+// operands or the operator are not handled here.
+func integerInfix(operator string, left, right eval.Object) eval.Object {
+	l, ok := left.(*eval.Integer)
+	if !ok {
+		return nil
+	}
+	r, ok := right.(*eval.Integer)
+	if !ok {
+		return nil
+	}
+
+	leftVal, rightVal := float64(l.Int64), float64(r.Int64)
+	switch operator {
+	case "+":
+		return eval.NewInteger(int64(leftVal + rightVal))
+	case "-":
+		return eval.NewInteger(int64(leftVal - rightVal))
+	case "*":
+		return eval.NewInteger(int64(leftVal * rightVal))
+	case "<":
+		return boolObject(leftVal < rightVal)
+	case "<=":
+		return boolObject(leftVal <= rightVal)
+	case ">":
+		return boolObject(leftVal > rightVal)
+	case ">=":
+		return boolObject(leftVal >= rightVal)
+	case "==":
+		return boolObject(leftVal == rightVal)
+	case "!=":
+		return boolObject(leftVal != rightVal)
+	}
+	return nil
+}
+
+func boolObject(b bool) eval.Object {
+	if b {
+		return eval.TRUE
+	}
+	return eval.FALSE
+}
@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"magpie/eval"
+	"magpie/lexer"
+	"magpie/parser"
+	"os"
+	"testing"
+	"time"
+)
+
+// The inputs of the evaluator's tests(eval/eval_test.go) are run with the vm
+// by eval/vm_backend_test.go. These are the inputs of the statements the vm
+// compiles itself which those tests don't cover, every one of them must give
+// the same result with the vm as with the tree-walking evaluator.
+var backendTests = []string{
+	"7 % 3", "2 ** 10", "1.5 + 2",
+	`let x = 10; "x = {x}"`,
+	"let sum = 0; for (i = 0; i < 10; i++) { if i == 2 { continue } if i == 8 { break } sum += i }; sum",
+	"let sum = 0; for i in 1..10 { sum += i }; sum",
+	"let i = 0; for { i++; if i > 5 { break } }; i",
+	"fn f() { return 1, 2 }; let (a, b) = f(); a + b",
+	`fn f(x) { if x > 1 { throw "big" } return x }; try { f(3) } catch e { "caught " + e }`,
+	"fn sum(args...) { let s = 0; for a in args { s += a }; s }; sum(1, 2, 3, 4)",
+	"let i = 0; while (i < 10) { i++; try { if i == 3 { break } } catch e {} }; i",
+	"fn add(x, y = 5) { x + y }; add(1)",
+	"fn loop(n) { let i = 0; while (true) { if i == n { return i * 2 } i++ } }; loop(4)",
+	"fn gen(n) { for i in 1..n { yield i } }; let s = 0; for x in gen(4) { s += x }; s",
+}
+
+func run(t *testing.T, input string, useVM bool) string {
+	l := lexer.New("test", input)
+	wd, _ := os.Getwd()
+	p := parser.New(l, wd)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	var out bytes.Buffer
+	scope := eval.NewScope(nil, &out)
+	var result eval.Object
+	if useVM {
+		result = New().Run(program, scope)
+	} else {
+		result = eval.Eval(program, scope)
+	}
+	if result == nil {
+		return out.String() + "<nil>"
+	}
+	return out.String() + result.Inspect()
+}
+
+func TestSameResultsAsEval(t *testing.T) {
+	for _, input := range backendTests {
+		expected := run(t, input, false)
+		got := run(t, input, true)
+		if got != expected {
+			t.Errorf("input %q: vm=%q, eval=%q", input, got, expected)
+		}
+	}
+}
+
+func TestRunContextLimits(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits eval.Limits
+	}{
+		{"let i = 0; while (true) { i++ }", eval.Limits{MaxNodes: 10000}},
+		{"let i = 0; for { i++ }", eval.Limits{Timeout: 50 * time.Millisecond}},
+		{"fn f(n) { return f(n + 1) }; f(0)", eval.Limits{MaxCallDepth: 100}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New("test", tt.input)
+		program := parser.New(l, "").ParseProgram()
+		scope := eval.NewScope(nil, &bytes.Buffer{})
+
+		result := New().RunContext(context.Background(), program, scope, tt.limits)
+		if err, ok := result.(*eval.Error); !ok || err.Kind != eval.EXECLIMITERROR {
+			t.Errorf("input %q: expected an EXECLIMITERROR, got=%s", tt.input, result.Inspect())
+		}
+	}
+}